@@ -1,28 +1,11 @@
 package main
 
 import (
-	"context"
 	"os"
 
-	"github.com/konflux-ci/monolithic-builder/pkg/imageindex"
-	"go.uber.org/zap"
+	"github.com/konflux-ci/monolithic-builder/pkg/cli"
 )
 
 func main() {
-	logger, _ := zap.NewProduction()
-	defer func() { _ = logger.Sync() }()
-
-	ctx := context.Background()
-
-	config, err := imageindex.LoadConfigFromEnv()
-	if err != nil {
-		logger.Error("Failed to load configuration", zap.Error(err))
-		os.Exit(1)
-	}
-
-	builder := imageindex.NewBuilder(logger, config)
-	if err := builder.Execute(ctx); err != nil {
-		logger.Error("Command execution failed", zap.Error(err))
-		os.Exit(1)
-	}
+	os.Exit(cli.Main("build-image-index", os.Args[1:], os.Stdout, os.Stderr, cli.RunBuildImageIndex))
 }
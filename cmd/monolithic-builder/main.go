@@ -1,23 +1,32 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 
-	"github.com/konflux-ci/monolithic-builder/pkg/buildcontainer"
+	"github.com/konflux-ci/monolithic-builder/pkg/cli"
 	"github.com/konflux-ci/monolithic-builder/pkg/exec"
-	"github.com/konflux-ci/monolithic-builder/pkg/imageindex"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
 func main() {
-	logger, _ := zap.NewProduction()
+	logger, err := cli.NewLoggerFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 	defer func() { _ = logger.Sync() }()
 
 	rootCmd := &cobra.Command{
-		Use:   "monolithic-builder",
-		Short: "Monolithic builder for Konflux pipelines",
-		Long:  "A unified builder that consolidates multiple Tekton pipeline tasks into efficient Go-based implementations.",
+		Use:     "monolithic-builder",
+		Short:   "Monolithic builder for Konflux pipelines",
+		Long:    "A unified builder that consolidates multiple Tekton pipeline tasks into efficient Go-based implementations.",
+		Version: cli.Version,
+		// Errors are logged by the subcommands, like the standalone binaries do
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 
 	// Add subcommands
@@ -30,7 +39,10 @@ func main() {
 		os.Args = append([]string{os.Args[0], cmd}, os.Args[1:]...)
 	}
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := cli.SignalContext(context.Background())
+	err = rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		os.Exit(1)
 	}
 }
@@ -44,21 +56,7 @@ Build arguments should be in the format KEY=value and will be passed to buildah
 		Args: cobra.ArbitraryArgs, // Accept any number of positional arguments
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// args contains the build arguments: ["KEY1=value1", "KEY2=value2", ...]
-			config, err := buildcontainer.LoadConfig(args)
-			if err != nil {
-				logger.Error("Failed to load build-container configuration", zap.Error(err))
-				return err
-			}
-
-			// Create command runner
-			runner := exec.NewRealCommandRunner()
-			builder := buildcontainer.NewBuilder(logger, config, runner)
-			if err := builder.Execute(cmd.Context()); err != nil {
-				logger.Error("Build-container execution failed", zap.Error(err))
-				return err
-			}
-
-			return nil
+			return cli.RunBuildContainer(cmd.Context(), logger, args, exec.NewRealCommandRunner())
 		},
 	}
 }
@@ -69,19 +67,7 @@ func buildImageIndexCmd(logger *zap.Logger) *cobra.Command {
 		Short: "Build multi-platform image index",
 		Long:  `Build a multi-platform image index from the provided container images.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			config, err := imageindex.LoadConfigFromEnv()
-			if err != nil {
-				logger.Error("Failed to load build-image-index configuration", zap.Error(err))
-				return err
-			}
-
-			builder := imageindex.NewBuilder(logger, config)
-			if err := builder.Execute(cmd.Context()); err != nil {
-				logger.Error("Build-image-index execution failed", zap.Error(err))
-				return err
-			}
-
-			return nil
+			return cli.RunBuildImageIndex(cmd.Context(), logger, args, exec.NewRealCommandRunner())
 		},
 	}
 }
@@ -0,0 +1,13 @@
+package buildcontainer_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBuildcontainer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Buildcontainer Suite")
+}
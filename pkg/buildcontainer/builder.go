@@ -1,32 +1,118 @@
 package buildcontainer
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/konflux-ci/monolithic-builder/pkg/exec"
 	"github.com/konflux-ci/monolithic-builder/pkg/git"
 	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"github.com/konflux-ci/monolithic-builder/pkg/metrics"
 	"github.com/konflux-ci/monolithic-builder/pkg/prefetch"
+	"github.com/konflux-ci/monolithic-builder/pkg/results"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
 	"go.uber.org/zap"
 )
 
 // Builder implements the monolithic build-container functionality
 type Builder struct {
-	logger *zap.Logger
-	config *Config
-	runner exec.CommandRunner
+	logger  *zap.Logger
+	config  *Config
+	runner  exec.CommandRunner
+	results *results.Writer
+
+	// registryRetries retries the commands rejected by a registry rate limit
+	// and counts them for BUILD_METRICS
+	registryRetries *exec.RetryingCommandRunner
+
+	// retrySummary counts the attempts of the registry and git commands for
+	// RETRY_SUMMARY
+	retrySummary *exec.RetrySummary
+
+	// failedCommand remembers the last failed command for the failure report
+	failedCommand *exec.FailedCommand
+
+	// manifests caches the manifests resolved during the run
+	manifests *image.ManifestCache
+
+	// authFile is the temporary authfile of the registry login, if any
+	authFile string
+
+	// outputPath is the private directory of the files written for later
+	// steps when the workspace is read-only, created on first use
+	outputPath string
+
+	// subUIDPath and subGIDPath are the subordinate ID files the unshare
+	// mappings are detected from, replaced in tests
+	subUIDPath string
+	subGIDPath string
+
+	// unshareIDMaps are the mappings of the unshare commands, resolved by
+	// configureUnshare
+	unshareIDMaps image.IDMaps
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+
+	// deleter deletes the temporary tags, created from TagDeletion when nil
+	deleter image.TagDeleter
+
+	// Steps is the ordered list of steps run by Execute. It defaults to
+	// DefaultSteps and may be modified to insert custom steps.
+	Steps []Step
 }
 
 // NewBuilder creates a new Builder instance
 func NewBuilder(logger *zap.Logger, config *Config, runner exec.CommandRunner) *Builder {
-	return &Builder{
-		logger: logger,
-		config: config,
-		runner: runner,
+	// containers/storage reads STORAGE_DRIVER, BUILDAH_STORAGE_DRIVER is kept
+	// for scripts run by the build
+	if config.StorageDriver != "" {
+		runner = exec.NewEnvCommandRunner(runner, []string{
+			"BUILDAH_STORAGE_DRIVER=" + config.StorageDriver,
+			"STORAGE_DRIVER=" + config.StorageDriver,
+		}, "buildah", "unshare")
+	}
+
+	registryRetries := exec.NewRetryingCommandRunner(runner, exec.RateLimitMaxRetries, exec.RateLimitBaseDelay, exec.IsRateLimited)
+	registryRetries.Retried = isRegistryCommand
+	registryRetries.OnRetry = func(retry int, err error) {
+		logger.Warn("Registry rate limit hit, backing off",
+			zap.Int("retry", retry),
+			zap.Int("max_retries", exec.RateLimitMaxRetries),
+			zap.Error(err))
+	}
+	retrySummary := newRetrySummary()
+	failedCommand := &exec.FailedCommand{}
+	registryRetries.OnAttempt = func(name string, args []string, attempt int, err error) {
+		retrySummary.Record(name, args, attempt, err)
+		failedCommand.Record(name, args, attempt, err)
+	}
+
+	b := &Builder{
+		logger:          logger,
+		config:          config,
+		runner:          registryRetries,
+		results:         results.NewWriter(config.ResultsPath),
+		registryRetries: registryRetries,
+		retrySummary:    retrySummary,
+		failedCommand:   failedCommand,
+		manifests:       image.NewManifestCache(),
+		subUIDPath:      image.SubUIDPath,
+		subGIDPath:      image.SubGIDPath,
+		now:             time.Now,
 	}
+	b.Steps = b.DefaultSteps()
+	return b
 }
 
 // Execute runs the complete monolithic build process
@@ -34,88 +120,272 @@ func (b *Builder) Execute(ctx context.Context) error {
 	b.logger.Info("Starting monolithic build-container task",
 		zap.String("image_url", b.config.ImageURL),
 		zap.String("git_url", b.config.GitURL),
-		zap.String("revision", b.config.GitRevision))
+		zap.String("revision", b.config.GitRevision),
+		zap.String("results_path", b.results.Dir()))
 
-	// Step 1: Initialize - check if we need to build
-	shouldBuild, err := b.initializeAndCheckBuild(ctx)
-	if err != nil {
-		return fmt.Errorf("initialization failed: %w", err)
+	if err := b.dumpConfig(); err != nil {
+		return fmt.Errorf("failed to dump effective configuration: %w", err)
 	}
 
-	// Write build result for potential pipeline consumption
-	if err := b.writeResult("build", fmt.Sprintf("%t", shouldBuild)); err != nil {
-		return fmt.Errorf("failed to write build result: %w", err)
+	if b.config.TempDir != "" {
+		cleanup, err := b.setupTempDir()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
 	}
 
-	// Step 2: Always clone repository to get git info (required for pipeline results)
-	b.logger.Info("Cloning repository")
-	gitResult, err := b.cloneRepository(ctx)
-	if err != nil {
-		return fmt.Errorf("git clone failed: %w", err)
-	}
+	state := &State{Warnings: warnings.Collector{Strict: b.config.StrictWarnings}}
+	defer func() {
+		if state.failureReport != nil {
+			b.logger.Error(state.failureReport.String())
+		}
+	}()
+	defer b.writeWarnings(state)
 
-	// Write git results (always required for Konflux pipeline traceability)
-	if err := b.writeResult("commit", gitResult.CommitSHA); err != nil {
-		return fmt.Errorf("failed to write commit result: %w", err)
+	// Clone-only runs never invoke the container tools
+	if !b.config.CloneOnly {
+		versions, err := b.detectToolVersions(ctx, state)
+		if err != nil {
+			return err
+		}
+		state.ToolVersions = versions
+	}
+	if !b.config.CloneOnly {
+		if err := b.configureUnshare(); err != nil {
+			return err
+		}
 	}
-	if err := b.writeResult("url", gitResult.URL); err != nil {
-		return fmt.Errorf("failed to write url result: %w", err)
+	if b.config.RegistryLogin && !b.config.CloneOnly {
+		logout, err := b.loginRegistry(ctx)
+		if err != nil {
+			return err
+		}
+		defer logout()
 	}
-
-	// Always write image results (required for downstream tasks like build-image-index)
-	if err := b.writeResult("IMAGE_URL", b.config.ImageURL); err != nil {
-		return fmt.Errorf("failed to write IMAGE_URL result: %w", err)
+	if b.config.PreflightPushCheck && !b.config.CloneOnly {
+		if err := b.checkPushAccess(ctx, state); err != nil {
+			return err
+		}
+	}
+	if b.config.Resume {
+		state.Checkpoint = b.loadCheckpoint()
 	}
 
-	if !shouldBuild {
-		b.logger.Info("Skipping build - image already exists and rebuild not requested")
+	budget := newBudget(b.config.Deadline, b.config.StepBudgets, b.now)
+	for _, step := range b.Steps {
+		if step.Skip(b.config) {
+			b.logger.Debug("Skipping step", zap.String("step", step.Name()))
+			continue
+		}
 
-		// Get digest of existing image for downstream tasks
-		digest, err := b.getExistingImageDigest(ctx)
+		stepCtx, cancel, err := budget.stepContext(ctx, step.Name())
 		if err != nil {
-			b.logger.Warn("Failed to get existing image digest, using empty value", zap.Error(err))
-			digest = ""
+			return b.failTimeout(state, step.Name(), err.Error())
 		}
 
-		if err := b.writeResult("IMAGE_DIGEST", digest); err != nil {
-			return fmt.Errorf("failed to write IMAGE_DIGEST result: %w", err)
+		start := b.now()
+		state.step = step.Name()
+		err = step.Run(stepCtx, state)
+		state.recordDuration(step.Name(), b.now().Sub(start))
+		deadlineExceeded := stepCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		if err != nil {
+			if deadlineExceeded {
+				return b.failTimeout(state, step.Name(), fmt.Sprintf("step %s exceeded its deadline: %v", step.Name(), err))
+			}
+			return b.fail(state, step.Name(), err)
 		}
 
-		b.logger.Info("Skipped build completed - wrote IMAGE_URL and IMAGE_DIGEST results",
-			zap.String("image_url", b.config.ImageURL),
-			zap.String("image_digest", digest))
-		return nil
+		if b.config.CloneOnly && step.Name() == "clone" {
+			b.logger.Info("Clone-only mode - stopping after the git results",
+				zap.String("commit_sha", state.CloneResult.CommitSHA))
+			return nil
+		}
 	}
 
-	// Step 3: Prefetch dependencies (if configured)
-	if b.config.PrefetchInput != "" {
-		b.logger.Info("Prefetching dependencies")
-		if err := b.prefetchDependencies(ctx); err != nil {
-			return fmt.Errorf("dependency prefetch failed: %w", err)
-		}
+	if err := b.writeRetrySummary(state); err != nil {
+		return err
+	}
+	return b.writeMetrics(state)
+}
+
+// configureUnshare maps the subordinate ID ranges of the current user, or
+// UnshareUIDMap and UnshareGIDMap, in the unshare commands of the build
+func (b *Builder) configureUnshare() error {
+	username := ""
+	if current, err := user.Current(); err == nil {
+		username = current.Username
+	}
+
+	users, err := image.ResolveIDMap(b.subUIDPath, b.config.UnshareUIDMap, username, os.Getuid())
+	if err != nil {
+		return fmt.Errorf("invalid unshare user mapping: %w", err)
 	}
+	groups, err := image.ResolveIDMap(b.subGIDPath, b.config.UnshareGIDMap, username, os.Getuid())
+	if err != nil {
+		return fmt.Errorf("invalid unshare group mapping: %w", err)
+	}
+
+	b.logger.Debug("Mapping subordinate IDs in unshare",
+		zap.Stringer("users", users),
+		zap.Stringer("groups", groups))
+	b.unshareIDMaps = image.IDMaps{Users: users, Groups: groups}
+	return nil
+}
+
+// tempDirCommands are the commands staging temporary files under TMPDIR
+var tempDirCommands = []string{"buildah", "skopeo", "unshare", "cachi2", "syft", "git"}
 
-	// Step 4: Build container image
-	b.logger.Info("Building container image")
-	buildResult, err := b.buildContainerImage(ctx, gitResult.CommitSHA)
+// setupTempDir creates a temporary directory under TempDir and makes the
+// commands run afterwards use it as TMPDIR. buildah has no flag for it and
+// reads TMPDIR, which unshare passes on; the directory is on the workspace
+// so its mount namespace sees it. The returned function removes it.
+func (b *Builder) setupTempDir() (func(), error) {
+	dir := b.config.TempDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(b.config.WorkspacePath, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create BUILD_TMPDIR: %w", err)
+	}
+	tempDir, err := os.MkdirTemp(dir, "tmp-")
 	if err != nil {
-		return fmt.Errorf("container build failed: %w", err)
+		return nil, fmt.Errorf("failed to create temporary directory in %s: %w", dir, err)
 	}
 
-	// Write build results (IMAGE_URL already written above)
-	if err := b.writeResult("IMAGE_DIGEST", buildResult.ImageDigest); err != nil {
-		return fmt.Errorf("failed to write IMAGE_DIGEST result: %w", err)
+	b.logger.Debug("Staging temporary files", zap.String("tmpdir", tempDir))
+	b.runner = exec.NewEnvCommandRunner(b.runner, []string{"TMPDIR=" + tempDir}, tempDirCommands...)
+
+	return func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			b.logger.Warn("Failed to remove temporary directory", zap.String("tmpdir", tempDir), zap.Error(err))
+		}
+	}, nil
+}
+
+// loginRegistry logs in to the registry of ImageURL and makes the commands
+// run afterwards use the temporary authfile. The returned function removes it.
+func (b *Builder) loginRegistry(ctx context.Context) (func(), error) {
+	login, err := image.Login(ctx, b.logger, &image.LoginConfig{
+		Registry:        image.RegistryHost(b.config.ImageURL),
+		Type:            b.config.RegistryType,
+		CredentialsPath: b.config.RegistryCredentialsPath,
+		AuthFile:        b.config.AuthFile,
+		TLSVerify:       b.tlsVerify(),
+	}, b.runner)
+	if err != nil {
+		return nil, fmt.Errorf("registry login failed: %w", err)
 	}
 
-	b.logger.Info("Monolithic build-container task completed successfully",
-		zap.String("image_url", buildResult.ImageURL),
-		zap.String("image_digest", buildResult.ImageDigest))
+	b.authFile = login.AuthFile
+	b.runner = exec.NewEnvCommandRunner(b.runner, []string{"REGISTRY_AUTH_FILE=" + login.AuthFile}, "buildah", "skopeo", "unshare")
 
+	return func() {
+		if err := login.Cleanup(); err != nil {
+			b.logger.Warn("Failed to clean up registry login", zap.Error(err))
+		}
+	}, nil
+}
+
+// checkPushAccess fails the build before the clone when the credentials
+// can't push to ImageURL. A preflight tag left in place is a warning.
+func (b *Builder) checkPushAccess(ctx context.Context, state *State) error {
+	err := image.CheckPushAccess(ctx, b.logger, b.config.ImageURL, b.tlsVerify(), b.runner)
+	if errors.Is(err, image.ErrPreflightCleanup) {
+		b.logger.Warn("Registry did not allow deleting the preflight tag, leaving it in place", zap.Error(err))
+		return state.AddWarning(warnings.CategoryTempTagCleanup, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("push preflight failed: %w", err)
+	}
 	return nil
 }
 
+// tagDeleter returns the TagDeleter of TagDeletion, nil when the temporary
+// tags are left in place
+func (b *Builder) tagDeleter() (image.TagDeleter, error) {
+	if b.deleter != nil {
+		return b.deleter, nil
+	}
+	if b.config.TagDeletion != image.TagDeletionQuay {
+		return nil, nil
+	}
+	token, err := os.ReadFile(b.config.QuayTokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Quay token: %w", err)
+	}
+	return image.NewQuayTagDeleter(strings.TrimSpace(string(token))), nil
+}
+
+// registryAuthFile returns the authfile of the registry login, or AuthFile
+func (b *Builder) registryAuthFile() string {
+	if b.authFile != "" {
+		return b.authFile
+	}
+	return b.config.AuthFile
+}
+
+// failTimeout ends a build whose deadline ran out, writing the TIMEOUT reason
+// along with the results gathered so far
+func (b *Builder) failTimeout(state *State, step, reason string) error {
+	b.logger.Error("Build deadline exceeded", zap.String("reason", reason))
+
+	if err := b.writeResult("TIMEOUT", reason); err != nil {
+		b.logger.Warn("Failed to write TIMEOUT result", zap.Error(err))
+	}
+	err := fmt.Errorf("build deadline exceeded: %s", reason)
+	b.flushFailure(state, step, results.ErrorCategoryTimeout, err)
+	return err
+}
+
+// fail ends a build whose step failed, flushing the results gathered so far.
+// err is returned unchanged.
+func (b *Builder) fail(state *State, step string, err error) error {
+	b.logger.Error("Build step failed", zap.String("step", step), zap.Error(err))
+	b.flushFailure(state, step, results.ErrorCategory(err), err)
+	return err
+}
+
+// flushFailure writes FAILED_STEP, ERROR_CATEGORY, the failure report and
+// whatever checks and metrics were collected so far, within
+// FailureGracePeriod. Results written by earlier steps, such as commit and
+// url, are kept. Write failures are only logged so that they never mask the
+// error of the step.
+func (b *Builder) flushFailure(state *State, step, category string, err error) {
+	command := b.failedCommand.Command(err)
+	step = failedStep(step, command)
+	state.failureReport = results.NewFailureReport(step, category, err, command)
+	flushed := results.FlushWithin(b.config.FailureGracePeriod, func() {
+		if err := b.writeResult("FAILED_STEP", step); err != nil {
+			b.logger.Warn("Failed to write FAILED_STEP result", zap.Error(err))
+		}
+		if err := b.writeResult("ERROR_CATEGORY", category); err != nil {
+			b.logger.Warn("Failed to write ERROR_CATEGORY result", zap.Error(err))
+		}
+		if err := state.failureReport.Write(b.results.Dir()); err != nil {
+			b.logger.Warn("Failed to write the failure report", zap.Error(err))
+		}
+		if len(state.Checks) > 0 {
+			if err := b.writeChecks(state); err != nil {
+				b.logger.Warn("Failed to write partial checks", zap.Error(err))
+			}
+		}
+		if err := b.writeRetrySummary(state); err != nil {
+			b.logger.Warn("Failed to write partial retry summary", zap.Error(err))
+		}
+		if err := b.writeMetrics(state); err != nil {
+			b.logger.Warn("Failed to write partial metrics", zap.Error(err))
+		}
+	})
+	if !flushed {
+		b.logger.Warn("Partial results weren't flushed within the grace period",
+			zap.Duration("grace_period", b.config.FailureGracePeriod))
+	}
+}
+
 // initializeAndCheckBuild implements the init task functionality
-func (b *Builder) initializeAndCheckBuild(ctx context.Context) (bool, error) {
+func (b *Builder) initializeAndCheckBuild(ctx context.Context, state *State) (bool, error) {
 	b.logger.Info("Checking if image build is required",
 		zap.String("image_url", b.config.ImageURL),
 		zap.Bool("rebuild", b.config.Rebuild),
@@ -126,73 +396,733 @@ func (b *Builder) initializeAndCheckBuild(ctx context.Context) (bool, error) {
 		return true, nil
 	}
 
-	// Check if image already exists
-	exists, err := image.CheckImageExists(ctx, b.config.ImageURL, b.config.TLSVerify, b.runner)
-	if err != nil {
-		b.logger.Warn("Failed to check image existence, proceeding with build", zap.Error(err))
+	// Check if the image, or one of the candidate tags, already exists
+	ref, raw, found := b.findExistingImage(ctx)
+	if !found {
 		return true, nil
 	}
+	if ref != b.config.ImageURL {
+		b.logger.Info("Existence check satisfied by a candidate tag",
+			zap.String("candidate", ref),
+			zap.String("image_url", b.config.ImageURL))
+
+		// Retag the candidate so IMAGE_URL resolves to the digest the results report
+		if err := b.registry().Copy(ctx, ref, b.config.ImageURL); err != nil {
+			b.logger.Warn("Failed to copy the candidate image, building instead", zap.Error(err))
+			if err := state.AddWarning(warnings.CategoryExistingImage, fmt.Sprintf("failed to copy existing image %s: %v", ref, err)); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+
+	// Remember the manifest so the skip path reports the digest of exactly this payload
+	manifest, err := image.ParseManifest(raw)
+	if err != nil {
+		b.logger.Warn("Failed to parse existing image manifest", zap.Error(err))
+		if err := state.AddWarning(warnings.CategoryExistingImage, fmt.Sprintf("failed to parse existing image manifest: %v", err)); err != nil {
+			return false, err
+		}
+	} else {
+		b.logger.Info("Image already exists",
+			zap.String("media_type", manifest.MediaType),
+			zap.Bool("is_index", manifest.IsIndex),
+			zap.String("digest", manifest.Digest))
+		state.ExistingManifest = manifest
+	}
+
+	if b.config.RebuildOnBaseChange {
+		changed := b.compareBaseImage(ctx, state.ExistingManifest)
+		if err := b.writeResult("BASE_IMAGE_CHANGED", changed); err != nil {
+			return false, fmt.Errorf("failed to write BASE_IMAGE_CHANGED result: %w", err)
+		}
+		// Rebuild unless the digests are known to match
+		return changed != "false", nil
+	}
+	return false, nil
+}
+
+// compareBaseImage compares the base image digest recorded on the existing
+// image with the current digest of the same reference, returning true or
+// false, or unknown when either digest can't be determined
+func (b *Builder) compareBaseImage(ctx context.Context, existing *image.Manifest) string {
+	if existing == nil {
+		b.logger.Info("Existing image manifest unknown, rebuilding")
+		return "unknown"
+	}
+	name, recorded, ok := existing.RecordedBaseImage()
+	if !ok {
+		b.logger.Info("Existing image has no recorded base image digest, rebuilding")
+		return "unknown"
+	}
 
-	return !exists, nil
+	current, err := b.registry().ManifestDigest(ctx, name)
+	if err != nil {
+		b.logger.Warn("Failed to resolve the current base image digest, rebuilding",
+			zap.String("base_image", name), zap.Error(err))
+		return "unknown"
+	}
+
+	changed := current != recorded
+	b.logger.Info("Compared the base image digests",
+		zap.String("base_image", name),
+		zap.String("recorded_digest", recorded),
+		zap.String("current_digest", current),
+		zap.Bool("changed", changed))
+	return strconv.FormatBool(changed)
+}
+
+// findExistingImage returns the first of ImageURL and the ExistenceCheckTags
+// candidates that exists, with its raw manifest
+func (b *Builder) findExistingImage(ctx context.Context) (string, []byte, bool) {
+	candidates := []string{b.config.ImageURL}
+	for _, tag := range b.config.ExistenceCheckTags {
+		candidates = append(candidates, candidateReference(b.config.ImageURL, tag))
+	}
+
+	// The candidates are resolved at once, the first one found in their order wins
+	resolver := image.NewResolver(b.registry(), image.DefaultResolveWorkers, b.manifests)
+	for _, result := range resolver.Resolve(ctx, candidates) {
+		if result.Err == nil {
+			return result.Ref, result.Raw, true
+		}
+		b.logger.Debug("Image not found", zap.String("reference", result.Ref), zap.Error(result.Err))
+	}
+	return "", nil, false
+}
+
+// candidateReference resolves an ExistenceCheckTags entry, a bare tag being
+// looked up in the repository of imageURL
+func candidateReference(imageURL, tag string) string {
+	if strings.ContainsAny(tag, "/@") {
+		return tag
+	}
+	return image.Repository(imageURL) + ":" + tag
 }
 
-// cloneRepository implements the git-clone task functionality
-func (b *Builder) cloneRepository(ctx context.Context) (*git.CloneResult, error) {
+// cloneRepository implements the git-clone task functionality, recording
+// submodule failures as warnings
+func (b *Builder) cloneRepository(ctx context.Context, state *State) (*git.CloneResult, error) {
 	cloneConfig := &git.CloneConfig{
 		URL:         b.config.GitURL,
 		Revision:    b.config.GitRevision,
+		Ref:         b.config.GitRef,
 		Refspec:     b.config.GitRefspec,
 		Depth:       b.config.GitDepth,
 		Submodules:  b.config.GitSubmodules,
-		Destination: filepath.Join(b.config.WorkspacePath, "source"),
+		Destination: b.sourcePath(),
 		AuthPath:    b.config.GitAuthPath,
+		PatchPath:   b.config.PatchPath,
+		Runner:      b.runner,
+
+		SparseCheckout: b.config.GitSparseCheckout,
+		ExcludePaths:   b.config.GitExcludePaths,
+
+		OnSubmoduleFailure: func(err error) error {
+			return state.AddWarning(warnings.CategorySubmodule, fmt.Sprintf("failed to update submodules: %v", err))
+		},
+
+		VerifyTagSignature: b.config.VerifyTagSignature,
+		TagSigningKeyPath:  b.config.TagSigningKeyPath,
 	}
 
-	return git.Clone(ctx, b.logger, cloneConfig)
+	// The clone runs in process rather than through the runner, count it as
+	// a single attempt
+	cloneResult, err := git.Clone(ctx, b.logger, cloneConfig)
+	b.retrySummary.Record("git", []string{"clone"}, 1, err)
+	return cloneResult, err
 }
 
-// prefetchDependencies implements the prefetch-dependencies task functionality
-func (b *Builder) prefetchDependencies(ctx context.Context) error {
+// openLocalSource describes the local source tree instead of cloning it,
+// rejecting dirty trees unless AllowDirtySource is set
+func (b *Builder) openLocalSource() (*git.CloneResult, error) {
+	local, err := git.OpenLocal(b.config.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if local.Dirty {
+		if !b.config.AllowDirtySource {
+			return nil, fmt.Errorf("source tree at %s has uncommitted changes", b.config.SourcePath)
+		}
+		b.logger.Warn("Building a local source tree with uncommitted changes",
+			zap.String("source_path", b.config.SourcePath))
+	}
+	if err := b.writeResult("DIRTY", fmt.Sprintf("%t", local.Dirty)); err != nil {
+		return nil, fmt.Errorf("failed to write DIRTY result: %w", err)
+	}
+
+	return &local.CloneResult, nil
+}
+
+// reuseClone describes the source a previous task cloned into the workspace
+// from the commit result it wrote, and its url result when present
+func (b *Builder) reuseClone() (*git.CloneResult, error) {
+	content, err := os.ReadFile(filepath.Join(b.results.Dir(), "commit"))
+	if err != nil {
+		return nil, fmt.Errorf("SKIP_GIT_CLONE requires the commit result of a previous task: %w", err)
+	}
+	commitSHA := strings.TrimSpace(string(content))
+	if commitSHA == "" {
+		return nil, fmt.Errorf("SKIP_GIT_CLONE requires the commit result of a previous task, found an empty one")
+	}
+	if _, err := os.Stat(b.sourcePath()); err != nil {
+		return nil, fmt.Errorf("SKIP_GIT_CLONE requires the source in the workspace: %w", err)
+	}
+
+	url := b.config.GitURL
+	if content, err := os.ReadFile(filepath.Join(b.results.Dir(), "url")); err == nil {
+		if recorded := strings.TrimSpace(string(content)); recorded != "" {
+			url = recorded
+		}
+	}
+
+	b.logger.Info("Skipping clone - using the source in the workspace",
+		zap.String("commit_sha", commitSHA),
+		zap.String("source_path", b.sourcePath()))
+	return &git.CloneResult{URL: url, CommitSHA: commitSHA}, nil
+}
+
+// maxCommitTitleLength bounds the commit_title result in characters
+const maxCommitTitleLength = 256
+
+// writeCommitTitle writes the first line of the commit message, truncated to
+// maxCommitTitleLength characters, to the commit_title result
+func (b *Builder) writeCommitTitle(commitSHA string) error {
+	title, err := git.CommitTitle(b.sourcePath(), commitSHA)
+	if err != nil {
+		return fmt.Errorf("failed to read commit title: %w", err)
+	}
+
+	if runes := []rune(title); len(runes) > maxCommitTitleLength {
+		title = string(runes[:maxCommitTitleLength])
+	}
+	if err := b.writeResult("commit_title", title); err != nil {
+		return fmt.Errorf("failed to write commit_title result: %w", err)
+	}
+	return nil
+}
+
+// prefetchDependencies implements the prefetch-dependencies task functionality,
+// counting cachi2 retries in the state
+func (b *Builder) prefetchDependencies(ctx context.Context, state *State) error {
 	prefetchConfig := &prefetch.Config{
-		Input:              b.config.PrefetchInput,
-		SourcePath:         filepath.Join(b.config.WorkspacePath, "source"),
-		OutputPath:         filepath.Join(b.config.WorkspacePath, "cachi2", "output"),
-		DevPackageManagers: b.config.DevPackageManagers,
-		LogLevel:           b.config.Cachi2LogLevel,
-		ConfigFileContent:  b.config.Cachi2ConfigFileContent,
-		GitAuthPath:        b.config.GitAuthPath,
-		NetrcPath:          b.config.NetrcPath,
+		Input:                   b.config.PrefetchInput,
+		SourcePath:              b.sourcePath(),
+		OutputPath:              b.prefetchOutputPath(),
+		DevPackageManagers:      b.config.DevPackageManagers,
+		LogLevel:                b.config.Cachi2LogLevel,
+		ConfigFileContent:       b.config.Cachi2ConfigFileContent,
+		GitAuthPath:             b.config.GitAuthPath,
+		NetrcPath:               b.config.NetrcPath,
+		PackageManagerCredsPath: b.config.PackageManagerCredsPath,
+		ScratchPath:             b.scratchDir(),
+		DryRunCheck:             b.config.PrefetchDryRunCheck,
+		EnvFormat:               b.config.PrefetchEnvFormat,
+		OutputDirForEnv:         b.config.Cachi2OutputDirForEnv,
+		OnRetry:                 func(int, error) { state.Retries++ },
 	}
 
-	return prefetch.FetchDependencies(ctx, b.logger, prefetchConfig)
+	return prefetch.FetchDependencies(ctx, b.logger, prefetchConfig, b.runner)
 }
 
-// buildContainerImage implements the buildah task functionality
-func (b *Builder) buildContainerImage(ctx context.Context, commitSHA string) (*image.BuildResult, error) {
+// buildContainerImage implements the buildah task functionality. A non-nil
+// timestamp fixes the creation time of the image.
+func (b *Builder) buildContainerImage(ctx context.Context, commitSHA string, labels map[string]string, timestamp *time.Time) (*image.BuildResult, error) {
+	buildContext := b.sourcePath()
+
+	// buildah writes to its context, so build from a writable copy of a read-only workspace
+	if b.config.WorkspaceReadOnly {
+		tempContext, err := os.MkdirTemp("", "build-context-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary build context: %w", err)
+		}
+		defer func() { _ = os.RemoveAll(tempContext) }()
+
+		b.logger.Info("Copying read-only source to a temporary build context", zap.String("context", tempContext))
+		if err := copyTree(buildContext, tempContext); err != nil {
+			return nil, fmt.Errorf("failed to copy source to temporary build context: %w", err)
+		}
+		buildContext = tempContext
+	}
+
+	dockerfile := b.config.Dockerfile
+	if b.config.DockerfileFrom != "" {
+		overridden, err := b.overrideDockerfileFrom()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = os.Remove(overridden) }()
+		dockerfile = overridden
+	}
+
+	buildArgs := b.config.BuildArgs
+	if b.config.InjectCachi2Env && b.config.PrefetchInput != "" {
+		cachi2Args, err := b.cachi2BuildArgs()
+		if err != nil {
+			return nil, err
+		}
+		buildArgs = append(cachi2Args, buildArgs...)
+	}
+
+	tagDeleter, err := b.tagDeleter()
+	if err != nil {
+		return nil, err
+	}
+
 	buildConfig := &image.BuildConfig{
-		ImageURL:          b.config.ImageURL,
-		Dockerfile:        b.config.Dockerfile,
-		Context:           filepath.Join(b.config.WorkspacePath, "source"),
-		Hermetic:          b.config.Hermetic,
-		PrefetchInput:     b.config.PrefetchInput,
-		PrefetchPath:      filepath.Join(b.config.WorkspacePath, "cachi2"),
-		ImageExpiresAfter: b.config.ImageExpiresAfter,
-		CommitSHA:         commitSHA,
-		BuildArgs:         b.config.BuildArgs,
-		BuildArgsFile:     b.config.BuildArgsFile,
-		TLSVerify:         b.config.TLSVerify,
+		ImageURL:               b.config.ImageURL,
+		Dockerfile:             dockerfile,
+		Context:                buildContext,
+		Hermetic:               b.config.Hermetic,
+		PrefetchInput:          b.config.PrefetchInput,
+		PrefetchPath:           filepath.Join(b.workDir(), "cachi2"),
+		ImageExpiresAfter:      b.config.ImageExpiresAfter,
+		CommitSHA:              commitSHA,
+		BuildArgs:              buildArgs,
+		BuildArgsFile:          b.config.BuildArgsFile,
+		IgnoreFile:             b.config.IgnoreFile,
+		NetworkMode:            b.config.NetworkMode,
+		Platform:               b.config.Platform,
+		UserNS:                 b.config.UserNS,
+		UserNSUIDMap:           b.config.UserNSUIDMap,
+		UserNSGIDMap:           b.config.UserNSGIDMap,
+		UnshareIDMaps:          b.unshareIDMaps,
+		Labels:                 labels,
+		TLSVerify:              b.config.TLSVerify,
+		InsecureRegistries:     b.config.InsecureRegistries,
+		RegistryMirrors:        b.config.ImageRegistryMirrors,
+		AuthFile:               b.registryAuthFile(),
+		CertDir:                b.config.CertDir,
+		PushByDigestOnly:       b.config.PushByDigestOnly,
+		RequireDigest:          b.config.RequireDigest,
+		VerifyImageIDAfterPush: b.config.VerifyImageID,
+		AtomicTag:              b.config.AtomicTag,
+		RunID:                  b.config.RunID,
+		TagDeleter:             tagDeleter,
+		ReadOnlyVolumes:        b.config.WorkspaceReadOnly,
+		MaxLayers:              b.config.MaxLayers,
+		MaxHistory:             b.config.MaxHistory,
+		MaxLayerCount:          b.config.MaxLayerCount,
+		LayerCacheDir:          b.config.LayerCacheDir,
+		LayerCacheMaxSize:      b.config.LayerCacheMaxSize,
+		Timestamp:              timestamp,
+
+		ImageConfigExpectations: b.config.ImageConfigExpectations,
+	}
+	if b.config.Hermetic && b.config.PrefetchInput != "" && b.config.PrefetchEnvFormat == prefetch.EnvFormatJSON {
+		env, err := prefetch.ReadEnvironment(b.prefetchOutputPath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prefetch environment: %w", err)
+		}
+		buildConfig.PrefetchEnv = env
+	}
+	if b.config.EnableBuildCache {
+		if err := os.MkdirAll(b.config.BuildCacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create build cache directory: %w", err)
+		}
+		buildConfig.BuildCacheDir = b.config.BuildCacheDir
+	}
+	if b.config.FileManifest {
+		path, err := b.fileManifestPath()
+		if err != nil {
+			return nil, err
+		}
+		buildConfig.FileManifestPath = path
+		buildConfig.FileDenyPatterns = b.config.FileDenyPatterns
+		buildConfig.FileDenyAction = b.config.FileDenyAction
 	}
 
 	return image.BuildAndPush(ctx, b.logger, buildConfig, b.runner)
 }
 
+// cachi2BuildArgs returns the variables of the cachi2 environment as
+// KEY=value build arguments, sorted by name. They come first so that the
+// BuildArgs of the same name override them.
+func (b *Builder) cachi2BuildArgs() ([]string, error) {
+	env, err := prefetch.ReadEnvironment(b.prefetchOutputPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prefetch environment: %w", err)
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key+"="+env[key])
+	}
+	b.logger.Info("Injecting the cachi2 environment as build arguments", zap.Strings("variables", keys))
+	return args, nil
+}
+
+// detectToolVersions logs the buildah and skopeo versions. Versions that
+// can't be determined are recorded as warnings and only fail the build when
+// STRICT_WARNINGS escalates them.
+func (b *Builder) detectToolVersions(ctx context.Context, state *State) (image.ToolVersions, error) {
+	versions, errs := image.DetectToolVersions(ctx, b.runner)
+	for _, err := range errs {
+		b.logger.Warn("Failed to detect tool version", zap.Error(err))
+		if err := state.AddWarning(warnings.CategoryToolVersion, err.Error()); err != nil {
+			return versions, err
+		}
+	}
+
+	b.logger.Info("Container tool versions",
+		zap.String("buildah", versions.Buildah),
+		zap.String("skopeo", versions.Skopeo))
+	return versions, nil
+}
+
+// dumpConfig logs the sanitized effective configuration at debug level and,
+// when DEBUG_CONFIG is enabled, writes it to effective-config.json in the results directory
+func (b *Builder) dumpConfig() error {
+	dump, err := b.config.DumpSanitized()
+	if err != nil {
+		return err
+	}
+
+	b.logger.Debug("Effective configuration", zap.ByteString("config", dump))
+
+	if b.config.DebugConfig {
+		return b.writeResult("effective-config.json", string(dump))
+	}
+	return nil
+}
+
+// writeBuildArgsUsed writes the build arguments of the build as a JSON list
+// in the BUILD_ARGS_USED result for auditing
+func (b *Builder) writeBuildArgsUsed(args []string) error {
+	if args == nil {
+		args = []string{}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("failed to encode build args: %w", err)
+	}
+	if err := b.writeResult("BUILD_ARGS_USED", string(data)); err != nil {
+		return fmt.Errorf("failed to write BUILD_ARGS_USED result: %w", err)
+	}
+	return nil
+}
+
+// writeEffectiveBuildArgs writes the resolved build arguments and their
+// source as a JSON list in the BUILD_ARGS_EFFECTIVE result
+func (b *Builder) writeEffectiveBuildArgs(args []image.EffectiveBuildArg) error {
+	if args == nil {
+		args = []image.EffectiveBuildArg{}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("failed to encode effective build args: %w", err)
+	}
+	if err := b.writeResult("BUILD_ARGS_EFFECTIVE", string(data)); err != nil {
+		return fmt.Errorf("failed to write BUILD_ARGS_EFFECTIVE result: %w", err)
+	}
+	return nil
+}
+
+// writeLabelFile writes the labels of the pushed image to LabelFile
+func (b *Builder) writeLabelFile(ctx context.Context, buildResult *image.BuildResult) error {
+	ref := buildResult.ImageURL
+	if buildResult.ImageDigest != "" {
+		ref = image.Repository(buildResult.ImageURL) + "@" + buildResult.ImageDigest
+	}
+
+	labels, err := image.GetImageLabels(ctx, ref, b.tlsVerify(), b.runner)
+	if err != nil {
+		return fmt.Errorf("failed to get the labels of %s: %w", ref, err)
+	}
+	if err := os.WriteFile(b.config.LabelFile, []byte(image.FormatLabels(labels)), 0644); err != nil {
+		return fmt.Errorf("failed to write label file: %w", err)
+	}
+	return nil
+}
+
 // writeResult writes a result to the Tekton results directory
 func (b *Builder) writeResult(name, value string) error {
-	resultPath := filepath.Join(b.config.ResultsPath, name)
-	return os.WriteFile(resultPath, []byte(value), 0644)
+	return b.results.Write(name, value)
+}
+
+// workDir returns the workspace directory owned by this builder, which is
+// WorkspaceSubPath inside the workspace when set
+func (b *Builder) workDir() string {
+	return filepath.Join(b.config.WorkspacePath, b.config.WorkspaceSubPath)
+}
+
+// outputDir returns the directory of the files written for later steps: the
+// working directory, or a directory of the builder's own under os.TempDir
+// when the workspace is read-only, so that builders sharing a process or a
+// temporary directory never overwrite each other's files
+func (b *Builder) outputDir() (string, error) {
+	if !b.config.WorkspaceReadOnly {
+		return b.workDir(), nil
+	}
+	if b.outputPath == "" {
+		dir, err := os.MkdirTemp("", "build-output-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create output directory: %w", err)
+		}
+		b.outputPath = dir
+	}
+	return b.outputPath, nil
+}
+
+// fileManifestPath returns where the file manifest of the built image is
+// written, outside of a read-only workspace
+func (b *Builder) fileManifestPath() (string, error) {
+	dir, err := b.outputDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "file-manifest.jsonl"), nil
+}
+
+// sbomDir returns the directory the SBOM and the image archive it is
+// generated from are written to, outside of a read-only workspace
+func (b *Builder) sbomDir() (string, error) {
+	dir, err := b.outputDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sbom"), nil
+}
+
+// generateSBOM exports the built image to an OCI archive and generates its
+// CycloneDX SBOM with syft, returning the SBOM path. The archive is removed.
+func (b *Builder) generateSBOM(ctx context.Context) (string, error) {
+	dir, err := b.sbomDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create SBOM directory: %w", err)
+	}
+
+	archivePath := filepath.Join(dir, "image.tar")
+	defer func() { _ = os.Remove(archivePath) }()
+	if err := image.ExportOCIArchive(ctx, b.config.ImageURL, archivePath, dir, b.layerCacheRoot(), b.unshareIDMaps, b.runner); err != nil {
+		return "", err
+	}
+
+	b.logger.Info("Generating SBOM with syft", zap.String("archive", archivePath))
+	return image.GenerateSyftSBOM(ctx, archivePath, b.runner)
+}
+
+// layerCacheRoot returns the buildah storage root of the image in the layer
+// cache, empty when there is no layer cache
+func (b *Builder) layerCacheRoot() string {
+	if b.config.LayerCacheDir == "" {
+		return ""
+	}
+	return image.LayerCacheRoot(b.config.LayerCacheDir, b.config.ImageURL)
+}
+
+// sourcePath returns the location of the source tree, which is SourcePath
+// for a local source and the clone in the workspace otherwise
+func (b *Builder) sourcePath() string {
+	if b.config.SourceMode == SourceModeLocal {
+		return b.config.SourcePath
+	}
+	return filepath.Join(b.workDir(), "source")
+}
+
+// prefetchOutputPath returns the cachi2 output directory
+func (b *Builder) prefetchOutputPath() string {
+	return filepath.Join(b.workDir(), "cachi2", "output")
+}
+
+// scratchDir returns the private directory for authentication material and
+// generated config files, so builders sharing a process never share a HOME
+func (b *Builder) scratchDir() string {
+	return filepath.Join(b.workDir(), ".scratch")
+}
+
+// overrideDockerfileFrom writes a copy of the Dockerfile whose first FROM
+// instruction uses DockerfileFrom to a temporary file and returns its path
+func (b *Builder) overrideDockerfileFrom() (string, error) {
+	content, err := b.dockerfileContent()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp("", "Dockerfile-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create overridden Dockerfile: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	if _, err := file.Write(content); err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write overridden Dockerfile: %w", err)
+	}
+
+	b.logger.Info("Overriding the base image of the first FROM instruction",
+		zap.String("base_image", b.config.DockerfileFrom),
+		zap.String("dockerfile", file.Name()))
+	return file.Name(), nil
+}
+
+// dockerfilePath returns the location of the Dockerfile in the cloned source
+// dockerfileContent returns the Dockerfile as it is built, its first FROM
+// instruction using DockerfileFrom when set
+func (b *Builder) dockerfileContent() ([]byte, error) {
+	content, err := os.ReadFile(b.dockerfilePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+	if b.config.DockerfileFrom == "" {
+		return content, nil
+	}
+	content, err = image.OverrideFirstFrom(content, b.config.DockerfileFrom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to override the base image: %w", err)
+	}
+	return content, nil
+}
+
+// parseDockerfile parses the Dockerfile as it is built, so that the checks
+// see the base image of DockerfileFrom
+func (b *Builder) parseDockerfile() ([]image.Instruction, error) {
+	content, err := b.dockerfileContent()
+	if err != nil {
+		return nil, err
+	}
+	return image.ParseDockerfile(bytes.NewReader(content))
+}
+
+func (b *Builder) dockerfilePath() string {
+	if filepath.IsAbs(b.config.Dockerfile) {
+		return b.config.Dockerfile
+	}
+	return filepath.Join(b.sourcePath(), b.config.Dockerfile)
+}
+
+// writeMetrics writes the BUILD_METRICS result for the Konflux metrics exporter
+func (b *Builder) writeMetrics(state *State) error {
+	m := metrics.New()
+	m.SetSeconds(metrics.KeyCloneSeconds, state.StepDurations["clone"])
+	m.SetSeconds(metrics.KeyPrefetchSeconds, state.StepDurations["prefetch"])
+
+	var buildResult image.BuildResult
+	if state.BuildResult != nil {
+		buildResult = *state.BuildResult
+	}
+	m.SetSeconds(metrics.KeyBuildSeconds, buildResult.BuildDuration)
+	m.SetSeconds(metrics.KeyPushSeconds, buildResult.PushDuration)
+	m.SetInt(metrics.KeyImageSizeBytes, buildResult.ImageSize)
+	m.SetInt(metrics.KeyRetriesTotal, int64(state.Retries+b.registryRetries.Retries))
+	m.SetInt(metrics.KeyRateLimited, int64(b.registryRetries.RateLimited))
+	m.SetSeconds(metrics.KeyBackoffSeconds, b.registryRetries.Backoff)
+	m.SetBool(metrics.KeySkipped, !state.ShouldBuild)
+
+	formatted, err := m.Format()
+	if err != nil {
+		b.logger.Warn("Skipping BUILD_METRICS result", zap.Error(err))
+		return nil
+	}
+	if err := b.writeResult("BUILD_METRICS", formatted); err != nil {
+		return fmt.Errorf("failed to write BUILD_METRICS result: %w", err)
+	}
+	return nil
+}
+
+// writeChecks writes the collected check findings as the CHECKS result
+func (b *Builder) writeChecks(state *State) error {
+	checks, err := json.Marshal(state.Checks)
+	if err != nil {
+		return fmt.Errorf("failed to encode CHECKS result: %w", err)
+	}
+	if err := b.writeResult("CHECKS", string(checks)); err != nil {
+		return fmt.Errorf("failed to write CHECKS result: %w", err)
+	}
+	return nil
+}
+
+// writeWarnings writes the WARNINGS result and the full list of warnings to
+// warnings.json in the workspace. Failures are only logged since it runs
+// after the build has succeeded or failed. Clone-only runs keep to the git
+// results unless warnings were met.
+func (b *Builder) writeWarnings(state *State) {
+	if b.config.CloneOnly && len(state.Warnings.Warnings()) == 0 {
+		return
+	}
+
+	result, err := state.Warnings.Result()
+	if err != nil {
+		b.logger.Warn("Skipping WARNINGS result", zap.Error(err))
+		return
+	}
+	if err := b.writeResult("WARNINGS", result); err != nil {
+		b.logger.Warn("Failed to write WARNINGS result", zap.Error(err))
+	}
+
+	if b.config.WorkspaceReadOnly {
+		return
+	}
+	full, err := state.Warnings.JSON()
+	if err != nil {
+		b.logger.Warn("Skipping warnings file", zap.Error(err))
+		return
+	}
+	if err := os.WriteFile(b.warningsPath(), full, 0644); err != nil {
+		b.logger.Warn("Failed to write warnings file", zap.Error(err))
+	}
+}
+
+// warningsPath returns where the full list of warnings is written
+func (b *Builder) warningsPath() string {
+	return filepath.Join(b.workDir(), "warnings.json")
+}
+
+// resultImageURL returns the IMAGE_URL result value, which is the bare
+// repository when the image is pushed by digest only
+func (b *Builder) resultImageURL() string {
+	if b.config.PushByDigestOnly {
+		return image.Repository(b.config.ImageURL)
+	}
+	return b.config.ImageURL
+}
+
+// tlsVerify returns whether TLS is verified for the target image's registry
+func (b *Builder) tlsVerify() bool {
+	return image.EffectiveTLSVerify(b.config.ImageURL, b.config.TLSVerify, b.config.InsecureRegistries)
 }
 
 // getExistingImageDigest retrieves the digest of an existing image from the registry
 func (b *Builder) getExistingImageDigest(ctx context.Context) (string, error) {
-	return image.GetImageDigest(ctx, b.config.ImageURL, b.config.TLSVerify, b.runner)
+	return b.registry().ManifestDigest(ctx, b.config.ImageURL)
+}
+
+// templateTag renders the additional tag of TagTemplate for the cloned
+// commit, empty when no template is configured
+func (b *Builder) templateTag(cloneResult *git.CloneResult) (string, error) {
+	if b.config.TagTemplate == "" {
+		return "", nil
+	}
+
+	tmpl, err := image.ParseTagTemplate(b.config.TagTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid TAG_TEMPLATE: %w", err)
+	}
+
+	var ref, sha string
+	if cloneResult != nil {
+		ref, sha = cloneResult.Ref, cloneResult.CommitSHA
+	}
+	tag, err := image.RenderTag(tmpl, image.NewTagTemplateData(ref, sha))
+	if err != nil {
+		return "", fmt.Errorf("failed to render TAG_TEMPLATE: %w", err)
+	}
+	return tag, nil
+}
+
+// registry returns a registry client honouring the configured TLS settings
+func (b *Builder) registry() *image.RegistryClient {
+	return image.NewRegistryClient(b.runner, image.RegistryOptions{
+		TLSVerify:          b.config.TLSVerify,
+		InsecureRegistries: b.config.InsecureRegistries,
+	})
 }
@@ -0,0 +1,189 @@
+package buildcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+// contextInspectingRunner records the Dockerfile found in the build context when unshare runs
+type contextInspectingRunner struct {
+	*exec.MockCommandRunner
+	buildContext string
+	dockerfile   string
+}
+
+func (r *contextInspectingRunner) Run(ctx context.Context, name string, args ...string) error {
+	if name == "unshare" {
+		for i, arg := range args {
+			if arg == "-w" && i+1 < len(args) {
+				r.buildContext = args[i+1]
+				content, _ := os.ReadFile(filepath.Join(r.buildContext, "Dockerfile"))
+				r.dockerfile = string(content)
+			}
+		}
+	}
+	return r.MockCommandRunner.Run(ctx, name, args...)
+}
+
+var _ = Describe("Builder", func() {
+	var (
+		ctx       context.Context
+		runner    *contextInspectingRunner
+		config    *Config
+		builder   *Builder
+		sourceDir string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		runner = &contextInspectingRunner{MockCommandRunner: exec.NewMockCommandRunner()}
+		runner.DefaultOutput = []byte(`{"Digest": "sha256:built"}`)
+		config = &Config{
+			ImageURL:      "quay.io/test/image:tag",
+			Dockerfile:    "./Dockerfile",
+			TLSVerify:     true,
+			WorkspacePath: GinkgoT().TempDir(),
+			ResultsPath:   GinkgoT().TempDir(),
+		}
+		builder = NewBuilder(zap.NewNop(), config, runner)
+
+		sourceDir = filepath.Join(config.WorkspacePath, "source")
+		Expect(os.MkdirAll(sourceDir, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(sourceDir, "Dockerfile"), []byte("FROM scratch\n"), 0644)).To(Succeed())
+	})
+
+	Describe("buildContainerImage", func() {
+		It("should build directly from the workspace source by default", func() {
+			_, err := builder.buildContainerImage(ctx, "abc123", nil, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.buildContext).To(Equal(sourceDir))
+		})
+
+		It("should build from a temporary copy of a read-only workspace", func() {
+			config.WorkspaceReadOnly = true
+
+			_, err := builder.buildContainerImage(ctx, "abc123", nil, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.buildContext).NotTo(Equal(sourceDir))
+			Expect(filepath.Base(runner.buildContext)).To(HavePrefix("build-context-"))
+			Expect(runner.dockerfile).To(Equal("FROM scratch\n"))
+
+			// The temporary copy is removed once the build is done
+			Expect(runner.buildContext).NotTo(BeADirectory())
+		})
+	})
+
+	Describe("fileManifestPath", func() {
+		It("should write the file manifest to the working directory by default", func() {
+			path, err := builder.fileManifestPath()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(config.WorkspacePath, "file-manifest.jsonl")))
+		})
+
+		It("should give each builder of a read-only workspace its own file manifest", func() {
+			config.WorkspaceReadOnly = true
+			other := NewBuilder(zap.NewNop(), config, runner)
+
+			path, err := builder.fileManifestPath()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, filepath.Dir(path))
+			otherPath, err := other.fileManifestPath()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, filepath.Dir(otherPath))
+
+			Expect(path).To(HavePrefix(os.TempDir()))
+			Expect(filepath.Dir(path)).To(BeADirectory())
+			Expect(otherPath).NotTo(Equal(path))
+			Expect(builder.fileManifestPath()).To(Equal(path))
+		})
+	})
+
+	Describe("sbomDir", func() {
+		It("should write the SBOM to the working directory by default", func() {
+			Expect(builder.sbomDir()).To(Equal(filepath.Join(config.WorkspacePath, "sbom")))
+		})
+
+		It("should give each builder of a read-only workspace its own SBOM directory", func() {
+			config.WorkspaceReadOnly = true
+			other := NewBuilder(zap.NewNop(), config, runner)
+
+			dir, err := builder.sbomDir()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, filepath.Dir(dir))
+			otherDir, err := other.sbomDir()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, filepath.Dir(otherDir))
+
+			Expect(dir).To(HavePrefix(os.TempDir()))
+			Expect(otherDir).NotTo(Equal(dir))
+		})
+	})
+
+	Describe("concurrent builders", func() {
+		It("should keep sources and results of builders sharing a workspace apart", func() {
+			type instance struct {
+				builder   *Builder
+				config    *Config
+				commitSHA string
+				manifest  []byte
+			}
+
+			workspace := GinkgoT().TempDir()
+			var instances []*instance
+			for _, name := range []string{"a", "b"} {
+				repoDir := GinkgoT().TempDir()
+				commitSHA := newFixtureRepo(repoDir)
+				imageURL := "quay.io/test/" + name + ":tag"
+				manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[],"annotations":{"name":"` + name + `"}}`)
+
+				mockRunner := exec.NewMockCommandRunner()
+				mockRunner.SetOutput("skopeo", manifest, "inspect", "--raw", "docker://"+imageURL)
+
+				instanceConfig := &Config{
+					GitURL:           repoDir,
+					ImageURL:         imageURL,
+					Dockerfile:       "./Dockerfile",
+					TLSVerify:        true,
+					WorkspacePath:    workspace,
+					WorkspaceSubPath: name,
+					ResultsPath:      GinkgoT().TempDir(),
+				}
+				instances = append(instances, &instance{
+					builder:   NewBuilder(zap.NewNop(), instanceConfig, mockRunner),
+					config:    instanceConfig,
+					commitSHA: commitSHA,
+					manifest:  manifest,
+				})
+			}
+
+			done := make(chan error, len(instances))
+			for _, inst := range instances {
+				go func(inst *instance) { done <- inst.builder.Execute(ctx) }(inst)
+			}
+			for range instances {
+				Expect(<-done).To(Succeed())
+			}
+
+			for _, inst := range instances {
+				expected, err := image.ParseManifest(inst.manifest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readResult(inst.config.ResultsPath, "commit")).To(Equal(inst.commitSHA))
+				Expect(readResult(inst.config.ResultsPath, "url")).To(Equal(inst.config.GitURL))
+				Expect(readResult(inst.config.ResultsPath, "IMAGE_URL")).To(Equal(inst.config.ImageURL))
+				Expect(readResult(inst.config.ResultsPath, "IMAGE_DIGEST")).To(Equal(expected.Digest))
+				Expect(filepath.Join(workspace, inst.config.WorkspaceSubPath, "source", "Dockerfile")).To(BeAnExistingFile())
+			}
+		})
+	})
+})
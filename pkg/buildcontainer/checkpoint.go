@@ -0,0 +1,134 @@
+package buildcontainer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/git"
+	"go.uber.org/zap"
+)
+
+// checkpointFile is the name of the checkpoint file in the builder's workspace directory
+const checkpointFile = ".checkpoint.json"
+
+// Checkpoint records the steps completed by a previous run together with
+// content hashes proving their output is still intact
+type Checkpoint struct {
+	// GitURL, GitRevision and GitRefspec identify the cloned source
+	GitURL      string `json:"gitURL"`
+	GitRevision string `json:"gitRevision,omitempty"`
+	GitRefspec  string `json:"gitRefspec,omitempty"`
+
+	// CloneResult is the result of the completed clone step
+	CloneResult *git.CloneResult `json:"cloneResult"`
+
+	// SourceHash is the hash of the source tree after the last completed step
+	SourceHash string `json:"sourceHash"`
+
+	// PrefetchKey identifies the inputs of the completed prefetch step
+	PrefetchKey string `json:"prefetchKey,omitempty"`
+
+	// PrefetchHash is the hash of the prefetch output
+	PrefetchHash string `json:"prefetchHash,omitempty"`
+}
+
+// checkpointPath returns the location of the checkpoint file
+func (b *Builder) checkpointPath() string {
+	return filepath.Join(b.workDir(), checkpointFile)
+}
+
+// loadCheckpoint returns the checkpoint of a previous run if it is still
+// valid. A stale checkpoint is removed so the run starts clean.
+func (b *Builder) loadCheckpoint() *Checkpoint {
+	data, err := os.ReadFile(b.checkpointPath())
+	if errors.Is(err, os.ErrNotExist) {
+		b.logger.Info("No checkpoint found, starting a clean run")
+		return nil
+	}
+
+	var checkpoint Checkpoint
+	if err == nil {
+		err = json.Unmarshal(data, &checkpoint)
+	}
+	if err == nil {
+		err = b.validateCheckpoint(&checkpoint)
+	}
+	if err != nil {
+		b.logger.Info("Discarding checkpoint, starting a clean run", zap.String("reason", err.Error()))
+		if err := os.Remove(b.checkpointPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			b.logger.Warn("Failed to remove stale checkpoint", zap.Error(err))
+		}
+		return nil
+	}
+
+	b.logger.Info("Resuming from checkpoint",
+		zap.String("commit_sha", checkpoint.CloneResult.CommitSHA),
+		zap.Bool("prefetch_completed", checkpoint.PrefetchKey != ""))
+	return &checkpoint
+}
+
+// validateCheckpoint checks that a checkpoint matches the configuration and
+// that the workspace content it describes is unchanged
+func (b *Builder) validateCheckpoint(checkpoint *Checkpoint) error {
+	if checkpoint.CloneResult == nil {
+		return fmt.Errorf("checkpoint has no completed clone")
+	}
+	if checkpoint.GitURL != b.config.GitURL || checkpoint.GitRevision != b.config.GitRevision ||
+		checkpoint.GitRefspec != b.config.GitRefspec {
+		return fmt.Errorf("git source configuration changed")
+	}
+
+	sourceHash, err := hashTree(b.sourcePath(), ".git")
+	if err != nil {
+		return fmt.Errorf("failed to hash source tree: %w", err)
+	}
+	if sourceHash != checkpoint.SourceHash {
+		return fmt.Errorf("source tree changed since the checkpoint")
+	}
+
+	if checkpoint.PrefetchKey != "" {
+		if checkpoint.PrefetchKey != b.prefetchKey() {
+			return fmt.Errorf("prefetch configuration changed")
+		}
+		prefetchHash, err := hashTree(b.prefetchOutputPath())
+		if err != nil {
+			return fmt.Errorf("failed to hash prefetch output: %w", err)
+		}
+		if prefetchHash != checkpoint.PrefetchHash {
+			return fmt.Errorf("prefetch output changed since the checkpoint")
+		}
+	}
+
+	return nil
+}
+
+// saveCheckpoint refreshes the source hash and writes the checkpoint
+func (b *Builder) saveCheckpoint(checkpoint *Checkpoint) error {
+	sourceHash, err := hashTree(b.sourcePath(), ".git")
+	if err != nil {
+		return fmt.Errorf("failed to hash source tree: %w", err)
+	}
+	checkpoint.SourceHash = sourceHash
+
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	if err := os.WriteFile(b.checkpointPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// prefetchKey identifies the configuration the prefetch output depends on
+func (b *Builder) prefetchKey() string {
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%s\x00%t\x00%s", b.config.PrefetchInput, b.config.DevPackageManagers,
+		b.config.Cachi2ConfigFileContent)
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,144 @@
+package buildcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Checkpoint", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *Config
+		builder    *Builder
+		repoDir    string
+		commitSHA  string
+	)
+
+	// readCheckpoint reads the checkpoint written by the builder
+	readCheckpoint := func() *Checkpoint {
+		data, err := os.ReadFile(builder.checkpointPath())
+		Expect(err).NotTo(HaveOccurred())
+		var checkpoint Checkpoint
+		Expect(json.Unmarshal(data, &checkpoint)).To(Succeed())
+		return &checkpoint
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		repoDir = GinkgoT().TempDir()
+		commitSHA = newFixtureRepo(repoDir)
+		config = &Config{
+			GitURL:        repoDir,
+			ImageURL:      "quay.io/test/image:tag",
+			Dockerfile:    "./Dockerfile",
+			TLSVerify:     true,
+			WorkspacePath: GinkgoT().TempDir(),
+			ResultsPath:   GinkgoT().TempDir(),
+			Resume:        true,
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+	})
+
+	It("should not write a checkpoint unless resuming is enabled", func() {
+		config.Resume = false
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(builder.checkpointPath()).NotTo(BeAnExistingFile())
+	})
+
+	It("should record the completed clone", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		checkpoint := readCheckpoint()
+		Expect(checkpoint.GitURL).To(Equal(repoDir))
+		Expect(checkpoint.CloneResult.CommitSHA).To(Equal(commitSHA))
+		Expect(checkpoint.SourceHash).To(HavePrefix("sha256:"))
+		Expect(checkpoint.PrefetchKey).To(BeEmpty())
+	})
+
+	It("should skip the clone when resuming from a valid checkpoint", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		// The repository is gone, so a second clone would fail
+		Expect(os.RemoveAll(repoDir)).To(Succeed())
+		config.ResultsPath = GinkgoT().TempDir()
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(readResult(config.ResultsPath, "commit")).To(Equal(commitSHA))
+		Expect(readResult(config.ResultsPath, "url")).To(Equal(repoDir))
+	})
+
+	It("should fall back to a clean run when the source changed", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		dockerfile := filepath.Join(builder.sourcePath(), "Dockerfile")
+		Expect(os.WriteFile(dockerfile, []byte("FROM tampered\n"), 0644)).To(Succeed())
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		content, err := os.ReadFile(dockerfile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("FROM scratch\n"))
+		Expect(readCheckpoint().CloneResult.CommitSHA).To(Equal(commitSHA))
+	})
+
+	It("should discard a checkpoint for a different git source", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		config.GitRevision = "other"
+
+		Expect(builder.loadCheckpoint()).To(BeNil())
+		Expect(builder.checkpointPath()).NotTo(BeAnExistingFile())
+	})
+
+	Describe("prefetch", func() {
+		var checkpoint *Checkpoint
+
+		BeforeEach(func() {
+			config.PrefetchInput = "gomod"
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(os.MkdirAll(builder.prefetchOutputPath(), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(builder.prefetchOutputPath(), "deps.json"), []byte("{}"), 0644)).To(Succeed())
+			prefetchHash, err := hashTree(builder.prefetchOutputPath())
+			Expect(err).NotTo(HaveOccurred())
+
+			checkpoint = readCheckpoint()
+			checkpoint.PrefetchKey = builder.prefetchKey()
+			checkpoint.PrefetchHash = prefetchHash
+			Expect(builder.saveCheckpoint(checkpoint)).To(Succeed())
+		})
+
+		It("should skip a prefetch recorded in a valid checkpoint", func() {
+			state := &State{ShouldBuild: true, Checkpoint: builder.loadCheckpoint()}
+			Expect(state.Checkpoint).NotTo(BeNil())
+
+			// cachi2 isn't available here, so running the prefetch would fail
+			Expect((&prefetchStep{b: builder}).Run(ctx, state)).To(Succeed())
+		})
+
+		It("should discard the checkpoint when the prefetch input changed", func() {
+			config.PrefetchInput = "pip"
+
+			Expect(builder.validateCheckpoint(checkpoint)).To(MatchError("prefetch configuration changed"))
+		})
+
+		It("should discard the checkpoint when the prefetch output changed", func() {
+			Expect(os.WriteFile(filepath.Join(builder.prefetchOutputPath(), "deps.json"), []byte("[]"), 0644)).To(Succeed())
+
+			Expect(builder.validateCheckpoint(checkpoint)).To(MatchError("prefetch output changed since the checkpoint"))
+		})
+	})
+})
@@ -1,12 +1,40 @@
 package buildcontainer
 
 import (
-	"os"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/duration"
+	"github.com/konflux-ci/monolithic-builder/pkg/envconfig"
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"github.com/konflux-ci/monolithic-builder/pkg/prefetch"
+	"github.com/konflux-ci/monolithic-builder/pkg/provenance"
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
+	"github.com/konflux-ci/monolithic-builder/pkg/results"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
+)
+
+// Source modes selecting where the source tree comes from
+const (
+	// SourceModeGit clones GIT_URL into the workspace
+	SourceModeGit = "git"
+
+	// SourceModeLocal builds an existing checkout at SOURCE_PATH without cloning
+	SourceModeLocal = "local"
 )
 
 // Config holds all configuration parameters for the monolithic build-container task
 type Config struct {
+	// Source configuration
+	SourceMode       string
+	SourcePath       string
+	AllowDirtySource bool
+
 	// Git configuration
 	GitURL        string
 	GitRevision   string
@@ -14,15 +42,130 @@ type Config struct {
 	GitDepth      int
 	GitSubmodules bool
 
+	// GitRef is the branch or tag GitRevision was resolved from. It labels
+	// the image and is available to TagTemplate.
+	GitRef string
+
+	// TagTemplate is a text/template deriving an additional tag of the built
+	// image from .Ref, .SHA and .ShortSHA, e.g. "{{.Ref}}-{{.ShortSHA}}"
+	TagTemplate string
+
+	// GitSparseCheckout and GitExcludePaths restrict the checkout to the
+	// given patterns and leave out the given paths, e.g. generated/
+	GitSparseCheckout []string
+	GitExcludePaths   []string
+
+	// PatchPath is a directory of *.patch files applied after cloning
+	PatchPath string
+
+	// WriteCommitTitle writes the first line of the commit message to the
+	// commit_title result for dashboards
+	WriteCommitTitle bool
+
+	// VerifyTagSignature fails the clone when GitRevision is a tag that
+	// isn't signed by a key of the armored keyring at TagSigningKeyPath
+	VerifyTagSignature bool
+	TagSigningKeyPath  string
+
 	// Image configuration
-	ImageURL          string
-	Dockerfile        string
-	Context           string
-	Rebuild           bool
-	SkipChecks        bool
+	ImageURL   string
+	Dockerfile string
+	Context    string
+	Rebuild    bool
+	SkipChecks bool
+
+	// RebuildOnBaseChange rebuilds an existing image when the current digest
+	// of its recorded base image differs, writing BASE_IMAGE_CHANGED
+	RebuildOnBaseChange bool
+
 	Hermetic          bool
 	TLSVerify         bool
 	ImageExpiresAfter string
+	PushByDigestOnly  bool
+	BaseImagePolicy   *image.BaseImagePolicy
+
+	// LabelFile is where the labels of the pushed image are written as
+	// key=value lines, for pipelines verifying the expected labels
+	LabelFile string
+
+	// DockerfileFrom replaces the image of the first FROM instruction, e.g.
+	// to test a build against a patched base image. The Dockerfile handed to
+	// buildah is a rewritten copy, the source is left untouched.
+	DockerfileFrom string
+
+	// ExistenceCheckTags lists additional tags, or full references, checked
+	// in order after ImageURL. The first existing one skips the build and is
+	// copied to ImageURL, easing migrations between tag naming schemes.
+	ExistenceCheckTags []string
+
+	// RemoteSourceAllowlist is a regular expression the URLs fetched by ADD
+	// and COPY must match in non-hermetic builds. Hermetic builds reject
+	// every remote source since their network is cut.
+	RemoteSourceAllowlist string
+
+	// InsecureRegistries lists registry hosts reached without TLS
+	// verification, e.g. plain HTTP dev registries
+	InsecureRegistries []string
+
+	// AutoInsecureLocalRegistries adds the loopback and in-cluster registries
+	// of ImageURL and ExistenceCheckTags to InsecureRegistries
+	AutoInsecureLocalRegistries bool
+
+	// ImageRegistryMirrors maps source registries to the mirror registry the
+	// build pulls their images from, e.g. {"docker.io": "mirror.example.com"}
+	ImageRegistryMirrors map[string]string
+
+	// AuthFile and CertDir configure registry access for pulling base images
+	// ahead of a hermetic build
+	AuthFile string
+	CertDir  string
+
+	// RegistryLogin logs in to the registry of ImageURL before it is
+	// inspected or pushed, for registries that need a login or a token
+	// exchange rather than a static authfile. RegistryType selects how the
+	// password is obtained: from the username and password files of
+	// RegistryCredentialsPath, or from the aws CLI for ecr.
+	RegistryLogin           bool
+	RegistryType            string
+	RegistryCredentialsPath string
+
+	// PreflightPushCheck verifies that the credentials can push to ImageURL
+	// before the clone, by pushing an empty manifest list to a <tag>-preflight
+	// tag and deleting it
+	PreflightPushCheck bool
+
+	// VerifyImageID warns when the pushed image ID differs from the built one
+	VerifyImageID bool
+
+	// RequireDigest fails the build when the image digest can't be retrieved
+	// instead of writing an empty IMAGE_DIGEST result
+	RequireDigest bool
+
+	// AtomicTag pushes to a temporary <tag>-<RunID> tag and retags the pushed
+	// digest, so concurrent builds of the same tag report their own digest
+	AtomicTag bool
+	RunID     string
+
+	// TagDeletion is the tag deletion API removing temporary tags, none or
+	// quay. Quay authenticates with the OAuth token in QuayTokenPath.
+	TagDeletion   string
+	QuayTokenPath string
+
+	// MaxLayers and MaxHistory fail the build when the built image has more
+	// layers or history entries. Zero disables the check.
+	MaxLayers  int
+	MaxHistory int
+
+	// MaxLayerCount fails the build when the pushed image has more layers
+	// according to the registry. Zero disables the check.
+	MaxLayerCount int
+
+	// FileManifest writes a manifest of the files of the built image to the
+	// workspace. Files matching FileDenyPatterns produce warnings, or fail
+	// the build when FileDenyAction is fail.
+	FileManifest     bool
+	FileDenyPatterns []string
+	FileDenyAction   string
 
 	// Prefetch configuration
 	PrefetchInput           string
@@ -30,18 +173,146 @@ type Config struct {
 	Cachi2LogLevel          string
 	Cachi2ConfigFileContent string
 
+	// PrefetchDryRunCheck validates with cachi2 check-deps that the declared
+	// dependencies resolve before fetching them
+	PrefetchDryRunCheck bool
+
+	// PrefetchEnvFormat is the format of the cachi2 environment file. With
+	// json, its variables are passed to a hermetic build with --env.
+	PrefetchEnvFormat string
+
+	// Cachi2OutputDirForEnv is the path of the prefetched dependencies within
+	// the build, which the cachi2 environment points to
+	Cachi2OutputDirForEnv string
+
+	// InjectCachi2Env passes the variables of the cachi2 environment, such as
+	// GOPROXY or PIP_INDEX_URL, as build arguments. BuildArgs take precedence.
+	InjectCachi2Env bool
+
 	// Build configuration
 	BuildArgs     []string
 	BuildArgsFile string
 	CommitSHA     string
 
+	// IgnoreFile is a custom ignore file used instead of .containerignore
+	// or .dockerignore
+	IgnoreFile string
+
+	// NetworkMode restricts the network of a non-hermetic build: open or
+	// none. Proxy-only is rejected, see image.NetworkModeProxyOnly.
+	NetworkMode string
+
+	// StorageDriver selects the containers/storage driver of every buildah
+	// invocation, e.g. overlay or vfs
+	StorageDriver string
+
+	// UnshareUIDMap and UnshareGIDMap override the outer,inner,count mappings
+	// of unshare, detected from /etc/subuid and /etc/subgid by default
+	UnshareUIDMap string
+	UnshareGIDMap string
+
+	// LayerCacheDir keeps a buildah storage root per repository between
+	// runs, typically on the workspace volume, pruned down to
+	// LayerCacheMaxSize bytes after the build. Zero disables pruning.
+	LayerCacheDir     string
+	LayerCacheMaxSize int64
+
+	// ImageConfigExpectations fails the build before pushing when the
+	// entrypoint, exposed ports or user of the built image differ from
+	// EXPECT_ENTRYPOINT, EXPECT_EXPOSED_PORTS or EXPECT_USER
+	ImageConfigExpectations *image.ImageConfigExpectations
+
+	// EnableBuildCache mounts BuildCacheDir into the build as a cache
+	// volume and uses it as the --cache-from source of a --layers build
+	EnableBuildCache bool
+	BuildCacheDir    string
+
+	// Platform is the os/arch[/variant] to build for, the pushed image
+	// failing the build when it doesn't match
+	Platform string
+
+	// UserNS sets the buildah user namespace, with the UID and GID maps used
+	// when it is auto
+	UserNS       string
+	UserNSUIDMap string
+	UserNSGIDMap string
+
 	// Workspace paths
-	WorkspacePath string
-	ResultsPath   string
+	WorkspacePath     string
+	WorkspaceSubPath  string
+	ResultsPath       string
+	WorkspaceReadOnly bool
+
+	// TempDir is where the container tools stage temporary files such as
+	// blobs, relative to the workspace unless absolute. The builder creates a
+	// directory there, propagated as TMPDIR and removed at the end.
+	TempDir string
 
 	// Authentication
 	GitAuthPath string
 	NetrcPath   string
+
+	// PackageManagerCredsPath holds the pip and npm credentials of the prefetch
+	PackageManagerCredsPath string
+
+	// ToolVersionLabels labels the image with the buildah and skopeo versions used
+	ToolVersionLabels bool
+
+	// UseCommitTimestamp sets the image creation time to the commit
+	// timestamp for reproducible builds
+	UseCommitTimestamp bool
+
+	// EmitProvenancePredicate writes a SLSA provenance predicate as the
+	// PREDICATE result, in the provenance.VersionSLSA02 or VersionSLSA10
+	// format selected by ProvenancePredicateType
+	EmitProvenancePredicate bool
+	ProvenancePredicateType string
+
+	// EmitEffectiveBuildArgs writes the resolved build arguments with their
+	// source as the BUILD_ARGS_EFFECTIVE result, secret-looking values redacted
+	EmitEffectiveBuildArgs bool
+
+	// GenerateSBOM scans the built image with syft after the push, writing a
+	// CycloneDX SBOM whose path is the SBOM_PATH result
+	GenerateSBOM bool
+
+	// Resume enables the workspace checkpoint, letting a restarted run skip
+	// the clone and prefetch steps completed by a previous run
+	Resume bool
+
+	// Deadline is the time budget of the whole build, zero for none. Steps
+	// listed in StepBudgets get at most their percentage of it; once it is
+	// exhausted the remaining steps fail and a TIMEOUT result is written.
+	Deadline    time.Duration
+	StepBudgets map[string]int
+
+	// FailureGracePeriod bounds the flush of the results gathered so far,
+	// with FAILED_STEP and ERROR_CATEGORY, when a step fails
+	FailureGracePeriod time.Duration
+
+	// CloneOnly stops the build once the git results are written, leaving
+	// prefetch and build to a later task
+	CloneOnly bool
+
+	// SkipGitClone builds the source a previous task cloned into the
+	// workspace, reading its commit from the commit result in ResultsPath
+	SkipGitClone bool
+
+	// CleanupTempTags deletes the tags of the target repository matching
+	// TempTagPattern pushed longer than TempTagTTL ago, at most
+	// TempTagCleanupMax of them per run, with the API of TagDeletion.
+	// TempTagPattern captures the push time of the tags in a pushed group.
+	CleanupTempTags   bool
+	TempTagPattern    string
+	TempTagTTL        time.Duration
+	TempTagCleanupMax int
+
+	// StrictWarnings lists the warning categories that fail the build
+	// instead of only being reported in the WARNINGS result
+	StrictWarnings []string
+
+	// Debugging
+	DebugConfig bool
 }
 
 // LoadConfigFromEnv loads configuration from environment variables
@@ -49,9 +320,19 @@ func LoadConfigFromEnv() (*Config, error) {
 	return LoadConfig(nil)
 }
 
-// LoadConfig loads configuration from environment variables and optional build args
+// LoadConfig loads configuration from the params files or environment
+// variables and optional build args
 func LoadConfig(buildArgs []string) (*Config, error) {
+	if err := envconfig.Validate(); err != nil {
+		return nil, err
+	}
+
 	config := &Config{
+		// Source defaults
+		SourceMode:       getEnv("SOURCE_MODE", SourceModeGit),
+		SourcePath:       getEnv("SOURCE_PATH", ""),
+		AllowDirtySource: getEnvBool("ALLOW_DIRTY_SOURCE", false),
+
 		// Git defaults
 		GitURL:        getEnv("GIT_URL", ""),
 		GitRevision:   getEnv("GIT_REVISION", ""),
@@ -59,48 +340,338 @@ func LoadConfig(buildArgs []string) (*Config, error) {
 		GitDepth:      getEnvInt("GIT_DEPTH", 1),
 		GitSubmodules: getEnvBool("GIT_SUBMODULES", true),
 
+		GitRef:      getEnv("GIT_REF", ""),
+		TagTemplate: getEnv("TAG_TEMPLATE", ""),
+
+		GitSparseCheckout: getEnvList("GIT_SPARSE_CHECKOUT"),
+		GitExcludePaths:   getEnvList("GIT_EXCLUDE_PATHS"),
+
+		PatchPath: getEnv("PATCH_PATH", ""),
+
+		WriteCommitTitle: getEnvBool("WRITE_COMMIT_TITLE", false),
+
+		VerifyTagSignature: getEnvBool("VERIFY_TAG_SIGNATURE", false),
+		TagSigningKeyPath:  getEnv("TAG_SIGNING_KEY_PATH", ""),
+
 		// Image defaults
-		ImageURL:          getEnv("IMAGE_URL", ""),
-		Dockerfile:        getEnv("DOCKERFILE", "./Dockerfile"),
-		Context:           getEnv("CONTEXT", "."),
-		Rebuild:           getEnvBool("REBUILD", false),
-		SkipChecks:        getEnvBool("SKIP_CHECKS", false),
-		Hermetic:          getEnvBool("HERMETIC", false),
-		TLSVerify:         getEnvBool("TLSVERIFY", true),
-		ImageExpiresAfter: getEnv("IMAGE_EXPIRES_AFTER", ""),
+		ImageURL:            getEnv("IMAGE_URL", ""),
+		Dockerfile:          getEnv("DOCKERFILE", "./Dockerfile"),
+		Context:             getEnv("CONTEXT", "."),
+		Rebuild:             getEnvBool("REBUILD", false),
+		SkipChecks:          getEnvBool("SKIP_CHECKS", false),
+		RebuildOnBaseChange: getEnvBool("REBUILD_ON_BASE_CHANGE", false),
+		Hermetic:            getEnvBool("HERMETIC", false),
+		TLSVerify:           getEnvBool("TLSVERIFY", true),
+		ImageExpiresAfter:   getEnv("IMAGE_EXPIRES_AFTER", ""),
+		PushByDigestOnly:    getEnvBool("PUSH_BY_DIGEST", false),
+		DockerfileFrom:      getEnv("DOCKERFILE_FROM_OVERRIDE", ""),
+		LabelFile:           getEnv("LABEL_FILE", ""),
+		MaxLayers:           getEnvInt("MAX_LAYERS", 0),
+		MaxHistory:          getEnvInt("MAX_HISTORY", 0),
+		MaxLayerCount:       getEnvInt("MAX_LAYER_COUNT", 0),
+
+		ExistenceCheckTags: getEnvList("EXISTENCE_CHECK_TAGS"),
+
+		RemoteSourceAllowlist: getEnv("REMOTE_SOURCE_ALLOWLIST", ""),
+
+		InsecureRegistries:          getEnvList("INSECURE_REGISTRIES"),
+		AutoInsecureLocalRegistries: getEnvBool("AUTO_INSECURE_LOCAL_REGISTRIES", true),
+
+		AuthFile: getEnv("REGISTRY_AUTH_FILE", ""),
+		CertDir:  getEnv("CERT_DIR", ""),
+
+		RegistryLogin:           getEnvBool("REGISTRY_LOGIN", false),
+		RegistryType:            getEnv("REGISTRY_TYPE", image.RegistryTypeDefault),
+		RegistryCredentialsPath: getEnv("REGISTRY_CREDENTIALS_PATH", ""),
+
+		VerifyImageID: getEnvBool("VERIFY_IMAGE_ID", false),
+		RequireDigest: getEnvBool("REQUIRE_DIGEST", true),
+
+		AtomicTag: getEnvBool("ATOMIC_TAG", false),
+		RunID:     getEnv("RUN_ID", ""),
+
+		TagDeletion:   getEnv("TAG_DELETION", image.TagDeletionNone),
+		QuayTokenPath: getEnv("QUAY_TOKEN_PATH", ""),
+
+		FileManifest:     getEnvBool("FILE_MANIFEST", false),
+		FileDenyPatterns: getEnvList("FILE_DENY_PATTERNS"),
+		FileDenyAction:   getEnv("FILE_DENY_ACTION", image.FileDenyActionWarn),
 
 		// Prefetch defaults
 		PrefetchInput:           getEnv("PREFETCH_INPUT", ""),
 		DevPackageManagers:      getEnvBool("DEV_PACKAGE_MANAGERS", false),
 		Cachi2LogLevel:          getEnv("LOG_LEVEL", "info"),
+		PrefetchDryRunCheck:     getEnvBool("PREFETCH_DRY_RUN_CHECK", false),
 		Cachi2ConfigFileContent: getEnv("CONFIG_FILE_CONTENT", ""),
 
+		PrefetchEnvFormat:     getEnv("PREFETCH_ENV_FORMAT", prefetch.EnvFormatEnv),
+		Cachi2OutputDirForEnv: getEnv("CACHI2_OUTPUT_DIR_FOR_ENV", prefetch.DefaultOutputDirForEnv),
+		InjectCachi2Env:       getEnvBool("INJECT_CACHI2_ENV", false),
+
 		// Build defaults
 		BuildArgs:     buildArgs,
 		BuildArgsFile: getEnv("BUILD_ARGS_FILE", ""),
 		CommitSHA:     getEnv("COMMIT_SHA", ""),
 
+		IgnoreFile: getEnv("BUILD_IGNORE_FILE", ""),
+
+		NetworkMode: getEnv("BUILD_NETWORK_MODE", image.NetworkModeOpen),
+
+		StorageDriver: getEnv("BUILDAH_STORAGE_DRIVER", ""),
+		UnshareUIDMap: getEnv("UNSHARE_UID_MAP", ""),
+		UnshareGIDMap: getEnv("UNSHARE_GID_MAP", ""),
+
+		LayerCacheDir: getEnv("LAYER_CACHE_DIR", ""),
+
+		EnableBuildCache: getEnvBool("ENABLE_BUILD_CACHE", false),
+		BuildCacheDir:    getEnv("BUILD_CACHE_DIR", ""),
+
+		Platform: getEnv("PLATFORM", ""),
+
+		UserNS:       getEnv("BUILDAH_USERNS", ""),
+		UserNSUIDMap: getEnv("BUILDAH_USERNS_UID_MAP", ""),
+		UserNSGIDMap: getEnv("BUILDAH_USERNS_GID_MAP", ""),
+
 		// Workspace paths
-		WorkspacePath: getEnv("WORKSPACE_PATH", "/workspace"),
-		ResultsPath:   getEnv("RESULTS_PATH", "/tekton/results"),
+		WorkspacePath:     getEnv("WORKSPACE_PATH", "/workspace"),
+		WorkspaceSubPath:  getEnv("WORKSPACE_SUBPATH", ""),
+		WorkspaceReadOnly: getEnvBool("WORKSPACE_READ_ONLY", false),
+		TempDir:           getEnv("BUILD_TMPDIR", ""),
 
 		// Authentication
-		GitAuthPath: getEnv("GIT_AUTH_PATH", ""),
-		NetrcPath:   getEnv("NETRC_PATH", ""),
+		GitAuthPath:             getEnv("GIT_AUTH_PATH", ""),
+		NetrcPath:               getEnv("NETRC_PATH", ""),
+		PackageManagerCredsPath: getEnv("PACKAGE_MANAGER_CREDS_PATH", ""),
+
+		ToolVersionLabels:       getEnvBool("TOOL_VERSION_LABELS", true),
+		UseCommitTimestamp:      getEnvBool("USE_COMMIT_TIMESTAMP", false),
+		EmitProvenancePredicate: getEnvBool("EMIT_PROVENANCE", false),
+		ProvenancePredicateType: getEnv("PROVENANCE_PREDICATE_TYPE", provenance.DefaultVersion),
+		EmitEffectiveBuildArgs:  getEnvBool("EMIT_BUILD_ARGS_EFFECTIVE", false),
+		GenerateSBOM:            getEnvBool("GENERATE_SBOM", false),
+
+		Resume: getEnvBool("RESUME", false),
+
+		CloneOnly:    getEnvBool("CLONE_ONLY", false),
+		SkipGitClone: getEnvBool("SKIP_GIT_CLONE", false),
+
+		PreflightPushCheck: getEnvBool("PREFLIGHT_PUSH_CHECK", false),
+
+		CleanupTempTags:   getEnvBool("CLEANUP_TEMP_TAGS", false),
+		TempTagPattern:    getEnv("TEMP_TAG_PATTERN", image.DefaultTemporaryTagPattern),
+		TempTagCleanupMax: getEnvInt("TEMP_TAG_CLEANUP_MAX", 10),
+
+		// Debugging
+		DebugConfig: getEnvBool("DEBUG_CONFIG", false),
+	}
+
+	if config.FileDenyAction != image.FileDenyActionWarn && config.FileDenyAction != image.FileDenyActionFail {
+		return nil, fmt.Errorf("invalid FILE_DENY_ACTION %q, expected %s or %s",
+			config.FileDenyAction, image.FileDenyActionWarn, image.FileDenyActionFail)
+	}
+	if config.PrefetchEnvFormat != prefetch.EnvFormatEnv && config.PrefetchEnvFormat != prefetch.EnvFormatJSON {
+		return nil, fmt.Errorf("invalid PREFETCH_ENV_FORMAT %q, expected %s or %s",
+			config.PrefetchEnvFormat, prefetch.EnvFormatEnv, prefetch.EnvFormatJSON)
+	}
+	if !filepath.IsAbs(config.Cachi2OutputDirForEnv) {
+		return nil, fmt.Errorf("CACHI2_OUTPUT_DIR_FOR_ENV must be absolute, got %q", config.Cachi2OutputDirForEnv)
+	}
+
+	if err := image.ValidateFilePatterns(config.FileDenyPatterns); err != nil {
+		return nil, fmt.Errorf("invalid FILE_DENY_PATTERNS: %w", err)
+	}
+
+	if err := image.ValidateNetworkMode(config.NetworkMode); err != nil {
+		return nil, fmt.Errorf("invalid BUILD_NETWORK_MODE: %w", err)
 	}
 
+	if config.Platform != "" {
+		if _, err := image.ParsePlatform(config.Platform); err != nil {
+			return nil, fmt.Errorf("invalid PLATFORM: %w", err)
+		}
+	}
+
+	if config.AtomicTag && config.PushByDigestOnly {
+		return nil, fmt.Errorf("ATOMIC_TAG and PUSH_BY_DIGEST are mutually exclusive")
+	}
+
+	switch config.TagDeletion {
+	case image.TagDeletionNone:
+	case image.TagDeletionQuay:
+		if config.QuayTokenPath == "" {
+			return nil, fmt.Errorf("QUAY_TOKEN_PATH is required when TAG_DELETION is %s", image.TagDeletionQuay)
+		}
+	default:
+		return nil, fmt.Errorf("invalid TAG_DELETION %q, expected %s or %s", config.TagDeletion, image.TagDeletionNone, image.TagDeletionQuay)
+	}
+
+	if config.VerifyTagSignature && config.TagSigningKeyPath == "" {
+		return nil, fmt.Errorf("TAG_SIGNING_KEY_PATH is required when VERIFY_TAG_SIGNATURE is set")
+	}
+
+	if config.RegistryLogin {
+		switch config.RegistryType {
+		case image.RegistryTypeDefault:
+			if config.RegistryCredentialsPath == "" {
+				return nil, fmt.Errorf("REGISTRY_CREDENTIALS_PATH is required when REGISTRY_LOGIN is set")
+			}
+		case image.RegistryTypeECR:
+		default:
+			return nil, fmt.Errorf("invalid REGISTRY_TYPE %q, expected %s or %s", config.RegistryType, image.RegistryTypeDefault, image.RegistryTypeECR)
+		}
+	}
+
+	for key, value := range map[string]string{"UNSHARE_UID_MAP": config.UnshareUIDMap, "UNSHARE_GID_MAP": config.UnshareGIDMap} {
+		if value == "" {
+			continue
+		}
+		if _, err := image.ParseIDMap(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if mirrors := getEnv("IMAGE_REGISTRY_MIRRORS", ""); mirrors != "" {
+		if err := json.Unmarshal([]byte(mirrors), &config.ImageRegistryMirrors); err != nil {
+			return nil, fmt.Errorf("invalid IMAGE_REGISTRY_MIRRORS, expected a JSON object of source to mirror registries: %w", err)
+		}
+		for source, mirror := range config.ImageRegistryMirrors {
+			if source == "" || mirror == "" || strings.Contains(source, "://") || strings.Contains(mirror, "://") {
+				return nil, fmt.Errorf("invalid IMAGE_REGISTRY_MIRRORS entry %q: %q, expected registry hosts without scheme", source, mirror)
+			}
+		}
+	}
+
+	if config.AutoInsecureLocalRegistries {
+		refs := append([]string{config.ImageURL}, config.ExistenceCheckTags...)
+		config.InsecureRegistries = image.AddLocalRegistries(config.InsecureRegistries, refs...)
+	}
+
+	if config.TempDir != "" && config.WorkspaceReadOnly && !filepath.IsAbs(config.TempDir) {
+		return nil, fmt.Errorf("BUILD_TMPDIR must be absolute when WORKSPACE_READ_ONLY is set")
+	}
+
+	if err := provenance.ValidateVersion(config.ProvenancePredicateType); err != nil {
+		return nil, fmt.Errorf("invalid PROVENANCE_PREDICATE_TYPE: %w", err)
+	}
+
+	if config.EnableBuildCache && config.BuildCacheDir == "" {
+		return nil, fmt.Errorf("BUILD_CACHE_DIR is required when ENABLE_BUILD_CACHE is set")
+	}
+
+	switch config.SourceMode {
+	case SourceModeGit:
+	case SourceModeLocal:
+		if config.SourcePath == "" {
+			return nil, fmt.Errorf("SOURCE_PATH is required when SOURCE_MODE is %s", SourceModeLocal)
+		}
+		if config.SkipGitClone {
+			return nil, fmt.Errorf("SKIP_GIT_CLONE can't be set when SOURCE_MODE is %s", SourceModeLocal)
+		}
+	default:
+		return nil, fmt.Errorf("invalid SOURCE_MODE %q, expected %s or %s", config.SourceMode, SourceModeGit, SourceModeLocal)
+	}
+
+	baseImagePolicy, err := image.ParseBaseImagePolicy(getEnv("BASE_IMAGE_POLICY", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse BASE_IMAGE_POLICY: %w", err)
+	}
+	config.BaseImagePolicy = baseImagePolicy
+
+	imageConfigExpectations, err := image.ParseImageConfigExpectations(
+		getEnv("EXPECT_ENTRYPOINT", ""), getEnv("EXPECT_EXPOSED_PORTS", ""), getEnv("EXPECT_USER", ""))
+	if err != nil {
+		return nil, err
+	}
+	config.ImageConfigExpectations = imageConfigExpectations
+
+	deadline, err := getEnvDuration("BUILD_DEADLINE", 0)
+	if err != nil {
+		return nil, err
+	}
+	config.Deadline = deadline
+
+	failureGracePeriod, err := getEnvDuration("FAILURE_GRACE_PERIOD", results.DefaultFailureGracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	config.FailureGracePeriod = failureGracePeriod
+
+	tempTagTTL, err := getEnvDuration("TEMP_TAG_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	config.TempTagTTL = tempTagTTL
+
+	if value := getEnv("LAYER_CACHE_MAX_SIZE", ""); value != "" {
+		maxSize, err := image.ParseSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LAYER_CACHE_MAX_SIZE: %w", err)
+		}
+		config.LayerCacheMaxSize = maxSize
+	}
+
+	if config.ImageExpiresAfter != "" {
+		if _, err := duration.ParseExtended(config.ImageExpiresAfter); err != nil {
+			return nil, fmt.Errorf("invalid IMAGE_EXPIRES_AFTER: %w", err)
+		}
+	}
+
+	if _, err := regexp.Compile(config.RemoteSourceAllowlist); err != nil {
+		return nil, fmt.Errorf("invalid REMOTE_SOURCE_ALLOWLIST: %w", err)
+	}
+
+	tempTagPattern, err := regexp.Compile(config.TempTagPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TEMP_TAG_PATTERN: %w", err)
+	}
+	if err := image.ValidateTemporaryTagPattern(tempTagPattern); err != nil {
+		return nil, fmt.Errorf("invalid TEMP_TAG_PATTERN: %w", err)
+	}
+	if config.CleanupTempTags && config.TagDeletion == image.TagDeletionNone {
+		return nil, fmt.Errorf("TAG_DELETION is required when CLEANUP_TEMP_TAGS is set")
+	}
+
+	if config.TagTemplate != "" {
+		if _, err := image.ParseTagTemplate(config.TagTemplate); err != nil {
+			return nil, fmt.Errorf("invalid TAG_TEMPLATE: %w", err)
+		}
+	}
+
+	strictWarnings, err := warnings.ParseCategories(getEnv("STRICT_WARNINGS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STRICT_WARNINGS: %w", err)
+	}
+	config.StrictWarnings = strictWarnings
+
+	stepBudgets, err := parseStepBudgets(getEnv("STEP_BUDGETS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse STEP_BUDGETS: %w", err)
+	}
+	config.StepBudgets = stepBudgets
+
+	resultsPath, err := results.ResolveDir()
+	if err != nil {
+		return nil, err
+	}
+	config.ResultsPath = resultsPath
+
 	return config, nil
 }
 
+// DumpSanitized renders the effective configuration as JSON with secret-bearing values masked
+func (c *Config) DumpSanitized() ([]byte, error) {
+	return redact.JSON(c)
+}
+
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := envconfig.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
 func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
+	if value := envconfig.Getenv(key); value != "" {
 		parsed, err := strconv.ParseBool(value)
 		if err == nil {
 			return parsed
@@ -110,7 +681,7 @@ func getEnvBool(key string, defaultValue bool) bool {
 }
 
 func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+	if value := envconfig.Getenv(key); value != "" {
 		parsed, err := strconv.Atoi(value)
 		if err == nil {
 			return parsed
@@ -118,3 +689,27 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvDuration parses a duration environment variable with duration.ParseExtended
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := envconfig.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := duration.ParseExtended(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// getEnvList splits a comma-separated environment variable, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(envconfig.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,83 @@
+package buildcontainer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deadlineReservePercent of the deadline is held back from the steps so the
+// results can still be written once the step budget is exhausted
+const deadlineReservePercent = 5
+
+// budget tracks the build deadline and derives a sub-deadline for each step
+type budget struct {
+	total  time.Duration
+	end    time.Time
+	shares map[string]int
+	now    func() time.Time
+}
+
+// newBudget starts a budget of deadline from now, nil when there is no deadline
+func newBudget(deadline time.Duration, shares map[string]int, now func() time.Time) *budget {
+	if deadline <= 0 {
+		return nil
+	}
+	reserve := deadline * deadlineReservePercent / 100
+	return &budget{
+		total:  deadline,
+		end:    now().Add(deadline - reserve),
+		shares: shares,
+		now:    now,
+	}
+}
+
+// stepContext bounds ctx by the sub-deadline of a step: its share of the
+// budget, never past the end of the budget. A sooner deadline already on ctx
+// wins. It fails when the budget is exhausted.
+func (b *budget) stepContext(ctx context.Context, step string) (context.Context, context.CancelFunc, error) {
+	if b == nil {
+		return ctx, func() {}, nil
+	}
+
+	remaining := b.end.Sub(b.now())
+	if remaining <= 0 {
+		return nil, nil, fmt.Errorf("build deadline of %s exhausted before step %s", b.total, step)
+	}
+
+	allowed := remaining
+	if share, ok := b.shares[step]; ok {
+		if stepBudget := b.total * time.Duration(share) / 100; stepBudget < allowed {
+			allowed = stepBudget
+		}
+	}
+
+	stepCtx, cancel := context.WithTimeout(ctx, allowed)
+	return stepCtx, cancel, nil
+}
+
+// parseStepBudgets parses step=percent pairs such as "clone=10,build=60"
+func parseStepBudgets(value string) (map[string]int, error) {
+	shares := make(map[string]int)
+	total := 0
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		step, percent, found := strings.Cut(pair, "=")
+		share, err := strconv.Atoi(strings.TrimSpace(percent))
+		if !found || err != nil || share <= 0 || share > 100 {
+			return nil, fmt.Errorf("invalid step budget %q, expected step=percent", pair)
+		}
+		shares[strings.TrimSpace(step)] = share
+		total += share
+	}
+
+	if total > 100 {
+		return nil, fmt.Errorf("step budgets add up to %d%%, more than 100%%", total)
+	}
+	return shares, nil
+}
@@ -0,0 +1,185 @@
+package buildcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.current }
+
+// clockStep advances the fake clock by elapsed when it runs
+type clockStep struct {
+	name    string
+	clock   *fakeClock
+	elapsed time.Duration
+	runs    *[]string
+}
+
+func (s *clockStep) Name() string { return s.name }
+
+func (s *clockStep) Skip(config *Config) bool { return false }
+
+func (s *clockStep) Run(ctx context.Context, state *State) error {
+	*s.runs = append(*s.runs, s.name)
+	s.clock.current = s.clock.current.Add(s.elapsed)
+	return nil
+}
+
+// blockingStep waits until its context is done
+type blockingStep struct {
+	name     string
+	deadline *time.Duration
+}
+
+func (s *blockingStep) Name() string { return s.name }
+
+func (s *blockingStep) Skip(config *Config) bool { return false }
+
+func (s *blockingStep) Run(ctx context.Context, state *State) error {
+	if deadline, ok := ctx.Deadline(); ok && s.deadline != nil {
+		*s.deadline = time.Until(deadline)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+var _ = Describe("Deadline", func() {
+	var (
+		ctx        context.Context
+		clock      *fakeClock
+		config     *Config
+		builder    *Builder
+		resultsDir string
+		runs       []string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		resultsDir = GinkgoT().TempDir()
+		config = &Config{
+			ImageURL:      "quay.io/test/image:tag",
+			WorkspacePath: GinkgoT().TempDir(),
+			ResultsPath:   resultsDir,
+		}
+		builder = NewBuilder(zap.NewNop(), config, exec.NewMockCommandRunner())
+		builder.now = clock.now
+		runs = nil
+	})
+
+	It("should run every step without a deadline", func() {
+		builder.Steps = []Step{
+			&clockStep{name: "first", clock: clock, elapsed: 24 * time.Hour, runs: &runs},
+			&clockStep{name: "second", clock: clock, runs: &runs},
+		}
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(runs).To(Equal([]string{"first", "second"}))
+		_, err := os.Stat(filepath.Join(resultsDir, "TIMEOUT"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should fail before a step once the budget is exhausted and keep earlier results", func() {
+		config.Deadline = time.Hour
+		builder.Steps = []Step{
+			&clockStep{name: "clone", clock: clock, elapsed: 58 * time.Minute, runs: &runs},
+			&clockStep{name: "build", clock: clock, runs: &runs},
+		}
+		Expect(builder.writeResult("COMMIT_SHA", "abc123")).To(Succeed())
+
+		err := builder.Execute(ctx)
+
+		Expect(err).To(MatchError(ContainSubstring("build deadline exceeded")))
+		Expect(runs).To(Equal([]string{"clone"}))
+		Expect(readResult(resultsDir, "TIMEOUT")).To(Equal("build deadline of 1h0m0s exhausted before step build"))
+		Expect(readResult(resultsDir, "COMMIT_SHA")).To(Equal("abc123"))
+		Expect(readResult(resultsDir, "BUILD_METRICS")).To(ContainSubstring("clone_seconds=3480.000\n"))
+	})
+
+	It("should fail a step that exceeds its share of the budget", func() {
+		config.Deadline = 200 * time.Millisecond
+		config.StepBudgets = map[string]int{"build": 10}
+		var stepDeadline time.Duration
+		builder.now = time.Now
+		builder.Steps = []Step{&blockingStep{name: "build", deadline: &stepDeadline}}
+
+		err := builder.Execute(ctx)
+
+		Expect(err).To(MatchError(ContainSubstring("step build exceeded its deadline")))
+		Expect(stepDeadline).To(BeNumerically("<=", 20*time.Millisecond))
+		Expect(readResult(resultsDir, "TIMEOUT")).To(ContainSubstring("step build exceeded its deadline"))
+	})
+
+	It("should keep a sooner deadline of the parent context", func() {
+		config.Deadline = time.Hour
+		var stepDeadline time.Duration
+		builder.now = time.Now
+		builder.Steps = []Step{&blockingStep{name: "build", deadline: &stepDeadline}}
+		parent, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		err := builder.Execute(parent)
+
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(stepDeadline).To(BeNumerically("<=", 20*time.Millisecond))
+		_, statErr := os.Stat(filepath.Join(resultsDir, "TIMEOUT"))
+		Expect(os.IsNotExist(statErr)).To(BeTrue())
+	})
+
+	Describe("newBudget", func() {
+		It("should give a step its share, never past the end of the budget", func() {
+			b := newBudget(100*time.Minute, map[string]int{"clone": 10, "build": 90}, clock.now)
+
+			stepCtx, cancel, err := b.stepContext(ctx, "clone")
+			Expect(err).NotTo(HaveOccurred())
+			defer cancel()
+			deadline, _ := stepCtx.Deadline()
+			Expect(time.Until(deadline)).To(BeNumerically("~", 10*time.Minute, time.Second))
+
+			clock.current = clock.current.Add(10 * time.Minute)
+			buildCtx, cancel, err := b.stepContext(ctx, "build")
+			Expect(err).NotTo(HaveOccurred())
+			defer cancel()
+			deadline, _ = buildCtx.Deadline()
+			Expect(time.Until(deadline)).To(BeNumerically("~", 85*time.Minute, time.Second))
+		})
+
+		It("should leave the context alone without a deadline", func() {
+			b := newBudget(0, nil, clock.now)
+
+			stepCtx, cancel, err := b.stepContext(ctx, "build")
+			Expect(err).NotTo(HaveOccurred())
+			defer cancel()
+			Expect(stepCtx).To(Equal(ctx))
+		})
+	})
+
+	Describe("parseStepBudgets", func() {
+		It("should parse step percentages", func() {
+			Expect(parseStepBudgets("clone=10, build=60")).To(Equal(map[string]int{"clone": 10, "build": 60}))
+		})
+
+		It("should reject malformed entries", func() {
+			_, err := parseStepBudgets("clone")
+			Expect(err).To(MatchError(ContainSubstring(`invalid step budget "clone"`)))
+		})
+
+		It("should reject budgets adding up to more than 100%", func() {
+			_, err := parseStepBudgets("clone=60,build=60")
+			Expect(err).To(MatchError(ContainSubstring("add up to 120%")))
+		})
+	})
+})
@@ -0,0 +1,61 @@
+package buildcontainer
+
+import (
+	"fmt"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/provenance"
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
+)
+
+// SLSA provenance identifiers of the monolithic builder
+const (
+	ProvenanceBuilderID = "https://konflux-ci.dev/monolithic-builder"
+	ProvenanceBuildType = "https://konflux-ci.dev/monolithic-builder/build-container@v1"
+)
+
+// provenanceResult describes a completed build for its predicate. Build
+// argument values are masked since they may carry credentials.
+func (b *Builder) provenanceResult(state *State) *provenance.BuildResult {
+	result := &provenance.BuildResult{
+		BuilderID: ProvenanceBuilderID,
+		BuildType: ProvenanceBuildType,
+		Parameters: map[string]interface{}{
+			"GIT_URL":        b.config.GitURL,
+			"GIT_REVISION":   b.config.GitRevision,
+			"IMAGE_URL":      b.config.ImageURL,
+			"DOCKERFILE":     b.config.Dockerfile,
+			"CONTEXT":        b.config.Context,
+			"HERMETIC":       b.config.Hermetic,
+			"PREFETCH_INPUT": b.config.PrefetchInput,
+			"BUILD_ARGS":     redact.KeyValues(b.config.BuildArgs),
+		},
+		// Record the tools that produced the image for reproducibility audits
+		Tools: []provenance.Tool{
+			{Name: "buildah", Version: state.ToolVersions.Buildah},
+			{Name: "skopeo", Version: state.ToolVersions.Skopeo},
+		},
+	}
+
+	if state.CloneResult != nil {
+		result.SourceURL = state.CloneResult.URL
+		result.CommitSHA = state.CloneResult.CommitSHA
+	}
+	if state.BuildResult != nil {
+		result.ImageURL = state.BuildResult.ImageURL
+		result.ImageDigest = state.BuildResult.ImageDigest
+	}
+	return result
+}
+
+// writeProvenancePredicate writes the SLSA provenance predicate, in the
+// format of ProvenancePredicateType, as the PREDICATE result
+func (b *Builder) writeProvenancePredicate(state *State) error {
+	predicate, err := provenance.GeneratePredicate(b.provenanceResult(state), b.config.ProvenancePredicateType)
+	if err != nil {
+		return fmt.Errorf("failed to encode provenance predicate: %w", err)
+	}
+	if err := b.writeResult("PREDICATE", string(predicate)); err != nil {
+		return fmt.Errorf("failed to write PREDICATE result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package buildcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/provenance"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Provenance predicate", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *Config
+		builder    *Builder
+		resultsDir string
+		repoDir    string
+		commitSHA  string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+		mockRunner.SetOutput("buildah", []byte("buildah version 1.33.7 (image-spec 1.1.0, runtime-spec 1.1.0)\n"), "--version")
+		resultsDir = GinkgoT().TempDir()
+		repoDir = GinkgoT().TempDir()
+		commitSHA = newFixtureRepo(repoDir)
+		config = &Config{
+			GitURL:                  repoDir,
+			ImageURL:                "quay.io/test/image:tag",
+			Dockerfile:              "./Dockerfile",
+			Context:                 ".",
+			BuildArgs:               []string{"TOKEN=secret"},
+			TLSVerify:               true,
+			Rebuild:                 true,
+			WorkspacePath:           GinkgoT().TempDir(),
+			ResultsPath:             resultsDir,
+			EmitProvenancePredicate: true,
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+	})
+
+	It("should write a SLSA predicate after a successful build", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		var predicate map[string]interface{}
+		Expect(json.Unmarshal([]byte(readResult(resultsDir, "PREDICATE")), &predicate)).To(Succeed())
+
+		Expect(predicate).To(HaveKeyWithValue("builder", map[string]interface{}{"id": ProvenanceBuilderID}))
+		Expect(predicate).To(HaveKeyWithValue("buildType", ProvenanceBuildType))
+		Expect(predicate["invocation"]).To(HaveKeyWithValue("parameters", And(
+			HaveKeyWithValue("IMAGE_URL", "quay.io/test/image:tag"),
+			HaveKeyWithValue("BUILD_ARGS", []interface{}{"TOKEN=********"}),
+		)))
+		Expect(predicate["materials"]).To(Equal([]interface{}{
+			map[string]interface{}{"uri": "git+" + repoDir, "digest": map[string]interface{}{"sha1": commitSHA}},
+			map[string]interface{}{"uri": "oci://quay.io/test/image:tag", "digest": map[string]interface{}{"sha256": "built"}},
+			map[string]interface{}{"uri": "pkg:generic/buildah@1.33.7"},
+		}))
+	})
+
+	It("should write a SLSA v1.0 predicate when selected", func() {
+		config.ProvenancePredicateType = provenance.VersionSLSA10
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		var predicate provenance.PredicateV1
+		Expect(json.Unmarshal([]byte(readResult(resultsDir, "PREDICATE")), &predicate)).To(Succeed())
+		Expect(predicate.BuildDefinition.BuildType).To(Equal(ProvenanceBuildType))
+		Expect(predicate.BuildDefinition.ExternalParameters).To(HaveKeyWithValue("BUILD_ARGS", []interface{}{"TOKEN=********"}))
+		Expect(predicate.BuildDefinition.ResolvedDependencies).To(Equal([]provenance.Material{
+			{URI: "git+" + repoDir, Digest: map[string]string{"sha1": commitSHA}},
+		}))
+		Expect(predicate.RunDetails.Builder).To(Equal(provenance.BuilderV1{
+			ID:      ProvenanceBuilderID,
+			Version: map[string]string{"buildah": "1.33.7"},
+		}))
+	})
+
+	It("should not write a predicate unless enabled", func() {
+		config.EmitProvenancePredicate = false
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(filepath.Join(resultsDir, "PREDICATE")).NotTo(BeAnExistingFile())
+	})
+
+	It("should not write a predicate when the build is skipped", func() {
+		config.Rebuild = false
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(filepath.Join(resultsDir, "PREDICATE")).NotTo(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,148 @@
+package buildcontainer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// Subsystems of the RETRY_SUMMARY result
+const (
+	// retryGit counts the git clone and the git commands run on the source
+	retryGit          = "git"
+	retryPush         = "push"
+	retryInspect      = "inspect"
+	retryManifestPush = "manifest_push"
+)
+
+// newRetrySummary creates the summary of the attempts of the registry and git
+// subsystems
+func newRetrySummary() *exec.RetrySummary {
+	return exec.NewRetrySummary(retrySubsystem, retryGit, retryPush, retryInspect, retryManifestPush)
+}
+
+// retrySubsystem classifies a command run through the registry retries into
+// a subsystem of RETRY_SUMMARY, "" for the commands that aren't tracked
+func retrySubsystem(name string, args []string) string {
+	switch name {
+	case "git":
+		return retryGit
+	case "skopeo":
+		switch subcommand(args) {
+		case "copy":
+			return retryPush
+		case "inspect":
+			return retryInspect
+		}
+	case "buildah":
+		args = skipGlobalFlags(args)
+		switch subcommand(args) {
+		case "push":
+			return retryPush
+		case "inspect":
+			return retryInspect
+		case "manifest":
+			if subcommand(args[1:]) == "push" {
+				return retryManifestPush
+			}
+		}
+	}
+	return ""
+}
+
+// failedStep returns the step a failure of step in command is reported as.
+// The build step also pushes the image, and a failed push is reported as the
+// push step so that its hint points at the registry rather than the
+// Containerfile.
+func failedStep(step string, command []string) string {
+	if step != "build" || len(command) == 0 {
+		return step
+	}
+	switch retrySubsystem(unwrapEnv(command[0], command[1:])) {
+	case retryPush, retryManifestPush:
+		return "push"
+	}
+	return step
+}
+
+// isRegistryCommand reports whether a command pulls from or pushes to a
+// registry, the only commands retried on rate limits: a build reports the
+// 429 answers of whatever its RUN instructions reach and must not be rerun.
+func isRegistryCommand(name string, args []string) bool {
+	name, args = unwrapEnv(name, args)
+	switch name {
+	case "skopeo":
+		switch subcommand(args) {
+		case "copy", "inspect":
+			return true
+		}
+	case "buildah":
+		args = skipGlobalFlags(args)
+		switch subcommand(args) {
+		case "push", "pull", "inspect":
+			return true
+		case "manifest":
+			return subcommand(args[1:]) == "push"
+		}
+	}
+	return false
+}
+
+// unwrapEnv returns the command an env command runs with its variables set,
+// the command itself otherwise
+func unwrapEnv(name string, args []string) (string, []string) {
+	if name != "env" {
+		return name, args
+	}
+	i := slices.IndexFunc(args, func(arg string) bool { return !strings.Contains(arg, "=") })
+	if i < 0 {
+		return name, args
+	}
+	return args[i], args[i+1:]
+}
+
+// skipGlobalFlags drops the global flags buildahArgs puts before a buildah
+// subcommand, such as --root <dir>
+func skipGlobalFlags(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] == "--root" && len(args) > 1 {
+			args = args[2:]
+			continue
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// subcommand returns the first argument, "" when there is none
+func subcommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// writeRetrySummary records the attempts of each subsystem on the state and
+// writes them to the RETRY_SUMMARY result. Retries are logged as a warning
+// because a build that succeeds after them hides a flaky registry or git
+// server.
+func (b *Builder) writeRetrySummary(state *State) error {
+	state.RetryCounts = b.retrySummary.Counts()
+	if retried := b.retrySummary.Retried(); len(retried) > 0 {
+		b.logger.Warn("Commands were retried, the registry or git server may be flaky",
+			zap.Strings("subsystems", retried),
+			zap.Any("retry_summary", state.RetryCounts))
+	}
+
+	summary, err := b.retrySummary.Format()
+	if err != nil {
+		return fmt.Errorf("failed to encode retry summary: %w", err)
+	}
+	if err := b.writeResult("RETRY_SUMMARY", summary); err != nil {
+		return fmt.Errorf("failed to write RETRY_SUMMARY result: %w", err)
+	}
+	return nil
+}
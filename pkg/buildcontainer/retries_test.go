@@ -0,0 +1,60 @@
+package buildcontainer
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("retrySubsystem", func() {
+	DescribeTable("should classify the commands of RETRY_SUMMARY",
+		func(name string, args []string, expected string) {
+			Expect(retrySubsystem(name, args)).To(Equal(expected))
+		},
+		Entry("git", "git", []string{"fetch", "origin"}, retryGit),
+		Entry("skopeo copy", "skopeo", []string{"copy", "docker://a", "docker://b"}, retryPush),
+		Entry("skopeo inspect", "skopeo", []string{"inspect", "docker://a"}, retryInspect),
+		Entry("skopeo delete", "skopeo", []string{"delete", "docker://a"}, ""),
+		Entry("buildah push", "buildah", []string{"push", "quay.io/test/image:tag"}, retryPush),
+		Entry("buildah push with a layer cache root", "buildah", []string{"--root", "/cache", "push", "image"}, retryPush),
+		Entry("buildah inspect", "buildah", []string{"inspect", "--type", "image", "image"}, retryInspect),
+		Entry("buildah manifest push", "buildah", []string{"manifest", "push", "--all", "list", "docker://a"}, retryManifestPush),
+		Entry("buildah manifest create", "buildah", []string{"manifest", "create", "list"}, ""),
+		Entry("buildah manifest alone", "buildah", []string{"manifest"}, ""),
+		Entry("buildah version", "buildah", []string{"--version"}, ""),
+		Entry("cachi2", "cachi2", []string{"fetch-deps"}, ""),
+	)
+})
+
+var _ = Describe("failedStep", func() {
+	DescribeTable("should report the pushes of the build step as the push step",
+		func(step string, command []string, expected string) {
+			Expect(failedStep(step, command)).To(Equal(expected))
+		},
+		Entry("buildah push", "build", []string{"buildah", "push", "quay.io/test/image:tag"}, "push"),
+		Entry("skopeo copy", "build", []string{"skopeo", "copy", "docker://a", "docker://b"}, "push"),
+		Entry("buildah push through env", "build", []string{"env", "TMPDIR=/tmp/build", "buildah", "push", "image"}, "push"),
+		Entry("buildah build", "build", []string{"env", "TMPDIR=/tmp/build", "buildah", "build", "."}, "build"),
+		Entry("skopeo inspect", "build", []string{"skopeo", "inspect", "docker://a"}, "build"),
+		Entry("no command", "build", nil, "build"),
+		Entry("another step", "clone", []string{"git", "push"}, "clone"),
+	)
+})
+
+var _ = Describe("isRegistryCommand", func() {
+	DescribeTable("should only retry the commands reaching a registry",
+		func(name string, args []string, expected bool) {
+			Expect(isRegistryCommand(name, args)).To(Equal(expected))
+		},
+		Entry("skopeo copy", "skopeo", []string{"copy", "docker://a", "docker://b"}, true),
+		Entry("skopeo inspect", "skopeo", []string{"inspect", "docker://a"}, true),
+		Entry("skopeo delete", "skopeo", []string{"delete", "docker://a"}, false),
+		Entry("buildah push", "buildah", []string{"push", "image"}, true),
+		Entry("buildah pull", "buildah", []string{"pull", "image"}, true),
+		Entry("buildah push through env", "env", []string{"TMPDIR=/tmp/build", "buildah", "--root", "/cache", "push", "image"}, true),
+		Entry("buildah manifest push", "buildah", []string{"manifest", "push", "--all", "list", "docker://a"}, true),
+		Entry("buildah manifest create", "buildah", []string{"manifest", "create", "list"}, false),
+		Entry("buildah build", "buildah", []string{"build", "."}, false),
+		Entry("unshare build", "unshare", []string{"-Uf", "--", "sh", "-c", "buildah build ."}, false),
+		Entry("git", "git", []string{"fetch", "origin"}, false),
+	)
+})
@@ -0,0 +1,619 @@
+package buildcontainer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/git"
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"github.com/konflux-ci/monolithic-builder/pkg/results"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
+	"go.uber.org/zap"
+)
+
+// State holds the data shared between the steps of a build
+type State struct {
+	// ShouldBuild is false when the image already exists and no rebuild was requested
+	ShouldBuild bool
+
+	// CloneResult is set by the clone step
+	CloneResult *git.CloneResult
+
+	// ExistingManifest is the manifest of the existing image found by the init step
+	ExistingManifest *image.Manifest
+
+	// BuildResult is set by the build step
+	BuildResult *image.BuildResult
+
+	// Warnings collects non-fatal problems encountered by the steps
+	Warnings warnings.Collector
+
+	// Checks collects the findings of the pre- and post-build checks, keyed by check name
+	Checks map[string]interface{}
+
+	// ToolVersions holds the buildah and skopeo versions detected at the start of the build
+	ToolVersions image.ToolVersions
+
+	// StepDurations records how long each step that ran took, keyed by step name
+	StepDurations map[string]time.Duration
+
+	// Retries counts the operations retried after a transient failure
+	Retries int
+
+	// RetryCounts holds the attempts of the registry and git commands per
+	// subsystem, set when RETRY_SUMMARY is written
+	RetryCounts map[string]exec.RetryCount
+
+	// Checkpoint records the completed steps when RESUME is enabled. It holds
+	// the validated checkpoint of a previous run when resuming.
+	Checkpoint *Checkpoint
+
+	// step is the name of the running step, recorded with the warnings
+	step string
+
+	// failureReport is the report of the failed step, logged last
+	failureReport *results.FailureReport
+}
+
+// AddWarning records a non-fatal problem met by the running step. It returns
+// an error when STRICT_WARNINGS escalates the category of the warning.
+func (s *State) AddWarning(category, message string) error {
+	return s.Warnings.Add(s.step, category, message)
+}
+
+// AddCheck records the findings of a check on the state
+func (s *State) AddCheck(name string, findings interface{}) {
+	if s.Checks == nil {
+		s.Checks = make(map[string]interface{})
+	}
+	s.Checks[name] = findings
+}
+
+// recordDuration records how long a step took
+func (s *State) recordDuration(step string, d time.Duration) {
+	if s.StepDurations == nil {
+		s.StepDurations = make(map[string]time.Duration)
+	}
+	s.StepDurations[step] = d
+}
+
+// Step is a single stage of the build-container pipeline
+type Step interface {
+	// Name identifies the step in logs and in the step list
+	Name() string
+
+	// Skip reports whether the step is disabled by the configuration
+	Skip(config *Config) bool
+
+	// Run executes the step, reading and updating the shared state
+	Run(ctx context.Context, state *State) error
+}
+
+// DefaultSteps returns the ordered steps reproducing the build-container task
+func (b *Builder) DefaultSteps() []Step {
+	return []Step{
+		&initStep{b: b},
+		&cloneStep{b: b},
+		&existingDigestStep{b: b},
+		&baseImagePolicyStep{b: b},
+		&remoteSourcesStep{b: b},
+		&prefetchStep{b: b},
+		&buildStep{b: b},
+		&cleanupTempTagsStep{b: b},
+	}
+}
+
+// InsertStepAfter inserts a step right after the step with the given name
+func (b *Builder) InsertStepAfter(name string, step Step) error {
+	for i, existing := range b.Steps {
+		if existing.Name() == name {
+			b.Steps = append(b.Steps[:i+1], append([]Step{step}, b.Steps[i+1:]...)...)
+			return nil
+		}
+	}
+	return fmt.Errorf("step %q not found", name)
+}
+
+// initStep implements the init task functionality
+type initStep struct {
+	b *Builder
+}
+
+func (s *initStep) Name() string { return "init" }
+
+func (s *initStep) Skip(config *Config) bool { return config.CloneOnly }
+
+func (s *initStep) Run(ctx context.Context, state *State) error {
+	shouldBuild, err := s.b.initializeAndCheckBuild(ctx, state)
+	if err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+	state.ShouldBuild = shouldBuild
+
+	// Write build result for potential pipeline consumption
+	if err := s.b.writeResult("build", fmt.Sprintf("%t", shouldBuild)); err != nil {
+		return fmt.Errorf("failed to write build result: %w", err)
+	}
+
+	return nil
+}
+
+// cloneStep implements the git-clone task functionality. It always runs to
+// get git info, which is required for pipeline results.
+type cloneStep struct {
+	b *Builder
+}
+
+func (s *cloneStep) Name() string { return "clone" }
+
+func (s *cloneStep) Skip(config *Config) bool { return false }
+
+func (s *cloneStep) Run(ctx context.Context, state *State) error {
+	gitResult, err := s.clone(ctx, state)
+	if err != nil {
+		return err
+	}
+	state.CloneResult = gitResult
+
+	// Write git results (always required for Konflux pipeline traceability)
+	if err := s.b.writeResult("commit", gitResult.CommitSHA); err != nil {
+		return fmt.Errorf("failed to write commit result: %w", err)
+	}
+	if err := s.b.writeResult("url", gitResult.URL); err != nil {
+		return fmt.Errorf("failed to write url result: %w", err)
+	}
+	if s.b.config.WriteCommitTitle {
+		if err := s.b.writeCommitTitle(gitResult.CommitSHA); err != nil {
+			return err
+		}
+	}
+
+	if s.b.config.CloneOnly {
+		return nil
+	}
+
+	// Always write image results (required for downstream tasks like build-image-index)
+	if err := s.b.writeResult("IMAGE_URL", s.b.resultImageURL()); err != nil {
+		return fmt.Errorf("failed to write IMAGE_URL result: %w", err)
+	}
+
+	return nil
+}
+
+// clone clones the repository, reuses the clone recorded in a valid checkpoint
+// or describes the local source tree
+func (s *cloneStep) clone(ctx context.Context, state *State) (*git.CloneResult, error) {
+	if s.b.config.SourceMode == SourceModeLocal {
+		s.b.logger.Info("Using local source tree", zap.String("source_path", s.b.config.SourcePath))
+		return s.b.openLocalSource()
+	}
+
+	if s.b.config.SkipGitClone {
+		return s.b.reuseClone()
+	}
+
+	if state.Checkpoint != nil {
+		s.b.logger.Info("Skipping clone - source restored from checkpoint",
+			zap.String("commit_sha", state.Checkpoint.CloneResult.CommitSHA))
+		return state.Checkpoint.CloneResult, nil
+	}
+
+	if s.b.config.Resume {
+		// Leftovers of an interrupted run would make the clone fail
+		if err := os.RemoveAll(s.b.sourcePath()); err != nil {
+			return nil, fmt.Errorf("failed to clean source directory: %w", err)
+		}
+	}
+
+	s.b.logger.Info("Cloning repository")
+	gitResult, err := s.b.cloneRepository(ctx, state)
+	if err != nil {
+		return nil, fmt.Errorf("git clone failed: %w", err)
+	}
+
+	if s.b.config.Resume {
+		state.Checkpoint = &Checkpoint{
+			GitURL:      s.b.config.GitURL,
+			GitRevision: s.b.config.GitRevision,
+			GitRefspec:  s.b.config.GitRefspec,
+			CloneResult: gitResult,
+		}
+		if err := s.b.saveCheckpoint(state.Checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	return gitResult, nil
+}
+
+// existingDigestStep writes the digest of the existing image when the build is skipped
+type existingDigestStep struct {
+	b *Builder
+}
+
+func (s *existingDigestStep) Name() string { return "existing-digest" }
+
+func (s *existingDigestStep) Skip(config *Config) bool { return false }
+
+func (s *existingDigestStep) Run(ctx context.Context, state *State) error {
+	if state.ShouldBuild {
+		return nil
+	}
+
+	s.b.logger.Info("Skipping build - image already exists and rebuild not requested")
+
+	// Prefer the digest of the raw manifest seen by the existence check, which
+	// is the index digest rather than a child's when the image is an index
+	var digest string
+	if state.ExistingManifest != nil {
+		digest = state.ExistingManifest.Digest
+		if err := s.b.writeResult("IMAGE_MEDIA_TYPE", state.ExistingManifest.MediaType); err != nil {
+			return fmt.Errorf("failed to write IMAGE_MEDIA_TYPE result: %w", err)
+		}
+	} else {
+		// Get digest of existing image for downstream tasks
+		var err error
+		digest, err = s.b.getExistingImageDigest(ctx)
+		if err != nil {
+			if s.b.config.RequireDigest {
+				return fmt.Errorf("failed to get digest of existing image %s: %w", s.b.config.ImageURL, err)
+			}
+			s.b.logger.Warn("Failed to get existing image digest, using empty value", zap.Error(err))
+			if err := state.AddWarning(warnings.CategoryDigest, fmt.Sprintf("failed to get existing image digest: %v", err)); err != nil {
+				return err
+			}
+			digest = ""
+		}
+	}
+
+	if err := s.b.writeResult("IMAGE_DIGEST", digest); err != nil {
+		return fmt.Errorf("failed to write IMAGE_DIGEST result: %w", err)
+	}
+
+	s.b.logger.Info("Skipped build completed - wrote IMAGE_URL and IMAGE_DIGEST results",
+		zap.String("image_url", s.b.config.ImageURL),
+		zap.String("image_digest", digest))
+	return nil
+}
+
+// baseImagePolicyStep evaluates the base images of the Dockerfile against BASE_IMAGE_POLICY
+type baseImagePolicyStep struct {
+	b *Builder
+}
+
+func (s *baseImagePolicyStep) Name() string { return "base-image-policy" }
+
+func (s *baseImagePolicyStep) Skip(config *Config) bool { return config.BaseImagePolicy == nil }
+
+func (s *baseImagePolicyStep) Run(ctx context.Context, state *State) error {
+	if !state.ShouldBuild {
+		return nil
+	}
+
+	instructions, err := s.b.parseDockerfile()
+	if err != nil {
+		return fmt.Errorf("base image policy check failed: %w", err)
+	}
+
+	violations := s.b.config.BaseImagePolicy.Evaluate(image.BaseImages(instructions, s.b.config.BuildArgs))
+	if violations == nil {
+		violations = []image.PolicyViolation{}
+	}
+
+	var denied []string
+	var strictErr error
+	for _, violation := range violations {
+		if violation.Action == image.PolicyActionDeny {
+			denied = append(denied, violation.Message)
+			continue
+		}
+
+		s.b.logger.Warn("Base image policy violation",
+			zap.Int("line", violation.Line),
+			zap.String("reference", violation.Reference),
+			zap.String("pattern", violation.Pattern),
+			zap.Bool("unresolvable", violation.Unresolvable))
+		if err := state.AddWarning(warnings.CategoryBaseImagePolicy, violation.Message); err != nil && strictErr == nil {
+			strictErr = err
+		}
+	}
+
+	state.AddCheck("base_image_policy", violations)
+	if err := s.b.writeChecks(state); err != nil {
+		return err
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("base image policy denied the build: %s", strings.Join(denied, "; "))
+	}
+	if strictErr != nil {
+		return strictErr
+	}
+
+	return nil
+}
+
+// remoteSourcesStep fails fast on the remote ADD and COPY sources the build
+// can't fetch, rather than letting buildah fail on them mid-build
+type remoteSourcesStep struct {
+	b *Builder
+}
+
+func (s *remoteSourcesStep) Name() string { return "remote-sources" }
+
+func (s *remoteSourcesStep) Skip(config *Config) bool {
+	return !config.Hermetic && config.RemoteSourceAllowlist == ""
+}
+
+func (s *remoteSourcesStep) Run(ctx context.Context, state *State) error {
+	if !state.ShouldBuild {
+		return nil
+	}
+
+	var allowlist *regexp.Regexp
+	if s.b.config.RemoteSourceAllowlist != "" {
+		var err error
+		allowlist, err = regexp.Compile(s.b.config.RemoteSourceAllowlist)
+		if err != nil {
+			return fmt.Errorf("invalid remote source allowlist: %w", err)
+		}
+	}
+
+	instructions, err := s.b.parseDockerfile()
+	if err != nil {
+		return fmt.Errorf("remote source check failed: %w", err)
+	}
+
+	sources := image.RemoteSources(instructions)
+	if sources == nil {
+		sources = []image.RemoteSource{}
+	}
+	state.AddCheck("remote_sources", sources)
+	if err := s.b.writeChecks(state); err != nil {
+		return err
+	}
+
+	return image.CheckRemoteSources(sources, s.b.config.Hermetic, allowlist)
+}
+
+// prefetchStep implements the prefetch-dependencies task functionality
+type prefetchStep struct {
+	b *Builder
+}
+
+func (s *prefetchStep) Name() string { return "prefetch" }
+
+func (s *prefetchStep) Skip(config *Config) bool { return config.PrefetchInput == "" }
+
+func (s *prefetchStep) Run(ctx context.Context, state *State) error {
+	if !state.ShouldBuild {
+		return nil
+	}
+
+	if state.Checkpoint != nil && state.Checkpoint.PrefetchKey != "" {
+		s.b.logger.Info("Skipping prefetch - dependencies restored from checkpoint")
+		return nil
+	}
+
+	s.b.logger.Info("Prefetching dependencies")
+	if err := s.b.prefetchDependencies(ctx, state); err != nil {
+		return fmt.Errorf("dependency prefetch failed: %w", err)
+	}
+
+	if state.Checkpoint != nil {
+		prefetchHash, err := hashTree(s.b.prefetchOutputPath())
+		if err != nil {
+			return fmt.Errorf("failed to hash prefetch output: %w", err)
+		}
+		state.Checkpoint.PrefetchKey = s.b.prefetchKey()
+		state.Checkpoint.PrefetchHash = prefetchHash
+		if err := s.b.saveCheckpoint(state.Checkpoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildStep implements the buildah task functionality
+type buildStep struct {
+	b *Builder
+}
+
+func (s *buildStep) Name() string { return "build" }
+
+func (s *buildStep) Skip(config *Config) bool { return false }
+
+func (s *buildStep) Run(ctx context.Context, state *State) error {
+	if !state.ShouldBuild {
+		return nil
+	}
+
+	var commitSHA, ref string
+	if state.CloneResult != nil {
+		commitSHA = state.CloneResult.CommitSHA
+		ref = state.CloneResult.Ref
+	}
+
+	labels := make(map[string]string)
+	if s.b.config.ToolVersionLabels {
+		labels = state.ToolVersions.Labels()
+	}
+	if ref != "" {
+		labels[image.LabelRef] = ref
+	}
+
+	// Render the additional tag before building so template errors fail early
+	templateTag, err := s.b.templateTag(state.CloneResult)
+	if err != nil {
+		return err
+	}
+
+	var timestamp *time.Time
+	if s.b.config.UseCommitTimestamp {
+		if state.CloneResult == nil || state.CloneResult.CommitTimestamp.IsZero() {
+			s.b.logger.Warn("Commit timestamp unknown, keeping the build time as image creation time")
+		} else {
+			timestamp = &state.CloneResult.CommitTimestamp
+		}
+	}
+
+	s.b.logger.Info("Building container image")
+	buildResult, err := s.b.buildContainerImage(ctx, commitSHA, labels, timestamp)
+	if err != nil {
+		return fmt.Errorf("container build failed: %w", err)
+	}
+	state.BuildResult = buildResult
+
+	if err := s.b.writeBuildArgsUsed(buildResult.BuildArgsUsed); err != nil {
+		return err
+	}
+	if s.b.config.EmitEffectiveBuildArgs {
+		if err := s.b.writeEffectiveBuildArgs(buildResult.EffectiveBuildArgs); err != nil {
+			return err
+		}
+	}
+
+	if buildResult.PushedImageID != "" && buildResult.PushedImageID != buildResult.ImageID {
+		if err := state.AddWarning(warnings.CategoryImageID, fmt.Sprintf("pushed image ID %s differs from the built image ID %s",
+			buildResult.PushedImageID, buildResult.ImageID)); err != nil {
+			return err
+		}
+	}
+
+	if buildResult.Layers != nil {
+		state.AddCheck("image_layers", buildResult.Layers)
+		if err := s.b.writeChecks(state); err != nil {
+			return err
+		}
+	}
+
+	if buildResult.ImageConfig != nil {
+		state.AddCheck("image_config", buildResult.ImageConfig)
+		if err := s.b.writeChecks(state); err != nil {
+			return err
+		}
+	}
+
+	if manifest := buildResult.FileManifest; manifest != nil {
+		state.AddCheck("file_manifest", manifest)
+		if err := s.b.writeChecks(state); err != nil {
+			return err
+		}
+		for _, match := range manifest.Matches {
+			if err := state.AddWarning(warnings.CategoryDeniedFile, fmt.Sprintf("image contains denied file %s", match)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write build results (IMAGE_URL already written by the clone step)
+	if err := s.b.writeResult("IMAGE_DIGEST", buildResult.ImageDigest); err != nil {
+		return fmt.Errorf("failed to write IMAGE_DIGEST result: %w", err)
+	}
+	if buildResult.ImageRef != "" {
+		if err := s.b.writeResult("IMAGE_REF", buildResult.ImageRef); err != nil {
+			return fmt.Errorf("failed to write IMAGE_REF result: %w", err)
+		}
+	}
+	if buildResult.MediaType != "" {
+		if err := s.b.writeResult("IMAGE_MEDIA_TYPE", buildResult.MediaType); err != nil {
+			return fmt.Errorf("failed to write IMAGE_MEDIA_TYPE result: %w", err)
+		}
+	}
+	if buildResult.DockerfileDigest != "" {
+		if err := s.b.writeResult("DOCKERFILE_DIGEST", buildResult.DockerfileDigest); err != nil {
+			return fmt.Errorf("failed to write DOCKERFILE_DIGEST result: %w", err)
+		}
+	}
+	if s.b.config.LabelFile != "" {
+		if err := s.b.writeLabelFile(ctx, buildResult); err != nil {
+			return err
+		}
+	}
+
+	if templateTag != "" {
+		source := buildResult.ImageURL
+		if buildResult.ImageDigest != "" {
+			source = image.Repository(buildResult.ImageURL) + "@" + buildResult.ImageDigest
+		}
+		destination := image.Repository(s.b.config.ImageURL) + ":" + templateTag
+		s.b.logger.Info("Tagging the image from TAG_TEMPLATE", zap.String("tag", destination))
+		if err := s.b.registry().Copy(ctx, source, destination); err != nil {
+			return fmt.Errorf("failed to tag the image from TAG_TEMPLATE: %w", err)
+		}
+	}
+
+	if s.b.config.GenerateSBOM {
+		sbomPath, err := s.b.generateSBOM(ctx)
+		if err != nil {
+			return fmt.Errorf("SBOM generation failed: %w", err)
+		}
+		if err := s.b.writeResult("SBOM_PATH", sbomPath); err != nil {
+			return fmt.Errorf("failed to write SBOM_PATH result: %w", err)
+		}
+	}
+
+	if s.b.config.EmitProvenancePredicate {
+		if err := s.b.writeProvenancePredicate(state); err != nil {
+			return err
+		}
+	}
+
+	s.b.logger.Info("Monolithic build-container task completed successfully",
+		zap.String("image_url", buildResult.ImageURL),
+		zap.String("image_digest", buildResult.ImageDigest))
+
+	return nil
+}
+
+// cleanupTempTagsStep deletes the expired temporary tags left in the target
+// repository by earlier runs whose cleanup didn't happen
+type cleanupTempTagsStep struct {
+	b *Builder
+}
+
+func (s *cleanupTempTagsStep) Name() string { return "cleanup-temp-tags" }
+
+func (s *cleanupTempTagsStep) Skip(config *Config) bool { return !config.CleanupTempTags }
+
+func (s *cleanupTempTagsStep) Run(ctx context.Context, state *State) error {
+	pattern, err := regexp.Compile(s.b.config.TempTagPattern)
+	if err != nil {
+		return fmt.Errorf("invalid temporary tag pattern: %w", err)
+	}
+	deleter, err := s.b.tagDeleter()
+	if err != nil {
+		return state.AddWarning(warnings.CategoryTempTagCleanup, fmt.Sprintf("failed to clean up temporary tags: %v", err))
+	}
+
+	result, err := image.CleanupExpiredTags(ctx, &image.TagCleanupConfig{
+		Repository:   image.Repository(s.b.config.ImageURL),
+		Pattern:      pattern,
+		TTL:          s.b.config.TempTagTTL,
+		MaxDeletions: s.b.config.TempTagCleanupMax,
+		Keep:         []string{image.Tag(s.b.config.ImageURL)},
+		TLSVerify:    s.b.tlsVerify(),
+		Deleter:      deleter,
+		Now:          s.b.now(),
+	}, s.b.runner)
+	if err != nil {
+		s.b.logger.Warn("Failed to clean up temporary tags", zap.Error(err))
+		return state.AddWarning(warnings.CategoryTempTagCleanup, fmt.Sprintf("failed to clean up temporary tags: %v", err))
+	}
+
+	for _, failure := range result.Failures {
+		s.b.logger.Warn("Failed to clean up temporary tag", zap.String("reason", failure))
+		if err := state.AddWarning(warnings.CategoryTempTagCleanup, failure); err != nil {
+			return err
+		}
+	}
+	s.b.logger.Info("Cleaned up expired temporary tags", zap.Strings("deleted", result.Deleted))
+
+	return nil
+}
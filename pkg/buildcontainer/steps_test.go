@@ -0,0 +1,1403 @@
+package buildcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	gogitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+// newFixtureRepo creates a git repository with a single commit and returns its SHA
+func newFixtureRepo(dir string) string {
+	repo, err := gogit.PlainInit(dir, false)
+	Expect(err).NotTo(HaveOccurred())
+
+	Expect(os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)).To(Succeed())
+
+	w, err := repo.Worktree()
+	Expect(err).NotTo(HaveOccurred())
+	_, err = w.Add("Dockerfile")
+	Expect(err).NotTo(HaveOccurred())
+
+	hash, err := w.Commit("Initial commit", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	return hash.String()
+}
+
+// commitWithMessage commits a change to the fixture repository with the given message and returns its SHA
+func commitWithMessage(dir, message string) string {
+	repo, err := gogit.PlainOpen(dir)
+	Expect(err).NotTo(HaveOccurred())
+
+	Expect(os.WriteFile(filepath.Join(dir, "README"), []byte(message), 0644)).To(Succeed())
+
+	w, err := repo.Worktree()
+	Expect(err).NotTo(HaveOccurred())
+	_, err = w.Add("README")
+	Expect(err).NotTo(HaveOccurred())
+
+	hash, err := w.Commit(message, &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	return hash.String()
+}
+
+// readResult reads a result file written by the builder
+func readResult(resultsDir, name string) string {
+	content, err := os.ReadFile(filepath.Join(resultsDir, name))
+	Expect(err).NotTo(HaveOccurred())
+	return string(content)
+}
+
+// recordingStep is a custom step that records when it runs
+type recordingStep struct {
+	name string
+	runs *[]string
+}
+
+func (s *recordingStep) Name() string { return s.name }
+
+func (s *recordingStep) Skip(config *Config) bool { return false }
+
+func (s *recordingStep) Run(ctx context.Context, state *State) error {
+	*s.runs = append(*s.runs, s.name)
+	return nil
+}
+
+// fileListingRunner writes a file listing when the built image is mounted
+// to list its files
+type fileListingRunner struct {
+	*exec.MockCommandRunner
+	listingPath string
+	listing     string
+}
+
+func (r *fileListingRunner) Run(ctx context.Context, name string, args ...string) error {
+	if err := r.MockCommandRunner.Run(ctx, name, args...); err != nil {
+		return err
+	}
+	if name == "unshare" && strings.Contains(args[len(args)-1], "buildah mount") {
+		return os.WriteFile(r.listingPath, []byte(r.listing), 0644)
+	}
+	return nil
+}
+
+var _ = Describe("Steps", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *Config
+		builder    *Builder
+		resultsDir string
+		state      *State
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		resultsDir = GinkgoT().TempDir()
+		config = &Config{
+			ImageURL:      "quay.io/test/image:tag",
+			Dockerfile:    "./Dockerfile",
+			TLSVerify:     true,
+			WorkspacePath: GinkgoT().TempDir(),
+			ResultsPath:   resultsDir,
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+		state = &State{}
+	})
+
+	Describe("DefaultSteps", func() {
+		It("should list the steps in task order", func() {
+			var names []string
+			for _, step := range builder.Steps {
+				names = append(names, step.Name())
+			}
+			Expect(names).To(Equal([]string{"init", "clone", "existing-digest", "base-image-policy", "remote-sources", "prefetch", "build", "cleanup-temp-tags"}))
+		})
+
+		It("should insert custom steps after a named step", func() {
+			var runs []string
+			Expect(builder.InsertStepAfter("clone", &recordingStep{name: "custom", runs: &runs})).To(Succeed())
+
+			Expect(builder.Steps[2].Name()).To(Equal("custom"))
+			Expect(builder.InsertStepAfter("missing", &recordingStep{name: "other", runs: &runs})).NotTo(Succeed())
+		})
+	})
+
+	Describe("init step", func() {
+		It("should build when rebuild is requested without checking the registry", func() {
+			config.Rebuild = true
+
+			Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(state.ShouldBuild).To(BeTrue())
+			Expect(mockRunner.String()).NotTo(ContainSubstring("inspect"))
+			Expect(readResult(resultsDir, "build")).To(Equal("true"))
+		})
+
+		It("should skip the build when the image already exists", func() {
+			Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(state.ShouldBuild).To(BeFalse())
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "inspect", "--raw", "docker://quay.io/test/image:tag")).To(BeTrue())
+			Expect(readResult(resultsDir, "build")).To(Equal("false"))
+		})
+
+		Context("when rebuilding on base image changes", func() {
+			const baseImage = "registry.access.redhat.com/ubi9/ubi-minimal:latest"
+
+			BeforeEach(func() {
+				config.RebuildOnBaseChange = true
+			})
+
+			existingImage := func(annotations string) {
+				mockRunner.SetOutput("skopeo", []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+					`"config":{"digest":"sha256:config"},"layers":[],"annotations":{`+annotations+`}}`),
+					"inspect", "--raw", "docker://quay.io/test/image:tag")
+			}
+			recorded := `"org.opencontainers.image.base.name":"` + baseImage + `",` +
+				`"org.opencontainers.image.base.digest":"sha256:recorded"`
+
+			It("should rebuild when the base image digest changed", func() {
+				existingImage(recorded)
+				mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:newer"}`), "inspect", "docker://"+baseImage)
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeTrue())
+				Expect(readResult(resultsDir, "BASE_IMAGE_CHANGED")).To(Equal("true"))
+				Expect(readResult(resultsDir, "build")).To(Equal("true"))
+			})
+
+			It("should skip the build when the base image digest is unchanged", func() {
+				existingImage(recorded)
+				mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:recorded"}`), "inspect", "docker://"+baseImage)
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeFalse())
+				Expect(readResult(resultsDir, "BASE_IMAGE_CHANGED")).To(Equal("false"))
+				Expect(readResult(resultsDir, "build")).To(Equal("false"))
+			})
+
+			It("should rebuild when the existing image has no recorded base image digest", func() {
+				existingImage(`"org.opencontainers.image.base.name":"` + baseImage + `"`)
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeTrue())
+				Expect(readResult(resultsDir, "BASE_IMAGE_CHANGED")).To(Equal("unknown"))
+				Expect(mockRunner.AssertCommandExecuted("skopeo", "inspect", "docker://"+baseImage)).To(BeFalse())
+			})
+
+			It("should rebuild when the current base image digest can't be resolved", func() {
+				existingImage(recorded)
+				mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "manifest unknown"}, "inspect", "docker://"+baseImage)
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeTrue())
+				Expect(readResult(resultsDir, "BASE_IMAGE_CHANGED")).To(Equal("unknown"))
+			})
+
+			It("should not compare the base image of a missing image", func() {
+				mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "manifest unknown"},
+					"inspect", "--raw", "docker://quay.io/test/image:tag")
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeTrue())
+				Expect(filepath.Join(resultsDir, "BASE_IMAGE_CHANGED")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("with existence check tags", func() {
+			const candidateRaw = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`
+
+			BeforeEach(func() {
+				config.ExistenceCheckTags = []string{"on-pr-abc", "quay.io/other/image:sha-abc"}
+				mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "manifest unknown"},
+					"inspect", "--raw", "docker://quay.io/test/image:tag")
+			})
+
+			It("should check the candidates at once and retag the first existing one", func() {
+				mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "manifest unknown"},
+					"inspect", "--raw", "docker://quay.io/test/image:on-pr-abc")
+				mockRunner.SetOutput("skopeo", []byte(candidateRaw), "inspect", "--raw", "docker://quay.io/other/image:sha-abc")
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeFalse())
+				commands := mockRunner.GetExecutedCommands()
+				Expect(commands[:3]).To(ConsistOf(
+					[]string{"skopeo", "inspect", "--raw", "docker://quay.io/test/image:tag"},
+					[]string{"skopeo", "inspect", "--raw", "docker://quay.io/test/image:on-pr-abc"},
+					[]string{"skopeo", "inspect", "--raw", "docker://quay.io/other/image:sha-abc"},
+				))
+				Expect(commands[3:]).To(Equal([][]string{
+					{"skopeo", "copy", "--all", "--preserve-digests",
+						"docker://quay.io/other/image:sha-abc", "docker://quay.io/test/image:tag"},
+				}))
+				Expect(state.ExistingManifest.Digest).To(Equal(
+					"sha256:dff9de10919148711140d349bf03f1a99eb06f94b03e51715ccebfa7cdc518e2"))
+			})
+
+			It("should prefer the first existing candidate in order", func() {
+				mockRunner.SetOutput("skopeo", []byte(candidateRaw), "inspect", "--raw", "docker://quay.io/test/image:on-pr-abc")
+				mockRunner.SetOutput("skopeo", []byte(candidateRaw), "inspect", "--raw", "docker://quay.io/other/image:sha-abc")
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeFalse())
+				Expect(mockRunner.GetLastCommand()).To(Equal([]string{"skopeo", "copy", "--all", "--preserve-digests",
+					"docker://quay.io/test/image:on-pr-abc", "docker://quay.io/test/image:tag"}))
+			})
+
+			It("should build when no candidate exists", func() {
+				mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "manifest unknown"}
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeTrue())
+				Expect(mockRunner.GetExecutedCommands()).To(HaveLen(3))
+			})
+
+			It("should build and record a warning when the candidate can't be retagged", func() {
+				mockRunner.SetOutput("skopeo", []byte(candidateRaw), "inspect", "--raw", "docker://quay.io/test/image:on-pr-abc")
+				mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "denied"}, "copy", "--all", "--preserve-digests",
+					"docker://quay.io/test/image:on-pr-abc", "docker://quay.io/test/image:tag")
+
+				Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.ShouldBuild).To(BeTrue())
+				Expect(state.Warnings.Messages()).To(ContainElement(ContainSubstring("failed to copy existing image quay.io/test/image:on-pr-abc")))
+			})
+		})
+	})
+
+	Describe("clone step", func() {
+		It("should clone the repository and write git and image results", func() {
+			repoDir := GinkgoT().TempDir()
+			commitSHA := newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+
+			Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(state.CloneResult.CommitSHA).To(Equal(commitSHA))
+			Expect(readResult(resultsDir, "commit")).To(Equal(commitSHA))
+			Expect(readResult(resultsDir, "url")).To(Equal(repoDir))
+			Expect(readResult(resultsDir, "IMAGE_URL")).To(Equal("quay.io/test/image:tag"))
+		})
+
+		Context("when writing the commit title", func() {
+			var repoDir string
+
+			BeforeEach(func() {
+				repoDir = GinkgoT().TempDir()
+				newFixtureRepo(repoDir)
+				config.GitURL = repoDir
+				config.WriteCommitTitle = true
+			})
+
+			It("should write the first line of the commit message", func() {
+				commitWithMessage(repoDir, "Fix \"quoting\" of $VARS & `ticks` in ünïcode 🚀\n\nLonger description\n")
+
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(readResult(resultsDir, "commit_title")).To(Equal("Fix \"quoting\" of $VARS & `ticks` in ünïcode 🚀"))
+			})
+
+			It("should truncate long titles to 256 characters", func() {
+				commitWithMessage(repoDir, strings.Repeat("é", 300))
+
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(readResult(resultsDir, "commit_title")).To(Equal(strings.Repeat("é", 256)))
+			})
+
+			It("should not write the commit title unless enabled", func() {
+				config.WriteCommitTitle = false
+
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(filepath.Join(resultsDir, "commit_title")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("with a local source", func() {
+			var (
+				sourceDir string
+				commitSHA string
+			)
+
+			BeforeEach(func() {
+				sourceDir = GinkgoT().TempDir()
+				commitSHA = newFixtureRepo(sourceDir)
+				config.SourceMode = SourceModeLocal
+				config.SourcePath = sourceDir
+			})
+
+			It("should use the tree in place and write git results from HEAD", func() {
+				repo, err := gogit.PlainOpen(sourceDir)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = repo.CreateRemote(&gogitconfig.RemoteConfig{
+					Name: "origin",
+					URLs: []string{"https://github.com/test/repo.git"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.CloneResult.CommitSHA).To(Equal(commitSHA))
+				Expect(builder.sourcePath()).To(Equal(sourceDir))
+				Expect(readResult(resultsDir, "commit")).To(Equal(commitSHA))
+				Expect(readResult(resultsDir, "url")).To(Equal("https://github.com/test/repo.git"))
+				Expect(readResult(resultsDir, "DIRTY")).To(Equal("false"))
+				Expect(filepath.Join(config.WorkspacePath, "source")).NotTo(BeADirectory())
+			})
+
+			It("should reject a dirty tree", func() {
+				Expect(os.WriteFile(filepath.Join(sourceDir, "Dockerfile"), []byte("FROM busybox\n"), 0644)).To(Succeed())
+
+				err := (&cloneStep{b: builder}).Run(ctx, state)
+
+				Expect(err).To(MatchError(ContainSubstring("has uncommitted changes")))
+			})
+
+			It("should flag a dirty tree when allowed", func() {
+				config.AllowDirtySource = true
+				Expect(os.WriteFile(filepath.Join(sourceDir, "Dockerfile"), []byte("FROM busybox\n"), 0644)).To(Succeed())
+
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(readResult(resultsDir, "commit")).To(Equal(commitSHA))
+				Expect(readResult(resultsDir, "DIRTY")).To(Equal("true"))
+				content, err := os.ReadFile(filepath.Join(sourceDir, "Dockerfile"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("FROM busybox\n"))
+			})
+		})
+
+		Context("with SKIP_GIT_CLONE", func() {
+			const commitSHA = "0123456789abcdef0123456789abcdef01234567"
+
+			BeforeEach(func() {
+				config.SkipGitClone = true
+				config.GitURL = "https://github.com/test/unreachable.git"
+				Expect(os.MkdirAll(builder.sourcePath(), 0755)).To(Succeed())
+				Expect(builder.writeResult("commit", commitSHA+"\n")).To(Succeed())
+			})
+
+			It("should reuse the workspace and the git results of the previous task", func() {
+				Expect(builder.writeResult("url", "https://github.com/test/repo.git")).To(Succeed())
+
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(state.CloneResult.CommitSHA).To(Equal(commitSHA))
+				Expect(readResult(resultsDir, "commit")).To(Equal(commitSHA))
+				Expect(readResult(resultsDir, "url")).To(Equal("https://github.com/test/repo.git"))
+				Expect(readResult(resultsDir, "IMAGE_URL")).To(Equal("quay.io/test/image:tag"))
+			})
+
+			It("should fall back to GIT_URL without a url result", func() {
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(readResult(resultsDir, "url")).To(Equal("https://github.com/test/unreachable.git"))
+			})
+
+			It("should fail without the commit result", func() {
+				Expect(os.Remove(filepath.Join(resultsDir, "commit"))).To(Succeed())
+
+				err := (&cloneStep{b: builder}).Run(ctx, state)
+
+				Expect(err).To(MatchError(ContainSubstring("SKIP_GIT_CLONE requires the commit result of a previous task")))
+				Expect(state.CloneResult).To(BeNil())
+			})
+
+			It("should clone and replace the commit result when disabled", func() {
+				config.SkipGitClone = false
+				Expect(os.RemoveAll(builder.sourcePath())).To(Succeed())
+				repoDir := GinkgoT().TempDir()
+				clonedSHA := newFixtureRepo(repoDir)
+				config.GitURL = repoDir
+
+				Expect((&cloneStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(readResult(resultsDir, "commit")).To(Equal(clonedSHA))
+				Expect(readResult(resultsDir, "url")).To(Equal(repoDir))
+			})
+
+			It("should fail without the source in the workspace", func() {
+				Expect(os.RemoveAll(builder.sourcePath())).To(Succeed())
+
+				err := (&cloneStep{b: builder}).Run(ctx, state)
+
+				Expect(err).To(MatchError(ContainSubstring("SKIP_GIT_CLONE requires the source in the workspace")))
+			})
+		})
+	})
+
+	Describe("existing-digest step", func() {
+		It("should do nothing when a build is required", func() {
+			state.ShouldBuild = true
+
+			Expect((&existingDigestStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(mockRunner.String()).NotTo(ContainSubstring("inspect"))
+		})
+
+		It("should write the digest of the existing image", func() {
+			digestJSON, _ := json.Marshal(map[string]interface{}{"Digest": "sha256:existing"})
+			mockRunner.SetOutput("skopeo", digestJSON, "inspect", "docker://quay.io/test/image:tag")
+
+			Expect((&existingDigestStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "IMAGE_DIGEST")).To(Equal("sha256:existing"))
+		})
+
+		It("should write the digest and media type of an existing index from its raw manifest", func() {
+			raw := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+			mockRunner.SetOutput("skopeo", raw, "inspect", "--raw", "docker://quay.io/test/image:tag")
+			// The non-raw inspect would resolve a child manifest and must not be used
+			childJSON, _ := json.Marshal(map[string]interface{}{"Digest": "sha256:child"})
+			mockRunner.SetOutput("skopeo", childJSON, "inspect", "docker://quay.io/test/image:tag")
+
+			Expect((&initStep{b: builder}).Run(ctx, state)).To(Succeed())
+			Expect((&existingDigestStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "IMAGE_DIGEST")).To(Equal(
+				"sha256:dff9de10919148711140d349bf03f1a99eb06f94b03e51715ccebfa7cdc518e2"))
+			Expect(state.ExistingManifest.IsIndex).To(BeTrue())
+			Expect(readResult(resultsDir, "IMAGE_MEDIA_TYPE")).To(Equal("application/vnd.oci.image.index.v1+json"))
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "inspect", "docker://quay.io/test/image:tag")).To(BeFalse())
+		})
+
+		It("should write an empty digest and record a warning when lookup fails", func() {
+			mockRunner.DefaultOutput = []byte("invalid json")
+
+			Expect((&existingDigestStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "IMAGE_DIGEST")).To(BeEmpty())
+			Expect(state.Warnings.Messages()).To(HaveLen(1))
+		})
+
+		It("should fail when lookup fails and the digest is required", func() {
+			config.RequireDigest = true
+			mockRunner.DefaultOutput = []byte("invalid json")
+
+			err := (&existingDigestStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to get digest of existing image quay.io/test/image:tag")))
+			Expect(filepath.Join(resultsDir, "IMAGE_DIGEST")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("base-image-policy step", func() {
+		BeforeEach(func() {
+			state.ShouldBuild = true
+			sourceDir := filepath.Join(config.WorkspacePath, "source")
+			Expect(os.MkdirAll(sourceDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sourceDir, "Dockerfile"), []byte(
+				"ARG BASE\n"+
+					"FROM registry.access.redhat.com/ubi7/ubi:7.9 AS builder\n"+
+					"FROM ${BASE}\n"+
+					"COPY --from=builder /app /app\n"), 0644)).To(Succeed())
+		})
+
+		It("should be skipped without a policy", func() {
+			Expect((&baseImagePolicyStep{b: builder}).Skip(config)).To(BeTrue())
+		})
+
+		It("should record warnings and the CHECKS result", func() {
+			policy, err := image.ParseBaseImagePolicy(
+				`{"rules":[{"repository":"registry.access.redhat.com/ubi7","action":"warn","reason":"EOL"}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			config.BaseImagePolicy = policy
+
+			Expect((&baseImagePolicyStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(state.Warnings.Messages()).To(HaveLen(2))
+			Expect(state.Warnings.Messages()[1]).To(ContainSubstring("can't be resolved"))
+			Expect(readResult(resultsDir, "CHECKS")).To(ContainSubstring(`"pattern":"registry.access.redhat.com/ubi7"`))
+		})
+
+		It("should fail naming the offending line and pattern when denied", func() {
+			policy, err := image.ParseBaseImagePolicy(
+				`{"rules":[{"repository":"registry.access.redhat.com/ubi7","tag":"7.*","action":"deny"}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			config.BaseImagePolicy = policy
+
+			err = (&baseImagePolicyStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("line 2 matches registry.access.redhat.com/ubi7:7.*")))
+			Expect(readResult(resultsDir, "CHECKS")).To(ContainSubstring(`"action":"deny"`))
+		})
+
+		It("should evaluate the base image of DockerfileFrom", func() {
+			policy, err := image.ParseBaseImagePolicy(
+				`{"rules":[{"repository":"registry.example.com/denied","action":"deny"}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			config.BaseImagePolicy = policy
+			config.DockerfileFrom = "registry.example.com/denied:latest"
+
+			err = (&baseImagePolicyStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("line 2 matches registry.example.com/denied")))
+		})
+	})
+
+	Describe("remote-sources step", func() {
+		BeforeEach(func() {
+			state.ShouldBuild = true
+			sourceDir := filepath.Join(config.WorkspacePath, "source")
+			Expect(os.MkdirAll(sourceDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sourceDir, "Dockerfile"), []byte(
+				"FROM quay.io/test/base:1\n"+
+					"ADD https://github.com/test/tool/releases/download/v1/tool.tar.gz /opt/\n"+
+					"COPY . /src\n"), 0644)).To(Succeed())
+		})
+
+		It("should check the Dockerfile with DockerfileFrom applied", func() {
+			config.Hermetic = true
+			config.DockerfileFrom = "registry.example.com/ubi9:patched"
+			Expect(os.WriteFile(filepath.Join(config.WorkspacePath, "source", "Dockerfile"), []byte("RUN make\n"), 0644)).To(Succeed())
+
+			err := (&remoteSourcesStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to override the base image")))
+		})
+
+		It("should only run for hermetic builds or with an allowlist", func() {
+			Expect((&remoteSourcesStep{b: builder}).Skip(config)).To(BeTrue())
+
+			config.Hermetic = true
+			Expect((&remoteSourcesStep{b: builder}).Skip(config)).To(BeFalse())
+
+			config.Hermetic = false
+			config.RemoteSourceAllowlist = `^https://github\.com/`
+			Expect((&remoteSourcesStep{b: builder}).Skip(config)).To(BeFalse())
+		})
+
+		It("should fail a hermetic build with the offending lines", func() {
+			config.Hermetic = true
+
+			err := (&remoteSourcesStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("prefetch them with PREFETCH_INPUT instead")))
+			Expect(err).To(MatchError(ContainSubstring("line 2: ADD https://github.com/test/tool/releases/download/v1/tool.tar.gz")))
+			Expect(readResult(resultsDir, "CHECKS")).To(ContainSubstring(`"remote_sources":[{"line":2`))
+		})
+
+		It("should accept remote sources matching the allowlist", func() {
+			config.RemoteSourceAllowlist = `^https://github\.com/test/`
+
+			Expect((&remoteSourcesStep{b: builder}).Run(ctx, state)).To(Succeed())
+		})
+
+		It("should reject remote sources outside the allowlist", func() {
+			config.RemoteSourceAllowlist = `^https://artifacts\.example\.com/`
+
+			err := (&remoteSourcesStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("remote sources don't match the allowlist: line 2")))
+		})
+	})
+
+	Describe("prefetch step", func() {
+		It("should be skipped without prefetch input", func() {
+			Expect((&prefetchStep{b: builder}).Skip(config)).To(BeTrue())
+
+			config.PrefetchInput = "gomod"
+			Expect((&prefetchStep{b: builder}).Skip(config)).To(BeFalse())
+		})
+	})
+
+	Describe("build step", func() {
+		It("should do nothing when the build is not required", func() {
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(mockRunner.String()).NotTo(ContainSubstring("inspect"))
+		})
+
+		It("should build, push and write the image digest", func() {
+			state.ShouldBuild = true
+			digestJSON, _ := json.Marshal(map[string]interface{}{"Digest": "sha256:built"})
+			mockRunner.SetOutput("skopeo", digestJSON, "inspect", "docker://quay.io/test/image:tag")
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(state.BuildResult.ImageDigest).To(Equal("sha256:built"))
+			Expect(readResult(resultsDir, "IMAGE_DIGEST")).To(Equal("sha256:built"))
+			Expect(filepath.Join(resultsDir, "DOCKERFILE_DIGEST")).NotTo(BeAnExistingFile())
+		})
+
+		It("should not rerun a build reporting 429 Too Many Requests", func() {
+			state.ShouldBuild = true
+			mockRunner.DefaultError = &exec.CommandError{ExitCode: 1,
+				Message: "exit status 1: error building at STEP \"RUN curl\": 429 Too Many Requests"}
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(MatchError(ContainSubstring("429 Too Many Requests")))
+
+			var builds int
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "unshare" {
+					builds++
+				}
+			}
+			Expect(builds).To(Equal(1))
+			Expect(builder.registryRetries.Retries).To(BeZero())
+		})
+
+		It("should pass the registry mirrors to the build", func() {
+			state.ShouldBuild = true
+			config.ImageRegistryMirrors = map[string]string{"docker.io": "mirror.internal.example.com"}
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			var buildScript string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "unshare" {
+					buildScript = cmd[len(cmd)-1]
+					break
+				}
+			}
+			Expect(buildScript).To(MatchRegexp(`'--registries-conf' '[^']+/registries.conf'`))
+		})
+
+		Context("with the cachi2 environment", func() {
+			buildScript := func() string {
+				for _, cmd := range mockRunner.GetExecutedCommands() {
+					if cmd[0] == "unshare" {
+						return cmd[len(cmd)-1]
+					}
+				}
+				return ""
+			}
+
+			BeforeEach(func() {
+				state.ShouldBuild = true
+				config.PrefetchInput = "gomod"
+				config.BuildArgs = []string{"GOFLAGS=-mod=vendor"}
+				envDir := filepath.Join(builder.workDir(), "cachi2")
+				Expect(os.MkdirAll(envDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(envDir, "cachi2.env"), []byte(
+					"export GOPROXY=\"file:///cachi2/output/deps/gomod/pkg/mod/cache/download\"\n"+
+						"export GOFLAGS='-mod=mod'\n"), 0644)).To(Succeed())
+			})
+
+			It("should inject its variables as build arguments overridden by BUILD_ARGS", func() {
+				config.InjectCachi2Env = true
+
+				Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(buildScript()).To(ContainSubstring(`'--build-arg' 'GOFLAGS=-mod=mod' ` +
+					`'--build-arg' 'GOPROXY=file:///cachi2/output/deps/gomod/pkg/mod/cache/download' ` +
+					`'--build-arg' 'GOFLAGS=-mod=vendor'`))
+			})
+
+			It("should not inject its variables unless enabled", func() {
+				Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+				Expect(buildScript()).To(ContainSubstring(`'--build-arg' 'GOFLAGS=-mod=vendor'`))
+				Expect(buildScript()).NotTo(ContainSubstring("GOPROXY"))
+			})
+
+			It("should fail when the environment file is missing", func() {
+				config.InjectCachi2Env = true
+				Expect(os.Remove(filepath.Join(builder.workDir(), "cachi2", "cachi2.env"))).To(Succeed())
+
+				err := (&buildStep{b: builder}).Run(ctx, state)
+
+				Expect(err).To(MatchError(ContainSubstring("failed to read prefetch environment")))
+			})
+		})
+
+		It("should generate an SBOM with syft after the push", func() {
+			state.ShouldBuild = true
+			config.GenerateSBOM = true
+			sbomDir := filepath.Join(config.WorkspacePath, "sbom")
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "SBOM_PATH")).To(Equal(filepath.Join(sbomDir, "sbom-cyclonedx.json")))
+			commands := mockRunner.GetExecutedCommands()
+			exportCmd := commands[len(commands)-2]
+			Expect(exportCmd[0]).To(Equal("unshare"))
+			Expect(exportCmd[len(exportCmd)-1]).To(ContainSubstring(
+				`'push' 'quay.io/test/image:tag' 'oci-archive:` + filepath.Join(sbomDir, "image.tar") + `'`))
+			Expect(mockRunner.AssertCommandExecuted("syft", "packages", "oci-archive:"+filepath.Join(sbomDir, "image.tar"),
+				"-o", "cyclonedx-json", "--file", filepath.Join(sbomDir, "sbom-cyclonedx.json"))).To(BeTrue())
+		})
+
+		It("should fail the build when the SBOM can't be generated", func() {
+			state.ShouldBuild = true
+			config.GenerateSBOM = true
+			sbomDir := filepath.Join(config.WorkspacePath, "sbom")
+			mockRunner.SetError("syft", &exec.CommandError{ExitCode: 127, Message: "syft: command not found"},
+				"packages", "oci-archive:"+filepath.Join(sbomDir, "image.tar"),
+				"-o", "cyclonedx-json", "--file", filepath.Join(sbomDir, "sbom-cyclonedx.json"))
+
+			err := (&buildStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("SBOM generation failed")))
+			Expect(filepath.Join(resultsDir, "SBOM_PATH")).NotTo(BeAnExistingFile())
+		})
+
+		It("should write and label the digest of the Dockerfile", func() {
+			state.ShouldBuild = true
+			Expect(os.MkdirAll(builder.sourcePath(), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(builder.sourcePath(), "Dockerfile"), []byte("FROM scratch\n"), 0644)).To(Succeed())
+			const digest = "sha256:bb57c7da220a8753d7bdabac0d3afdb6efa742e4c736c5bc93ab40dfd5e23b9b"
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "DOCKERFILE_DIGEST")).To(Equal(digest))
+			Expect(mockRunner.String()).To(ContainSubstring(`'--label' 'io.konflux.dockerfile-digest=` + digest + `'`))
+		})
+
+		It("should write the media type of the pushed manifest", func() {
+			state.ShouldBuild = true
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+			mockRunner.SetOutput("skopeo", []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","layers":[]}`),
+				"inspect", "--raw", "docker://quay.io/test/image@sha256:built")
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "IMAGE_MEDIA_TYPE")).To(Equal("application/vnd.docker.distribution.manifest.v2+json"))
+		})
+
+		It("should write the labels of the pushed image to the label file", func() {
+			state.ShouldBuild = true
+			config.LabelFile = filepath.Join(GinkgoT().TempDir(), "labels")
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built","Labels":{"io.konflux.commit":"abc123","vendor":"Red Hat"}}`),
+				"inspect", "docker://quay.io/test/image@sha256:built")
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(os.ReadFile(config.LabelFile)).To(Equal([]byte("io.konflux.commit=abc123\nvendor=Red Hat\n")))
+		})
+
+		It("should build a copy of the Dockerfile using DockerfileFrom as base image", func() {
+			state.ShouldBuild = true
+			config.DockerfileFrom = "registry.example.com/ubi9:patched"
+			dockerfile := filepath.Join(builder.sourcePath(), "Dockerfile")
+			Expect(os.MkdirAll(builder.sourcePath(), 0755)).To(Succeed())
+			Expect(os.WriteFile(dockerfile, []byte("FROM scratch\n"), 0644)).To(Succeed())
+			const digest = "sha256:7bdbd649d08967f76faccd2f7c0cca50270dc8003bfcb847bac25ae2f795b164"
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "DOCKERFILE_DIGEST")).To(Equal(digest))
+			Expect(os.ReadFile(dockerfile)).To(Equal([]byte("FROM scratch\n")))
+			Expect(mockRunner.String()).To(ContainSubstring(`'--file' '` + filepath.Join(os.TempDir(), "Dockerfile-")))
+		})
+
+		It("should fail when the Dockerfile has no FROM to override", func() {
+			state.ShouldBuild = true
+			config.DockerfileFrom = "registry.example.com/ubi9:patched"
+			Expect(os.MkdirAll(builder.sourcePath(), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(builder.sourcePath(), "Dockerfile"), []byte("RUN make\n"), 0644)).To(Succeed())
+
+			err := (&buildStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to override the base image")))
+		})
+
+		It("should record the layer counts in the CHECKS result", func() {
+			state.ShouldBuild = true
+			config.MaxLayers = 127
+			digestJSON, _ := json.Marshal(map[string]interface{}{"Digest": "sha256:built"})
+			mockRunner.SetOutput("skopeo", digestJSON, "inspect", "docker://quay.io/test/image:tag")
+			mockRunner.SetOutput("buildah", []byte(`{"OCIv1":{"rootfs":{"diff_ids":["sha256:a","sha256:b"]},"history":[{},{},{}]}}`),
+				"inspect", "--type", "image", "quay.io/test/image:tag")
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "CHECKS")).To(Equal(`{"image_layers":{"layers":2,"history":3}}`))
+		})
+
+		It("should record the checked image configuration in the CHECKS result", func() {
+			state.ShouldBuild = true
+			config.ImageConfigExpectations = &image.ImageConfigExpectations{Entrypoint: []string{"/app"}}
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+			mockRunner.SetOutput("buildah", []byte(`{"OCIv1":{"config":{"Entrypoint":["/app"],"User":"1001"}}}`),
+				"inspect", "--type", "image", "quay.io/test/image:tag")
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(readResult(resultsDir, "CHECKS")).To(Equal(
+				`{"image_config":{"entrypoint":["/app"],"exposed_ports":[],"user":"1001"}}`))
+		})
+
+		It("should record the file manifest and warn about denied files", func() {
+			state.ShouldBuild = true
+			config.FileManifest = true
+			config.FileDenyPatterns = []string{".npmrc"}
+			config.FileDenyAction = image.FileDenyActionWarn
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+			builder.runner = &fileListingRunner{
+				MockCommandRunner: mockRunner,
+				listingPath:       filepath.Join(config.WorkspacePath, "file-manifest.jsonl.listing"),
+				listing:           "d 755 0 app\x00f 600 10 app/.npmrc\x00",
+			}
+
+			Expect((&buildStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(state.BuildResult.FileManifest.Path).To(Equal(filepath.Join(config.WorkspacePath, "file-manifest.jsonl")))
+			Expect(state.Warnings.Messages()).To(ConsistOf("image contains denied file app/.npmrc"))
+			Expect(readResult(resultsDir, "CHECKS")).To(MatchRegexp(
+				`^\{"file_manifest":\{"path":".*/file-manifest.jsonl","digest":"sha256:[0-9a-f]{64}","files":2,"matches":\["app/.npmrc"\],"denied_files":1\}\}$`))
+		})
+	})
+
+	Describe("cleanup-temp-tags step", func() {
+		BeforeEach(func() {
+			config.CleanupTempTags = true
+			config.TempTagPattern = image.DefaultTemporaryTagPattern
+			config.TempTagTTL = time.Hour
+			config.TempTagCleanupMax = 5
+			builder.now = func() time.Time { return time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC) }
+		})
+
+		It("should be skipped unless enabled", func() {
+			config.CleanupTempTags = false
+			Expect((&cleanupTempTagsStep{b: builder}).Skip(config)).To(BeTrue())
+		})
+
+		It("should delete expired temporary tags and record failures as warnings", func() {
+			deleter := &tagDeleter{failing: "v1-run-2-tmp1717000000"}
+			builder.deleter = deleter
+			mockRunner.SetOutput("skopeo", []byte(`{"Tags":["tag","tag-run-1","v1-run-1-tmp1717000000","v1-run-2-tmp1717000000","v1-run-3-tmp1717242000"]}`),
+				"list-tags", "docker://quay.io/test/image")
+
+			Expect((&cleanupTempTagsStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(deleter.deleted).To(Equal([]string{"v1-run-1-tmp1717000000"}))
+			Expect(mockRunner.String()).NotTo(ContainSubstring("skopeo delete"))
+			Expect(state.Warnings.Messages()).To(ConsistOf(ContainSubstring("failed to delete temporary tag v1-run-2-tmp1717000000")))
+		})
+
+		It("should only warn when the tags can't be listed", func() {
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+				"list-tags", "docker://quay.io/test/image")
+
+			Expect((&cleanupTempTagsStep{b: builder}).Run(ctx, state)).To(Succeed())
+
+			Expect(state.Warnings.Messages()).To(ConsistOf(ContainSubstring("failed to clean up temporary tags")))
+		})
+
+		It("should fail when STRICT_WARNINGS escalates cleanup failures", func() {
+			state.Warnings.Strict = []string{warnings.CategoryTempTagCleanup}
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+				"list-tags", "docker://quay.io/test/image")
+
+			err := (&cleanupTempTagsStep{b: builder}).Run(ctx, state)
+
+			Expect(err).To(MatchError(ContainSubstring("temp-tag-cleanup warning escalated to an error")))
+			Expect(state.Warnings.Warnings()).To(HaveLen(1))
+		})
+	})
+
+	Describe("Execute", func() {
+		It("should run every step in order including custom ones", func() {
+			var runs []string
+			builder.Steps = []Step{
+				&recordingStep{name: "first", runs: &runs},
+				&recordingStep{name: "second", runs: &runs},
+			}
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(runs).To(Equal([]string{"first", "second"}))
+		})
+
+		It("should skip an existing image end to end", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			digestJSON, _ := json.Marshal(map[string]interface{}{"Digest": "sha256:existing"})
+			mockRunner.SetOutput("skopeo", digestJSON, "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(readResult(resultsDir, "build")).To(Equal("false"))
+			Expect(readResult(resultsDir, "IMAGE_DIGEST")).To(Equal("sha256:existing"))
+			Expect(readResult(resultsDir, "BUILD_METRICS")).To(MatchRegexp(
+				`^clone_seconds=\d+\.\d{3}\nprefetch_seconds=0\.000\nbuild_seconds=0\.000\npush_seconds=0\.000\n` +
+					`image_size_bytes=0\nretries_total=0\nrate_limited_total=0\ntotal_backoff_seconds=0\.000\nskipped=true$`))
+		})
+
+		It("should back off from registry rate limits and count them in BUILD_METRICS", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			mockRunner.QueueResult("skopeo", nil, &exec.CommandError{
+				ExitCode: 1,
+				Message:  "exit status 1: received unexpected HTTP status: 429 Too Many Requests (Retry-After: 0)",
+			}, "inspect", "docker://quay.io/test/image:tag")
+			digestJSON, _ := json.Marshal(map[string]interface{}{"Digest": "sha256:existing"})
+			mockRunner.SetOutput("skopeo", digestJSON, "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(readResult(resultsDir, "IMAGE_DIGEST")).To(Equal("sha256:existing"))
+			metrics := readResult(resultsDir, "BUILD_METRICS")
+			Expect(metrics).To(ContainSubstring("retries_total=1\nrate_limited_total=1\ntotal_backoff_seconds=0.000\n"))
+		})
+
+		It("should summarize the retried commands in RETRY_SUMMARY", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			rateLimited := &exec.CommandError{
+				ExitCode: 1,
+				Message:  "exit status 1: received unexpected HTTP status: 429 Too Many Requests (Retry-After: 0)",
+			}
+			mockRunner.QueueResult("skopeo", nil, rateLimited, "inspect", "docker://quay.io/test/image:tag")
+			mockRunner.QueueResult("skopeo", nil, rateLimited, "inspect", "docker://quay.io/test/image:tag")
+			digestJSON, _ := json.Marshal(map[string]interface{}{"Digest": "sha256:existing"})
+			mockRunner.SetOutput("skopeo", digestJSON, "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var summary map[string]exec.RetryCount
+			Expect(json.Unmarshal([]byte(readResult(resultsDir, "RETRY_SUMMARY")), &summary)).To(Succeed())
+			Expect(summary["inspect"].Retries).To(Equal(2))
+			Expect(summary["inspect"].Attempts).To(BeNumerically(">", 2))
+			Expect(summary).To(HaveKeyWithValue("push", exec.RetryCount{}))
+			Expect(summary).To(HaveKeyWithValue("manifest_push", exec.RetryCount{}))
+			Expect(summary["git"].Attempts).To(BeNumerically(">=", 1))
+			Expect(summary["git"].Retries).To(BeZero())
+		})
+
+		It("should set the image creation time to the commit timestamp when requested", func() {
+			repoDir := GinkgoT().TempDir()
+			sha := newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			config.UseCommitTimestamp = true
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			repo, err := gogit.PlainOpen(repoDir)
+			Expect(err).NotTo(HaveOccurred())
+			commit, err := repo.CommitObject(plumbing.NewHash(sha))
+			Expect(err).NotTo(HaveOccurred())
+
+			var buildCmd string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "unshare" && strings.Contains(cmd[len(cmd)-1], `'build'`) {
+					buildCmd = cmd[len(cmd)-1]
+				}
+			}
+			Expect(buildCmd).To(ContainSubstring(fmt.Sprintf(`'--timestamp' '%d'`, commit.Committer.When.Unix())))
+		})
+
+		It("should keep the build time as image creation time by default", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				Expect(strings.Join(cmd, " ")).NotTo(ContainSubstring("--timestamp"))
+			}
+		})
+
+		It("should label the image with the detected tool versions", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			config.ToolVersionLabels = true
+			mockRunner.SetOutput("buildah", []byte("buildah version 1.33.7 (image-spec 1.1.0, runtime-spec 1.1.0)\n"), "--version")
+			mockRunner.SetOutput("skopeo", []byte("skopeo version 1.14.2\n"), "--version")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var buildCmd string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "unshare" {
+					buildCmd = cmd[len(cmd)-1]
+				}
+			}
+			Expect(buildCmd).To(ContainSubstring(`'--label' 'io.konflux.buildah-version=1.33.7'`))
+			Expect(buildCmd).To(ContainSubstring(`'--label' 'io.konflux.skopeo-version=1.14.2'`))
+		})
+
+		It("should build when the tool versions can't be detected", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			config.ToolVersionLabels = true
+			mockRunner.SetError("buildah", &exec.CommandError{ExitCode: 1, Message: "unknown flag"}, "--version")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.String()).NotTo(ContainSubstring("io.konflux.buildah-version"))
+		})
+
+		It("should write the warnings of every step as the WARNINGS result", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.RequireDigest = false
+			mockRunner.SetOutput("skopeo", []byte("invalid json"), "inspect", "--raw", "docker://quay.io/test/image:tag")
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+				"inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var result struct {
+				Count    int
+				Warnings []warnings.Warning
+			}
+			Expect(json.Unmarshal([]byte(readResult(resultsDir, "WARNINGS")), &result)).To(Succeed())
+			var categories, steps []string
+			for _, warning := range result.Warnings {
+				categories = append(categories, warning.Category)
+				steps = append(steps, warning.Step)
+			}
+			Expect(result.Count).To(Equal(4))
+			Expect(categories).To(Equal([]string{warnings.CategoryToolVersion, warnings.CategoryToolVersion,
+				warnings.CategoryExistingImage, warnings.CategoryDigest}))
+			Expect(steps).To(Equal([]string{"", "", "init", "existing-digest"}))
+
+			full, err := os.ReadFile(filepath.Join(config.WorkspacePath, "warnings.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(full)).To(ContainSubstring("failed to get existing image digest"))
+		})
+
+		It("should fail the build on a warning escalated by STRICT_WARNINGS", func() {
+			config.StrictWarnings = []string{warnings.CategoryToolVersion}
+			var runs []string
+			builder.Steps = []Step{&recordingStep{name: "first", runs: &runs}}
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("tool-version warning escalated to an error")))
+			Expect(runs).To(BeEmpty())
+			Expect(readResult(resultsDir, "WARNINGS")).To(ContainSubstring(`"count":1`))
+		})
+
+		It("should stop after the git results in clone-only mode", func() {
+			repoDir := GinkgoT().TempDir()
+			commitSHA := newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.CloneOnly = true
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			entries, err := os.ReadDir(resultsDir)
+			Expect(err).NotTo(HaveOccurred())
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			Expect(names).To(ConsistOf("commit", "url"))
+			Expect(readResult(resultsDir, "commit")).To(Equal(commitSHA))
+			Expect(mockRunner.String()).NotTo(ContainSubstring("inspect"))
+		})
+
+		It("should set the storage driver for every buildah invocation", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			config.StorageDriver = "vfs"
+			builder = NewBuilder(zap.NewNop(), config, mockRunner)
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var buildah int
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				Expect(cmd[0]).NotTo(Equal("buildah"))
+				Expect(cmd[0]).NotTo(Equal("unshare"))
+				if cmd[0] == "env" {
+					Expect(cmd[1:3]).To(Equal([]string{"BUILDAH_STORAGE_DRIVER=vfs", "STORAGE_DRIVER=vfs"}))
+					buildah++
+				}
+			}
+			Expect(buildah).To(BeNumerically(">=", 2))
+		})
+
+		It("should write BUILD_METRICS with the pushed image size", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built","LayersData":[{"Size":100},{"Size":23}]}`),
+				"inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			metrics := readResult(resultsDir, "BUILD_METRICS")
+			Expect(metrics).To(ContainSubstring("image_size_bytes=123\n"))
+			Expect(metrics).To(HaveSuffix("\nskipped=false"))
+			Expect(metrics).To(MatchRegexp(`build_seconds=\d+\.\d{3}\npush_seconds=\d+\.\d{3}\n`))
+		})
+
+		It("should write the build arguments of the file and the command line as BUILD_ARGS_USED", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			argsFile := filepath.Join(GinkgoT().TempDir(), "build-args")
+			Expect(os.WriteFile(argsFile, []byte("GO_VERSION=1.20\nNODE_VERSION=20\n"), 0644)).To(Succeed())
+			config.GitURL = repoDir
+			config.Rebuild = true
+			config.BuildArgsFile = argsFile
+			config.BuildArgs = []string{"GO_VERSION=1.21", "DEBUG=true"}
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(readResult(resultsDir, "BUILD_ARGS_USED")).To(Equal(`["GO_VERSION=1.21","NODE_VERSION=20","DEBUG=true"]`))
+		})
+
+		It("should write the redacted effective build arguments as BUILD_ARGS_EFFECTIVE when enabled", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			argsFile := filepath.Join(GinkgoT().TempDir(), "build-args")
+			Expect(os.WriteFile(argsFile, []byte("GO_VERSION=1.20\nNPM_TOKEN=secret\n"), 0644)).To(Succeed())
+			config.GitURL = repoDir
+			config.Rebuild = true
+			config.EmitEffectiveBuildArgs = true
+			config.BuildArgsFile = argsFile
+			config.BuildArgs = []string{"GO_VERSION=1.21"}
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(readResult(resultsDir, "BUILD_ARGS_EFFECTIVE")).To(Equal(
+				`[{"arg":"GO_VERSION=1.21","source":"cli"},{"arg":"NPM_TOKEN=********","source":"file"}]`))
+		})
+
+		It("should not write BUILD_ARGS_EFFECTIVE unless enabled", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(filepath.Join(resultsDir, "BUILD_ARGS_EFFECTIVE")).NotTo(BeAnExistingFile())
+		})
+
+		It("should log in to the registry and use the temporary authfile for the following commands", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			credsDir := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(credsDir, "username"), []byte("robot"), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(credsDir, "password"), []byte("s3cret"), 0600)).To(Succeed())
+			config.GitURL = repoDir
+			config.RegistryLogin = true
+			config.RegistryType = image.RegistryTypeDefault
+			config.RegistryCredentialsPath = credsDir
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var login []string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if len(cmd) > 1 && cmd[0] == "buildah" && cmd[1] == "login" {
+					login = cmd
+				}
+			}
+			Expect(login).NotTo(BeNil())
+			Expect(string(mockRunner.GetStdin("buildah", login[1:]...))).To(Equal("s3cret"))
+			authFile := login[3]
+			Expect(mockRunner.AssertCommandExecuted("env", append([]string{"REGISTRY_AUTH_FILE=" + authFile, "skopeo"},
+				image.SkopeoExistsCommand("quay.io/test/image:tag", true)...)...)).To(BeTrue())
+			Expect(authFile).NotTo(BeAnExistingFile())
+		})
+
+		It("should stage the temporary files of the container tools under BUILD_TMPDIR and remove them", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			config.TempDir = "tmp"
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			commands := mockRunner.GetExecutedCommands()
+			Expect(commands).NotTo(BeEmpty())
+			tmpDir := strings.TrimPrefix(commands[0][1], "TMPDIR=")
+			var tools []string
+			for _, cmd := range commands {
+				Expect(cmd[:2]).To(Equal([]string{"env", "TMPDIR=" + tmpDir}))
+				tools = append(tools, cmd[2])
+			}
+			Expect(tools).To(ContainElements("buildah", "skopeo", "unshare"))
+			Expect(tmpDir).To(HavePrefix(filepath.Join(config.WorkspacePath, "tmp", "tmp-")))
+			Expect(tmpDir).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(config.WorkspacePath, "tmp")).To(BeADirectory())
+		})
+
+		It("should fail before the clone when the push preflight is denied", func() {
+			config.PreflightPushCheck = true
+			var runs []string
+			builder.Steps = []Step{&recordingStep{name: "clone", runs: &runs}}
+			mockRunner.SetError("buildah", &exec.CommandError{ExitCode: 125, Message: "requested access to the resource is denied"},
+				"manifest", "push", "--all", "quay.io/test/image:tag-preflight", "docker://quay.io/test/image:tag-preflight")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("cannot push to quay.io/test/image with the provided credentials")))
+			Expect(runs).To(BeEmpty())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", "quay.io/test/image:tag-preflight")).To(BeTrue())
+		})
+
+		It("should warn and go on when the preflight tag can't be deleted", func() {
+			config.PreflightPushCheck = true
+			var runs []string
+			builder.Steps = []Step{&recordingStep{name: "clone", runs: &runs}}
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+				"delete", "docker://quay.io/test/image:tag-preflight")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(runs).To(Equal([]string{"clone"}))
+			Expect(readResult(resultsDir, "WARNINGS")).To(ContainSubstring(warnings.CategoryTempTagCleanup))
+		})
+
+		It("should map the subordinate IDs granted to the user in unshare", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.Rebuild = true
+			subIDDir := GinkgoT().TempDir()
+			builder.subUIDPath = filepath.Join(subIDDir, "subuid")
+			builder.subGIDPath = filepath.Join(subIDDir, "subgid")
+			Expect(os.WriteFile(builder.subUIDPath, []byte(fmt.Sprintf("%d:524288:10000\n", os.Getuid())), 0644)).To(Succeed())
+			Expect(os.WriteFile(builder.subGIDPath, []byte(fmt.Sprintf("%d:624288:20000\n", os.Getuid())), 0644)).To(Succeed())
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var buildCmd []string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "unshare" {
+					buildCmd = cmd
+				}
+			}
+			Expect(buildCmd).To(ContainElements("--map-users", "524288,1,10000", "--map-groups", "624288,1,20000"))
+		})
+
+		It("should fail before the build when UNSHARE_UID_MAP exceeds the granted range", func() {
+			builder.subUIDPath = filepath.Join(GinkgoT().TempDir(), "subuid")
+			Expect(os.WriteFile(builder.subUIDPath, []byte(fmt.Sprintf("%d:524288:10000\n", os.Getuid())), 0644)).To(Succeed())
+			config.UnshareUIDMap = "1,1,65536"
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("invalid unshare user mapping: mapping 1,1,65536 needs host IDs 1-65536")))
+			Expect(err).To(MatchError(ContainSubstring("grants 524288-534287 (10000 IDs)")))
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				Expect(cmd[0]).NotTo(Equal("unshare"))
+			}
+		})
+
+		It("should leave the excluded paths out of the checkout", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.GitExcludePaths = []string{"generated/"}
+			config.Rebuild = true
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			sourceDir := filepath.Join(config.WorkspacePath, "source")
+			content, err := os.ReadFile(filepath.Join(sourceDir, ".git", "info", "sparse-checkout"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("/*\n!generated/\n"))
+			Expect(mockRunner.AssertCommandExecuted("git", "-C", sourceDir, "read-tree", "-mu", "HEAD")).To(BeTrue())
+		})
+
+		It("should label the image with the git ref and tag it from the template", func() {
+			repoDir := GinkgoT().TempDir()
+			sha := newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.GitRef = "refs/heads/feature/login"
+			config.TagTemplate = "{{.Ref}}-{{.ShortSHA}}"
+			config.Rebuild = true
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:built"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var buildCmd string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "unshare" {
+					buildCmd = cmd[len(cmd)-1]
+				}
+			}
+			Expect(buildCmd).To(ContainSubstring(`'--label' 'io.konflux.ref=refs/heads/feature/login'`))
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "copy", "--all", "--preserve-digests",
+				"docker://quay.io/test/image@sha256:built", "docker://quay.io/test/image:feature-login-"+sha[:7])).To(BeTrue())
+		})
+
+		It("should fail before building when the template renders an invalid tag", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			config.TagTemplate = "{{.Ref}}"
+			config.Rebuild = true
+
+			Expect(builder.Execute(ctx)).To(MatchError(ContainSubstring("failed to render TAG_TEMPLATE")))
+
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				Expect(cmd[0]).NotTo(Equal("unshare"))
+			}
+		})
+
+		It("should not write BUILD_ARGS_USED when the image isn't built", func() {
+			repoDir := GinkgoT().TempDir()
+			newFixtureRepo(repoDir)
+			config.GitURL = repoDir
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:existing"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(filepath.Join(resultsDir, "BUILD_ARGS_USED")).NotTo(BeAnExistingFile())
+		})
+	})
+})
+
+// tagDeleter records the tags it deletes, failing on the failing tag
+type tagDeleter struct {
+	deleted []string
+	failing string
+}
+
+func (d *tagDeleter) DeleteTag(ctx context.Context, repository, tag string) error {
+	if tag == d.failing {
+		return errors.New("unauthorized")
+	}
+	d.deleted = append(d.deleted, tag)
+	return nil
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/buildcontainer"
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/imageindex"
+	"go.uber.org/zap"
+)
+
+// Version is the version of the binaries, set at build time with
+// -ldflags "-X github.com/konflux-ci/monolithic-builder/pkg/cli.Version=..."
+var Version = "dev"
+
+// RunFunc runs a subcommand with its positional arguments
+type RunFunc func(ctx context.Context, logger *zap.Logger, args []string, runner exec.CommandRunner) error
+
+// SignalContext returns a context cancelled on SIGINT or SIGTERM, so that
+// running commands are stopped when the pod is terminated
+func SignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+}
+
+// RunBuildContainer loads the build-container configuration from the
+// environment and args, which are KEY=value build arguments, and runs the build
+func RunBuildContainer(ctx context.Context, logger *zap.Logger, args []string, runner exec.CommandRunner) error {
+	config, err := buildcontainer.LoadConfig(args)
+	if err != nil {
+		return configError(logger, "build-container", err)
+	}
+
+	if err := buildcontainer.NewBuilder(logger, config, runner).Execute(ctx); err != nil {
+		logger.Error("Build-container execution failed", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// RunBuildImageIndex loads the build-image-index configuration from the
+// environment and builds the index. It takes no positional arguments.
+func RunBuildImageIndex(ctx context.Context, logger *zap.Logger, args []string, runner exec.CommandRunner) error {
+	if len(args) > 0 {
+		return configError(logger, "build-image-index", fmt.Errorf("unexpected arguments %q", args))
+	}
+
+	config, err := imageindex.LoadConfigFromEnv()
+	if err != nil {
+		return configError(logger, "build-image-index", err)
+	}
+
+	if err := imageindex.NewBuilder(logger, config, runner).Execute(ctx); err != nil {
+		logger.Error("Build-image-index execution failed", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// configError logs and wraps an invalid configuration of a subcommand the
+// same way for every entrypoint
+func configError(logger *zap.Logger, name string, err error) error {
+	logger.Error(fmt.Sprintf("Failed to load %s configuration", name), zap.Error(err))
+	return fmt.Errorf("invalid %s configuration: %w", name, err)
+}
+
+// VersionString formats the version printed by --version
+func VersionString(name string) string {
+	return fmt.Sprintf("%s version %s\n", name, Version)
+}
+
+// Main is the entrypoint of the standalone binaries. It handles --version,
+// creates the logger from LOG_LEVEL and LOG_FORMAT and runs run with a
+// signal-aware context, returning the exit code.
+func Main(name string, args []string, stdout, stderr io.Writer, run RunFunc) int {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	version := flags.Bool("version", false, "print the version and exit")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *version {
+		_, _ = io.WriteString(stdout, VersionString(name))
+		return 0
+	}
+
+	logger, err := NewLoggerFromEnv()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() { _ = logger.Sync() }()
+
+	ctx, stop := SignalContext(context.Background())
+	defer stop()
+
+	if err := run(ctx, logger, flags.Args(), exec.NewRealCommandRunner()); err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Main", func() {
+	var stdout, stderr *bytes.Buffer
+
+	BeforeEach(func() {
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+	})
+
+	It("should print the version without running the subcommand", func() {
+		run := func(context.Context, *zap.Logger, []string, exec.CommandRunner) error {
+			Fail("run should not be called")
+			return nil
+		}
+
+		Expect(Main("build-container", []string{"--version"}, stdout, stderr, run)).To(Equal(0))
+		Expect(stdout.String()).To(Equal("build-container version dev\n"))
+	})
+
+	It("should pass the positional arguments and a cancellable context", func() {
+		var received []string
+		run := func(ctx context.Context, _ *zap.Logger, args []string, _ exec.CommandRunner) error {
+			Expect(ctx.Done()).NotTo(BeNil())
+			received = args
+			return nil
+		}
+
+		Expect(Main("build-container", []string{"FOO=bar", "BAZ=qux"}, stdout, stderr, run)).To(Equal(0))
+		Expect(received).To(Equal([]string{"FOO=bar", "BAZ=qux"}))
+	})
+
+	It("should exit with 1 when the subcommand fails", func() {
+		run := func(context.Context, *zap.Logger, []string, exec.CommandRunner) error {
+			return errors.New("boom")
+		}
+
+		Expect(Main("build-container", nil, stdout, stderr, run)).To(Equal(1))
+	})
+
+	It("should exit with 1 on an invalid log configuration", func() {
+		GinkgoT().Setenv("LOG_FORMAT", "xml")
+		run := func(context.Context, *zap.Logger, []string, exec.CommandRunner) error {
+			Fail("run should not be called")
+			return nil
+		}
+
+		Expect(Main("build-image-index", nil, stdout, stderr, run)).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring(`invalid log format "xml"`))
+	})
+
+	It("should exit with 2 on an unknown flag", func() {
+		run := func(context.Context, *zap.Logger, []string, exec.CommandRunner) error {
+			Fail("run should not be called")
+			return nil
+		}
+
+		Expect(Main("build-image-index", []string{"--bogus"}, stdout, stderr, run)).To(Equal(2))
+	})
+})
+
+var _ = Describe("VersionString", func() {
+	It("should match the cobra version template", func() {
+		Expect(VersionString("monolithic-builder")).To(Equal("monolithic-builder version dev\n"))
+	})
+})
+
+var _ = Describe("Run helpers", func() {
+	var mockRunner *exec.MockCommandRunner
+
+	BeforeEach(func() {
+		mockRunner = exec.NewMockCommandRunner()
+	})
+
+	It("should report an invalid build-container configuration", func() {
+		GinkgoT().Setenv("SOURCE_MODE", "ftp")
+
+		err := RunBuildContainer(context.Background(), zap.NewNop(), nil, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring(`invalid build-container configuration: invalid SOURCE_MODE "ftp"`)))
+		Expect(mockRunner.GetExecutedCommands()).To(BeEmpty())
+	})
+
+	It("should report an invalid build-image-index configuration", func() {
+		GinkgoT().Setenv("IMAGE_EXPIRES_AFTER", "soon")
+
+		err := RunBuildImageIndex(context.Background(), zap.NewNop(), nil, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("invalid build-image-index configuration: invalid IMAGE_EXPIRES_AFTER")))
+		Expect(mockRunner.GetExecutedCommands()).To(BeEmpty())
+	})
+
+	It("should reject positional arguments to build-image-index", func() {
+		err := RunBuildImageIndex(context.Background(), zap.NewNop(), []string{"extra"}, mockRunner)
+
+		Expect(err).To(MatchError(`invalid build-image-index configuration: unexpected arguments ["extra"]`))
+	})
+})
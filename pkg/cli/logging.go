@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewLogger creates the logger of the binaries. level is one of debug, info,
+// warn (or warning, as passed to cachi2) and error; format is json or
+// console. Empty values select info and json.
+func NewLogger(level, format string) (*zap.Logger, error) {
+	var config zap.Config
+	switch strings.ToLower(format) {
+	case "", "json":
+		config = zap.NewProductionConfig()
+	case "console":
+		config = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected json or console", format)
+	}
+
+	if level == "" {
+		level = "info"
+	}
+	if strings.EqualFold(level, "warning") {
+		level = "warn"
+	}
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+	config.Level = zap.NewAtomicLevelAt(parsed)
+
+	return config.Build()
+}
+
+// NewLoggerFromEnv creates the logger of the binaries from LOG_LEVEL and
+// LOG_FORMAT
+func NewLoggerFromEnv() (*zap.Logger, error) {
+	return NewLogger(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap/zapcore"
+)
+
+var _ = Describe("NewLogger", func() {
+	DescribeTable("selecting the level",
+		func(level string, expected zapcore.Level) {
+			logger, err := NewLogger(level, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(logger.Core().Enabled(expected)).To(BeTrue())
+			Expect(logger.Core().Enabled(expected - 1)).To(BeFalse())
+		},
+		Entry("info by default", "", zapcore.InfoLevel),
+		Entry("debug", "debug", zapcore.DebugLevel),
+		Entry("warn", "warn", zapcore.WarnLevel),
+		Entry("warning as passed to cachi2", "warning", zapcore.WarnLevel),
+		Entry("upper case", "ERROR", zapcore.ErrorLevel),
+	)
+
+	It("should accept the console format", func() {
+		_, err := NewLogger("info", "console")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject an unknown level", func() {
+		_, err := NewLogger("verbose", "json")
+		Expect(err).To(MatchError(`invalid log level "verbose", expected debug, info, warn or error`))
+	})
+
+	It("should reject an unknown format", func() {
+		_, err := NewLogger("info", "xml")
+		Expect(err).To(MatchError(`invalid log format "xml", expected json or console`))
+	})
+
+	It("should read LOG_LEVEL and LOG_FORMAT", func() {
+		GinkgoT().Setenv("LOG_LEVEL", "error")
+		GinkgoT().Setenv("LOG_FORMAT", "console")
+
+		logger, err := NewLoggerFromEnv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logger.Core().Enabled(zapcore.WarnLevel)).To(BeFalse())
+	})
+})
@@ -0,0 +1,165 @@
+// Package duration parses the durations of expirations, timeouts and TTLs
+package duration
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Day and Week are the fixed-length units added to the stdlib ones
+	Day  = 24 * time.Hour
+	Week = 7 * Day
+
+	// month and year approximate ISO 8601 calendar units
+	month = 30 * Day
+	year  = 365 * Day
+)
+
+// units maps the unit suffixes to their length
+var units = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"μs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  Day,
+	"w":  Week,
+}
+
+// ParseExtended parses a duration made of one or more <number><unit>
+// components such as "90s", "1.5h" or "1w2d3h". Units are those of
+// time.ParseDuration plus d (24h) and w (7d). ISO 8601 durations such as
+// "P30D" or "P1DT12H" are accepted too, with a month of 30 days and a year of
+// 365 days. "0" is zero; empty, negative, malformed and overflowing values
+// are errors.
+func ParseExtended(value string) (time.Duration, error) {
+	switch {
+	case value == "":
+		return 0, fmt.Errorf("empty duration")
+	case value == "0":
+		return 0, nil
+	case strings.HasPrefix(value, "-"):
+		return 0, fmt.Errorf("invalid duration %q: negative durations are not allowed", value)
+	case strings.HasPrefix(value, "P"):
+		return parseISO8601(value)
+	}
+
+	var total time.Duration
+	rest := value
+	for rest != "" {
+		number := leadingNumber(rest)
+		if number == "" || number == "." {
+			return 0, fmt.Errorf("invalid duration %q: expected a number at %q", value, rest)
+		}
+		rest = rest[len(number):]
+
+		unitEnd := strings.IndexFunc(rest, func(r rune) bool { return r == '.' || (r >= '0' && r <= '9') })
+		if unitEnd < 0 {
+			unitEnd = len(rest)
+		}
+		unitName := rest[:unitEnd]
+		rest = rest[unitEnd:]
+
+		if unitName == "" {
+			return 0, fmt.Errorf("invalid duration %q: missing unit after %q", value, number)
+		}
+		unit, ok := units[unitName]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q: unknown unit %q", value, unitName)
+		}
+
+		component, err := scale(number, unit)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+		if total > math.MaxInt64-component {
+			return 0, fmt.Errorf("invalid duration %q: overflows the maximum duration", value)
+		}
+		total += component
+	}
+
+	return total, nil
+}
+
+// leadingNumber returns the decimal number at the start of s
+func leadingNumber(s string) string {
+	end := 0
+	seenDot := false
+	for end < len(s) {
+		c := s[end]
+		if c == '.' && !seenDot {
+			seenDot = true
+		} else if c < '0' || c > '9' {
+			break
+		}
+		end++
+	}
+	return s[:end]
+}
+
+// scale multiplies a decimal number by a unit, failing on overflow
+func scale(number string, unit time.Duration) (time.Duration, error) {
+	whole, fraction, _ := strings.Cut(number, ".")
+
+	var result time.Duration
+	if whole != "" {
+		n, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil || n > int64(math.MaxInt64/unit) {
+			return 0, fmt.Errorf("%s overflows the maximum duration", number)
+		}
+		result = time.Duration(n) * unit
+	}
+
+	if fraction != "" {
+		f, err := strconv.ParseFloat("0."+fraction, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fraction in %s", number)
+		}
+		part := time.Duration(f * float64(unit))
+		if result > math.MaxInt64-part {
+			return 0, fmt.Errorf("%s overflows the maximum duration", number)
+		}
+		result += part
+	}
+
+	return result, nil
+}
+
+// iso8601Pattern matches ISO 8601 durations such as "P1Y2M3D" or "P1DT12H"
+var iso8601Pattern = regexp.MustCompile(
+	`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseISO8601 parses an ISO 8601 duration with integer components
+func parseISO8601(value string) (time.Duration, error) {
+	matches := iso8601Pattern.FindStringSubmatch(value)
+	if matches == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", value)
+	}
+
+	isoUnits := []time.Duration{year, month, Week, Day, time.Hour, time.Minute, time.Second}
+
+	var total time.Duration
+	for i, unit := range isoUnits {
+		if matches[i+1] == "" {
+			continue
+		}
+		component, err := scale(matches[i+1], unit)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: %w", value, err)
+		}
+		if total > math.MaxInt64-component {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: overflows the maximum duration", value)
+		}
+		total += component
+	}
+
+	return total, nil
+}
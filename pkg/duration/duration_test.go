@@ -0,0 +1,77 @@
+package duration
+
+import (
+	"math"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseExtended", func() {
+	DescribeTable("should parse supported durations",
+		func(input string, expected time.Duration) {
+			Expect(ParseExtended(input)).To(Equal(expected))
+		},
+		Entry("zero", "0", time.Duration(0)),
+		Entry("zero with unit", "0s", time.Duration(0)),
+		Entry("nanoseconds", "15ns", 15*time.Nanosecond),
+		Entry("microseconds", "15us", 15*time.Microsecond),
+		Entry("micro sign microseconds", "15µs", 15*time.Microsecond),
+		Entry("milliseconds", "500ms", 500*time.Millisecond),
+		Entry("seconds", "90s", 90*time.Second),
+		Entry("minutes", "5m", 5*time.Minute),
+		Entry("hours", "24h", 24*time.Hour),
+		Entry("days", "2d", 2*Day),
+		Entry("weeks", "3w", 3*Week),
+		Entry("fractional hours", "1.5h", 90*time.Minute),
+		Entry("fraction without whole part", ".5d", 12*time.Hour),
+		Entry("trailing dot", "1.h", time.Hour),
+		Entry("stdlib compound", "1h30m", 90*time.Minute),
+		Entry("extended compound", "1w2d3h", Week+2*Day+3*time.Hour),
+		Entry("repeated units", "1h1h", 2*time.Hour),
+		Entry("maximum duration", "9223372036854775807ns", time.Duration(math.MaxInt64)),
+		Entry("largest whole weeks", "15250w", 15250*Week),
+		Entry("ISO 8601 days", "P30D", 30*Day),
+		Entry("ISO 8601 months", "P2M", 60*Day),
+		Entry("ISO 8601 years", "P1Y", 365*Day),
+		Entry("ISO 8601 weeks", "P2W", 14*Day),
+		Entry("ISO 8601 hours", "PT12H", 12*time.Hour),
+		Entry("ISO 8601 minutes and seconds", "PT1M30S", 90*time.Second),
+		Entry("ISO 8601 date and time", "P1DT12H", 36*time.Hour),
+		Entry("ISO 8601 mixed date", "P1Y2M3D", (365+60+3)*Day),
+	)
+
+	DescribeTable("should reject invalid durations",
+		func(input, message string) {
+			_, err := ParseExtended(input)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("empty string", "", "empty duration"),
+		Entry("negative", "-1h", "negative durations are not allowed"),
+		Entry("negative zero", "-0", "negative durations are not allowed"),
+		Entry("missing unit", "10", `missing unit after "10"`),
+		Entry("missing unit after a component", "1h30", `missing unit after "30"`),
+		Entry("unknown unit", "3y", `unknown unit "y"`),
+		Entry("uppercase unit", "3D", `unknown unit "D"`),
+		Entry("letters only", "abc", `expected a number at "abc"`),
+		Entry("bare dot", ".h", `expected a number at ".h"`),
+		Entry("two dots", "1.2.3h", `missing unit after "1.2"`),
+		Entry("leading plus", "+1h", `expected a number at "+1h"`),
+		Entry("whitespace", " 1h", `expected a number at " 1h"`),
+		Entry("trailing whitespace", "1h ", `unknown unit "h "`),
+		Entry("overflowing number", "9223372036854775808ns", "overflows the maximum duration"),
+		Entry("overflowing days", "106752d", "overflows the maximum duration"),
+		Entry("overflowing weeks", "15251w", "overflows the maximum duration"),
+		Entry("overflowing sum", "9223372036854775807ns1ns", "overflows the maximum duration"),
+		Entry("overflowing fraction", "15250.9w", "overflows the maximum duration"),
+		Entry("huge number", "99999999999999999999999h", "overflows the maximum duration"),
+		Entry("ISO 8601 bare designator", "P", `invalid ISO 8601 duration "P"`),
+		Entry("ISO 8601 time designator without components", "P1DT", `invalid ISO 8601 duration "P1DT"`),
+		Entry("ISO 8601 wrong component order", "P3D1Y", `invalid ISO 8601 duration "P3D1Y"`),
+		Entry("ISO 8601 fractional values", "P1.5D", `invalid ISO 8601 duration "P1.5D"`),
+		Entry("ISO 8601 unknown unit", "P1X", `invalid ISO 8601 duration "P1X"`),
+		Entry("ISO 8601 overflowing years", "P300Y", "overflows the maximum duration"),
+		Entry("ISO 8601 overflowing sum", "P292Y1000D", "overflows the maximum duration"),
+	)
+})
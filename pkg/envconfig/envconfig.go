@@ -0,0 +1,104 @@
+// Package envconfig reads the parameters of the builders from the files Tekton
+// projects under the params directory, falling back to the environment
+package envconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ParamsPathEnv names the directory holding a file per parameter. An
+	// empty value disables the params files.
+	ParamsPathEnv = "PARAMS_PATH"
+
+	// DefaultParamsPath is where Tekton projects the params as files
+	DefaultParamsPath = "/tekton/params"
+
+	// MaxParamSize bounds the content of a params file
+	MaxParamSize = 4 << 20
+)
+
+// ParamsPath returns the params directory, empty when disabled
+func ParamsPath() string {
+	path, set := os.LookupEnv(ParamsPathEnv)
+	if !set {
+		return DefaultParamsPath
+	}
+	return path
+}
+
+// Lookup returns the value of the key parameter. The content of the file
+// named after key in the params directory is preferred over the environment
+// variable, with a trailing newline trimmed.
+func Lookup(key string) (string, bool) {
+	if value, found := readParam(ParamsPath(), key); found {
+		return value, true
+	}
+	return os.LookupEnv(key)
+}
+
+// Getenv returns the value of the key parameter, empty when unset
+func Getenv(key string) string {
+	value, _ := Lookup(key)
+	return value
+}
+
+// Validate checks that every file of the params directory fits in
+// MaxParamSize, so that no parameter is silently truncated. A missing params
+// directory is valid.
+func Validate() error {
+	dir := ParamsPath()
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %w", ParamsPathEnv, err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat param %s: %w", entry.Name(), err)
+		}
+		if info.Size() > MaxParamSize {
+			return fmt.Errorf("param %s is %d bytes, larger than the limit of %d bytes", entry.Name(), info.Size(), MaxParamSize)
+		}
+	}
+	return nil
+}
+
+// readParam reads the key file of dir. A file larger than MaxParamSize is
+// not found rather than truncated, Validate reporting it.
+func readParam(dir, key string) (string, bool) {
+	if dir == "" || key == "" || strings.ContainsRune(key, filepath.Separator) {
+		return "", false
+	}
+	file, err := os.Open(filepath.Join(dir, key))
+	if err != nil {
+		return "", false
+	}
+	defer func() { _ = file.Close() }()
+
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() || info.Size() > MaxParamSize {
+		return "", false
+	}
+	// The file may have grown since Stat
+	data, err := io.ReadAll(io.LimitReader(file, MaxParamSize+1))
+	if err != nil || len(data) > MaxParamSize {
+		return "", false
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), true
+}
@@ -0,0 +1,104 @@
+package envconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lookup", func() {
+	var paramsDir string
+
+	BeforeEach(func() {
+		paramsDir = GinkgoT().TempDir()
+		GinkgoT().Setenv(ParamsPathEnv, paramsDir)
+	})
+
+	param := func(name, content string) {
+		Expect(os.WriteFile(filepath.Join(paramsDir, name), []byte(content), 0644)).To(Succeed())
+	}
+
+	It("should read the environment variable without a params file", func() {
+		GinkgoT().Setenv("TEST_PARAM", "from-env")
+
+		value, found := Lookup("TEST_PARAM")
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("from-env"))
+	})
+
+	It("should read the params file without an environment variable", func() {
+		param("TEST_PARAM", "from-file\n")
+
+		value, found := Lookup("TEST_PARAM")
+		Expect(found).To(BeTrue())
+		Expect(value).To(Equal("from-file"))
+	})
+
+	It("should prefer the params file over the environment variable", func() {
+		GinkgoT().Setenv("TEST_PARAM", "from-env")
+		param("TEST_PARAM", "from-file")
+
+		Expect(Getenv("TEST_PARAM")).To(Equal("from-file"))
+	})
+
+	It("should report a parameter set nowhere as missing", func() {
+		_, found := Lookup("TEST_MISSING_PARAM")
+		Expect(found).To(BeFalse())
+		Expect(Getenv("TEST_MISSING_PARAM")).To(BeEmpty())
+	})
+
+	It("should only trim a single trailing newline", func() {
+		param("TEST_PARAM", "line 1\nline 2\n\n")
+		Expect(Getenv("TEST_PARAM")).To(Equal("line 1\nline 2\n"))
+
+		param("TEST_PARAM", "  spaced  \r\n")
+		Expect(Getenv("TEST_PARAM")).To(Equal("  spaced  "))
+	})
+
+	It("should ignore the params files when PARAMS_PATH is empty", func() {
+		GinkgoT().Setenv("TEST_PARAM", "from-env")
+		param("TEST_PARAM", "from-file")
+		GinkgoT().Setenv(ParamsPathEnv, "")
+
+		Expect(Getenv("TEST_PARAM")).To(Equal("from-env"))
+	})
+
+	It("should ignore directories and names outside the params directory", func() {
+		Expect(os.Mkdir(filepath.Join(paramsDir, "TEST_DIR"), 0755)).To(Succeed())
+		GinkgoT().Setenv("TEST_DIR", "from-env")
+
+		Expect(Getenv("TEST_DIR")).To(Equal("from-env"))
+		Expect(Getenv("../" + filepath.Base(paramsDir))).To(BeEmpty())
+	})
+
+	It("should read a file of MaxParamSize bytes", func() {
+		param("TEST_PARAM", strings.Repeat("a", MaxParamSize))
+		Expect(Getenv("TEST_PARAM")).To(HaveLen(MaxParamSize))
+	})
+
+	It("should not find a file larger than MaxParamSize rather than truncate it", func() {
+		param("TEST_PARAM", strings.Repeat("a", MaxParamSize+1))
+		_, found := Lookup("TEST_PARAM")
+		Expect(found).To(BeFalse())
+	})
+})
+
+var _ = Describe("Validate", func() {
+	It("should accept a missing params directory", func() {
+		GinkgoT().Setenv(ParamsPathEnv, filepath.Join(GinkgoT().TempDir(), "missing"))
+		Expect(Validate()).To(Succeed())
+	})
+
+	It("should reject a params file larger than MaxParamSize", func() {
+		paramsDir := GinkgoT().TempDir()
+		GinkgoT().Setenv(ParamsPathEnv, paramsDir)
+		Expect(os.WriteFile(filepath.Join(paramsDir, "SMALL"), []byte("ok"), 0644)).To(Succeed())
+		Expect(Validate()).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(paramsDir, "PREFETCH_INPUT"), make([]byte, MaxParamSize+1), 0644)).To(Succeed())
+		Expect(Validate()).To(MatchError(ContainSubstring("param PREFETCH_INPUT is 4194305 bytes, larger than the limit of 4194304 bytes")))
+	})
+})
@@ -0,0 +1,40 @@
+package exec
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// FailedCommand remembers the last command that failed, so that a fatal error
+// can be traced back to the command line that caused it
+type FailedCommand struct {
+	mu      sync.Mutex
+	command []string
+	err     error
+}
+
+// Record remembers the command of a failed attempt. It has the signature of
+// RetryingCommandRunner.OnAttempt.
+func (f *FailedCommand) Record(name string, args []string, _ int, err error) {
+	if err == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.command = append([]string{name}, args...)
+	f.err = err
+}
+
+// Command returns the command line of the last failed command when err wraps
+// its error, nil otherwise so that an error is never blamed on an unrelated
+// command
+func (f *FailedCommand) Command(err error) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil || !errors.Is(err, f.err) {
+		return nil
+	}
+	return slices.Clone(f.command)
+}
@@ -0,0 +1,46 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FailedCommand", func() {
+	var (
+		mock   *MockCommandRunner
+		runner *RetryingCommandRunner
+		failed *FailedCommand
+	)
+
+	BeforeEach(func() {
+		mock = NewMockCommandRunner()
+		runner = NewRetryingCommandRunner(mock, 0, 0, nil)
+		failed = &FailedCommand{}
+		runner.OnAttempt = failed.Record
+	})
+
+	It("should return the command of the error it wraps", func() {
+		cmdErr := &CommandError{ExitCode: 1, Message: "unauthorized"}
+		mock.SetError("buildah", cmdErr, "push", "quay.io/test/image:tag")
+		Expect(runner.Run(context.Background(), "buildah", "version")).To(Succeed())
+
+		err := runner.Run(context.Background(), "buildah", "push", "quay.io/test/image:tag")
+
+		Expect(failed.Command(fmt.Errorf("failed to push image: %w", err))).To(Equal([]string{"buildah", "push", "quay.io/test/image:tag"}))
+	})
+
+	It("should not blame an unrelated error on the last failed command", func() {
+		mock.SetError("skopeo", &CommandError{ExitCode: 1, Message: "manifest unknown"}, "inspect", "docker://quay.io/test/image:tag")
+		Expect(runner.Run(context.Background(), "skopeo", "inspect", "docker://quay.io/test/image:tag")).NotTo(Succeed())
+
+		Expect(failed.Command(errors.New("invalid prefetch input"))).To(BeNil())
+	})
+
+	It("should return nil before any command failed", func() {
+		Expect(failed.Command(&CommandError{ExitCode: 1})).To(BeNil())
+	})
+})
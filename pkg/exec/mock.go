@@ -4,20 +4,27 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 )
 
-// CommandError represents a command execution error for testing
+// CommandError represents a failed command with its exit code and message
 type CommandError struct {
 	ExitCode int
 	Message  string
+
+	// Stderr is the tail of the command's stderr, when captured
+	Stderr string
 }
 
 func (e *CommandError) Error() string {
 	return e.Message
 }
 
-// MockCommandRunner implements CommandRunner for testing
+// MockCommandRunner implements CommandRunner for testing. Its runs and
+// setters are safe for concurrent use.
 type MockCommandRunner struct {
+	mu sync.Mutex
+
 	// Commands stores all executed commands for verification
 	Commands [][]string
 
@@ -32,6 +39,26 @@ type MockCommandRunner struct {
 
 	// DefaultError is returned when no specific error is configured
 	DefaultError error
+
+	// CaptureOutput makes Run record the output it would have streamed,
+	// retrievable with GetCommandOutput
+	CaptureOutput bool
+
+	// captured maps command signatures to the output captured by Run
+	captured map[string][]byte
+
+	// queued maps command signatures to results returned in order by
+	// successive runs before falling back to Outputs and Errors
+	queued map[string][]mockResult
+
+	// stdin maps command signatures to the stdin of their last run
+	stdin map[string][]byte
+}
+
+// mockResult is a queued command result
+type mockResult struct {
+	output []byte
+	err    error
 }
 
 // NewMockCommandRunner creates a new mock command runner
@@ -45,6 +72,9 @@ func NewMockCommandRunner() *MockCommandRunner {
 
 // Run executes a command and streams output to stdout/stderr (mocked)
 func (m *MockCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	// Record the command
 	cmd := append([]string{name}, args...)
 	m.Commands = append(m.Commands, cmd)
@@ -52,16 +82,52 @@ func (m *MockCommandRunner) Run(ctx context.Context, name string, args ...string
 	// Generate command signature for lookup
 	signature := m.commandSignature(name, args...)
 
+	// Return the next queued result if any
+	if result, exists := m.nextResult(signature); exists {
+		if result.err == nil && m.CaptureOutput {
+			m.capture(signature, result.output)
+		}
+		return result.err
+	}
+
 	// Return configured error if any
 	if err, exists := m.Errors[signature]; exists {
 		return err
 	}
 
+	if m.CaptureOutput {
+		output, exists := m.Outputs[signature]
+		if !exists {
+			output = m.DefaultOutput
+		}
+		m.capture(signature, output)
+	}
+
 	return m.DefaultError
 }
 
+// RunWithStdin executes a command reading stdin (mocked), recording stdin
+// for GetStdin
+func (m *MockCommandRunner) RunWithStdin(ctx context.Context, stdin []byte, name string, args ...string) error {
+	m.mu.Lock()
+	if m.stdin == nil {
+		m.stdin = make(map[string][]byte)
+	}
+	m.stdin[m.commandSignature(name, args...)] = stdin
+	m.mu.Unlock()
+	return m.Run(ctx, name, args...)
+}
+
+// GetStdin returns the stdin fed to the last run of a command by RunWithStdin
+func (m *MockCommandRunner) GetStdin(name string, args ...string) []byte {
+	return m.stdin[m.commandSignature(name, args...)]
+}
+
 // RunWithOutput executes a command and returns output (mocked)
 func (m *MockCommandRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	// Record the command
 	cmd := append([]string{name}, args...)
 	m.Commands = append(m.Commands, cmd)
@@ -69,6 +135,11 @@ func (m *MockCommandRunner) RunWithOutput(ctx context.Context, name string, args
 	// Generate command signature for lookup
 	signature := m.commandSignature(name, args...)
 
+	// Return the next queued result if any
+	if result, exists := m.nextResult(signature); exists {
+		return result.output, result.err
+	}
+
 	// Return configured error if any
 	if err, exists := m.Errors[signature]; exists {
 		return nil, err
@@ -85,16 +156,57 @@ func (m *MockCommandRunner) RunWithOutput(ctx context.Context, name string, args
 
 // SetOutput configures the output for a specific command
 func (m *MockCommandRunner) SetOutput(name string, output []byte, args ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	signature := m.commandSignature(name, args...)
 	m.Outputs[signature] = output
 }
 
 // SetError configures the error for a specific command
 func (m *MockCommandRunner) SetError(name string, err error, args ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	signature := m.commandSignature(name, args...)
 	m.Errors[signature] = err
 }
 
+// QueueResult queues an output and error for a specific command. Queued
+// results are returned by successive runs in order; once they are used up
+// the command falls back to its configured output and error.
+func (m *MockCommandRunner) QueueResult(name string, output []byte, err error, args ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	signature := m.commandSignature(name, args...)
+	if m.queued == nil {
+		m.queued = make(map[string][]mockResult)
+	}
+	m.queued[signature] = append(m.queued[signature], mockResult{output: output, err: err})
+}
+
+// nextResult pops the next queued result for a command signature
+func (m *MockCommandRunner) nextResult(signature string) (mockResult, bool) {
+	results := m.queued[signature]
+	if len(results) == 0 {
+		return mockResult{}, false
+	}
+	m.queued[signature] = results[1:]
+	return results[0], true
+}
+
+// capture records output streamed by Run
+func (m *MockCommandRunner) capture(signature string, output []byte) {
+	if m.captured == nil {
+		m.captured = make(map[string][]byte)
+	}
+	m.captured[signature] = append(m.captured[signature], output...)
+}
+
+// GetCommandOutput returns the output captured by Run for a specific command.
+// Output of repeated runs is concatenated.
+func (m *MockCommandRunner) GetCommandOutput(name string, args ...string) []byte {
+	return m.captured[m.commandSignature(name, args...)]
+}
+
 // GetExecutedCommands returns all executed commands
 func (m *MockCommandRunner) GetExecutedCommands() [][]string {
 	return m.Commands
@@ -115,6 +227,9 @@ func (m *MockCommandRunner) Reset() {
 	m.Errors = make(map[string]error)
 	m.DefaultOutput = nil
 	m.DefaultError = nil
+	m.captured = nil
+	m.queued = nil
+	m.stdin = nil
 }
 
 // commandSignature creates a unique signature for a command
@@ -156,3 +271,35 @@ func (m *MockCommandRunner) String() string {
 	}
 	return strings.Join(result, "\n")
 }
+
+// PrintExecutedCommands formats all executed commands as a shell script that
+// can be pasted into a terminal to reproduce a failing test
+func (m *MockCommandRunner) PrintExecutedCommands() string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	for _, cmd := range m.Commands {
+		quoted := make([]string, len(cmd))
+		for i, arg := range cmd {
+			quoted[i] = shellQuote(arg)
+		}
+		b.WriteString(strings.Join(quoted, " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// shellQuote quotes arg for a POSIX shell. Arguments made only of safe
+// characters are left as-is; others are single-quoted, with each embedded
+// single quote closing the quoting, escaped and reopening it.
+func shellQuote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if strings.Trim(arg, shellSafe) == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// shellSafe lists the characters that never need quoting
+const shellSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%_+=:,./-"
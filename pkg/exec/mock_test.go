@@ -0,0 +1,133 @@
+package exec
+
+import (
+	"context"
+	osexec "os/exec"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MockCommandRunner", func() {
+	var (
+		ctx    context.Context
+		runner *MockCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		runner = NewMockCommandRunner()
+	})
+
+	Describe("CaptureOutput", func() {
+		It("should not capture output by default", func() {
+			runner.SetOutput("buildah", []byte("built"), "build", ".")
+
+			Expect(runner.Run(ctx, "buildah", "build", ".")).To(Succeed())
+
+			Expect(runner.GetCommandOutput("buildah", "build", ".")).To(BeNil())
+		})
+
+		It("should capture the configured output of a command", func() {
+			runner.CaptureOutput = true
+			runner.SetOutput("buildah", []byte("built"), "build", ".")
+
+			Expect(runner.Run(ctx, "buildah", "build", ".")).To(Succeed())
+
+			Expect(runner.GetCommandOutput("buildah", "build", ".")).To(Equal([]byte("built")))
+		})
+
+		It("should capture the default output when no output is configured", func() {
+			runner.CaptureOutput = true
+			runner.DefaultOutput = []byte("default")
+
+			Expect(runner.Run(ctx, "buildah", "push", "image")).To(Succeed())
+
+			Expect(runner.GetCommandOutput("buildah", "push", "image")).To(Equal([]byte("default")))
+			Expect(runner.GetCommandOutput("buildah", "build", ".")).To(BeNil())
+		})
+
+		It("should concatenate the output of repeated runs", func() {
+			runner.CaptureOutput = true
+			runner.SetOutput("echo", []byte("hi\n"), "hi")
+
+			Expect(runner.Run(ctx, "echo", "hi")).To(Succeed())
+			Expect(runner.Run(ctx, "echo", "hi")).To(Succeed())
+
+			Expect(runner.GetCommandOutput("echo", "hi")).To(Equal([]byte("hi\nhi\n")))
+		})
+
+		It("should not capture output of a command configured to fail", func() {
+			runner.CaptureOutput = true
+			runner.SetOutput("buildah", []byte("partial"), "build", ".")
+			runner.SetError("buildah", &CommandError{ExitCode: 1, Message: "failed"}, "build", ".")
+
+			Expect(runner.Run(ctx, "buildah", "build", ".")).To(MatchError("failed"))
+
+			Expect(runner.GetCommandOutput("buildah", "build", ".")).To(BeNil())
+		})
+
+		It("should clear captured output on reset", func() {
+			runner.CaptureOutput = true
+			runner.DefaultOutput = []byte("default")
+			Expect(runner.Run(ctx, "true")).To(Succeed())
+
+			runner.Reset()
+
+			Expect(runner.GetCommandOutput("true")).To(BeNil())
+		})
+	})
+
+	Describe("QueueResult", func() {
+		It("should return queued results in order before the configured ones", func() {
+			failure := &CommandError{ExitCode: 1, Message: "timeout"}
+			runner.QueueResult("skopeo", nil, failure, "inspect")
+			runner.QueueResult("skopeo", []byte("second"), nil, "inspect")
+			runner.SetOutput("skopeo", []byte("configured"), "inspect")
+
+			_, err := runner.RunWithOutput(ctx, "skopeo", "inspect")
+			Expect(err).To(MatchError(failure))
+			output, err := runner.RunWithOutput(ctx, "skopeo", "inspect")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal([]byte("second")))
+			output, err = runner.RunWithOutput(ctx, "skopeo", "inspect")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal([]byte("configured")))
+		})
+
+		It("should capture queued output of successful runs", func() {
+			runner.CaptureOutput = true
+			runner.QueueResult("cachi2", []byte("partial"), &CommandError{ExitCode: 1}, "fetch-deps")
+			runner.QueueResult("cachi2", []byte("done"), nil, "fetch-deps")
+
+			Expect(runner.Run(ctx, "cachi2", "fetch-deps")).NotTo(Succeed())
+			Expect(runner.Run(ctx, "cachi2", "fetch-deps")).To(Succeed())
+
+			Expect(runner.GetCommandOutput("cachi2", "fetch-deps")).To(Equal([]byte("done")))
+		})
+	})
+
+	Describe("PrintExecutedCommands", func() {
+		It("should format the commands as a shell script", func() {
+			_ = runner.Run(ctx, "buildah", "build", "--tag", "quay.io/test/image:tag", ".")
+			_, _ = runner.RunWithOutput(ctx, "sh", "-c", "echo it's $HOME", "")
+
+			Expect(runner.PrintExecutedCommands()).To(Equal("#!/bin/sh\n" +
+				"buildah build --tag quay.io/test/image:tag .\n" +
+				`sh -c 'echo it'\''s $HOME' ''` + "\n"))
+		})
+
+		It("should produce syntactically valid shell", func() {
+			if _, err := osexec.LookPath("sh"); err != nil {
+				Skip("sh is not available")
+			}
+			_ = runner.Run(ctx, "echo", "unbalanced ' quote", `double " quote`, `back\slash`, "$(subshell)", "new\nline", "")
+
+			check := osexec.Command("sh", "-n")
+			check.Stdin = strings.NewReader(runner.PrintExecutedCommands())
+			output, err := check.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+		})
+	})
+})
@@ -0,0 +1,62 @@
+package exec
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retries of the commands rejected by a registry rate limit, used when the
+// registry doesn't send Retry-After
+const (
+	RateLimitMaxRetries = 5
+	RateLimitBaseDelay  = 10 * time.Second
+)
+
+// rateLimitPattern matches the stderr of skopeo and buildah when a registry
+// answers with HTTP 429: the status line or the toomanyrequests error code of
+// the registry API. A bare 429 is also found in digests and build output.
+var rateLimitPattern = regexp.MustCompile(`(?i)\b429 too many requests\b|\btoomanyrequests\b`)
+
+// retryAfterPattern matches a Retry-After header echoed on stderr
+var retryAfterPattern = regexp.MustCompile(`(?i)retry-after"?\s*[:=]\s*"?(\d+|\w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} GMT)`)
+
+// IsRateLimited reports whether a failed command was rejected by a registry
+// rate limit: it exited non-zero with 429 Too Many Requests or toomanyrequests
+// on stderr
+func IsRateLimited(err error) bool {
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.ExitCode == 0 {
+		return false
+	}
+	return rateLimitPattern.MatchString(cmdErr.Message)
+}
+
+// RetryAfter extracts the delay requested by the Retry-After header of a
+// rate-limited command, given either in seconds or as an HTTP date
+func RetryAfter(err error, now time.Time) (time.Duration, bool) {
+	var cmdErr *CommandError
+	if !IsRateLimited(err) || !errors.As(err, &cmdErr) {
+		return 0, false
+	}
+
+	match := retryAfterPattern.FindStringSubmatch(cmdErr.Message)
+	if match == nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(match[1])
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
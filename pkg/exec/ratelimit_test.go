@@ -0,0 +1,41 @@
+package exec
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rate limits", func() {
+	now := time.Date(2026, time.March, 2, 10, 0, 0, 0, time.UTC)
+
+	DescribeTable("IsRateLimited",
+		func(err error, rateLimited bool) {
+			Expect(IsRateLimited(err)).To(Equal(rateLimited))
+		},
+		Entry("skopeo HTTP status", &CommandError{ExitCode: 1, Message: "exit status 1: received unexpected HTTP status: 429 Too Many Requests"}, true),
+		Entry("registry error code", &CommandError{ExitCode: 125, Message: "exit status 125: toomanyrequests: Rate limit exceeded"}, true),
+		Entry("other failure", &CommandError{ExitCode: 1, Message: "exit status 1: manifest unknown"}, false),
+		Entry("digest containing 429", &CommandError{ExitCode: 1, Message: "exit status 1: sha256:a429b not found"}, false),
+		Entry("build output containing 429", &CommandError{ExitCode: 1, Message: "exit status 1: test_status_429 failed: expected 429, got 200"}, false),
+		Entry("too many requests without the status", &CommandError{ExitCode: 1, Message: "exit status 1: npm ERR! too many requests, slow down"}, false),
+		Entry("zero exit code", &CommandError{ExitCode: 0, Message: "429 Too Many Requests"}, false),
+		Entry("not a command error", errors.New("429 Too Many Requests"), false),
+	)
+
+	DescribeTable("RetryAfter",
+		func(message string, expected time.Duration, found bool) {
+			delay, ok := RetryAfter(&CommandError{ExitCode: 1, Message: message}, now)
+			Expect(ok).To(Equal(found))
+			Expect(delay).To(Equal(expected))
+		},
+		Entry("seconds", "429 Too Many Requests; Retry-After: 30", 30*time.Second, true),
+		Entry("quoted header", `toomanyrequests {"Retry-After": "12"}`, 12*time.Second, true),
+		Entry("HTTP date", "429 Too Many Requests, retry-after: Mon, 02 Mar 2026 10:01:30 GMT", 90*time.Second, true),
+		Entry("past HTTP date", "429 Too Many Requests, retry-after: Mon, 02 Mar 2026 09:00:00 GMT", time.Duration(0), true),
+		Entry("without Retry-After", "429 Too Many Requests", time.Duration(0), false),
+		Entry("Retry-After without a rate limit", "503 Service Unavailable; Retry-After: 30", time.Duration(0), false),
+	)
+})
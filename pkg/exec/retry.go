@@ -0,0 +1,151 @@
+package exec
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxRetryAfter bounds the delay honoured from a Retry-After header
+const maxRetryAfter = 5 * time.Minute
+
+// RetryingCommandRunner wraps a CommandRunner, retrying commands that fail
+// with a retryable error and doubling the delay after each attempt. Commands
+// rejected by a registry rate limit are always retried, waiting for the
+// delay requested by Retry-After when given. It is safe for concurrent use,
+// its counters being updated and its hooks called one attempt at a time.
+type RetryingCommandRunner struct {
+	mu sync.Mutex
+
+	Runner     CommandRunner
+	MaxRetries int
+	BaseDelay  time.Duration
+
+	// Retryable reports whether a failed command should be retried. Every
+	// error is retried when nil.
+	Retryable func(err error) bool
+
+	// Retried reports whether the name command may be retried at all, rate
+	// limits included. Every command may be when nil.
+	Retried func(name string, args []string) bool
+
+	// OnRetry is called before each retry with the retry number, starting at
+	// 1, and the error that caused it
+	OnRetry func(retry int, err error)
+
+	// OnAttempt is called after each attempt of a command with the attempt
+	// number, starting at 1, and its error, nil on success
+	OnAttempt func(name string, args []string, attempt int, err error)
+
+	// Retries counts the retried attempts
+	Retries int
+
+	// RateLimited counts the attempts rejected by a registry rate limit
+	RateLimited int
+
+	// Backoff is the total time waited between attempts
+	Backoff time.Duration
+}
+
+// NewRetryingCommandRunner creates a runner retrying commands up to maxRetries
+// times on errors accepted by retryable
+func NewRetryingCommandRunner(runner CommandRunner, maxRetries int, baseDelay time.Duration, retryable func(err error) bool) *RetryingCommandRunner {
+	return &RetryingCommandRunner{
+		Runner:     runner,
+		MaxRetries: maxRetries,
+		BaseDelay:  baseDelay,
+		Retryable:  retryable,
+	}
+}
+
+// Run executes a command, retrying it on retryable errors
+func (r *RetryingCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	return r.retry(ctx, name, args, func() error {
+		return r.Runner.Run(ctx, name, args...)
+	})
+}
+
+// RunWithOutput executes a command and returns the output of the last attempt
+func (r *RetryingCommandRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var output []byte
+	err := r.retry(ctx, name, args, func() error {
+		var err error
+		output, err = r.Runner.RunWithOutput(ctx, name, args...)
+		return err
+	})
+	return output, err
+}
+
+// RunWithStdin executes a command reading stdin, retrying it on retryable
+// errors with the same stdin
+func (r *RetryingCommandRunner) RunWithStdin(ctx context.Context, stdin []byte, name string, args ...string) error {
+	return r.retry(ctx, name, args, func() error {
+		return RunWithStdin(ctx, r.Runner, stdin, name, args...)
+	})
+}
+
+// retry runs attempt of the name command until it succeeds, fails with a
+// non-retryable error or runs out of retries, returning the last error
+func (r *RetryingCommandRunner) retry(ctx context.Context, name string, args []string, attempt func() error) error {
+	delay := r.BaseDelay
+	for retry := 1; ; retry++ {
+		err := attempt()
+		if !r.record(name, args, retry, err) {
+			return err
+		}
+
+		wait := delay
+		if retryAfter, ok := retryAfter(err); ok {
+			wait = retryAfter
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		r.mu.Lock()
+		r.Backoff += wait
+		r.mu.Unlock()
+		delay *= 2
+	}
+}
+
+// record counts an attempt and calls the hooks, reporting whether the
+// attempt is retried
+func (r *RetryingCommandRunner) record(name string, args []string, retry int, err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.OnAttempt != nil {
+		r.OnAttempt(name, args, retry, err)
+	}
+	if err == nil || (r.Retried != nil && !r.Retried(name, args)) {
+		return false
+	}
+	rateLimited := IsRateLimited(err)
+	if rateLimited {
+		r.RateLimited++
+	}
+	if retry > r.MaxRetries || (!rateLimited && r.Retryable != nil && !r.Retryable(err)) {
+		return false
+	}
+
+	r.Retries++
+	if r.OnRetry != nil {
+		r.OnRetry(retry, err)
+	}
+	return true
+}
+
+// retryAfter returns the delay requested by a rate-limited error, bounded by
+// maxRetryAfter
+func retryAfter(err error) (time.Duration, bool) {
+	delay, ok := RetryAfter(err, time.Now())
+	if !ok {
+		return 0, false
+	}
+	return min(delay, maxRetryAfter), true
+}
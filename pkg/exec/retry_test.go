@@ -0,0 +1,195 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryingCommandRunner", func() {
+	var (
+		ctx      context.Context
+		mock     *MockCommandRunner
+		runner   *RetryingCommandRunner
+		retries  []int
+		network  error
+		notFound error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = NewMockCommandRunner()
+		network = &CommandError{ExitCode: 1, Message: "network unreachable"}
+		notFound = &CommandError{ExitCode: 2, Message: "not found"}
+		runner = NewRetryingCommandRunner(mock, 3, time.Millisecond, func(err error) bool {
+			return errors.Is(err, network)
+		})
+		retries = nil
+		runner.OnRetry = func(retry int, err error) { retries = append(retries, retry) }
+	})
+
+	It("should retry retryable errors until the command succeeds", func() {
+		mock.QueueResult("cachi2", nil, network, "fetch-deps")
+		mock.QueueResult("cachi2", nil, network, "fetch-deps")
+
+		Expect(runner.Run(ctx, "cachi2", "fetch-deps")).To(Succeed())
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(3))
+		Expect(retries).To(Equal([]int{1, 2}))
+	})
+
+	It("should not retry errors that aren't retryable", func() {
+		mock.QueueResult("cachi2", nil, notFound, "fetch-deps")
+
+		Expect(runner.Run(ctx, "cachi2", "fetch-deps")).To(MatchError(notFound))
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(1))
+		Expect(retries).To(BeEmpty())
+	})
+
+	It("should return the last error after running out of retries", func() {
+		mock.SetError("cachi2", network, "fetch-deps")
+
+		Expect(runner.Run(ctx, "cachi2", "fetch-deps")).To(MatchError(network))
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(4))
+		Expect(retries).To(Equal([]int{1, 2, 3}))
+	})
+
+	It("should return the output of the successful attempt", func() {
+		mock.QueueResult("skopeo", nil, network, "inspect")
+		mock.QueueResult("skopeo", []byte("{}"), nil, "inspect")
+
+		output, err := runner.RunWithOutput(ctx, "skopeo", "inspect")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal([]byte("{}")))
+	})
+
+	It("should stop retrying when the context is cancelled", func() {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		runner.BaseDelay = time.Hour
+		mock.SetError("cachi2", network, "fetch-deps")
+
+		Expect(runner.Run(cancelled, "cachi2", "fetch-deps")).To(MatchError(network))
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("RetryingCommandRunner rate limits", func() {
+	var (
+		ctx    context.Context
+		mock   *MockCommandRunner
+		runner *RetryingCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = NewMockCommandRunner()
+		runner = NewRetryingCommandRunner(mock, 3, time.Millisecond, func(error) bool { return false })
+	})
+
+	It("should retry rate-limited commands even when the classifier doesn't", func() {
+		mock.QueueResult("skopeo", nil, &CommandError{ExitCode: 1, Message: "exit status 1: reading manifest: toomanyrequests: too many requests"}, "inspect")
+
+		Expect(runner.Run(ctx, "skopeo", "inspect")).To(Succeed())
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(2))
+		Expect(runner.Retries).To(Equal(1))
+		Expect(runner.RateLimited).To(Equal(1))
+		Expect(runner.Backoff).To(Equal(time.Millisecond))
+	})
+
+	It("should wait for the delay requested by Retry-After", func() {
+		mock.QueueResult("skopeo", nil, &CommandError{ExitCode: 1, Message: "exit status 1: received unexpected HTTP status: 429 Too Many Requests (Retry-After: 0)"}, "copy")
+		mock.QueueResult("skopeo", nil, &CommandError{ExitCode: 1, Message: "exit status 1: received unexpected HTTP status: 429 Too Many Requests"}, "copy")
+
+		Expect(runner.Run(ctx, "skopeo", "copy")).To(Succeed())
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(3))
+		Expect(runner.RateLimited).To(Equal(2))
+		// Retry-After: 0, then the doubled base delay
+		Expect(runner.Backoff).To(Equal(2 * time.Millisecond))
+	})
+
+	It("should not count errors that aren't rate limits", func() {
+		mock.SetError("skopeo", &CommandError{ExitCode: 1, Message: "manifest unknown"}, "inspect")
+
+		Expect(runner.Run(ctx, "skopeo", "inspect")).To(HaveOccurred())
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(1))
+		Expect(runner.RateLimited).To(BeZero())
+		Expect(runner.Backoff).To(BeZero())
+	})
+
+	It("should not retry the commands Retried rejects, even when rate-limited", func() {
+		runner.Retried = func(name string, args []string) bool { return name == "skopeo" }
+		mock.SetError("buildah", &CommandError{ExitCode: 1, Message: "exit status 1: curl: 429 Too Many Requests"}, "build")
+
+		Expect(runner.Run(ctx, "buildah", "build")).To(HaveOccurred())
+
+		Expect(mock.GetExecutedCommands()).To(HaveLen(1))
+		Expect(runner.Retries).To(BeZero())
+		Expect(runner.RateLimited).To(BeZero())
+	})
+})
+
+var _ = Describe("RetrySummary", func() {
+	var (
+		ctx     context.Context
+		mock    *MockCommandRunner
+		runner  *RetryingCommandRunner
+		summary *RetrySummary
+		network error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = NewMockCommandRunner()
+		network = &CommandError{ExitCode: 1, Message: "network unreachable"}
+		runner = NewRetryingCommandRunner(mock, 3, time.Millisecond, func(err error) bool {
+			return errors.Is(err, network)
+		})
+		summary = NewRetrySummary(func(name string, args []string) string {
+			if name == "skopeo" && len(args) > 0 {
+				return args[0]
+			}
+			return ""
+		}, "copy", "inspect")
+		runner.OnAttempt = summary.Record
+	})
+
+	It("should count the attempts and retries of each subsystem", func() {
+		mock.QueueResult("skopeo", nil, network, "inspect")
+		mock.QueueResult("skopeo", nil, network, "inspect")
+
+		Expect(runner.Run(ctx, "skopeo", "inspect")).To(Succeed())
+		Expect(runner.Run(ctx, "skopeo", "inspect")).To(Succeed())
+
+		Expect(summary.Counts()).To(Equal(map[string]RetryCount{
+			"copy":    {},
+			"inspect": {Attempts: 4, Retries: 2},
+		}))
+		Expect(summary.Retried()).To(Equal([]string{"inspect"}))
+	})
+
+	It("should count the failed attempts after running out of retries", func() {
+		mock.SetError("skopeo", network, "copy")
+
+		Expect(runner.Run(ctx, "skopeo", "copy")).To(HaveOccurred())
+
+		Expect(summary.Counts()["copy"]).To(Equal(RetryCount{Attempts: 4, Retries: 3}))
+	})
+
+	It("should ignore the commands without a subsystem", func() {
+		Expect(runner.Run(ctx, "buildah", "push")).To(Succeed())
+
+		Expect(summary.Retried()).To(BeEmpty())
+		Expect(summary.Format()).To(Equal(`{"copy":{"attempts":0,"retries":0},"inspect":{"attempts":0,"retries":0}}`))
+	})
+})
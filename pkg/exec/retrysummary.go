@@ -0,0 +1,77 @@
+package exec
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// RetryCount counts the attempts of the commands of a subsystem and how many
+// of them were retries
+type RetryCount struct {
+	Attempts int `json:"attempts"`
+	Retries  int `json:"retries"`
+}
+
+// RetrySummary aggregates the attempts of a RetryingCommandRunner per
+// subsystem, such as registry pushes or git commands, to tell flaky
+// infrastructure apart from builds that succeeded at once
+type RetrySummary struct {
+	classify func(name string, args []string) string
+	counts   map[string]RetryCount
+}
+
+// NewRetrySummary creates a summary of subsystems, reported even when their
+// commands never ran. classify returns the subsystem of a command, or "" for
+// the commands that aren't tracked.
+func NewRetrySummary(classify func(name string, args []string) string, subsystems ...string) *RetrySummary {
+	counts := make(map[string]RetryCount, len(subsystems))
+	for _, subsystem := range subsystems {
+		counts[subsystem] = RetryCount{}
+	}
+	return &RetrySummary{classify: classify, counts: counts}
+}
+
+// Record counts an attempt of a command, a retry when attempt is above 1. It
+// has the signature of RetryingCommandRunner.OnAttempt.
+func (s *RetrySummary) Record(name string, args []string, attempt int, _ error) {
+	subsystem := s.classify(name, args)
+	if subsystem == "" {
+		return
+	}
+	count := s.counts[subsystem]
+	count.Attempts++
+	if attempt > 1 {
+		count.Retries++
+	}
+	s.counts[subsystem] = count
+}
+
+// Counts returns a copy of the counts, keyed by subsystem
+func (s *RetrySummary) Counts() map[string]RetryCount {
+	counts := make(map[string]RetryCount, len(s.counts))
+	for subsystem, count := range s.counts {
+		counts[subsystem] = count
+	}
+	return counts
+}
+
+// Retried lists the subsystems whose commands were retried, sorted
+func (s *RetrySummary) Retried() []string {
+	var retried []string
+	for subsystem, count := range s.counts {
+		if count.Retries > 0 {
+			retried = append(retried, subsystem)
+		}
+	}
+	sort.Strings(retried)
+	return retried
+}
+
+// Format encodes the counts as a JSON object keyed by subsystem
+func (s *RetrySummary) Format() (string, error) {
+	data, err := json.Marshal(s.counts)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -1,11 +1,22 @@
 package exec
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 )
 
+// stderrTailSize is the amount of stderr kept in a CommandError
+const stderrTailSize = 4096
+
 // CommandRunner interface abstracts command execution for testability
 type CommandRunner interface {
 	// Run executes a command and streams output to stdout/stderr
@@ -15,6 +26,23 @@ type CommandRunner interface {
 	RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error)
 }
 
+// StdinRunner is implemented by the runners that can feed a command's stdin,
+// which keeps secrets such as passwords out of the command line
+type StdinRunner interface {
+	// RunWithStdin executes a command reading stdin and streams output to stdout/stderr
+	RunWithStdin(ctx context.Context, stdin []byte, name string, args ...string) error
+}
+
+// RunWithStdin executes a command reading stdin through runner, failing when
+// runner can't feed stdin
+func RunWithStdin(ctx context.Context, runner CommandRunner, stdin []byte, name string, args ...string) error {
+	stdinRunner, ok := runner.(StdinRunner)
+	if !ok {
+		return fmt.Errorf("%T can't feed stdin to %s", runner, name)
+	}
+	return stdinRunner.RunWithStdin(ctx, stdin, name, args...)
+}
+
 // RealCommandRunner implements CommandRunner using os/exec
 type RealCommandRunner struct{}
 
@@ -23,16 +51,224 @@ func NewRealCommandRunner() *RealCommandRunner {
 	return &RealCommandRunner{}
 }
 
-// Run executes a command and streams output to stdout/stderr
+// Run executes a command and streams output to stdout/stderr. A failed
+// command returns a *CommandError carrying the tail of stderr.
 func (r *RealCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	var stderr bytes.Buffer
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	return WrapExitError(cmd.Run(), stderr.Bytes())
 }
 
-// RunWithOutput executes a command and returns output
+// RunWithOutput executes a command and returns output. A failed command
+// returns a *CommandError carrying the tail of stderr.
 func (r *RealCommandRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	return output, WrapExitError(err, stderr.Bytes())
+}
+
+// RunWithStdin executes a command reading stdin and streams output to
+// stdout/stderr. A failed command returns a *CommandError carrying the tail
+// of stderr.
+func (r *RealCommandRunner) RunWithStdin(ctx context.Context, stdin []byte, name string, args ...string) error {
+	var stderr bytes.Buffer
 	cmd := exec.CommandContext(ctx, name, args...)
-	return cmd.Output()
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	return WrapExitError(cmd.Run(), stderr.Bytes())
+}
+
+// WrapExitError converts a command exit error into a *CommandError carrying
+// the tail of stderr. Other errors are returned unchanged.
+func WrapExitError(err error, stderr []byte) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	if len(stderr) > stderrTailSize {
+		stderr = stderr[len(stderr)-stderrTailSize:]
+	}
+	tail := strings.TrimSpace(string(stderr))
+	return &CommandError{
+		ExitCode: exitErr.ExitCode(),
+		Message:  fmt.Sprintf("%v: %s", err, tail),
+		Stderr:   tail,
+	}
+}
+
+// SudoCommandRunner wraps a CommandRunner, running every command through sudo
+// when enabled, for operations that need elevated privileges outside rootless
+// environments
+type SudoCommandRunner struct {
+	Inner   CommandRunner
+	Enabled bool
+
+	// SudoArgs replaces the plain "sudo" prefix, e.g. {"sudo", "-n"}
+	SudoArgs []string
+}
+
+// NewSudoCommandRunner creates a runner prefixing commands with sudo when enabled
+func NewSudoCommandRunner(inner CommandRunner, enabled bool) *SudoCommandRunner {
+	return &SudoCommandRunner{Inner: inner, Enabled: enabled}
+}
+
+// Run executes a command, through sudo when enabled
+func (r *SudoCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	name, args = r.command(name, args)
+	return r.Inner.Run(ctx, name, args...)
+}
+
+// RunWithOutput executes a command and returns output, through sudo when enabled
+func (r *SudoCommandRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	name, args = r.command(name, args)
+	return r.Inner.RunWithOutput(ctx, name, args...)
+}
+
+// RunWithStdin executes a command reading stdin, through sudo when enabled
+func (r *SudoCommandRunner) RunWithStdin(ctx context.Context, stdin []byte, name string, args ...string) error {
+	name, args = r.command(name, args)
+	return RunWithStdin(ctx, r.Inner, stdin, name, args...)
+}
+
+// command returns the command to run, prefixed with the sudo arguments when enabled
+func (r *SudoCommandRunner) command(name string, args []string) (string, []string) {
+	if !r.Enabled {
+		return name, args
+	}
+
+	prefix := r.SudoArgs
+	if len(prefix) == 0 {
+		prefix = []string{"sudo"}
+	}
+
+	command := make([]string, 0, len(prefix)+len(args))
+	command = append(command, prefix[1:]...)
+	command = append(command, name)
+	command = append(command, args...)
+	return prefix[0], command
+}
+
+// EnvCommandRunner wraps a CommandRunner, running the listed commands through
+// env with extra KEY=value variables. The variables are inherited by the
+// processes the commands start, e.g. buildah run within unshare.
+type EnvCommandRunner struct {
+	Inner    CommandRunner
+	Env      []string
+	Commands []string
+}
+
+// NewEnvCommandRunner creates a runner setting env for the given commands
+func NewEnvCommandRunner(inner CommandRunner, env []string, commands ...string) *EnvCommandRunner {
+	return &EnvCommandRunner{Inner: inner, Env: env, Commands: commands}
+}
+
+// Run executes a command, through env when it is one of Commands
+func (r *EnvCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	name, args = r.command(name, args)
+	return r.Inner.Run(ctx, name, args...)
+}
+
+// RunWithOutput executes a command and returns output, through env when it is one of Commands
+func (r *EnvCommandRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	name, args = r.command(name, args)
+	return r.Inner.RunWithOutput(ctx, name, args...)
+}
+
+// RunWithStdin executes a command reading stdin, through env when it is one of Commands
+func (r *EnvCommandRunner) RunWithStdin(ctx context.Context, stdin []byte, name string, args ...string) error {
+	name, args = r.command(name, args)
+	return RunWithStdin(ctx, r.Inner, stdin, name, args...)
+}
+
+// command returns the command to run, prefixed with env and the variables
+// when it is one of Commands. A command an outer EnvCommandRunner already
+// runs through env gets the variables added to its env arguments.
+func (r *EnvCommandRunner) command(name string, args []string) (string, []string) {
+	if len(r.Env) == 0 {
+		return name, args
+	}
+	if name == "env" {
+		i := slices.IndexFunc(args, func(arg string) bool { return !strings.Contains(arg, "=") })
+		if i < 0 || !slices.Contains(r.Commands, args[i]) {
+			return name, args
+		}
+		command := make([]string, 0, len(r.Env)+len(args))
+		command = append(command, args[:i]...)
+		command = append(command, r.Env...)
+		command = append(command, args[i:]...)
+		return "env", command
+	}
+	if !slices.Contains(r.Commands, name) {
+		return name, args
+	}
+
+	command := make([]string, 0, len(r.Env)+len(args)+1)
+	command = append(command, r.Env...)
+	command = append(command, name)
+	command = append(command, args...)
+	return "env", command
+}
+
+// FileOutputCommandRunner wraps a CommandRunner, saving the stdout of each
+// command to <LogDir>/<sequence>-<command>.log for inspection after the
+// build. Commands are run with RunWithOutput so that stdout can be captured,
+// Run copying it to Stdout once the command exits.
+type FileOutputCommandRunner struct {
+	Inner  CommandRunner
+	LogDir string
+
+	// Stdout receives the output of Run, os.Stdout by default
+	Stdout io.Writer
+
+	sequence atomic.Int64
+}
+
+// NewFileOutputCommandRunner creates a runner saving the stdout of each command in logDir
+func NewFileOutputCommandRunner(inner CommandRunner, logDir string) *FileOutputCommandRunner {
+	return &FileOutputCommandRunner{Inner: inner, LogDir: logDir, Stdout: os.Stdout}
+}
+
+// Run executes a command, saving its stdout and copying it to Stdout
+func (r *FileOutputCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	output, err := r.RunWithOutput(ctx, name, args...)
+	if r.Stdout != nil {
+		_, _ = r.Stdout.Write(output)
+	}
+	return err
+}
+
+// RunWithOutput executes a command, saving its stdout and returning it. The
+// output of a failed command is saved too.
+func (r *FileOutputCommandRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := r.Inner.RunWithOutput(ctx, name, args...)
+	if writeErr := r.save(name, output); writeErr != nil && err == nil {
+		err = writeErr
+	}
+	return output, err
+}
+
+// RunWithStdin executes a command reading stdin. Its output isn't saved, as
+// StdinRunner doesn't return it.
+func (r *FileOutputCommandRunner) RunWithStdin(ctx context.Context, stdin []byte, name string, args ...string) error {
+	return RunWithStdin(ctx, r.Inner, stdin, name, args...)
+}
+
+// save writes the output of a command to the next log file
+func (r *FileOutputCommandRunner) save(name string, output []byte) error {
+	if err := os.MkdirAll(r.LogDir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	sequence := r.sequence.Add(1)
+	path := filepath.Join(r.LogDir, fmt.Sprintf("%03d-%s.log", sequence, filepath.Base(name)))
+	if err := os.WriteFile(path, output, 0644); err != nil {
+		return fmt.Errorf("failed to save output of %s: %w", name, err)
+	}
+	return nil
 }
@@ -0,0 +1,207 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RealCommandRunner", func() {
+	It("should return the exit code and stderr of a failed command", func() {
+		err := NewRealCommandRunner().Run(context.Background(), "sh", "-c", "echo 'unsupported media type' >&2; exit 125")
+
+		var cmdErr *CommandError
+		Expect(errors.As(err, &cmdErr)).To(BeTrue())
+		Expect(cmdErr.ExitCode).To(Equal(125))
+		Expect(cmdErr.Message).To(Equal("exit status 125: unsupported media type"))
+		Expect(cmdErr.Stderr).To(Equal("unsupported media type"))
+	})
+
+	It("should return the output of a successful command", func() {
+		output, err := NewRealCommandRunner().RunWithOutput(context.Background(), "sh", "-c", "echo ok; echo noise >&2")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(output)).To(Equal("ok\n"))
+	})
+
+	It("should feed stdin to the command", func() {
+		err := NewRealCommandRunner().RunWithStdin(context.Background(), []byte("secret"), "sh", "-c", `test "$(cat)" = secret`)
+
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("RunWithStdin", func() {
+	It("should feed stdin through the wrapping runners", func() {
+		mock := NewMockCommandRunner()
+		runner := NewRetryingCommandRunner(NewEnvCommandRunner(mock, []string{"STORAGE_DRIVER=vfs"}, "buildah"), 0, 0, nil)
+
+		Expect(RunWithStdin(context.Background(), runner, []byte("secret"), "buildah", "login", "--password-stdin", "quay.io")).To(Succeed())
+
+		Expect(mock.GetLastCommand()).To(Equal([]string{"env", "STORAGE_DRIVER=vfs", "buildah", "login", "--password-stdin", "quay.io"}))
+		Expect(string(mock.GetStdin("env", "STORAGE_DRIVER=vfs", "buildah", "login", "--password-stdin", "quay.io"))).To(Equal("secret"))
+	})
+
+	It("should fail with runners that can't feed stdin", func() {
+		err := RunWithStdin(context.Background(), struct{ CommandRunner }{NewMockCommandRunner()}, []byte("secret"), "buildah", "login")
+
+		Expect(err).To(MatchError(ContainSubstring("can't feed stdin to buildah")))
+	})
+})
+
+var _ = Describe("SudoCommandRunner", func() {
+	var (
+		ctx  context.Context
+		mock *MockCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = NewMockCommandRunner()
+	})
+
+	It("should run commands unchanged when disabled", func() {
+		runner := NewSudoCommandRunner(mock, false)
+
+		Expect(runner.Run(ctx, "buildah", "push", "image")).To(Succeed())
+
+		Expect(mock.GetLastCommand()).To(Equal([]string{"buildah", "push", "image"}))
+	})
+
+	It("should prepend sudo when enabled", func() {
+		runner := NewSudoCommandRunner(mock, true)
+
+		Expect(runner.Run(ctx, "buildah", "push", "image")).To(Succeed())
+
+		Expect(mock.GetLastCommand()).To(Equal([]string{"sudo", "buildah", "push", "image"}))
+	})
+
+	It("should use SudoArgs instead of plain sudo", func() {
+		runner := NewSudoCommandRunner(mock, true)
+		runner.SudoArgs = []string{"sudo", "-n", "-E"}
+		mock.SetOutput("sudo", []byte("1.37.0"), "-n", "-E", "buildah", "--version")
+
+		output, err := runner.RunWithOutput(ctx, "buildah", "--version")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal([]byte("1.37.0")))
+		Expect(mock.GetLastCommand()).To(Equal([]string{"sudo", "-n", "-E", "buildah", "--version"}))
+	})
+})
+
+var _ = Describe("EnvCommandRunner", func() {
+	var (
+		ctx  context.Context
+		mock *MockCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = NewMockCommandRunner()
+	})
+
+	It("should run the listed commands through env", func() {
+		runner := NewEnvCommandRunner(mock, []string{"STORAGE_DRIVER=vfs"}, "buildah")
+		mock.SetOutput("env", []byte("1.37.0"), "STORAGE_DRIVER=vfs", "buildah", "--version")
+
+		Expect(runner.Run(ctx, "buildah", "push", "image")).To(Succeed())
+		output, err := runner.RunWithOutput(ctx, "buildah", "--version")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(output)).To(Equal("1.37.0"))
+		Expect(mock.AssertCommandExecuted("env", "STORAGE_DRIVER=vfs", "buildah", "push", "image")).To(BeTrue())
+	})
+
+	It("should leave other commands alone", func() {
+		runner := NewEnvCommandRunner(mock, []string{"STORAGE_DRIVER=vfs"}, "buildah")
+
+		Expect(runner.Run(ctx, "skopeo", "inspect", "docker://image")).To(Succeed())
+
+		Expect(mock.GetLastCommand()).To(Equal([]string{"skopeo", "inspect", "docker://image"}))
+	})
+
+	It("should add its variables to a command an outer runner runs through env", func() {
+		inner := NewEnvCommandRunner(mock, []string{"STORAGE_DRIVER=vfs"}, "buildah")
+		runner := NewEnvCommandRunner(inner, []string{"TMPDIR=/workspace/tmp"}, "buildah", "skopeo")
+
+		Expect(runner.Run(ctx, "buildah", "push", "image")).To(Succeed())
+		Expect(runner.Run(ctx, "skopeo", "inspect", "docker://image")).To(Succeed())
+
+		Expect(mock.GetExecutedCommands()).To(Equal([][]string{
+			{"env", "TMPDIR=/workspace/tmp", "STORAGE_DRIVER=vfs", "buildah", "push", "image"},
+			{"env", "TMPDIR=/workspace/tmp", "skopeo", "inspect", "docker://image"},
+		}))
+	})
+
+	It("should run commands unchanged without variables", func() {
+		runner := NewEnvCommandRunner(mock, nil, "buildah")
+
+		Expect(runner.Run(ctx, "buildah", "push", "image")).To(Succeed())
+
+		Expect(mock.GetLastCommand()).To(Equal([]string{"buildah", "push", "image"}))
+	})
+})
+
+var _ = Describe("FileOutputCommandRunner", func() {
+	var (
+		ctx    context.Context
+		mock   *MockCommandRunner
+		logDir string
+		stdout *bytes.Buffer
+		runner *FileOutputCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = NewMockCommandRunner()
+		logDir = filepath.Join(GinkgoT().TempDir(), "logs")
+		stdout = &bytes.Buffer{}
+		runner = NewFileOutputCommandRunner(mock, logDir)
+		runner.Stdout = stdout
+	})
+
+	readLog := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(logDir, name))
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	It("should save the stdout of each command to a numbered file", func() {
+		mock.SetOutput("/usr/bin/buildah", []byte("buildah version 1.39.0\n"), "--version")
+		mock.SetOutput("skopeo", []byte(`{"Digest":"sha256:abc"}`), "inspect", "docker://quay.io/test/image:tag")
+
+		output, err := runner.RunWithOutput(ctx, "/usr/bin/buildah", "--version")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(output)).To(Equal("buildah version 1.39.0\n"))
+		Expect(runner.Run(ctx, "skopeo", "inspect", "docker://quay.io/test/image:tag")).To(Succeed())
+
+		entries, err := os.ReadDir(logDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+		Expect(readLog("001-buildah.log")).To(Equal("buildah version 1.39.0\n"))
+		Expect(readLog("002-skopeo.log")).To(Equal(`{"Digest":"sha256:abc"}`))
+		Expect(stdout.String()).To(Equal(`{"Digest":"sha256:abc"}`))
+	})
+
+	It("should save the output of a failed command and return its error", func() {
+		mock.QueueResult("buildah", []byte("partial output\n"), &CommandError{ExitCode: 1, Message: "failed"}, "push", "image")
+
+		err := runner.Run(ctx, "buildah", "push", "image")
+
+		Expect(err).To(MatchError("failed"))
+		Expect(readLog("001-buildah.log")).To(Equal("partial output\n"))
+	})
+
+	It("should fail a command whose output can't be saved", func() {
+		Expect(os.WriteFile(logDir, nil, 0644)).To(Succeed())
+
+		_, err := runner.RunWithOutput(ctx, "buildah", "--version")
+
+		Expect(err).To(MatchError(ContainSubstring("failed to create log directory")))
+	})
+})
@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	gitconfig "github.com/go-git/go-git/v5/plumbing/format/config"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
 	"go.uber.org/zap"
 )
 
@@ -23,12 +27,52 @@ type CloneConfig struct {
 	Submodules  bool
 	Destination string
 	AuthPath    string
+
+	// Ref is the branch or tag Revision was resolved from, e.g. by
+	// Pipelines-as-Code. It is only recorded in the result, Revision being
+	// checked out.
+	Ref string
+
+	// OnSubmoduleFailure is called when the submodules can't be updated. The
+	// clone fails with the error it returns, if any.
+	OnSubmoduleFailure func(err error) error
+
+	// ForceCheckout checks out the revision even when the working tree has
+	// modifications, e.g. left behind by an interrupted checkout. Local
+	// modifications are discarded.
+	ForceCheckout bool
+
+	// VerifyTagSignature verifies, when Revision is a tag, that the tag is
+	// signed by a key of the armored keyring at TagSigningKeyPath
+	VerifyTagSignature bool
+	TagSigningKeyPath  string
+
+	// SparseCheckout lists the gitignore-style patterns of the paths checked
+	// out, and ExcludePaths the paths left out of the checkout, such as
+	// generated/. The working tree is reduced with git read-tree, run through
+	// Runner.
+	SparseCheckout []string
+	ExcludePaths   []string
+
+	// PatchPath is a directory of *.patch files applied to the clone in
+	// lexicographic order with git apply, run through Runner
+	PatchPath string
+	Runner    exec.CommandRunner
 }
 
 // CloneResult holds the results of a git clone operation
 type CloneResult struct {
 	CommitSHA string
 	URL       string
+
+	// Ref is the branch or tag of CloneConfig.Ref
+	Ref string `json:",omitempty"`
+
+	// AppliedPatches lists the file names of the patches applied after cloning
+	AppliedPatches []string `json:",omitempty"`
+
+	// CommitTimestamp is the committer date of the commit
+	CommitTimestamp time.Time `json:",omitzero"`
 }
 
 // Clone performs git clone operation similar to the git-clone task
@@ -79,7 +123,7 @@ func Clone(ctx context.Context, logger *zap.Logger, config *CloneConfig) (*Clone
 	// Checkout specific revision if specified
 	var commitSHA string
 	if config.Revision != "" {
-		commitSHA, err = checkoutRevision(repo, config.Revision)
+		commitSHA, err = checkoutRevision(repo, config.Revision, config.ForceCheckout)
 		if err != nil {
 			return nil, fmt.Errorf("failed to checkout revision %s: %w", config.Revision, err)
 		}
@@ -92,25 +136,287 @@ func Clone(ctx context.Context, logger *zap.Logger, config *CloneConfig) (*Clone
 		commitSHA = head.Hash().String()
 	}
 
+	if config.VerifyTagSignature && config.TagSigningKeyPath != "" && config.Revision != "" {
+		if _, err := repo.Tag(config.Revision); err == nil {
+			if err := verifyTagSignature(repo, config.Revision, config.TagSigningKeyPath); err != nil {
+				return nil, err
+			}
+			// A branch named like the tag is checked out before the tag
+			if err := checkTagTarget(repo, config.Revision, commitSHA); err != nil {
+				return nil, err
+			}
+			logger.Info("Verified tag signature", zap.String("tag", config.Revision))
+		}
+	}
+
+	if len(config.SparseCheckout) > 0 || len(config.ExcludePaths) > 0 {
+		if err := applySparseCheckout(ctx, config.Destination, config.SparseCheckout, config.ExcludePaths, cloneRunner(config)); err != nil {
+			return nil, err
+		}
+		logger.Info("Applied sparse checkout",
+			zap.Strings("patterns", config.SparseCheckout),
+			zap.Strings("exclude_paths", config.ExcludePaths))
+	}
+
 	// Handle submodules if requested
 	if config.Submodules {
+		// A submodule path escaping the repository fails the clone even when
+		// submodule failures are tolerated
+		if err := EnsureNoMaliciousSubmodules(repo); err != nil {
+			return nil, err
+		}
 		if err := updateSubmodules(repo, auth); err != nil {
 			logger.Warn("Failed to update submodules", zap.Error(err))
+			if config.OnSubmoduleFailure != nil {
+				if err := config.OnSubmoduleFailure(err); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var appliedPatches []string
+	if config.PatchPath != "" {
+		appliedPatches, err = ApplyPatches(ctx, logger, config.Destination, config.PatchPath, cloneRunner(config))
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	commitTimestamp, err := commitTime(repo, commitSHA)
+	if err != nil {
+		return nil, err
+	}
+
 	logger.Info("Git clone completed successfully",
 		zap.String("commit_sha", commitSHA),
 		zap.String("url", config.URL))
 
 	return &CloneResult{
-		CommitSHA: commitSHA,
-		URL:       config.URL,
+		CommitSHA:       commitSHA,
+		URL:             config.URL,
+		Ref:             config.Ref,
+		AppliedPatches:  appliedPatches,
+		CommitTimestamp: commitTimestamp,
 	}, nil
 }
 
-// checkoutRevision checks out a specific revision (branch, tag, or commit)
-func checkoutRevision(repo *git.Repository, revision string) (string, error) {
+// cloneRunner returns the runner of the git commands of a clone
+func cloneRunner(config *CloneConfig) exec.CommandRunner {
+	if config.Runner == nil {
+		return exec.NewRealCommandRunner()
+	}
+	return config.Runner
+}
+
+// sparseCheckoutPatterns combines the included patterns with the negation
+// patterns of the excluded paths. Everything is included when only paths are
+// excluded.
+func sparseCheckoutPatterns(include, exclude []string) []string {
+	patterns := append([]string{}, include...)
+	if len(patterns) == 0 {
+		patterns = append(patterns, "/*")
+	}
+	for _, path := range exclude {
+		patterns = append(patterns, "!"+strings.TrimPrefix(path, "!"))
+	}
+	return patterns
+}
+
+// applySparseCheckout writes the patterns to .git/info/sparse-checkout,
+// enables core.sparseCheckout and updates the working tree to match
+func applySparseCheckout(ctx context.Context, dir string, include, exclude []string, runner exec.CommandRunner) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open repository at %s: %w", dir, err)
+	}
+
+	infoDir := filepath.Join(dir, git.GitDirName, "info")
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", infoDir, err)
+	}
+	content := strings.Join(sparseCheckoutPatterns(include, exclude), "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(infoDir, "sparse-checkout"), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write sparse-checkout patterns: %w", err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read repository config: %w", err)
+	}
+	cfg.Raw.Section("core").SetOption("sparseCheckout", "true")
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to enable sparse checkout: %w", err)
+	}
+
+	if err := runner.Run(ctx, "git", "-C", dir, "read-tree", "-mu", "HEAD"); err != nil {
+		return fmt.Errorf("failed to apply sparse checkout: %w", err)
+	}
+	return nil
+}
+
+// ApplyPatches applies the *.patch files of patchPath to the working tree at
+// dir in lexicographic order and returns their file names
+func ApplyPatches(ctx context.Context, logger *zap.Logger, dir, patchPath string, runner exec.CommandRunner) ([]string, error) {
+	patches, err := filepath.Glob(filepath.Join(patchPath, "*.patch"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list patches in %s: %w", patchPath, err)
+	}
+	sort.Strings(patches)
+
+	applied := []string{}
+	for _, patch := range patches {
+		absPatch, err := filepath.Abs(patch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve patch %s: %w", patch, err)
+		}
+
+		logger.Info("Applying patch", zap.String("patch", filepath.Base(patch)))
+		if err := runner.Run(ctx, "git", "-C", dir, "apply", absPatch); err != nil {
+			return nil, fmt.Errorf("failed to apply patch %s: %w", filepath.Base(patch), err)
+		}
+		applied = append(applied, filepath.Base(patch))
+	}
+
+	return applied, nil
+}
+
+// LocalSource describes a source tree checked out outside the builder
+type LocalSource struct {
+	CloneResult
+
+	// Dirty is true when the working tree has uncommitted or untracked changes
+	Dirty bool
+}
+
+// OpenLocal describes an existing checkout from its HEAD commit and origin
+// remote. The URL is empty when the repository has no origin remote.
+func OpenLocal(path string) (*LocalSource, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository at %s: %w", path, err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	var url string
+	if origin, err := repo.Remote("origin"); err == nil && len(origin.Config().URLs) > 0 {
+		url = origin.Config().URLs[0]
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worktree: %w", err)
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+
+	commitTimestamp, err := commitTime(repo, head.Hash().String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalSource{
+		CloneResult: CloneResult{
+			CommitSHA:       head.Hash().String(),
+			URL:             url,
+			CommitTimestamp: commitTimestamp,
+		},
+		Dirty: !status.IsClean(),
+	}, nil
+}
+
+// commitTime returns the committer date of a commit
+func commitTime(repo *git.Repository, commitSHA string) (time.Time, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitSHA))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read commit %s: %w", commitSHA, err)
+	}
+	return commit.Committer.When, nil
+}
+
+// CommitTitle returns the first line of the message of a commit in the
+// repository at path
+func CommitTitle(path, commitSHA string) (string, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository at %s: %w", path, err)
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(commitSHA))
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit %s: %w", commitSHA, err)
+	}
+
+	title, _, _ := strings.Cut(commit.Message, "\n")
+	return strings.TrimSpace(title), nil
+}
+
+// VerifyTagSignature verifies that the tag tagName of the repository at path
+// is an annotated tag signed by a key of the armored keyring at keyPath
+func VerifyTagSignature(path, tagName, keyPath string) error {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return fmt.Errorf("failed to open repository at %s: %w", path, err)
+	}
+	return verifyTagSignature(repo, tagName, keyPath)
+}
+
+// verifyTagSignature verifies the signature of the annotated tag tagName
+// against the armored keyring at keyPath
+func verifyTagSignature(repo *git.Repository, tagName, keyPath string) error {
+	keyRing, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read tag signing key: %w", err)
+	}
+
+	ref, err := repo.Tag(tagName)
+	if err != nil {
+		return fmt.Errorf("failed to find tag %s: %w", tagName, err)
+	}
+	tag, err := repo.TagObject(ref.Hash())
+	if err != nil {
+		return fmt.Errorf("tag %s is not an annotated tag and can't be signed: %w", tagName, err)
+	}
+	if tag.PGPSignature == "" {
+		return fmt.Errorf("tag %s is not signed", tagName)
+	}
+
+	if _, err := tag.Verify(string(keyRing)); err != nil {
+		return fmt.Errorf("signature of tag %s is not valid for the key at %s: %w", tagName, keyPath, err)
+	}
+	return nil
+}
+
+// checkTagTarget fails unless the annotated tag tagName points to the
+// commit commitSHA
+func checkTagTarget(repo *git.Repository, tagName, commitSHA string) error {
+	ref, err := repo.Tag(tagName)
+	if err != nil {
+		return fmt.Errorf("failed to find tag %s: %w", tagName, err)
+	}
+	tag, err := repo.TagObject(ref.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to read tag %s: %w", tagName, err)
+	}
+	commit, err := tag.Commit()
+	if err != nil {
+		return fmt.Errorf("tag %s doesn't point to a commit: %w", tagName, err)
+	}
+	if commit.Hash.String() != commitSHA {
+		return fmt.Errorf("tag %s points to commit %s but %s was checked out", tagName, commit.Hash, commitSHA)
+	}
+	return nil
+}
+
+// checkoutRevision checks out a specific revision (branch, tag, or commit),
+// discarding local modifications when force is set
+func checkoutRevision(repo *git.Repository, revision string, force bool) (string, error) {
 	w, err := repo.Worktree()
 	if err != nil {
 		return "", err
@@ -119,14 +425,14 @@ func checkoutRevision(repo *git.Repository, revision string) (string, error) {
 	// Try to parse as a commit hash first
 	if len(revision) >= 7 && len(revision) <= 40 {
 		hash := plumbing.NewHash(revision)
-		if err := w.Checkout(&git.CheckoutOptions{Hash: hash}); err == nil {
+		if err := w.Checkout(&git.CheckoutOptions{Hash: hash, Force: force}); err == nil {
 			return hash.String(), nil
 		}
 	}
 
 	// Try as a branch reference
 	branchRef := plumbing.NewBranchReferenceName(revision)
-	if err := w.Checkout(&git.CheckoutOptions{Branch: branchRef}); err == nil {
+	if err := w.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: force}); err == nil {
 		head, err := repo.Head()
 		if err != nil {
 			return "", err
@@ -136,7 +442,7 @@ func checkoutRevision(repo *git.Repository, revision string) (string, error) {
 
 	// Try as a tag reference
 	tagRef := plumbing.NewTagReferenceName(revision)
-	if err := w.Checkout(&git.CheckoutOptions{Branch: tagRef}); err == nil {
+	if err := w.Checkout(&git.CheckoutOptions{Branch: tagRef, Force: force}); err == nil {
 		head, err := repo.Head()
 		if err != nil {
 			return "", err
@@ -171,6 +477,66 @@ func updateSubmodules(repo *git.Repository, auth transport.AuthMethod) error {
 	return nil
 }
 
+// MaliciousSubmoduleError is returned when .gitmodules declares submodule
+// paths that escape the repository
+type MaliciousSubmoduleError struct {
+	Paths []string
+}
+
+func (e *MaliciousSubmoduleError) Error() string {
+	return fmt.Sprintf("submodule paths escape the repository: %s", strings.Join(e.Paths, ", "))
+}
+
+// EnsureNoMaliciousSubmodules fails with a MaliciousSubmoduleError when a
+// submodule path of .gitmodules is absolute or has a .. component. The file
+// is read directly as go-git silently skips such submodules.
+func EnsureNoMaliciousSubmodules(repo *git.Repository) error {
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	f, err := w.Filesystem.Open(".gitmodules")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open .gitmodules: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	modules := gitconfig.New()
+	if err := gitconfig.NewDecoder(f).Decode(modules); err != nil {
+		return fmt.Errorf("failed to parse .gitmodules: %w", err)
+	}
+
+	var paths []string
+	for _, submodule := range modules.Section("submodule").Subsections {
+		path := submodule.Option("path")
+		if submodulePathEscapes(path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) > 0 {
+		return &MaliciousSubmoduleError{Paths: paths}
+	}
+	return nil
+}
+
+// submodulePathEscapes reports whether a submodule path is absolute or has a
+// .. component, with either separator
+func submodulePathEscapes(path string) bool {
+	if strings.HasPrefix(path, "/") || strings.HasPrefix(path, "\\") || filepath.IsAbs(path) {
+		return true
+	}
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
 // loadAuthFromPath loads git authentication from a file path
 func loadAuthFromPath(authPath string) (transport.AuthMethod, error) {
 	// Try to read username/password from auth path
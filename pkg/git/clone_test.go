@@ -0,0 +1,224 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+// commitFile writes a file into the worktree and commits it, returning the commit SHA
+func commitFile(repo *git.Repository, dir, name, content string) string {
+	Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+
+	w, err := repo.Worktree()
+	Expect(err).NotTo(HaveOccurred())
+	_, err = w.Add(name)
+	Expect(err).NotTo(HaveOccurred())
+
+	hash, err := w.Commit("Update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	return hash.String()
+}
+
+var _ = Describe("checkoutRevision", func() {
+	var (
+		dir         string
+		repo        *git.Repository
+		firstCommit string
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		var err error
+		repo, err = git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		firstCommit = commitFile(repo, dir, "Dockerfile", "FROM scratch\n")
+		commitFile(repo, dir, "Dockerfile", "FROM busybox\n")
+
+		// Leave modifications behind as an interrupted checkout would
+		Expect(os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM dirty\n"), 0644)).To(Succeed())
+	})
+
+	It("should refuse to check out over a dirty working tree", func() {
+		_, err := checkoutRevision(repo, firstCommit, false)
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should discard local modifications when forced", func() {
+		sha, err := checkoutRevision(repo, firstCommit, true)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sha).To(Equal(firstCommit))
+		content, err := os.ReadFile(filepath.Join(dir, "Dockerfile"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("FROM scratch\n"))
+	})
+})
+
+var _ = Describe("OpenLocal", func() {
+	var (
+		dir    string
+		repo   *git.Repository
+		commit string
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		var err error
+		repo, err = git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+		commit = commitFile(repo, dir, "Dockerfile", "FROM scratch\n")
+	})
+
+	It("should describe a clean tree from HEAD and the origin remote", func() {
+		_, err := repo.CreateRemote(&config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{"https://github.com/test/repo.git"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		local, err := OpenLocal(dir)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(local.CommitSHA).To(Equal(commit))
+		Expect(local.URL).To(Equal("https://github.com/test/repo.git"))
+		Expect(local.Dirty).To(BeFalse())
+	})
+
+	It("should flag uncommitted changes", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM dirty\n"), 0644)).To(Succeed())
+
+		local, err := OpenLocal(dir)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(local.CommitSHA).To(Equal(commit))
+		Expect(local.URL).To(BeEmpty())
+		Expect(local.Dirty).To(BeTrue())
+	})
+
+	It("should record the committer date of HEAD", func() {
+		committed := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+		Expect(os.WriteFile(filepath.Join(dir, "README"), []byte("readme\n"), 0644)).To(Succeed())
+		w, err := repo.Worktree()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = w.Add("README")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = w.Commit("Add README", &git.CommitOptions{
+			Author:    &object.Signature{Name: "Test", Email: "test@example.com", When: committed.Add(-time.Hour)},
+			Committer: &object.Signature{Name: "Test", Email: "test@example.com", When: committed},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		local, err := OpenLocal(dir)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(local.CommitTimestamp.Equal(committed)).To(BeTrue())
+	})
+
+	It("should fail for a directory that is not a repository", func() {
+		_, err := OpenLocal(GinkgoT().TempDir())
+
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("CommitTitle", func() {
+	It("should return the first line of the commit message", func() {
+		dir := GinkgoT().TempDir()
+		repo, err := git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+		commit := commitFile(repo, dir, "Dockerfile", "FROM scratch\n")
+
+		title, err := CommitTitle(dir, commit)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(title).To(Equal("Update Dockerfile"))
+	})
+
+	It("should fail for an unknown commit", func() {
+		dir := GinkgoT().TempDir()
+		_, err := git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = CommitTitle(dir, "0123456789abcdef0123456789abcdef01234567")
+
+		Expect(err).To(MatchError(ContainSubstring("failed to read commit")))
+	})
+})
+
+var _ = Describe("EnsureNoMaliciousSubmodules", func() {
+	var (
+		dir  string
+		repo *git.Repository
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		var err error
+		repo, err = git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	writeGitmodules := func(paths ...string) {
+		var content strings.Builder
+		for i, path := range paths {
+			fmt.Fprintf(&content, "[submodule \"module%d\"]\n\tpath = %s\n\turl = https://github.com/example/module%d\n", i, path, i)
+		}
+		Expect(os.WriteFile(filepath.Join(dir, ".gitmodules"), []byte(content.String()), 0644)).To(Succeed())
+	}
+
+	It("should accept a repository without .gitmodules", func() {
+		Expect(EnsureNoMaliciousSubmodules(repo)).To(Succeed())
+	})
+
+	It("should accept submodules within the repository", func() {
+		writeGitmodules("libs/common", "vendor/..data", "third_party/tool")
+
+		Expect(EnsureNoMaliciousSubmodules(repo)).To(Succeed())
+	})
+
+	It("should list the submodule paths escaping the repository", func() {
+		writeGitmodules("libs/common", "../../../etc", "libs/../../outside", "/etc/cron.d", "..")
+
+		err := EnsureNoMaliciousSubmodules(repo)
+
+		var maliciousErr *MaliciousSubmoduleError
+		Expect(errors.As(err, &maliciousErr)).To(BeTrue())
+		Expect(maliciousErr.Paths).To(Equal([]string{"../../../etc", "libs/../../outside", "/etc/cron.d", ".."}))
+	})
+
+	It("should fail the clone before updating the submodules", func() {
+		commitFile(repo, dir, ".gitmodules", "[submodule \"escape\"]\n\tpath = ../escape\n\turl = https://github.com/example/escape\n")
+
+		failures := 0
+		_, err := Clone(context.Background(), zap.NewNop(), &CloneConfig{
+			URL:         dir,
+			Destination: filepath.Join(GinkgoT().TempDir(), "source"),
+			Submodules:  true,
+			OnSubmoduleFailure: func(err error) error {
+				failures++
+				return nil
+			},
+		})
+
+		var maliciousErr *MaliciousSubmoduleError
+		Expect(errors.As(err, &maliciousErr)).To(BeTrue())
+		Expect(failures).To(BeZero())
+	})
+})
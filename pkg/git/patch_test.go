@@ -0,0 +1,79 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("ApplyPatches", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		patchDir   string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		patchDir = GinkgoT().TempDir()
+		for _, name := range []string{"0002-second.patch", "0001-first.patch", "README.md", "0010-tenth.patch"} {
+			Expect(os.WriteFile(filepath.Join(patchDir, name), []byte("patch"), 0644)).To(Succeed())
+		}
+	})
+
+	It("should apply the patches in lexicographic order", func() {
+		applied, err := ApplyPatches(ctx, zap.NewNop(), "/workspace/source", patchDir, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied).To(Equal([]string{"0001-first.patch", "0002-second.patch", "0010-tenth.patch"}))
+		Expect(mockRunner.GetExecutedCommands()).To(Equal([][]string{
+			{"git", "-C", "/workspace/source", "apply", filepath.Join(patchDir, "0001-first.patch")},
+			{"git", "-C", "/workspace/source", "apply", filepath.Join(patchDir, "0002-second.patch")},
+			{"git", "-C", "/workspace/source", "apply", filepath.Join(patchDir, "0010-tenth.patch")},
+		}))
+	})
+
+	It("should stop at the first patch that doesn't apply", func() {
+		mockRunner.SetError("git", &exec.CommandError{ExitCode: 1, Message: "patch does not apply"},
+			"-C", "/workspace/source", "apply", filepath.Join(patchDir, "0002-second.patch"))
+
+		_, err := ApplyPatches(ctx, zap.NewNop(), "/workspace/source", patchDir, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to apply patch 0002-second.patch")))
+		Expect(mockRunner.String()).NotTo(ContainSubstring("0010-tenth.patch"))
+	})
+
+	It("should apply nothing from a directory without patches", func() {
+		applied, err := ApplyPatches(ctx, zap.NewNop(), "/workspace/source", GinkgoT().TempDir(), mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied).To(BeEmpty())
+		Expect(mockRunner.GetExecutedCommands()).To(BeEmpty())
+	})
+
+	It("should record the applied patches of a clone", func() {
+		origin := GinkgoT().TempDir()
+		repo, err := git.PlainInit(origin, false)
+		Expect(err).NotTo(HaveOccurred())
+		commitFile(repo, origin, "Dockerfile", "FROM scratch\n")
+		destination := filepath.Join(GinkgoT().TempDir(), "source")
+
+		result, err := Clone(ctx, zap.NewNop(), &CloneConfig{
+			URL:         origin,
+			Destination: destination,
+			PatchPath:   patchDir,
+			Runner:      mockRunner,
+		})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.AppliedPatches).To(Equal([]string{"0001-first.patch", "0002-second.patch", "0010-tenth.patch"}))
+		Expect(mockRunner.AssertCommandExecuted("git", "-C", destination, "apply", filepath.Join(patchDir, "0001-first.patch"))).To(BeTrue())
+	})
+})
@@ -0,0 +1,170 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+// newSigningKey generates an ed25519 OpenPGP key, fast enough for tests
+func newSigningKey() *openpgp.Entity {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	Expect(err).NotTo(HaveOccurred())
+	return entity
+}
+
+// writePublicKey writes the armored public key of entity and returns its path
+func writePublicKey(dir string, entity *openpgp.Entity) string {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(entity.Serialize(w)).To(Succeed())
+	Expect(w.Close()).To(Succeed())
+
+	path := filepath.Join(dir, "key.asc")
+	Expect(os.WriteFile(path, buf.Bytes(), 0644)).To(Succeed())
+	return path
+}
+
+// createTag tags HEAD with an annotated tag, signed when signKey is set
+func createTag(repo *git.Repository, name string, signKey *openpgp.Entity) {
+	head, err := repo.Head()
+	Expect(err).NotTo(HaveOccurred())
+
+	_, err = repo.CreateTag(name, head.Hash(), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+		Message: "Release " + name,
+		SignKey: signKey,
+	})
+	Expect(err).NotTo(HaveOccurred())
+}
+
+var _ = Describe("VerifyTagSignature", func() {
+	var (
+		dir     string
+		repo    *git.Repository
+		signer  *openpgp.Entity
+		keyPath string
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		var err error
+		repo, err = git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+		commitFile(repo, dir, "Dockerfile", "FROM scratch\n")
+
+		signer = newSigningKey()
+		keyPath = writePublicKey(GinkgoT().TempDir(), signer)
+	})
+
+	It("should accept a tag signed by the trusted key", func() {
+		createTag(repo, "v1.0.0", signer)
+
+		Expect(VerifyTagSignature(dir, "v1.0.0", keyPath)).To(Succeed())
+	})
+
+	It("should reject a tag signed by another key", func() {
+		createTag(repo, "v1.0.0", newSigningKey())
+
+		err := VerifyTagSignature(dir, "v1.0.0", keyPath)
+
+		Expect(err).To(MatchError(ContainSubstring("signature of tag v1.0.0 is not valid for the key at " + keyPath)))
+	})
+
+	It("should reject an unsigned annotated tag", func() {
+		createTag(repo, "v1.0.0", nil)
+
+		Expect(VerifyTagSignature(dir, "v1.0.0", keyPath)).To(MatchError("tag v1.0.0 is not signed"))
+	})
+
+	It("should reject a lightweight tag", func() {
+		head, err := repo.Head()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = repo.CreateTag("v1.0.0", head.Hash(), nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = VerifyTagSignature(dir, "v1.0.0", keyPath)
+
+		Expect(err).To(MatchError(ContainSubstring("tag v1.0.0 is not an annotated tag")))
+	})
+
+	It("should fail for an unknown tag", func() {
+		Expect(VerifyTagSignature(dir, "v9.9.9", keyPath)).To(MatchError(ContainSubstring("failed to find tag v9.9.9")))
+	})
+
+	It("should fail when the key can't be read", func() {
+		createTag(repo, "v1.0.0", signer)
+
+		err := VerifyTagSignature(dir, "v1.0.0", filepath.Join(dir, "missing.asc"))
+
+		Expect(err).To(MatchError(ContainSubstring("failed to read tag signing key")))
+	})
+
+	Context("when cloning", func() {
+		var config *CloneConfig
+
+		BeforeEach(func() {
+			config = &CloneConfig{
+				URL:                dir,
+				Revision:           "v1.0.0",
+				Destination:        filepath.Join(GinkgoT().TempDir(), "source"),
+				VerifyTagSignature: true,
+				TagSigningKeyPath:  keyPath,
+			}
+		})
+
+		It("should verify the signature of the checked out tag", func() {
+			createTag(repo, "v1.0.0", signer)
+
+			_, err := Clone(context.Background(), zap.NewNop(), config)
+
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should fail the clone when the tag isn't signed by the trusted key", func() {
+			createTag(repo, "v1.0.0", newSigningKey())
+
+			_, err := Clone(context.Background(), zap.NewNop(), config)
+
+			Expect(err).To(MatchError(ContainSubstring("signature of tag v1.0.0 is not valid")))
+		})
+
+		It("should fail the clone when a branch named like the tag is checked out", func() {
+			createTag(repo, "v1.0.0", signer)
+			tagged, err := repo.Head()
+			Expect(err).NotTo(HaveOccurred())
+			branchCommit := commitFile(repo, dir, "Dockerfile", "FROM busybox\n")
+			Expect(repo.Storer.SetReference(plumbing.NewHashReference(
+				plumbing.NewBranchReferenceName("v1.0.0"), plumbing.NewHash(branchCommit)))).To(Succeed())
+			config.Refspec = "refs/heads/v1.0.0"
+
+			_, err = Clone(context.Background(), zap.NewNop(), config)
+
+			Expect(err).To(MatchError(ContainSubstring(
+				"tag v1.0.0 points to commit " + tagged.Hash().String() + " but " + branchCommit + " was checked out")))
+		})
+
+		It("should not verify revisions that aren't tags", func() {
+			head, err := repo.Head()
+			Expect(err).NotTo(HaveOccurred())
+			config.Revision = head.Hash().String()
+
+			_, err = Clone(context.Background(), zap.NewNop(), config)
+
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,67 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sparseCheckoutPatterns", func() {
+	It("should include everything but the excluded paths", func() {
+		Expect(sparseCheckoutPatterns(nil, []string{"generated/", "!vendor/"})).To(Equal([]string{"/*", "!generated/", "!vendor/"}))
+	})
+
+	It("should combine the included patterns with the negation patterns", func() {
+		Expect(sparseCheckoutPatterns([]string{"/src/", "/Dockerfile"}, []string{"src/generated/"})).To(Equal([]string{"/src/", "/Dockerfile", "!src/generated/"}))
+	})
+})
+
+var _ = Describe("applySparseCheckout", func() {
+	var (
+		ctx        context.Context
+		dir        string
+		mockRunner *exec.MockCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		repo, err := git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+		commitFile(repo, dir, "Dockerfile", "FROM scratch\n")
+		mockRunner = exec.NewMockCommandRunner()
+	})
+
+	It("should write the negation patterns to .git/info/sparse-checkout", func() {
+		Expect(applySparseCheckout(ctx, dir, nil, []string{"generated/"}, mockRunner)).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(dir, ".git", "info", "sparse-checkout"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("/*\n!generated/\n"))
+	})
+
+	It("should enable core.sparseCheckout and update the working tree", func() {
+		Expect(applySparseCheckout(ctx, dir, []string{"/Dockerfile"}, nil, mockRunner)).To(Succeed())
+
+		repo, err := git.PlainOpen(dir)
+		Expect(err).NotTo(HaveOccurred())
+		cfg, err := repo.Config()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Raw.Section("core").Option("sparseCheckout")).To(Equal("true"))
+		Expect(mockRunner.AssertCommandExecuted("git", "-C", dir, "read-tree", "-mu", "HEAD")).To(BeTrue())
+	})
+
+	It("should fail when the working tree can't be updated", func() {
+		mockRunner.SetError("git", &exec.CommandError{ExitCode: 128, Message: "fatal: not a git repository"},
+			"-C", dir, "read-tree", "-mu", "HEAD")
+
+		err := applySparseCheckout(ctx, dir, nil, []string{"generated/"}, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to apply sparse checkout")))
+	})
+})
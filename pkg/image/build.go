@@ -4,8 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
 	"go.uber.org/zap"
 )
 
@@ -22,12 +29,185 @@ type BuildConfig struct {
 	BuildArgs         []string
 	BuildArgsFile     string
 	TLSVerify         bool
+
+	// IgnoreFile replaces .containerignore and .dockerignore of the context
+	IgnoreFile string
+
+	// PrefetchEnv holds the variables of the cachi2 environment, passed to a
+	// hermetic build with --env instead of sourcing the environment file
+	PrefetchEnv map[string]string
+
+	// AuthFile and CertDir configure registry authentication and certificates
+	// for pulling base images before a hermetic build
+	AuthFile string
+	CertDir  string
+
+	// ReadOnlyVolumes mounts the workspace volumes into the build read-only
+	ReadOnlyVolumes bool
+
+	// PushByDigestOnly publishes the image without leaving a tag behind. The
+	// image is pushed to a temporary tag of the run which TagDeleter deletes
+	// once the digest is known. Without a TagDeleter, or when the deletion
+	// fails, the temporary tag is kept and a warning is logged.
+	PushByDigestOnly bool
+
+	// AtomicTag pushes the image to a unique temporary tag, then retags the
+	// pushed digest to the final tag and deletes the temporary tag with
+	// TagDeleter. The reported digest is always this build's content, even
+	// when concurrent builds race for the same tag. Without a TagDeleter the
+	// temporary tag is left for the cleanup of expired temporary tags.
+	AtomicTag bool
+
+	// RunID makes the temporary tags of pushes unique. A timestamp is used
+	// when empty.
+	RunID string
+
+	// TagDeleter deletes temporary tags without deleting the manifest they
+	// point to, nil when the registry has no tag deletion API
+	TagDeleter TagDeleter
+
+	// NetworkMode is open (the default) or none
+	NetworkMode string
+
+	// UserNS sets the user namespace of the build (auto, host, private,
+	// keep-id). With auto, UserNSUIDMap and UserNSGIDMap give the mappings.
+	UserNS       string
+	UserNSUIDMap string
+	UserNSGIDMap string
+
+	// UnshareIDMaps are the subordinate IDs mapped by the unshare commands
+	// of the build
+	UnshareIDMaps IDMaps
+
+	// Platform is the os/arch[/variant] to build for. The pushed image is
+	// verified to match it, catching cross-arch builds without qemu.
+	Platform string
+
+	// IIDFile makes buildah write the ID of the built image to this file
+	IIDFile string
+
+	// Timestamp fixes the creation time of the image and the timestamps of
+	// its layers for reproducible builds, unset when nil
+	Timestamp *time.Time
+
+	// VerifyImageIDAfterPush compares the ID of the built image with the
+	// image ID in the pushed manifest and logs a warning when they differ,
+	// e.g. because the registry rewrote the manifest
+	VerifyImageIDAfterPush bool
+
+	// RequireDigest fails the build when the digest of the pushed image can't
+	// be retrieved. When unset a warning is logged and the digest is empty.
+	RequireDigest bool
+
+	// FileManifestPath, when set, receives a manifest of the files of the
+	// built image. Files matching FileDenyPatterns are logged, or fail the
+	// build before it is pushed when FileDenyAction is fail.
+	FileManifestPath string
+	FileDenyPatterns []string
+	FileDenyAction   string
+
+	// Labels are added to the image in addition to the commit and expiration labels
+	Labels map[string]string
+
+	// InsecureRegistries lists registry hosts reached without TLS
+	// verification, over plain HTTP if needed
+	InsecureRegistries []string
+
+	// RegistryMirrors maps source registries to the mirror registry their
+	// base images are pulled from
+	RegistryMirrors map[string]string
+
+	// RegistriesConf is the registries.conf passed to buildah build, set by
+	// BuildAndPush for InsecureRegistries and RegistryMirrors
+	RegistriesConf string
+
+	// MaxLayers and MaxHistory fail the build before pushing when the built
+	// image has more layers or history entries. Zero disables the limit.
+	MaxLayers  int
+	MaxHistory int
+
+	// MaxLayerCount fails the build after pushing when the pushed image, as
+	// reported by the registry, has more layers. Zero disables the limit.
+	MaxLayerCount int
+
+	// LayerCacheDir, typically on the workspace volume, holds a buildah
+	// storage root per repository that is kept between runs so that layers
+	// are reused by --layers builds. A build failing on a corrupted cache
+	// wipes it and is retried once.
+	LayerCacheDir string
+
+	// LayerCacheMaxSize caps the layers of the cache in bytes, the least
+	// recently used ones being pruned after the build. Zero disables pruning.
+	LayerCacheMaxSize int64
+
+	// ImageConfigExpectations fails the build before pushing when the
+	// entrypoint, exposed ports or user of the built image differ. Nil
+	// disables the check.
+	ImageConfigExpectations *ImageConfigExpectations
+
+	// BuildCacheDir is a persistent cache directory used as --cache-from
+	// source of a --layers build and mounted as an overlay volume at
+	// BuildCacheMountPath, so writes of the build don't persist
+	BuildCacheDir string
+}
+
+// tlsVerify returns whether TLS is verified when pushing and inspecting the image
+func (c *BuildConfig) tlsVerify() bool {
+	return EffectiveTLSVerify(c.ImageURL, c.TLSVerify, c.InsecureRegistries)
+}
+
+// registryClient returns a registry client honouring the TLS settings of config
+func (c *BuildConfig) registryClient(runner exec.CommandRunner) *RegistryClient {
+	return NewRegistryClient(runner, RegistryOptions{
+		TLSVerify:          c.TLSVerify,
+		InsecureRegistries: c.InsecureRegistries,
+	})
 }
 
 // BuildResult holds the results of a container image build
 type BuildResult struct {
 	ImageURL    string
 	ImageDigest string
+
+	// ImageRef is the repo@digest reference, set when pushing by digest only
+	ImageRef string
+
+	// MediaType is the media type of the pushed manifest, as the registry
+	// returns it, empty when the manifest couldn't be fetched
+	MediaType string
+
+	// ImageSize is the total compressed size of the pushed layers in bytes
+	ImageSize int64
+
+	// BuildDuration and PushDuration measure the buildah build and push
+	BuildDuration time.Duration
+	PushDuration  time.Duration
+
+	// Layers describes the layers of the built image, nil when not checked
+	Layers *LayerStats
+
+	// ImageID and PushedImageID are the IDs of the built and pushed image,
+	// set when verifying the image ID after push
+	ImageID       string
+	PushedImageID string
+
+	// FileManifest summarizes the files of the built image, nil when not exported
+	FileManifest *FileManifest
+
+	// ImageConfig is the configuration of the built image, nil when not checked
+	ImageConfig *ImageConfig
+
+	// DockerfileDigest is the sha256 digest of the Dockerfile handed to
+	// buildah, empty when it couldn't be read
+	DockerfileDigest string
+
+	// BuildArgsUsed lists the build arguments of BuildArgsFile and BuildArgs
+	// as buildah applies them, a command line argument overriding the file
+	BuildArgsUsed []string
+
+	// EffectiveBuildArgs lists the resolved build arguments with their source
+	// and secret-looking values redacted, sorted by key
+	EffectiveBuildArgs []EffectiveBuildArg
 }
 
 // BuildAndPush builds and pushes a container image using buildah
@@ -37,17 +217,325 @@ func BuildAndPush(ctx context.Context, logger *zap.Logger, config *BuildConfig,
 		zap.String("dockerfile", config.Dockerfile),
 		zap.String("context", config.Context))
 
+	// Reject build arguments that could inject commands into the unshare shell
+	if err := ValidateBuildArgs(config.BuildArgs); err != nil {
+		return nil, err
+	}
+
+	if err := validatePrefetchEnv(config.PrefetchEnv); err != nil {
+		return nil, err
+	}
+
+	buildArgsUsed, err := BuildArgsUsed(config)
+	if err != nil {
+		return nil, err
+	}
+
+	effectiveBuildArgs, err := EffectiveBuildArgs(config, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateFilePatterns(config.FileDenyPatterns); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateNetworkMode(config.NetworkMode); err != nil {
+		return nil, err
+	}
+
+	if config.AtomicTag && config.PushByDigestOnly {
+		return nil, fmt.Errorf("atomic tagging and pushing by digest only are mutually exclusive")
+	}
+
+	// Capture the ID of the built image to verify the pushed one against
+	if config.VerifyImageIDAfterPush && config.IIDFile == "" {
+		iidDir, err := os.MkdirTemp("", "iidfile-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create image ID file directory: %w", err)
+		}
+		defer func() { _ = os.RemoveAll(iidDir) }()
+
+		withIIDFile := *config
+		withIIDFile.IIDFile = filepath.Join(iidDir, "iid")
+		config = &withIIDFile
+	}
+
+	// Record the exact Dockerfile buildah reads. A missing Dockerfile is left
+	// for buildah to report.
+	dockerfileDigest, err := DockerfileDigest(config.dockerfilePath())
+	if err != nil {
+		logger.Warn("Failed to compute the Dockerfile digest", zap.Error(err))
+	} else {
+		labels := make(map[string]string, len(config.Labels)+1)
+		for key, value := range config.Labels {
+			labels[key] = value
+		}
+		labels[LabelDockerfileDigest] = dockerfileDigest
+
+		withDigestLabel := *config
+		withDigestLabel.Labels = labels
+		config = &withDigestLabel
+	}
+
+	// Let the build pull base images from insecure registries and mirrors
+	// through a private registries.conf rather than the system one
+	var env []string
+	if len(config.InsecureRegistries) > 0 || len(config.RegistryMirrors) > 0 {
+		confDir, err := os.MkdirTemp("", "registries-conf-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create registries.conf directory: %w", err)
+		}
+		defer func() { _ = os.RemoveAll(confDir) }()
+
+		confPath, err := WriteRegistriesConf(confDir, SystemRegistriesConf, config.InsecureRegistries, config.RegistryMirrors)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, "CONTAINERS_REGISTRIES_CONF="+confPath)
+
+		withRegistriesConf := *config
+		withRegistriesConf.RegistriesConf = confPath
+		config = &withRegistriesConf
+	}
+
 	// Build the buildah build command
-	buildArgs := BuildahBuildCommand(config)
-	logger.Info("Executing buildah build", zap.Strings("args", buildArgs))
+	buildArgs, err := BuildahBuildCommand(config)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Executing buildah build", zap.Strings("args", redact.Args(buildArgs)))
+
+	// The isolated build can't reach registries, so pull its base images first
+	if config.Hermetic {
+		if err := prePullBaseImages(ctx, logger, config, env, runner); err != nil {
+			return nil, err
+		}
+	}
 
 	// Execute buildah build using unshare wrapper for rootless execution
-	unshareCmd := UnshareCommand(buildArgs, config.Context)
-	if err := runner.Run(ctx, unshareCmd[0], unshareCmd[1:]...); err != nil {
+	unshareCmd := UnshareCommandWithEnv(buildArgs, config.Context, env, config.UnshareIDMaps)
+	buildStart := time.Now()
+	err = runner.Run(ctx, unshareCmd[0], unshareCmd[1:]...)
+	if err != nil && config.LayerCacheDir != "" && isLayerCacheCorruption(err, config.layerCacheRoot()) {
+		logger.Warn("Layer cache looks corrupted, wiping it and retrying the build",
+			zap.String("layer_cache", config.layerCacheRoot()),
+			zap.Error(err))
+		if err := WipeLayerCache(ctx, config.layerCacheRoot(), config.UnshareIDMaps, runner); err != nil {
+			return nil, err
+		}
+		err = runner.Run(ctx, unshareCmd[0], unshareCmd[1:]...)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("buildah build failed: %w", err)
 	}
+	buildDuration := time.Since(buildStart)
+
+	var layers *LayerStats
+	if config.MaxLayers > 0 || config.MaxHistory > 0 {
+		var err error
+		layers, err = checkLayers(ctx, logger, config, runner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var imageConfig *ImageConfig
+	if config.ImageConfigExpectations != nil {
+		var err error
+		imageConfig, err = checkImageConfig(ctx, logger, config, runner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fileManifest *FileManifest
+	if config.FileManifestPath != "" {
+		var err error
+		fileManifest, err = exportFileManifest(ctx, logger, config, runner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result *BuildResult
+	pushStart := time.Now()
+	switch {
+	case config.PushByDigestOnly:
+		result, err = pushByDigest(ctx, logger, config, runner)
+	case config.AtomicTag:
+		result, err = pushAtomic(ctx, logger, config, runner)
+	default:
+		result, err = push(ctx, logger, config, runner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.BuildDuration = buildDuration
+	result.PushDuration = time.Since(pushStart)
+	result.Layers = layers
+	result.FileManifest = fileManifest
+	result.ImageConfig = imageConfig
+	result.DockerfileDigest = dockerfileDigest
+	result.BuildArgsUsed = buildArgsUsed
+	result.EffectiveBuildArgs = effectiveBuildArgs
+
+	// The pushed manifest tells its media type and, when verifying, the image ID
+	manifest := fetchPushedManifest(ctx, logger, config, result, runner)
+	if manifest != nil {
+		result.MediaType = manifest.MediaType
+	}
+
+	if config.VerifyImageIDAfterPush {
+		verifyImageID(logger, config, result, manifest)
+	}
+
+	if config.MaxLayerCount > 0 {
+		if err := checkLayerCount(ctx, logger, config, pushedReference(config, result), runner); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Platform != "" {
+		if err := verifyPlatform(ctx, logger, config, pushedReference(config, result), runner); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.LayerCacheDir != "" && config.LayerCacheMaxSize > 0 {
+		pruneLayerCache(ctx, logger, config, runner)
+	}
+
+	return result, nil
+}
+
+// fetchPushedManifest fetches and parses the pushed manifest. Failures are
+// only logged and return nil.
+func fetchPushedManifest(ctx context.Context, logger *zap.Logger, config *BuildConfig, result *BuildResult, runner exec.CommandRunner) *Manifest {
+	raw, err := config.registryClient(runner).RawManifest(ctx, pushedReference(config, result))
+	if err != nil {
+		logger.Warn("Failed to fetch the pushed manifest", zap.Error(err))
+		return nil
+	}
+	manifest, err := ParseManifest(raw)
+	if err != nil {
+		logger.Warn("Failed to parse the pushed manifest", zap.Error(err))
+		return nil
+	}
+	return manifest
+}
+
+// verifyImageID records the IDs of the built and pushed image in the result,
+// logging a warning when they differ. Failing to read either ID is only logged.
+func verifyImageID(logger *zap.Logger, config *BuildConfig, result *BuildResult, manifest *Manifest) {
+	content, err := os.ReadFile(config.IIDFile)
+	if err != nil {
+		logger.Warn("Failed to read the built image ID", zap.Error(err))
+		return
+	}
+	result.ImageID = strings.TrimSpace(string(content))
+
+	if manifest == nil {
+		logger.Warn("Pushed manifest unavailable, the image ID can't be verified")
+		return
+	}
+	result.PushedImageID = manifest.ConfigDigest
+
+	if result.PushedImageID != result.ImageID {
+		logger.Warn("Pushed image ID differs from the built image ID, the registry may have rewritten the manifest",
+			zap.String("image_id", result.ImageID),
+			zap.String("pushed_image_id", result.PushedImageID))
+	}
+}
+
+// pushedReference returns the reference of exactly what was pushed when the
+// digest is known, falling back to the image URL
+func pushedReference(config *BuildConfig, result *BuildResult) string {
+	if result.ImageDigest == "" {
+		return config.ImageURL
+	}
+	return fmt.Sprintf("%s@%s", Repository(config.ImageURL), result.ImageDigest)
+}
+
+// LayerStats holds the layer and history counts of a locally built image
+type LayerStats struct {
+	Layers  int `json:"layers"`
+	History int `json:"history"`
+}
+
+// InspectLocalImage counts the layers and history entries of a locally built image
+func InspectLocalImage(ctx context.Context, imageURL string, runner exec.CommandRunner) (*LayerStats, error) {
+	return inspectLocalImage(ctx, BuildahInspectCommand(imageURL), runner)
+}
+
+// inspectLocalImage counts the layers and history entries of a local image
+// inspected with the buildah arguments args
+func inspectLocalImage(ctx context.Context, args []string, runner exec.CommandRunner) (*LayerStats, error) {
+	output, err := runner.RunWithOutput(ctx, "buildah", args...)
+	if err != nil {
+		return nil, fmt.Errorf("buildah inspect failed: %w", err)
+	}
+
+	var inspect struct {
+		OCIv1 struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+			History []json.RawMessage `json:"history"`
+		} `json:"OCIv1"`
+	}
+	if err := json.Unmarshal(output, &inspect); err != nil {
+		return nil, fmt.Errorf("failed to parse buildah inspect output: %w", err)
+	}
+	if inspect.OCIv1.RootFS.DiffIDs == nil {
+		return nil, fmt.Errorf("layers not found in buildah inspect output")
+	}
+
+	return &LayerStats{
+		Layers:  len(inspect.OCIv1.RootFS.DiffIDs),
+		History: len(inspect.OCIv1.History),
+	}, nil
+}
+
+// checkLayers fails when the built image exceeds the configured layer or
+// history limits. Missing inspect data only produces a warning.
+func checkLayers(ctx context.Context, logger *zap.Logger, config *BuildConfig, runner exec.CommandRunner) (*LayerStats, error) {
+	stats, err := inspectLocalImage(ctx, config.buildahArgs(BuildahInspectCommand(config.ImageURL)), runner)
+	if err != nil {
+		logger.Warn("Unable to check image layers", zap.Error(err))
+		return nil, nil
+	}
+
+	logger.Info("Built image layers",
+		zap.Int("layers", stats.Layers),
+		zap.Int("history", stats.History))
+
+	if config.MaxLayers > 0 && stats.Layers > config.MaxLayers {
+		return nil, fmt.Errorf("image has %d layers, exceeding the maximum of %d", stats.Layers, config.MaxLayers)
+	}
+	if config.MaxHistory > 0 && stats.History > config.MaxHistory {
+		return nil, fmt.Errorf("image has %d history entries, exceeding the maximum of %d", stats.History, config.MaxHistory)
+	}
+	return stats, nil
+}
+
+// checkLayerCount fails when the image pushed at ref has more layers than
+// MaxLayerCount. The image stays pushed.
+func checkLayerCount(ctx context.Context, logger *zap.Logger, config *BuildConfig, ref string, runner exec.CommandRunner) error {
+	count, err := GetLayerCount(ctx, ref, config.tlsVerify(), runner)
+	if err != nil {
+		return fmt.Errorf("failed to count the layers of the pushed image: %w", err)
+	}
+
+	logger.Info("Pushed image layers", zap.Int("layers", count))
+	if count > config.MaxLayerCount {
+		return fmt.Errorf("pushed image has %d layers, exceeding the maximum of %d", count, config.MaxLayerCount)
+	}
+	return nil
+}
 
-	// Push the image
+// push pushes the image to its tag and resolves the pushed digest
+func push(ctx context.Context, logger *zap.Logger, config *BuildConfig, runner exec.CommandRunner) (*BuildResult, error) {
 	logger.Info("Pushing image to registry")
 	pushArgs := BuildahPushCommand(config)
 	if err := runner.Run(ctx, "buildah", pushArgs...); err != nil {
@@ -55,10 +543,211 @@ func BuildAndPush(ctx context.Context, logger *zap.Logger, config *BuildConfig,
 	}
 
 	// Get image digest
-	digest, err := getImageDigest(ctx, config.ImageURL, config.TLSVerify, runner)
+	inspect, err := inspectImage(ctx, config.ImageURL, config.tlsVerify(), runner)
 	if err != nil {
+		if config.RequireDigest {
+			return nil, fmt.Errorf("failed to get digest of pushed image %s: %w", config.ImageURL, err)
+		}
 		logger.Warn("Failed to get image digest", zap.Error(err))
-		digest = ""
+		inspect = &inspectResult{}
+	}
+
+	logger.Info("Container image build completed successfully",
+		zap.String("image_url", config.ImageURL),
+		zap.String("image_digest", inspect.Digest))
+
+	return &BuildResult{
+		ImageURL:    config.ImageURL,
+		ImageDigest: inspect.Digest,
+		ImageSize:   inspect.size(),
+	}, nil
+}
+
+// buildArgDenyList holds sequences that are interpreted by the shell running buildah
+var buildArgDenyList = []string{"$(", "`", ";", "\n"}
+
+// ValidateBuildArgs rejects build arguments whose value contains shell injection characters
+func ValidateBuildArgs(args []string) error {
+	for _, arg := range args {
+		if err := validateBuildArg(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateBuildArg checks the value part (after "=") of a single build argument
+func validateBuildArg(arg string) error {
+	key, value, _ := strings.Cut(arg, "=")
+	for _, denied := range buildArgDenyList {
+		if strings.Contains(value, denied) {
+			return fmt.Errorf("build argument %s contains disallowed sequence %q", key, denied)
+		}
+	}
+	return nil
+}
+
+// BuildArgsUsed merges the build arguments of BuildArgsFile with BuildArgs the
+// way buildah does: the file is read first, blank lines and comments being
+// skipped, then command line arguments override the same keys. Arguments
+// keep the position of their first occurrence.
+func BuildArgsUsed(config *BuildConfig) ([]string, error) {
+	args, err := readBuildArgsFile(config.BuildArgsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range config.BuildArgs {
+		if arg != "" {
+			args = append(args, arg)
+		}
+	}
+
+	used := make([]string, 0, len(args))
+	positions := make(map[string]int, len(args))
+	for _, arg := range args {
+		key, _, _ := strings.Cut(arg, "=")
+		if i, seen := positions[key]; seen {
+			used[i] = arg
+			continue
+		}
+		positions[key] = len(used)
+		used = append(used, arg)
+	}
+	return used, nil
+}
+
+// validatePrefetchEnv rejects prefetch environment values that the shell
+// running buildah would interpret
+func validatePrefetchEnv(env map[string]string) error {
+	for key, value := range env {
+		for _, denied := range buildArgDenyList {
+			if strings.Contains(value, denied) {
+				return fmt.Errorf("prefetch environment variable %s contains disallowed sequence %q", key, denied)
+			}
+		}
+	}
+	return nil
+}
+
+// pushByDigest pushes the image to a temporary tag of the run, reads the
+// pushed digest from buildah's digest file and deletes the temporary tag so
+// that only the digest reference remains
+func pushByDigest(ctx context.Context, logger *zap.Logger, config *BuildConfig, runner exec.CommandRunner) (*BuildResult, error) {
+	repository := Repository(config.ImageURL)
+	tempRef := TemporaryReference(config, time.Now())
+
+	digestDir, err := os.MkdirTemp("", "push-digest-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create digest file directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(digestDir) }()
+	digestFile := filepath.Join(digestDir, "digest")
+
+	logger.Info("Pushing image to registry by digest", zap.String("temporary_reference", tempRef))
+	pushArgs := buildahPushToCommand(config, tempRef, digestFile)
+	if err := runner.Run(ctx, "buildah", pushArgs...); err != nil {
+		return nil, fmt.Errorf("buildah push failed: %w", err)
+	}
+
+	// Without a digest there is no way to reference the pushed image
+	digest, err := readPushedDigest(digestFile)
+	if err != nil {
+		return nil, err
+	}
+	imageRef := fmt.Sprintf("%s@%s", repository, digest)
+
+	deleteTemporaryTag(ctx, logger, config, tempRef)
+
+	var size int64
+	if inspect, err := inspectImage(ctx, imageRef, config.tlsVerify(), runner); err != nil {
+		logger.Warn("Failed to get size of pushed image", zap.Error(err))
+	} else {
+		size = inspect.size()
+	}
+
+	logger.Info("Container image build completed successfully",
+		zap.String("image_url", repository),
+		zap.String("image_ref", imageRef))
+
+	return &BuildResult{
+		ImageURL:    repository,
+		ImageDigest: digest,
+		ImageRef:    imageRef,
+		ImageSize:   size,
+	}, nil
+}
+
+// readPushedDigest reads the digest buildah push wrote to digestFile
+func readPushedDigest(digestFile string) (string, error) {
+	content, err := os.ReadFile(digestFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read digest of pushed image: %w", err)
+	}
+	digest := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("invalid digest %q in digest file", digest)
+	}
+	return digest, nil
+}
+
+// deleteTemporaryTag deletes the temporary tag of tempRef with the
+// TagDeleter, leaving it in place with a warning when there is none or the
+// deletion fails. skopeo delete isn't an option: it deletes the manifest the
+// tag points to, which is the image just pushed.
+func deleteTemporaryTag(ctx context.Context, logger *zap.Logger, config *BuildConfig, tempRef string) {
+	if config.TagDeleter == nil {
+		logger.Warn("Registry has no tag deletion API, leaving the temporary tag in place",
+			zap.String("temporary_reference", tempRef))
+		return
+	}
+	if err := config.TagDeleter.DeleteTag(ctx, Repository(tempRef), Tag(tempRef)); err != nil {
+		logger.Warn("Failed to delete the temporary tag, leaving it in place",
+			zap.String("temporary_reference", tempRef),
+			zap.Error(err))
+	}
+}
+
+// pushAtomic pushes the image to a unique temporary tag, reads the pushed
+// digest from buildah's digest file and copies that digest to the final tag.
+// Deleting the temporary tag is best effort and never deletes its manifest,
+// which the final tag now points to.
+func pushAtomic(ctx context.Context, logger *zap.Logger, config *BuildConfig, runner exec.CommandRunner) (*BuildResult, error) {
+	repository := Repository(config.ImageURL)
+	tempRef := RunTemporaryReference(config, time.Now())
+
+	digestDir, err := os.MkdirTemp("", "push-digest-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create digest file directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(digestDir) }()
+	digestFile := filepath.Join(digestDir, "digest")
+
+	logger.Info("Pushing image to a temporary tag", zap.String("temporary_reference", tempRef))
+	pushArgs := buildahPushToCommand(config, tempRef, digestFile)
+	if err := runner.Run(ctx, "buildah", pushArgs...); err != nil {
+		return nil, fmt.Errorf("buildah push failed: %w", err)
+	}
+
+	digest, err := readPushedDigest(digestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copying by digest tags exactly the content this build pushed, whatever
+	// other builds have pushed to the temporary or final tag meanwhile
+	digestRef := fmt.Sprintf("%s@%s", repository, digest)
+	logger.Info("Tagging pushed digest", zap.String("image_ref", digestRef), zap.String("image_url", config.ImageURL))
+	if err := config.registryClient(runner).Copy(ctx, digestRef, config.ImageURL); err != nil {
+		return nil, fmt.Errorf("failed to tag pushed digest: %w", err)
+	}
+
+	deleteTemporaryTag(ctx, logger, config, tempRef)
+
+	var size int64
+	if inspect, err := inspectImage(ctx, digestRef, config.tlsVerify(), runner); err != nil {
+		logger.Warn("Failed to get size of pushed image", zap.Error(err))
+	} else {
+		size = inspect.size()
 	}
 
 	logger.Info("Container image build completed successfully",
@@ -68,59 +757,169 @@ func BuildAndPush(ctx context.Context, logger *zap.Logger, config *BuildConfig,
 	return &BuildResult{
 		ImageURL:    config.ImageURL,
 		ImageDigest: digest,
+		ImageSize:   size,
 	}, nil
 }
 
-// getImageDigest retrieves the digest of a pushed image
-func getImageDigest(ctx context.Context, imageURL string, tlsVerify bool, runner exec.CommandRunner) (string, error) {
+// Repository strips the tag and digest from an image reference
+func Repository(imageURL string) string {
+	repository, _, _ := strings.Cut(imageURL, "@")
+
+	// A colon after the last slash separates the tag; earlier colons belong to the registry port
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+
+	return repository
+}
+
+// TemporaryReference returns the tmp-push-<commit>-<runID>-tmp<pushed>
+// reference an image is pushed to before its tag is deleted. The run ID keeps
+// concurrent builds of the same commit apart.
+func TemporaryReference(config *BuildConfig, pushed time.Time) string {
+	tag := "tmp-push"
+	if commit := config.CommitSHA; commit != "" {
+		tag += "-" + commit[:min(len(commit), 12)]
+	}
+	return fmt.Sprintf("%s:%s%s", Repository(config.ImageURL), tag, temporarySuffix(config, pushed))
+}
+
+// maxTagLength is the longest tag registries accept
+const maxTagLength = 128
+
+// maxRunIDLength bounds the run ID part of a temporary tag
+const maxRunIDLength = 64
+
+// invalidTagChars matches characters that aren't allowed in a tag
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Tag returns the tag of an image reference, "latest" when it has none
+func Tag(imageURL string) string {
+	repository, _, _ := strings.Cut(imageURL, "@")
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		return repository[i+1:]
+	}
+	return "latest"
+}
+
+// RunTemporaryReference returns the unique <tag>-<runID>-tmp<pushed>
+// reference an image is pushed to before being retagged by an atomic push.
+// The tag is shortened to keep the temporary tag within the registry limit.
+func RunTemporaryReference(config *BuildConfig, pushed time.Time) string {
+	suffix := temporarySuffix(config, pushed)
+	tag := Tag(config.ImageURL)
+	if room := maxTagLength - len(suffix); len(tag) > room {
+		tag = tag[:room]
+	}
+	return fmt.Sprintf("%s:%s%s", Repository(config.ImageURL), tag, suffix)
+}
+
+// temporarySuffix returns the -<runID>-tmp<pushed> suffix of temporary tags.
+// The run ID, or a timestamp without one, makes them unique and the push
+// time, in seconds since the epoch, tells their age to the cleanup of
+// expired temporary tags.
+func temporarySuffix(config *BuildConfig, pushed time.Time) string {
+	runID := invalidTagChars.ReplaceAllString(config.RunID, "-")
+	if runID == "" {
+		runID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	runID = runID[:min(len(runID), maxRunIDLength)]
+	return fmt.Sprintf("-%s%s%d", runID, temporaryTagMarker, pushed.Unix())
+}
+
+// inspectResult holds the fields of skopeo inspect output used after a push
+type inspectResult struct {
+	Digest     string
+	Labels     map[string]string
+	LayersData []struct {
+		Size int64
+	}
+}
+
+// size returns the total compressed size of the image layers
+func (r *inspectResult) size() int64 {
+	var total int64
+	for _, layer := range r.LayersData {
+		total += layer.Size
+	}
+	return total
+}
+
+// inspectImage retrieves the digest and layer sizes of a pushed image
+func inspectImage(ctx context.Context, imageURL string, tlsVerify bool, runner exec.CommandRunner) (*inspectResult, error) {
 	args := SkopeoInspectCommand(imageURL, tlsVerify)
 
 	output, err := runner.RunWithOutput(ctx, "skopeo", args...)
 	if err != nil {
-		return "", fmt.Errorf("skopeo inspect failed: %w", err)
+		return nil, fmt.Errorf("skopeo inspect failed: %w", err)
 	}
 
-	// Parse JSON output to extract digest
-	var result map[string]interface{}
+	var result inspectResult
 	if err := json.Unmarshal(output, &result); err != nil {
-		return "", fmt.Errorf("failed to parse skopeo output: %w", err)
+		return nil, fmt.Errorf("failed to parse skopeo output: %w", err)
 	}
+	if result.Digest == "" {
+		return nil, fmt.Errorf("digest not found in skopeo output")
+	}
+
+	return &result, nil
+}
 
-	digest, ok := result["Digest"].(string)
-	if !ok {
-		return "", fmt.Errorf("digest not found in skopeo output")
+// GetLayerCount returns the number of layers of an image in the registry
+func GetLayerCount(ctx context.Context, imageURL string, tlsVerify bool, runner exec.CommandRunner) (int, error) {
+	inspect, err := inspectImage(ctx, imageURL, tlsVerify, runner)
+	if err != nil {
+		return 0, err
 	}
+	return len(inspect.LayersData), nil
+}
 
-	return digest, nil
+// GetImageLabels returns the labels of the configuration of an image in the
+// registry, empty when the image has none
+func GetImageLabels(ctx context.Context, imageURL string, tlsVerify bool, runner exec.CommandRunner) (map[string]string, error) {
+	inspect, err := inspectImage(ctx, imageURL, tlsVerify, runner)
+	if err != nil {
+		return nil, err
+	}
+	if inspect.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return inspect.Labels, nil
 }
 
-// CheckImageExists checks if an image exists in the registry
-func CheckImageExists(ctx context.Context, imageURL string, tlsVerify bool, runner exec.CommandRunner) (bool, error) {
-	args := SkopeoExistsCommand(imageURL, tlsVerify)
+// FormatLabels renders labels as key=value lines sorted by key
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	err := runner.Run(ctx, "skopeo", args...)
-	return err == nil, nil
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, labels[key])
+	}
+	return b.String()
 }
 
-// GetImageDigest retrieves the digest of an existing image from the registry
-func GetImageDigest(ctx context.Context, imageURL string, tlsVerify bool, runner exec.CommandRunner) (string, error) {
-	args := SkopeoInspectCommand(imageURL, tlsVerify)
+// ListImageTags returns the tags of a repository
+func ListImageTags(ctx context.Context, registryURL string, tlsVerify bool, runner exec.CommandRunner) ([]string, error) {
+	args := SkopeoListTagsCommand(registryURL, tlsVerify)
 
 	output, err := runner.RunWithOutput(ctx, "skopeo", args...)
 	if err != nil {
-		return "", fmt.Errorf("failed to inspect image %s: %w", imageURL, err)
+		return nil, fmt.Errorf("failed to list tags of %s: %w", registryURL, err)
 	}
 
-	// Parse the JSON output to extract the digest
-	var manifest map[string]interface{}
-	if err := json.Unmarshal([]byte(output), &manifest); err != nil {
-		return "", fmt.Errorf("failed to parse skopeo output: %w", err)
+	var result struct {
+		Tags []string `json:"Tags"`
 	}
-
-	digest, ok := manifest["Digest"].(string)
-	if !ok || digest == "" {
-		return "", fmt.Errorf("digest not found in skopeo output")
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse skopeo output: %w", err)
+	}
+	if result.Tags == nil {
+		return []string{}, nil
 	}
 
-	return digest, nil
+	return result.Tags, nil
 }
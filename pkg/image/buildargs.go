@@ -0,0 +1,98 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
+)
+
+// Sources of an effective build argument
+const (
+	BuildArgSourceFile = "file"
+	BuildArgSourceCLI  = "cli"
+	BuildArgSourceEnv  = "env"
+)
+
+// EffectiveBuildArg is a build argument as buildah resolves it, with its
+// value redacted when it looks like a secret
+type EffectiveBuildArg struct {
+	Arg    string `json:"arg"`
+	Source string `json:"source"`
+}
+
+// readBuildArgsFile reads the KEY=value lines of a build args file, ignoring
+// blank lines and comments
+func readBuildArgsFile(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build args file: %w", err)
+	}
+
+	var args []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			args = append(args, line)
+		}
+	}
+	return args, nil
+}
+
+// EffectiveBuildArgs resolves the build arguments of BuildArgsFile and
+// BuildArgs, the latter taking precedence. Like buildah, a KEY without a
+// value takes its value from the environment and is dropped when the
+// variable isn't set. Arguments are sorted by key.
+func EffectiveBuildArgs(config *BuildConfig, lookupEnv func(string) (string, bool)) ([]EffectiveBuildArg, error) {
+	fileArgs, err := readBuildArgsFile(config.BuildArgsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	type resolved struct {
+		value  string
+		source string
+	}
+	args := make(map[string]resolved)
+	add := func(arg, source string) {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			envValue, ok := lookupEnv(key)
+			if !ok {
+				delete(args, key)
+				return
+			}
+			value, source = envValue, BuildArgSourceEnv
+		}
+		args[key] = resolved{value: value, source: source}
+	}
+	for _, arg := range fileArgs {
+		add(arg, BuildArgSourceFile)
+	}
+	for _, arg := range config.BuildArgs {
+		if arg != "" {
+			add(arg, BuildArgSourceCLI)
+		}
+	}
+
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	effective := make([]EffectiveBuildArg, 0, len(keys))
+	for _, key := range keys {
+		value, _ := redact.Value(key, args[key].value)
+		effective = append(effective, EffectiveBuildArg{
+			Arg:    key + "=" + value,
+			Source: args[key].source,
+		})
+	}
+	return effective, nil
+}
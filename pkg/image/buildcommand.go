@@ -0,0 +1,209 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// BuildOption configures the buildah build command of NewBuildCommand
+type BuildOption func(*buildCommand)
+
+// buildCommand collects the flags and labels of a buildah build command.
+// Labels are kept apart so that they always follow the flags, whatever the
+// order the options are given in.
+type buildCommand struct {
+	dockerfile string
+	flags      []string
+	labels     []string
+	layers     bool
+}
+
+// NewBuildCommand returns the buildah build arguments tagging image and
+// building context, which is always the last argument:
+//
+//	build [--file dockerfile] --tag image [flags...] [--label ...] context
+//
+// Flags keep the order of opts.
+func NewBuildCommand(image, context string, opts ...BuildOption) []string {
+	cmd := &buildCommand{}
+	for _, opt := range opts {
+		opt(cmd)
+	}
+
+	args := []string{"build"}
+	if cmd.dockerfile != "" {
+		args = append(args, "--file", cmd.dockerfile)
+	}
+	args = append(args, "--tag", image)
+	args = append(args, cmd.flags...)
+	args = append(args, cmd.labels...)
+	return append(args, context)
+}
+
+// WithDockerfile builds the Dockerfile or Containerfile at path
+func WithDockerfile(path string) BuildOption {
+	return func(cmd *buildCommand) {
+		cmd.dockerfile = path
+	}
+}
+
+// WithTLSVerify disables TLS verification of the registries when verify is false
+func WithTLSVerify(verify bool) BuildOption {
+	return func(cmd *buildCommand) {
+		if !verify {
+			cmd.flags = append(cmd.flags, "--tls-verify=false")
+		}
+	}
+}
+
+// WithRegistriesConf reads the registries.conf at path, ignored when empty
+func WithRegistriesConf(path string) BuildOption {
+	return withFlag("--registries-conf", path)
+}
+
+// WithPlatform builds for the os/arch[/variant] platform, ignored when empty
+func WithPlatform(platform string) BuildOption {
+	return withFlag("--platform", platform)
+}
+
+// WithBuildArg passes a KEY=value build argument, ignored when empty. The
+// argument is passed as given, BuildahBuildCommand rejects the unsafe ones
+// with ValidateBuildArgs.
+func WithBuildArg(arg string) BuildOption {
+	return func(cmd *buildCommand) {
+		if arg != "" {
+			cmd.flags = append(cmd.flags, "--build-arg", arg)
+		}
+	}
+}
+
+// WithBuildArgsFile reads build arguments from the file at path, ignored when empty
+func WithBuildArgsFile(path string) BuildOption {
+	return withFlag("--build-arg-file", path)
+}
+
+// WithIgnoreFile uses the ignore file at path instead of .containerignore or
+// .dockerignore, ignored when empty
+func WithIgnoreFile(path string) BuildOption {
+	return withFlag("--ignorefile", path)
+}
+
+// WithUserNS configures the user namespace of the build. The UID and GID maps
+// only apply to the auto mode.
+func WithUserNS(mode, uidMap, gidMap string) BuildOption {
+	return func(cmd *buildCommand) {
+		if mode == "" {
+			return
+		}
+		cmd.flags = append(cmd.flags, "--userns="+mode)
+		if mode == "auto" {
+			if uidMap != "" {
+				cmd.flags = append(cmd.flags, "--userns-uid-map", uidMap)
+			}
+			if gidMap != "" {
+				cmd.flags = append(cmd.flags, "--userns-gid-map", gidMap)
+			}
+		}
+	}
+}
+
+// WithLayers keeps the intermediate layers of the build for reuse
+func WithLayers() BuildOption {
+	return func(cmd *buildCommand) {
+		if !cmd.layers {
+			cmd.layers = true
+			cmd.flags = append(cmd.flags, "--layers")
+		}
+	}
+}
+
+// WithBuildCache reuses the layers cached in dir and mounts it at
+// BuildCacheMountPath, ignored when empty
+func WithBuildCache(dir string) BuildOption {
+	return func(cmd *buildCommand) {
+		if dir == "" {
+			return
+		}
+		WithLayers()(cmd)
+		cmd.flags = append(cmd.flags,
+			"--cache-from", "file://"+dir,
+			"--volume", fmt.Sprintf("%s:%s:O", dir, BuildCacheMountPath))
+	}
+}
+
+// WithIIDFile writes the ID of the built image to path, ignored when empty
+func WithIIDFile(path string) BuildOption {
+	return withFlag("--iidfile", path)
+}
+
+// WithTimestamp sets the creation time of the image and the timestamps of its
+// layers to t, in seconds since the epoch
+func WithTimestamp(t time.Time) BuildOption {
+	return func(cmd *buildCommand) {
+		cmd.flags = append(cmd.flags, "--timestamp", strconv.FormatInt(t.Unix(), 10))
+	}
+}
+
+// WithHermetic runs the build without network, mounting the prefetched
+// dependencies at prefetchPath, if any, and setting env for the build steps
+// in a stable order
+func WithHermetic(prefetchPath string, readOnly bool, env map[string]string) BuildOption {
+	return func(cmd *buildCommand) {
+		if prefetchPath != "" {
+			options := "Z"
+			if readOnly {
+				options += ",ro"
+			}
+			cmd.flags = append(cmd.flags, "--volume", fmt.Sprintf("%s:/tmp/cachi2:%s", prefetchPath, options))
+		}
+
+		keys := make([]string, 0, len(env))
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			cmd.flags = append(cmd.flags, "--env", fmt.Sprintf("%s=%s", key, env[key]))
+		}
+		cmd.flags = append(cmd.flags, "--network=none")
+	}
+}
+
+// WithFlags passes flags verbatim, for the flags without a dedicated option
+func WithFlags(flags ...string) BuildOption {
+	return func(cmd *buildCommand) {
+		cmd.flags = append(cmd.flags, flags...)
+	}
+}
+
+// WithLabel sets the key=value label on the image
+func WithLabel(key, value string) BuildOption {
+	return func(cmd *buildCommand) {
+		cmd.labels = append(cmd.labels, "--label", fmt.Sprintf("%s=%s", key, value))
+	}
+}
+
+// WithLabels sets the labels on the image in a stable order
+func WithLabels(labels map[string]string) BuildOption {
+	return func(cmd *buildCommand) {
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			WithLabel(key, labels[key])(cmd)
+		}
+	}
+}
+
+// withFlag passes flag with value, ignored when value is empty
+func withFlag(flag, value string) BuildOption {
+	return func(cmd *buildCommand) {
+		if value != "" {
+			cmd.flags = append(cmd.flags, flag, value)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package image
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewBuildCommand", func() {
+	It("should build a minimal command", func() {
+		Expect(NewBuildCommand("quay.io/test/image:tag", "ctx")).To(Equal([]string{
+			"build", "--tag", "quay.io/test/image:tag", "ctx",
+		}))
+	})
+
+	It("should keep the order of the flags and put the labels after them", func() {
+		args := NewBuildCommand("quay.io/test/image:tag", ".",
+			WithLabel("version", "1.0"),
+			WithDockerfile("Containerfile"),
+			WithHermetic("/prefetch", true, map[string]string{"B": "2", "A": "1"}),
+			WithPlatform("linux/arm64"),
+		)
+
+		Expect(args).To(Equal([]string{
+			"build",
+			"--file", "Containerfile",
+			"--tag", "quay.io/test/image:tag",
+			"--volume", "/prefetch:/tmp/cachi2:Z,ro",
+			"--env", "A=1",
+			"--env", "B=2",
+			"--network=none",
+			"--platform", "linux/arm64",
+			"--label", "version=1.0",
+			".",
+		}))
+	})
+
+	It("should ignore empty values", func() {
+		args := NewBuildCommand("quay.io/test/image:tag", ".",
+			WithDockerfile(""),
+			WithPlatform(""),
+			WithBuildArg(""),
+			WithBuildCache(""),
+			WithUserNS("", "0:1:1", ""),
+		)
+		Expect(args).To(Equal([]string{"build", "--tag", "quay.io/test/image:tag", "."}))
+	})
+
+	It("should pass build arguments as given, leaving their validation to BuildAndPush", func() {
+		args := NewBuildCommand("quay.io/test/image:tag", ".",
+			WithBuildArg("SAFE=1"),
+			WithBuildArg("UNSAFE=$(id)"),
+		)
+		Expect(args).To(Equal([]string{"build", "--tag", "quay.io/test/image:tag",
+			"--build-arg", "SAFE=1", "--build-arg", "UNSAFE=$(id)", "."}))
+	})
+
+	It("should pass --layers once with a build cache", func() {
+		args := NewBuildCommand("quay.io/test/image:tag", ".", WithLayers(), WithBuildCache("/cache"))
+		layers := 0
+		for _, arg := range args {
+			if arg == "--layers" {
+				layers++
+			}
+		}
+		Expect(layers).To(Equal(1))
+		Expect(args).To(ContainElements("--cache-from", "file:///cache"))
+	})
+
+	It("should put the context last and the labels after the flags for any options", func() {
+		options := []BuildOption{
+			WithDockerfile("Dockerfile"),
+			WithTLSVerify(false),
+			WithRegistriesConf("/registries.conf"),
+			WithPlatform("linux/amd64"),
+			WithBuildArg("FOO=bar"),
+			WithBuildArgsFile("/args"),
+			WithIgnoreFile(".ignore"),
+			WithUserNS("auto", "0:1:1", "0:1:1"),
+			WithBuildCache("/cache"),
+			WithIIDFile("/iid"),
+			WithHermetic("/prefetch", false, map[string]string{"A": "1"}),
+			WithFlags("--squash"),
+			WithLabel("a", "1"),
+			WithLabels(map[string]string{"b": "2", "c": "3"}),
+		}
+
+		random := rand.New(rand.NewSource(GinkgoRandomSeed()))
+		for combination := 0; combination < 1<<len(options); combination++ {
+			var opts []BuildOption
+			for i, opt := range options {
+				if combination&(1<<i) != 0 {
+					opts = append(opts, opt)
+				}
+			}
+			random.Shuffle(len(opts), func(i, j int) { opts[i], opts[j] = opts[j], opts[i] })
+
+			args := NewBuildCommand("quay.io/test/image:tag", "ctx", opts...)
+			description := fmt.Sprintf("options %b: %v", combination, args)
+
+			Expect(args[0]).To(Equal("build"), description)
+			Expect(args[len(args)-1]).To(Equal("ctx"), description)
+			Expect(slices.Index(args, "ctx")).To(Equal(len(args)-1), description)
+
+			tag := slices.Index(args, "--tag")
+			Expect(args[tag+1]).To(Equal("quay.io/test/image:tag"), description)
+			if file := slices.Index(args, "--file"); file >= 0 {
+				Expect(file).To(BeNumerically("<", tag), description)
+			}
+
+			firstLabel := slices.Index(args, "--label")
+			if firstLabel < 0 {
+				continue
+			}
+			for i := firstLabel; i < len(args)-1; i += 2 {
+				Expect(args[i]).To(Equal("--label"), description)
+			}
+		}
+	})
+})
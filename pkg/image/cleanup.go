@@ -0,0 +1,123 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+)
+
+// temporaryTagMarker precedes the push time, in seconds since the epoch,
+// ending the temporary tags of pushes by digest and atomic pushes
+const temporaryTagMarker = "-tmp"
+
+// DefaultTemporaryTagPattern matches the temporary tags of pushes by digest
+// and atomic pushes, capturing their push time in the PushedGroup group
+const DefaultTemporaryTagPattern = `-tmp(?P<pushed>[0-9]{10})$`
+
+// PushedGroup names the group of a temporary tag pattern capturing the push
+// time of the tag in seconds since the epoch
+const PushedGroup = "pushed"
+
+// ValidateTemporaryTagPattern checks that pattern captures the push time of
+// the tags it matches
+func ValidateTemporaryTagPattern(pattern *regexp.Regexp) error {
+	if pattern.SubexpIndex(PushedGroup) < 0 {
+		return fmt.Errorf("pattern %q must capture the push time of the tags in a (?P<%s>...) group", pattern, PushedGroup)
+	}
+	return nil
+}
+
+// TagCleanupConfig selects the expired temporary tags of a repository
+type TagCleanupConfig struct {
+	// Repository is the repository whose tags are cleaned up
+	Repository string
+
+	// Pattern matches the temporary tags, capturing their push time in the
+	// PushedGroup group
+	Pattern *regexp.Regexp
+
+	// TTL is how long a temporary tag is kept after it was pushed
+	TTL time.Duration
+
+	// MaxDeletions bounds the number of tags deleted in one run
+	MaxDeletions int
+
+	// Keep lists tags that are never deleted, such as the tag being built
+	Keep []string
+
+	TLSVerify bool
+
+	// Deleter deletes the expired tags without deleting their manifests,
+	// which the released tags and digest references share
+	Deleter TagDeleter
+
+	// Now is the reference time tags expire against
+	Now time.Time
+}
+
+// TagCleanupResult reports the outcome of a cleanup
+type TagCleanupResult struct {
+	// Deleted lists the deleted tags
+	Deleted []string
+
+	// Failures describes the tags that couldn't be deleted
+	Failures []string
+}
+
+// CleanupExpiredTags deletes the tags matching the pattern pushed longer than
+// the TTL ago, at most MaxDeletions of them. The push time is read from the
+// tag rather than from the image, whose creation time may be fixed for
+// reproducible builds. Only failing to list the tags is an error; failures on
+// single tags are reported in the result.
+func CleanupExpiredTags(ctx context.Context, config *TagCleanupConfig, runner exec.CommandRunner) (*TagCleanupResult, error) {
+	if err := ValidateTemporaryTagPattern(config.Pattern); err != nil {
+		return nil, err
+	}
+	if config.Deleter == nil {
+		return nil, fmt.Errorf("cleaning up temporary tags requires a tag deletion API")
+	}
+
+	tags, err := ListImageTags(ctx, config.Repository, config.TLSVerify, runner)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool, len(config.Keep))
+	for _, tag := range config.Keep {
+		keep[tag] = true
+	}
+
+	result := &TagCleanupResult{}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if len(result.Deleted) >= config.MaxDeletions {
+			break
+		}
+		match := config.Pattern.FindStringSubmatch(tag)
+		if keep[tag] || match == nil {
+			continue
+		}
+
+		pushed, err := strconv.ParseInt(match[config.Pattern.SubexpIndex(PushedGroup)], 10, 64)
+		if err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("invalid push time in temporary tag %s: %v", tag, err))
+			continue
+		}
+		if config.Now.Sub(time.Unix(pushed, 0)) < config.TTL {
+			continue
+		}
+
+		if err := config.Deleter.DeleteTag(ctx, config.Repository, tag); err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("failed to delete temporary tag %s: %v", tag, err))
+			continue
+		}
+		result.Deleted = append(result.Deleted, tag)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,133 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CleanupExpiredTags", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		deleter    *recordingTagDeleter
+		config     *TagCleanupConfig
+		now        time.Time
+		expired    string
+		recent     string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		deleter = &recordingTagDeleter{}
+		now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		config = &TagCleanupConfig{
+			Repository:   "quay.io/test/image",
+			Pattern:      regexp.MustCompile(DefaultTemporaryTagPattern),
+			TTL:          24 * time.Hour,
+			MaxDeletions: 10,
+			TLSVerify:    true,
+			Deleter:      deleter,
+			Now:          now,
+		}
+
+		buildConfig := &BuildConfig{ImageURL: "quay.io/test/image:v1", CommitSHA: "abc123def456", RunID: "run-1"}
+		expired = Tag(RunTemporaryReference(buildConfig, now.Add(-48*time.Hour)))
+		recent = Tag(TemporaryReference(buildConfig, now.Add(-time.Hour)))
+		mockRunner.SetOutput("skopeo",
+			[]byte(fmt.Sprintf(`{"Tags":["latest","v1.0.0","v1-run-1","%s","%s"]}`, expired, recent)),
+			"list-tags", "docker://quay.io/test/image")
+	})
+
+	It("should delete only the temporary tags pushed longer than the TTL ago", func() {
+		result, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expired).To(Equal("v1-run-1-tmp1717070400"))
+		Expect(result.Deleted).To(Equal([]string{expired}))
+		Expect(result.Failures).To(BeEmpty())
+		Expect(deleter.deleted).To(Equal([]string{"quay.io/test/image:" + expired}))
+	})
+
+	It("should never delete manifests nor read the age of the images", func() {
+		_, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mockRunner.GetExecutedCommands()).To(Equal([][]string{{"skopeo", "list-tags", "docker://quay.io/test/image"}}))
+	})
+
+	It("should match the temporary tags of both pushes", func() {
+		config.TTL = 0
+
+		result, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Deleted).To(ConsistOf(expired, recent))
+	})
+
+	It("should match a custom pattern and never delete kept tags", func() {
+		config.Pattern = regexp.MustCompile(`^(latest|v1\.0\.0)-(?P<pushed>[0-9]+)$`)
+		config.Keep = []string{"latest-1"}
+		mockRunner.SetOutput("skopeo", []byte(`{"Tags":["latest-1","v1.0.0-1"]}`), "list-tags", "docker://quay.io/test/image")
+
+		result, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Deleted).To(Equal([]string{"v1.0.0-1"}))
+	})
+
+	It("should reject a pattern not capturing the push time", func() {
+		config.Pattern = regexp.MustCompile(`^tmp-`)
+
+		_, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("must capture the push time of the tags in a (?P<pushed>...) group")))
+		Expect(mockRunner.GetExecutedCommands()).To(BeEmpty())
+	})
+
+	It("should require a tag deleter", func() {
+		config.Deleter = nil
+
+		_, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).To(MatchError("cleaning up temporary tags requires a tag deletion API"))
+	})
+
+	It("should stop after the maximum number of deletions", func() {
+		config.MaxDeletions = 1
+		config.TTL = 0
+
+		result, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Deleted).To(HaveLen(1))
+	})
+
+	It("should report failures on single tags and carry on", func() {
+		config.TTL = 0
+		deleter.err = errors.New("unauthorized")
+		deleter.failing = []string{expired}
+
+		result, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Deleted).To(Equal([]string{recent}))
+		Expect(result.Failures).To(ConsistOf(ContainSubstring("failed to delete temporary tag " + expired)))
+	})
+
+	It("should fail when the tags can't be listed", func() {
+		mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "not found"},
+			"list-tags", "docker://quay.io/test/image")
+
+		_, err := CleanupExpiredTags(ctx, config, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to list tags of quay.io/test/image")))
+	})
+})
@@ -4,81 +4,113 @@ import (
 	"fmt"
 	"strings"
 	"time"
-)
-
-// BuildahBuildCommand builds the buildah build command arguments
-func BuildahBuildCommand(config *BuildConfig) []string {
-	args := []string{"build"}
 
-	// Add dockerfile path
-	args = append(args, "--file", config.Dockerfile)
+	"github.com/konflux-ci/monolithic-builder/pkg/duration"
+)
 
-	// Add image tag
-	args = append(args, "--tag", config.ImageURL)
+// BuildCacheMountPath is where the build cache is mounted in the build containers
+const BuildCacheMountPath = "/var/cache/buildah"
 
-	// Configure TLS verification
-	if !config.TLSVerify {
-		args = append(args, "--tls-verify=false")
+// BuildahBuildCommand builds the buildah build command arguments of config
+// with NewBuildCommand. It fails when a build argument could inject commands
+// into the unshare shell or ImageExpiresAfter isn't a valid duration.
+func BuildahBuildCommand(config *BuildConfig) ([]string, error) {
+	if err := ValidateBuildArgs(config.BuildArgs); err != nil {
+		return nil, err
 	}
 
-	// Add custom build arguments
+	opts := []BuildOption{
+		WithDockerfile(config.Dockerfile),
+		WithTLSVerify(config.TLSVerify),
+		WithRegistriesConf(config.RegistriesConf),
+		WithPlatform(config.Platform),
+	}
 	for _, arg := range config.BuildArgs {
-		if arg != "" {
-			args = append(args, "--build-arg", arg)
-		}
+		opts = append(opts, WithBuildArg(arg))
 	}
+	opts = append(opts,
+		WithBuildArgsFile(config.BuildArgsFile),
+		WithIgnoreFile(config.IgnoreFile),
+		WithUserNS(config.UserNS, config.UserNSUIDMap, config.UserNSGIDMap),
+	)
 
-	// Add build args file if specified
-	if config.BuildArgsFile != "" {
-		args = append(args, "--build-arg-file", config.BuildArgsFile)
+	// Reuse the layers of previous builds kept in the layer or build cache
+	if config.LayerCacheDir != "" {
+		opts = append(opts, WithLayers())
+	}
+	opts = append(opts, WithBuildCache(config.BuildCacheDir), WithIIDFile(config.IIDFile))
+	if config.Timestamp != nil {
+		opts = append(opts, WithTimestamp(*config.Timestamp))
 	}
 
-	// Configure hermetic build
+	// A hermetic build has no network, otherwise the network mode applies
 	if config.Hermetic && config.PrefetchInput != "" {
-		// Add hermetic build configuration
-		if config.PrefetchPath != "" {
-			args = append(args, "--volume", fmt.Sprintf("%s:/tmp/cachi2:Z", config.PrefetchPath))
-		}
-		args = append(args, "--network=none")
+		opts = append(opts, WithHermetic(config.PrefetchPath, config.ReadOnlyVolumes, config.PrefetchEnv))
+	} else {
+		opts = append(opts, WithFlags(networkArgs(config)...))
 	}
 
-	// Add commit SHA as label
 	if config.CommitSHA != "" {
-		args = append(args, "--label", fmt.Sprintf("io.konflux.commit=%s", config.CommitSHA))
+		opts = append(opts, WithLabel("io.konflux.commit", config.CommitSHA))
 	}
+	opts = append(opts, WithLabels(config.Labels))
 
-	// Add expiration label if specified
 	if config.ImageExpiresAfter != "" {
-		expirationTime := time.Now().Add(parseDuration(config.ImageExpiresAfter))
-		args = append(args, "--label", fmt.Sprintf("quay.expires-after=%s", expirationTime.Format(time.RFC3339)))
+		expiresAfter, err := duration.ParseExtended(config.ImageExpiresAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image expiration: %w", err)
+		}
+		expirationTime := time.Now().Add(expiresAfter)
+		opts = append(opts, WithLabel("quay.expires-after", expirationTime.Format(time.RFC3339)))
 	}
 
-	// Add build context as the LAST argument (buildah build expects: buildah build [flags] context)
-	args = append(args, ".")
-
-	return args
+	// buildah build expects the build context last: buildah build [flags] context
+	return config.buildahArgs(NewBuildCommand(config.ImageURL, ".", opts...)), nil
 }
 
 // UnshareCommand wraps a buildah command with unshare for rootless execution
-func UnshareCommand(buildahArgs []string, context string) []string {
+func UnshareCommand(buildahArgs []string, context string, maps IDMaps) []string {
+	return UnshareCommandWithEnv(buildahArgs, context, nil, maps)
+}
+
+// UnshareCommandWithEnv wraps a buildah command with unshare, setting the
+// KEY=value environment variables for buildah only
+func UnshareCommandWithEnv(buildahArgs []string, context string, env []string, maps IDMaps) []string {
 	// Build the buildah command string like the official task does
 	buildahCmdArray := []string{"buildah"}
 	buildahCmdArray = append(buildahCmdArray, buildahArgs...)
 
-	// Use printf to properly quote the command like the official task
+	// Every word is single-quoted, so that the shell expands nothing in it
 	var quotedArgs []string
+	for _, variable := range env {
+		key, value, _ := strings.Cut(variable, "=")
+		quotedArgs = append(quotedArgs, key+"="+shellQuote(value))
+	}
 	for _, arg := range buildahCmdArray {
-		quotedArgs = append(quotedArgs, fmt.Sprintf("%q", arg))
+		quotedArgs = append(quotedArgs, shellQuote(arg))
 	}
-	buildahCmd := strings.Join(quotedArgs, " ")
+	return UnshareScript(strings.Join(quotedArgs, " "), context, maps)
+}
 
-	// Use unshare with the same arguments as the official buildah task (UBI 10 supports these)
+// shellQuote quotes a word for a POSIX shell. Single quotes keep every
+// character literal, a single quote in s is ended, escaped and reopened.
+// Go's %q must not be used instead: the shell still expands $ and ` within
+// double quotes and doesn't understand escapes such as \t or \x00.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// UnshareScript runs a shell script with unshare for rootless execution, for
+// buildah operations such as mounts that only last within the namespace
+func UnshareScript(script string, context string, maps IDMaps) []string {
+	// Use unshare with the same arguments as the official buildah task (UBI 10 supports these),
+	// mapping the subordinate IDs of maps
 	return []string{
 		"unshare", "-Uf", "--keep-caps", "-r",
-		"--map-users", "1,1,65536",
-		"--map-groups", "1,1,65536",
+		"--map-users", orDefault(maps.Users).String(),
+		"--map-groups", orDefault(maps.Groups).String(),
 		"-w", context,
-		"--mount", "--", "sh", "-c", buildahCmd,
+		"--mount", "--", "sh", "-c", script,
 	}
 }
 
@@ -86,12 +118,30 @@ func UnshareCommand(buildahArgs []string, context string) []string {
 func BuildahPushCommand(config *BuildConfig) []string {
 	args := []string{"push"}
 
-	if !config.TLSVerify {
+	if !config.tlsVerify() {
 		args = append(args, "--tls-verify=false")
 	}
 
 	args = append(args, config.ImageURL)
-	return args
+	return config.buildahArgs(args)
+}
+
+// buildahPushToCommand builds the buildah push command arguments for pushing
+// the locally tagged image to destination, writing the pushed digest to digestFile
+func buildahPushToCommand(config *BuildConfig, destination, digestFile string) []string {
+	args := []string{"push"}
+
+	if !config.tlsVerify() {
+		args = append(args, "--tls-verify=false")
+	}
+
+	args = append(args, "--digestfile", digestFile, config.ImageURL, "docker://"+destination)
+	return config.buildahArgs(args)
+}
+
+// BuildahInspectCommand builds the buildah inspect command arguments for a local image
+func BuildahInspectCommand(imageURL string) []string {
+	return []string{"inspect", "--type", "image", imageURL}
 }
 
 // SkopeoInspectCommand builds the skopeo inspect command arguments
@@ -118,41 +168,40 @@ func SkopeoExistsCommand(imageURL string, tlsVerify bool) []string {
 	return args
 }
 
-// parseDuration parses duration strings like "1h", "2d", "3w"
-func parseDuration(duration string) time.Duration {
-	if duration == "" {
-		return 0
-	}
+// SkopeoListTagsCommand builds the skopeo list-tags command arguments for a repository
+func SkopeoListTagsCommand(registryURL string, tlsVerify bool) []string {
+	args := []string{"list-tags"}
 
-	// Simple parsing for common formats
-	if strings.HasSuffix(duration, "h") {
-		if hours, err := time.ParseDuration(duration); err == nil {
-			return hours
-		}
-	}
-	if strings.HasSuffix(duration, "d") {
-		if len(duration) > 1 {
-			if days := duration[:len(duration)-1]; days != "" {
-				if d, err := time.ParseDuration(days + "h"); err == nil {
-					return d * 24
-				}
-			}
-		}
+	if !tlsVerify {
+		args = append(args, "--tls-verify=false")
 	}
-	if strings.HasSuffix(duration, "w") {
-		if len(duration) > 1 {
-			if weeks := duration[:len(duration)-1]; weeks != "" {
-				if w, err := time.ParseDuration(weeks + "h"); err == nil {
-					return w * 24 * 7
-				}
-			}
-		}
+
+	args = append(args, "docker://"+registryURL)
+	return args
+}
+
+// SkopeoCopyCommand builds the skopeo copy command arguments for copying an
+// image between registry references without changing its digest. Every child
+// of an index is copied, which keeping the index digest requires.
+func SkopeoCopyCommand(source, destination string, tlsVerify bool) []string {
+	args := []string{"copy", "--all", "--preserve-digests"}
+
+	if !tlsVerify {
+		args = append(args, "--src-tls-verify=false", "--dest-tls-verify=false")
 	}
 
-	// Fallback to standard time.ParseDuration
-	if d, err := time.ParseDuration(duration); err == nil {
-		return d
+	args = append(args, "docker://"+source, "docker://"+destination)
+	return args
+}
+
+// SkopeoDeleteCommand builds the skopeo delete command arguments
+func SkopeoDeleteCommand(imageURL string, tlsVerify bool) []string {
+	args := []string{"delete"}
+
+	if !tlsVerify {
+		args = append(args, "--tls-verify=false")
 	}
 
-	return 0
+	args = append(args, "docker://"+imageURL)
+	return args
 }
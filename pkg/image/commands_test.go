@@ -1,7 +1,12 @@
 package image
 
 import (
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -17,7 +22,8 @@ var _ = Describe("BuildahBuildCommand", func() {
 				BuildArgs:  []string{},
 			}
 
-			result := BuildahBuildCommand(config)
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
 
 			Expect(result).To(Equal([]string{
 				"build",
@@ -35,7 +41,8 @@ var _ = Describe("BuildahBuildCommand", func() {
 				BuildArgs:  []string{"GO_VERSION=1.21", "DEBUG=true"},
 			}
 
-			result := BuildahBuildCommand(config)
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
 
 			Expect(result).To(Equal([]string{
 				"build",
@@ -47,6 +54,173 @@ var _ = Describe("BuildahBuildCommand", func() {
 			}))
 		})
 
+		It("should write the image ID to the iidfile when set", func() {
+			config := &BuildConfig{
+				ImageURL:   "quay.io/test/image:tag",
+				Dockerfile: "./Dockerfile",
+				TLSVerify:  true,
+				IIDFile:    "/tmp/iid",
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(Equal([]string{
+				"build",
+				"--file", "./Dockerfile",
+				"--tag", "quay.io/test/image:tag",
+				"--iidfile", "/tmp/iid",
+				".",
+			}))
+		})
+
+		It("should fix the image creation time when a timestamp is set", func() {
+			timestamp := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+			config := &BuildConfig{
+				ImageURL:   "quay.io/test/image:tag",
+				Dockerfile: "./Dockerfile",
+				TLSVerify:  true,
+				Timestamp:  &timestamp,
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(Equal([]string{
+				"build",
+				"--file", "./Dockerfile",
+				"--tag", "quay.io/test/image:tag",
+				"--timestamp", "1709296200",
+				".",
+			}))
+		})
+
+		It("should not pass --timestamp without a timestamp", func() {
+			config := &BuildConfig{
+				ImageURL:   "quay.io/test/image:tag",
+				Dockerfile: "./Dockerfile",
+				TLSVerify:  true,
+				Timestamp:  nil,
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).NotTo(ContainElement("--timestamp"))
+		})
+
+		It("should pass a custom ignore file when set", func() {
+			config := &BuildConfig{
+				ImageURL:   "quay.io/test/image:tag",
+				Dockerfile: "./Dockerfile",
+				TLSVerify:  true,
+				IgnoreFile: "build.ignore",
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(Equal([]string{
+				"build",
+				"--file", "./Dockerfile",
+				"--tag", "quay.io/test/image:tag",
+				"--ignorefile", "build.ignore",
+				".",
+			}))
+		})
+
+		It("should not pass an ignore file by default", func() {
+			config := &BuildConfig{
+				ImageURL:   "quay.io/test/image:tag",
+				Dockerfile: "./Dockerfile",
+				TLSVerify:  true,
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).NotTo(ContainElement("--ignorefile"))
+		})
+
+		It("should build for the requested platform", func() {
+			config := &BuildConfig{
+				ImageURL:   "quay.io/test/image:tag",
+				Dockerfile: "./Dockerfile",
+				TLSVerify:  true,
+				Platform:   "linux/arm64",
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(Equal([]string{
+				"build",
+				"--file", "./Dockerfile",
+				"--tag", "quay.io/test/image:tag",
+				"--platform", "linux/arm64",
+				".",
+			}))
+		})
+
+		It("should set the user namespace when configured", func() {
+			config := &BuildConfig{
+				ImageURL:     "quay.io/test/image:tag",
+				Dockerfile:   "./Dockerfile",
+				TLSVerify:    true,
+				UserNS:       "keep-id",
+				UserNSUIDMap: "0:1:65536",
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(Equal([]string{
+				"build",
+				"--file", "./Dockerfile",
+				"--tag", "quay.io/test/image:tag",
+				"--userns=keep-id",
+				".",
+			}))
+		})
+
+		It("should add the UID and GID maps to an automatic user namespace", func() {
+			config := &BuildConfig{
+				ImageURL:     "quay.io/test/image:tag",
+				Dockerfile:   "./Dockerfile",
+				TLSVerify:    true,
+				UserNS:       "auto",
+				UserNSUIDMap: "0:1:65536",
+				UserNSGIDMap: "0:1:65536",
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(Equal([]string{
+				"build",
+				"--file", "./Dockerfile",
+				"--tag", "quay.io/test/image:tag",
+				"--userns=auto",
+				"--userns-uid-map", "0:1:65536",
+				"--userns-gid-map", "0:1:65536",
+				".",
+			}))
+		})
+
+		It("should reject unsafe build arguments", func() {
+			config := &BuildConfig{
+				ImageURL:   "quay.io/test/image:tag",
+				Dockerfile: "./Dockerfile",
+				TLSVerify:  true,
+				BuildArgs:  []string{"SAFE=1", "EVIL=$(id)"},
+			}
+
+			result, err := BuildahBuildCommand(config)
+
+			Expect(err).To(MatchError(ContainSubstring("EVIL")))
+			Expect(result).To(BeNil())
+		})
+
 		It("should disable TLS verification when configured", func() {
 			config := &BuildConfig{
 				ImageURL:   "quay.io/test/image:tag",
@@ -55,7 +229,8 @@ var _ = Describe("BuildahBuildCommand", func() {
 				BuildArgs:  []string{"KEY=value"},
 			}
 
-			result := BuildahBuildCommand(config)
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
 
 			Expect(result).To(ContainElements(
 				"build",
@@ -76,7 +251,8 @@ var _ = Describe("BuildahBuildCommand", func() {
 				BuildArgs:  []string{},
 			}
 
-			result := BuildahBuildCommand(config)
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
 
 			Expect(result).To(ContainElements(
 				"build",
@@ -88,6 +264,51 @@ var _ = Describe("BuildahBuildCommand", func() {
 		})
 	})
 
+	Context("when a build cache is configured", func() {
+		It("should mount the cache and use it as cache source of a layered build", func() {
+			config := &BuildConfig{
+				ImageURL:      "quay.io/test/image:tag",
+				Dockerfile:    "./Dockerfile",
+				TLSVerify:     true,
+				BuildCacheDir: "/workspace/build-cache",
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(Equal([]string{
+				"build",
+				"--file", "./Dockerfile",
+				"--tag", "quay.io/test/image:tag",
+				"--layers",
+				"--cache-from", "file:///workspace/build-cache",
+				"--volume", "/workspace/build-cache:/var/cache/buildah:O",
+				".",
+			}))
+		})
+
+		It("should pass --layers once together with the layer cache", func() {
+			config := &BuildConfig{
+				ImageURL:      "quay.io/test/image:tag",
+				Dockerfile:    "./Dockerfile",
+				TLSVerify:     true,
+				LayerCacheDir: "/workspace/layers",
+				BuildCacheDir: "/workspace/build-cache",
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			layers := 0
+			for _, arg := range result {
+				if arg == "--layers" {
+					layers++
+				}
+			}
+			Expect(layers).To(Equal(1))
+		})
+	})
+
 	Context("when configuring hermetic builds", func() {
 		It("should add network isolation and volume mounts for hermetic builds", func() {
 			config := &BuildConfig{
@@ -100,11 +321,54 @@ var _ = Describe("BuildahBuildCommand", func() {
 				BuildArgs:     []string{},
 			}
 
-			result := BuildahBuildCommand(config)
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
 
 			Expect(result).To(ContainElement("--network=none"))
 			Expect(result).To(ContainElement("--volume"))
 		})
+
+		It("should pass the prefetch environment as --env flags", func() {
+			config := &BuildConfig{
+				ImageURL:      "quay.io/test/image:tag",
+				Dockerfile:    "./Dockerfile",
+				Hermetic:      true,
+				PrefetchInput: "gomod",
+				PrefetchPath:  "/workspace/cachi2",
+				TLSVerify:     true,
+				PrefetchEnv: map[string]string{
+					"PIP_FIND_LINKS": "/cachi2/output/deps/pip",
+					"GOFLAGS":        "-mod=mod -modcacherw",
+				},
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(ContainElements(
+				"--env", "GOFLAGS=-mod=mod -modcacherw",
+				"--env", "PIP_FIND_LINKS=/cachi2/output/deps/pip",
+			))
+			Expect(strings.Join(result, " ")).To(ContainSubstring(
+				"--env GOFLAGS=-mod=mod -modcacherw --env PIP_FIND_LINKS=/cachi2/output/deps/pip"))
+		})
+
+		It("should mount the prefetch volume read-only for read-only workspaces", func() {
+			config := &BuildConfig{
+				ImageURL:        "quay.io/test/image:tag",
+				Dockerfile:      "./Dockerfile",
+				TLSVerify:       true,
+				Hermetic:        true,
+				PrefetchInput:   "input.json",
+				PrefetchPath:    "/workspace/cachi2",
+				ReadOnlyVolumes: true,
+			}
+
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result).To(ContainElement("/workspace/cachi2:/tmp/cachi2:Z,ro"))
+		})
 	})
 
 	Context("when handling expiration labels", func() {
@@ -117,7 +381,8 @@ var _ = Describe("BuildahBuildCommand", func() {
 				BuildArgs:         []string{},
 			}
 
-			result := BuildahBuildCommand(config)
+			result, err := BuildahBuildCommand(config)
+			Expect(err).NotTo(HaveOccurred())
 
 			Expect(result).To(ContainElement("--label"))
 			// Find the expiration label
@@ -131,6 +396,80 @@ var _ = Describe("BuildahBuildCommand", func() {
 			}
 			Expect(expirationLabel).To(HavePrefix("quay.expires-after="))
 		})
+
+		It("should fail on an invalid expiration", func() {
+			config := &BuildConfig{
+				ImageURL:          "quay.io/test/image:tag",
+				Dockerfile:        "./Dockerfile",
+				ImageExpiresAfter: "2 weeks",
+			}
+
+			_, err := BuildahBuildCommand(config)
+
+			Expect(err).To(MatchError(ContainSubstring(`invalid image expiration: invalid duration "2 weeks"`)))
+		})
+	})
+})
+
+var _ = Describe("ValidateBuildArgs", func() {
+	DescribeTable("should accept clean build arguments",
+		func(args []string) {
+			Expect(ValidateBuildArgs(args)).To(Succeed())
+		},
+		Entry("no arguments", nil),
+		Entry("plain values", []string{"GO_VERSION=1.21", "DEBUG=true"}),
+		Entry("values with spaces and symbols", []string{"LABEL=value with spaces", "URL=https://example.com/?a=b&c=d"}),
+		Entry("dollar without parenthesis", []string{"PRICE=$5"}),
+		Entry("argument without value", []string{"FLAG"}),
+	)
+
+	DescribeTable("should reject shell injection characters in values",
+		func(arg, denied string) {
+			err := ValidateBuildArgs([]string{"SAFE=1", arg})
+
+			Expect(err).To(MatchError(ContainSubstring(denied)))
+		},
+		Entry("command substitution", "CMD=$(rm -rf /)", `"$("`),
+		Entry("backticks", "CMD=`id`", "\"`\""),
+		Entry("command separator", "CMD=1; rm -rf /", `";"`),
+		Entry("newline", "CMD=1\nrm -rf /", `"\n"`),
+	)
+})
+
+var _ = Describe("BuildArgsUsed", func() {
+	It("should list the command line arguments", func() {
+		Expect(BuildArgsUsed(&BuildConfig{BuildArgs: []string{"GO_VERSION=1.21", "", "FLAG"}})).To(Equal([]string{"GO_VERSION=1.21", "FLAG"}))
+	})
+
+	It("should read the file first and let the command line override it", func() {
+		argsFile := filepath.Join(GinkgoT().TempDir(), "build-args")
+		Expect(os.WriteFile(argsFile, []byte("# versions\nGO_VERSION=1.20\n\n  NODE_VERSION=20  \n"), 0644)).To(Succeed())
+
+		used, err := BuildArgsUsed(&BuildConfig{
+			BuildArgsFile: argsFile,
+			BuildArgs:     []string{"DEBUG=true", "GO_VERSION=1.21"},
+		})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(used).To(Equal([]string{"GO_VERSION=1.21", "NODE_VERSION=20", "DEBUG=true"}))
+	})
+
+	It("should fail when the file can't be read", func() {
+		_, err := BuildArgsUsed(&BuildConfig{BuildArgsFile: filepath.Join(GinkgoT().TempDir(), "missing")})
+
+		Expect(err).To(MatchError(ContainSubstring("failed to read build args file")))
+	})
+})
+
+var _ = Describe("validatePrefetchEnv", func() {
+	It("should accept values with spaces", func() {
+		Expect(validatePrefetchEnv(map[string]string{"GOFLAGS": "-mod=mod -modcacherw"})).To(Succeed())
+	})
+
+	It("should reject shell injection characters in values", func() {
+		err := validatePrefetchEnv(map[string]string{"GOFLAGS": "$(id)"})
+
+		Expect(err).To(MatchError(`prefetch environment variable GOFLAGS contains disallowed sequence "$("`))
 	})
 })
 
@@ -139,7 +478,7 @@ var _ = Describe("UnshareCommand", func() {
 		buildahArgs := []string{"build", "--tag", "test:tag", "."}
 		context := "/workspace/source"
 
-		result := UnshareCommand(buildahArgs, context)
+		result := UnshareCommand(buildahArgs, context, IDMaps{})
 
 		Expect(result).To(Equal([]string{
 			"unshare", "-Uf", "--keep-caps", "-r",
@@ -147,7 +486,7 @@ var _ = Describe("UnshareCommand", func() {
 			"--map-groups", "1,1,65536",
 			"-w", "/workspace/source",
 			"--mount", "--", "sh", "-c",
-			`"buildah" "build" "--tag" "test:tag" "."`,
+			`'buildah' 'build' '--tag' 'test:tag' '.'`,
 		}))
 	})
 
@@ -157,12 +496,28 @@ var _ = Describe("UnshareCommand", func() {
 		}
 		context := "/workspace/source"
 
-		result := UnshareCommand(buildahArgs, context)
+		result := UnshareCommand(buildahArgs, context, IDMaps{})
 
 		Expect(result).To(HaveLen(15)) // Updated to match actual length
 		Expect(result[0]).To(Equal("unshare"))
 		Expect(result[len(result)-1]).To(ContainSubstring("KEY=value with spaces"))
 	})
+
+	It("should pass every argument and variable to the shell literally", func() {
+		if _, err := osexec.LookPath("sh"); err != nil {
+			Skip("sh is not available")
+		}
+		const variable = "$HOME 'quoted' `id`"
+		args := []string{"$HOME", "${HOME}", "`id`", "$(id)", `it's`, `tab\tliteral`, `back\slash`, "new\nline", `"quoted"`, ""}
+
+		result := UnshareCommandWithEnv(args, "/workspace/source", []string{"VALUE=" + variable}, IDMaps{})
+
+		// Run a script printing the variable and the arguments in place of buildah
+		script := strings.Replace(result[len(result)-1], "'buildah'", `sh -c 'printf "%s\0" "$VALUE" "$@"' sh`, 1)
+		output, err := osexec.Command("sh", "-c", script).Output()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")).To(Equal(append([]string{variable}, args...)))
+	})
 })
 
 var _ = Describe("BuildahPushCommand", func() {
@@ -192,6 +547,111 @@ var _ = Describe("BuildahPushCommand", func() {
 				"push", "--tls-verify=false", "quay.io/test/image:tag"}))
 		})
 	})
+
+	Context("when pushing to another reference", func() {
+		It("should push the local image and write its digest", func() {
+			config := &BuildConfig{ImageURL: "quay.io/test/image:tag", TLSVerify: true}
+
+			result := buildahPushToCommand(config, "quay.io/test/image:tmp-push-run-1", "/tmp/digest")
+
+			Expect(result).To(Equal([]string{
+				"push", "--digestfile", "/tmp/digest", "quay.io/test/image:tag", "docker://quay.io/test/image:tmp-push-run-1"}))
+		})
+	})
+})
+
+var _ = Describe("Repository", func() {
+	DescribeTable("should strip tags and digests",
+		func(input, expected string) {
+			Expect(Repository(input)).To(Equal(expected))
+		},
+		Entry("tagged", "quay.io/test/image:tag", "quay.io/test/image"),
+		Entry("untagged", "quay.io/test/image", "quay.io/test/image"),
+		Entry("digest", "quay.io/test/image@sha256:abc", "quay.io/test/image"),
+		Entry("tag and digest", "quay.io/test/image:tag@sha256:abc", "quay.io/test/image"),
+		Entry("registry port", "localhost:5000/image:tag", "localhost:5000/image"),
+		Entry("registry port without tag", "localhost:5000/image", "localhost:5000/image"),
+	)
+})
+
+var _ = Describe("TemporaryReference", func() {
+	pushed := time.Unix(1717243200, 0)
+
+	DescribeTable("should suffix the commit with the run ID and the push time",
+		func(commit, runID, expected string) {
+			config := &BuildConfig{ImageURL: "quay.io/test/image:tag", CommitSHA: commit, RunID: runID}
+			Expect(TemporaryReference(config, pushed)).To(Equal(expected))
+		},
+		Entry("commit", "0123456789abcdef", "run-1", "quay.io/test/image:tmp-push-0123456789ab-run-1-tmp1717243200"),
+		Entry("short commit", "abc", "run-1", "quay.io/test/image:tmp-push-abc-run-1-tmp1717243200"),
+		Entry("no commit", "", "run-1", "quay.io/test/image:tmp-push-run-1-tmp1717243200"),
+	)
+
+	It("should keep concurrent builds of the same commit apart", func() {
+		first := TemporaryReference(&BuildConfig{ImageURL: "quay.io/test/image", CommitSHA: "0123456789abcdef", RunID: "run-1"}, pushed)
+		second := TemporaryReference(&BuildConfig{ImageURL: "quay.io/test/image", CommitSHA: "0123456789abcdef", RunID: "run-2"}, pushed)
+		Expect(first).NotTo(Equal(second))
+	})
+
+	It("should be matched by the default temporary tag pattern", func() {
+		tag := Tag(TemporaryReference(&BuildConfig{ImageURL: "quay.io/test/image", CommitSHA: "0123456789abcdef"}, pushed))
+		Expect(regexp.MustCompile(DefaultTemporaryTagPattern).FindStringSubmatch(tag)).To(ContainElement("1717243200"))
+	})
+})
+
+var _ = Describe("RunTemporaryReference", func() {
+	pushed := time.Unix(1717243200, 0)
+
+	DescribeTable("should append the run ID and the push time to the tag",
+		func(imageURL, runID, expected string) {
+			Expect(RunTemporaryReference(&BuildConfig{ImageURL: imageURL, RunID: runID}, pushed)).To(Equal(expected))
+		},
+		Entry("tagged", "quay.io/test/image:v1", "run-1", "quay.io/test/image:v1-run-1-tmp1717243200"),
+		Entry("untagged", "quay.io/test/image", "run-1", "quay.io/test/image:latest-run-1-tmp1717243200"),
+		Entry("registry port", "localhost:5000/image:v1", "run-1", "localhost:5000/image:v1-run-1-tmp1717243200"),
+		Entry("invalid run ID characters", "quay.io/test/image:v1", "ns/run:1", "quay.io/test/image:v1-ns-run-1-tmp1717243200"),
+	)
+
+	It("should keep the temporary tag within the tag length limit", func() {
+		ref := RunTemporaryReference(&BuildConfig{
+			ImageURL: "quay.io/test/image:" + strings.Repeat("t", 128),
+			RunID:    strings.Repeat("r", 100),
+		}, pushed)
+
+		_, tag, _ := strings.Cut(ref, "image:")
+		Expect(tag).To(HaveLen(128))
+		Expect(tag).To(HaveSuffix("-" + strings.Repeat("r", 64) + "-tmp1717243200"))
+	})
+
+	It("should fall back to a unique suffix without a run ID", func() {
+		Expect(RunTemporaryReference(&BuildConfig{ImageURL: "quay.io/test/image:v1"}, pushed)).To(
+			MatchRegexp(`^quay\.io/test/image:v1-\d+-tmp1717243200$`))
+	})
+
+	It("should be matched by the default temporary tag pattern", func() {
+		tag := Tag(RunTemporaryReference(&BuildConfig{ImageURL: "quay.io/test/image:v1", RunID: "run-1"}, pushed))
+		Expect(regexp.MustCompile(DefaultTemporaryTagPattern).MatchString(tag)).To(BeTrue())
+		Expect(regexp.MustCompile(DefaultTemporaryTagPattern).MatchString("v1-run-1")).To(BeFalse())
+	})
+})
+
+var _ = Describe("SkopeoCopyCommand", func() {
+	It("should copy preserving digests", func() {
+		Expect(SkopeoCopyCommand("quay.io/test/image@sha256:abc", "quay.io/test/image:v1", true)).To(Equal([]string{
+			"copy", "--all", "--preserve-digests",
+			"docker://quay.io/test/image@sha256:abc",
+			"docker://quay.io/test/image:v1",
+		}))
+	})
+
+	It("should disable TLS verification on both ends", func() {
+		Expect(SkopeoCopyCommand("quay.io/test/image@sha256:abc", "quay.io/test/image:v1", false)).To(Equal([]string{
+			"copy", "--all", "--preserve-digests",
+			"--src-tls-verify=false", "--dest-tls-verify=false",
+			"docker://quay.io/test/image@sha256:abc",
+			"docker://quay.io/test/image:v1",
+		}))
+	})
 })
 
 var _ = Describe("SkopeoInspectCommand", func() {
@@ -241,3 +701,20 @@ var _ = Describe("SkopeoInspectCommand", func() {
 		})
 	})
 })
+
+var _ = Describe("SkopeoListTagsCommand", func() {
+	It("should generate list-tags command with docker:// prefix", func() {
+		Expect(SkopeoListTagsCommand("quay.io/test/image", true)).To(Equal([]string{
+			"list-tags",
+			"docker://quay.io/test/image",
+		}))
+	})
+
+	It("should generate list-tags command with TLS verification disabled", func() {
+		Expect(SkopeoListTagsCommand("quay.io/test/image", false)).To(Equal([]string{
+			"list-tags",
+			"--tls-verify=false",
+			"docker://quay.io/test/image",
+		}))
+	})
+})
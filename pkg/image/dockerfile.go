@@ -0,0 +1,383 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// LabelDockerfileDigest records the digest of the Dockerfile an image was built from
+const LabelDockerfileDigest = "io.konflux.dockerfile-digest"
+
+// Instruction is a single Dockerfile instruction with its continuation lines joined
+type Instruction struct {
+	// Line is the 1-based line number the instruction starts on
+	Line int
+
+	// Command is the upper-cased instruction keyword, e.g. FROM
+	Command string
+
+	// Args is the remainder of the instruction after the keyword
+	Args string
+}
+
+// ParseDockerfile splits a Dockerfile into instructions, skipping comments and
+// joining lines ending with a backslash. The heredoc bodies of RUN, COPY and
+// ADD instructions are skipped so they aren't mistaken for instructions.
+func ParseDockerfile(r io.Reader) ([]Instruction, error) {
+	var instructions []Instruction
+	var current strings.Builder
+	var pending []heredoc
+	startLine := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		if len(pending) > 0 {
+			if pending[0].terminatedBy(scanner.Text()) {
+				pending = pending[1:]
+			}
+			continue
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#") || (line == "" && current.Len() == 0) {
+			continue
+		}
+		if current.Len() == 0 {
+			startLine = lineNumber
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+
+		current.WriteString(line)
+		instruction := newInstruction(startLine, current.String())
+		instructions = append(instructions, instruction)
+		pending = heredocs(instruction)
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current.Len() > 0 {
+		instructions = append(instructions, newInstruction(startLine, current.String()))
+	}
+
+	return instructions, nil
+}
+
+// heredocPattern matches the <<EOF, <<-EOF and quoted heredoc markers of an instruction
+var heredocPattern = regexp.MustCompile(`<<(-?)["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+
+// heredoc is a heredoc body ending at a line holding only its delimiter
+type heredoc struct {
+	delimiter string
+
+	// stripTabs is set for <<- heredocs, whose delimiter may be indented with tabs
+	stripTabs bool
+}
+
+// terminatedBy reports whether line ends the heredoc body
+func (h heredoc) terminatedBy(line string) bool {
+	line = strings.TrimRight(line, "\r")
+	if h.stripTabs {
+		line = strings.TrimLeft(line, "\t")
+	}
+	return line == h.delimiter
+}
+
+// heredocs returns the heredocs opened by an instruction, in order
+func heredocs(instruction Instruction) []heredoc {
+	switch instruction.Command {
+	case "RUN", "COPY", "ADD":
+	default:
+		return nil
+	}
+
+	var docs []heredoc
+	for _, match := range heredocPattern.FindAllStringSubmatch(instruction.Args, -1) {
+		docs = append(docs, heredoc{delimiter: match[2], stripTabs: match[1] == "-"})
+	}
+	return docs
+}
+
+// ParseDockerfileFile parses the Dockerfile at the given path
+func ParseDockerfileFile(path string) ([]Instruction, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Dockerfile: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	return ParseDockerfile(file)
+}
+
+// DockerfileDigest returns the sha256 digest of the exact bytes of the
+// Dockerfile at the given path
+func DockerfileDigest(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// OverrideFirstFrom replaces the image of the first FROM instruction of a
+// Dockerfile with ref, keeping its flags and stage name. The instruction is
+// rewritten on a single line and the rest of the Dockerfile is left as is.
+func OverrideFirstFrom(content []byte, ref string) ([]byte, error) {
+	instructions, err := ParseDockerfile(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Dockerfile: %w", err)
+	}
+
+	var from *Instruction
+	for i := range instructions {
+		if instructions[i].Command == "FROM" {
+			from = &instructions[i]
+			break
+		}
+	}
+	if from == nil {
+		return nil, fmt.Errorf("no FROM instruction in the Dockerfile")
+	}
+
+	fields := strings.Fields(from.Args)
+	replaced := false
+	for i, field := range fields {
+		if !strings.HasPrefix(field, "--") {
+			fields[i] = ref
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		return nil, fmt.Errorf("FROM instruction on line %d has no image", from.Line)
+	}
+
+	// Drop the continuation lines of the original instruction
+	lines := strings.SplitAfter(string(content), "\n")
+	start, end := from.Line-1, from.Line-1
+	for end < len(lines)-1 && strings.HasSuffix(strings.TrimRight(lines[end], " \t\r\n"), "\\") {
+		end++
+	}
+	newline := ""
+	if strings.HasSuffix(lines[end], "\n") {
+		newline = "\n"
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Join(lines[:start], ""))
+	b.WriteString("FROM " + strings.Join(fields, " ") + newline)
+	b.WriteString(strings.Join(lines[end+1:], ""))
+	return []byte(b.String()), nil
+}
+
+func newInstruction(line int, text string) Instruction {
+	command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	return Instruction{
+		Line:    line,
+		Command: strings.ToUpper(command),
+		Args:    strings.TrimSpace(args),
+	}
+}
+
+// RemoteSource is a URL an ADD or COPY instruction fetches during the build
+type RemoteSource struct {
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+	URL     string `json:"url"`
+}
+
+// remoteSourcePrefixes start the sources that are fetched over the network
+var remoteSourcePrefixes = []string{"http://", "https://", "git://", "git@", "ssh://"}
+
+// RemoteSources returns the remote URLs used as sources by ADD and COPY
+// instructions, in the order they appear
+func RemoteSources(instructions []Instruction) []RemoteSource {
+	var remote []RemoteSource
+	for _, instruction := range instructions {
+		if instruction.Command != "ADD" && instruction.Command != "COPY" {
+			continue
+		}
+		for _, source := range instructionSources(instruction.Args) {
+			for _, prefix := range remoteSourcePrefixes {
+				if strings.HasPrefix(strings.ToLower(source), prefix) {
+					remote = append(remote, RemoteSource{
+						Line:    instruction.Line,
+						Command: instruction.Command,
+						URL:     source,
+					})
+					break
+				}
+			}
+		}
+	}
+	return remote
+}
+
+// instructionSources returns the sources of ADD or COPY arguments in shell or
+// JSON form, the last argument being the destination. Sources copied --from a
+// stage or image are never remote and aren't returned.
+func instructionSources(args string) []string {
+	rest := strings.TrimSpace(args)
+	for strings.HasPrefix(rest, "--") {
+		flag, remainder, _ := strings.Cut(rest, " ")
+		if strings.HasPrefix(flag, "--from") {
+			return nil
+		}
+		rest = strings.TrimSpace(remainder)
+	}
+
+	var fields []string
+	if strings.HasPrefix(rest, "[") {
+		if err := json.Unmarshal([]byte(rest), &fields); err != nil {
+			fields = strings.Fields(rest)
+		}
+	} else {
+		fields = strings.Fields(rest)
+	}
+	if len(fields) < 2 {
+		return nil
+	}
+	return fields[:len(fields)-1]
+}
+
+// CheckRemoteSources fails when the remote sources of a Dockerfile can't be
+// fetched: any of them in a hermetic build, whose network is cut, and those
+// not matching allowlist otherwise. A nil allowlist allows every source of a
+// non-hermetic build.
+func CheckRemoteSources(sources []RemoteSource, hermetic bool, allowlist *regexp.Regexp) error {
+	var offending []string
+	for _, source := range sources {
+		if hermetic || (allowlist != nil && !allowlist.MatchString(source.URL)) {
+			offending = append(offending, fmt.Sprintf("line %d: %s %s", source.Line, source.Command, source.URL))
+		}
+	}
+	if len(offending) == 0 {
+		return nil
+	}
+
+	if hermetic {
+		return fmt.Errorf("hermetic builds have no network to fetch remote sources, prefetch them with PREFETCH_INPUT instead: %s",
+			strings.Join(offending, "; "))
+	}
+	return fmt.Errorf("remote sources don't match the allowlist: %s", strings.Join(offending, "; "))
+}
+
+// BaseImage is an external image referenced by a FROM instruction
+type BaseImage struct {
+	// Line is the line number of the FROM instruction
+	Line int
+
+	// Original is the reference as written in the Dockerfile
+	Original string
+
+	// Reference is the reference with build arguments substituted
+	Reference string
+
+	// Resolved is false when the reference uses a build argument without a value
+	Resolved bool
+}
+
+// argPattern matches $VAR and ${VAR} build argument references
+var argPattern = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?`)
+
+// BaseImages returns the external base images of a Dockerfile. ARG defaults
+// declared before the first FROM are substituted and overridden by buildArgs
+// (KEY=value). References to earlier stages and scratch are not base images.
+func BaseImages(instructions []Instruction, buildArgs []string) []BaseImage {
+	args := make(map[string]string)
+	overrides := make(map[string]string)
+	for _, arg := range buildArgs {
+		if key, value, found := strings.Cut(arg, "="); found {
+			overrides[key] = value
+		}
+	}
+
+	stages := make(map[string]bool)
+	var bases []BaseImage
+	seenFrom := false
+	for _, instruction := range instructions {
+		switch instruction.Command {
+		case "ARG":
+			if seenFrom {
+				continue
+			}
+			key, value, _ := strings.Cut(instruction.Args, "=")
+			if override, ok := overrides[key]; ok {
+				value = override
+			}
+			args[key] = strings.Trim(value, `"'`)
+		case "FROM":
+			seenFrom = true
+			original, stage := parseFrom(instruction.Args)
+			reference, resolved := substituteArgs(original, args)
+			isStage := stages[strings.ToLower(reference)]
+			if stage != "" {
+				stages[strings.ToLower(stage)] = true
+			}
+			if isStage || strings.EqualFold(reference, "scratch") {
+				continue
+			}
+
+			bases = append(bases, BaseImage{
+				Line:      instruction.Line,
+				Original:  original,
+				Reference: reference,
+				Resolved:  resolved,
+			})
+		}
+	}
+
+	return bases
+}
+
+// parseFrom returns the image and optional stage name of FROM arguments
+func parseFrom(args string) (string, string) {
+	var fields []string
+	for _, field := range strings.Fields(args) {
+		if strings.HasPrefix(field, "--") {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return "", ""
+	}
+	if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+		return fields[0], fields[2]
+	}
+	return fields[0], ""
+}
+
+// substituteArgs replaces build argument references, reporting whether all of them had values
+func substituteArgs(reference string, args map[string]string) (string, bool) {
+	resolved := true
+	result := argPattern.ReplaceAllStringFunc(reference, func(match string) string {
+		name := argPattern.FindStringSubmatch(match)[1]
+		value, ok := args[name]
+		if !ok || value == "" {
+			resolved = false
+			return match
+		}
+		return value
+	})
+	return result, resolved
+}
@@ -0,0 +1,198 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseDockerfile", func() {
+	It("should join continuation lines and skip comments", func() {
+		instructions, err := ParseDockerfile(strings.NewReader(
+			"# syntax comment\n" +
+				"FROM quay.io/test/base:1\n" +
+				"\n" +
+				"RUN dnf install -y \\\n" +
+				"    git \\\n" +
+				"    make\n" +
+				"copy . /src\n"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instructions).To(Equal([]Instruction{
+			{Line: 2, Command: "FROM", Args: "quay.io/test/base:1"},
+			{Line: 4, Command: "RUN", Args: "dnf install -y  git  make"},
+			{Line: 7, Command: "COPY", Args: ". /src"},
+		}))
+	})
+})
+
+var _ = Describe("BaseImages", func() {
+	parse := func(content string) []Instruction {
+		instructions, err := ParseDockerfile(strings.NewReader(content))
+		Expect(err).NotTo(HaveOccurred())
+		return instructions
+	}
+
+	It("should skip stage references and scratch", func() {
+		bases := BaseImages(parse(
+			"FROM --platform=linux/amd64 quay.io/test/builder:1 AS build\n"+
+				"FROM build AS test\n"+
+				"FROM scratch\n"+
+				"FROM quay.io/test/runtime@sha256:abc\n"), nil)
+
+		Expect(bases).To(Equal([]BaseImage{
+			{Line: 1, Original: "quay.io/test/builder:1", Reference: "quay.io/test/builder:1", Resolved: true},
+			{Line: 4, Original: "quay.io/test/runtime@sha256:abc", Reference: "quay.io/test/runtime@sha256:abc", Resolved: true},
+		}))
+	})
+
+	It("should substitute ARG defaults and build argument overrides", func() {
+		bases := BaseImages(parse(
+			"ARG REGISTRY=quay.io\n"+
+				"ARG VERSION=\"1\"\n"+
+				"ARG UNSET\n"+
+				"FROM ${REGISTRY}/test/image:$VERSION\n"+
+				"FROM $UNSET\n"), []string{"VERSION=2"})
+
+		Expect(bases).To(HaveLen(2))
+		Expect(bases[0].Reference).To(Equal("quay.io/test/image:2"))
+		Expect(bases[0].Resolved).To(BeTrue())
+		Expect(bases[1].Resolved).To(BeFalse())
+	})
+})
+
+var _ = Describe("OverrideFirstFrom", func() {
+	It("should replace the image of the first FROM only", func() {
+		content, err := OverrideFirstFrom([]byte("# syntax=docker/dockerfile:1\nARG GO=1.21\nFROM golang:${GO} AS builder\nRUN make\nFROM ubi9/ubi-minimal\n"),
+			"registry.example.com/golang:1.21-patched")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("# syntax=docker/dockerfile:1\nARG GO=1.21\nFROM registry.example.com/golang:1.21-patched AS builder\nRUN make\nFROM ubi9/ubi-minimal\n"))
+	})
+
+	It("should keep the flags and join a continued instruction", func() {
+		content, err := OverrideFirstFrom([]byte("from --platform=$BUILDPLATFORM \\\n    golang:1.21 \\\n    AS builder\nRUN make"), "golang:patched")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("FROM --platform=$BUILDPLATFORM golang:patched AS builder\nRUN make"))
+	})
+
+	It("should fail without a FROM instruction", func() {
+		_, err := OverrideFirstFrom([]byte("RUN make\n"), "golang:patched")
+
+		Expect(err).To(MatchError("no FROM instruction in the Dockerfile"))
+	})
+})
+
+var _ = Describe("DockerfileDigest", func() {
+	It("should digest the exact bytes of the Dockerfile", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "Dockerfile")
+		Expect(os.WriteFile(path, []byte("FROM scratch\n"), 0644)).To(Succeed())
+
+		digest, err := DockerfileDigest(path)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(digest).To(Equal("sha256:bb57c7da220a8753d7bdabac0d3afdb6efa742e4c736c5bc93ab40dfd5e23b9b"))
+	})
+
+	It("should fail when the Dockerfile is missing", func() {
+		_, err := DockerfileDigest(filepath.Join(GinkgoT().TempDir(), "Dockerfile"))
+
+		Expect(err).To(MatchError(ContainSubstring("failed to read Dockerfile")))
+	})
+})
+
+var _ = Describe("RemoteSources", func() {
+	DescribeTable("detecting remote ADD and COPY sources",
+		func(content string, expected []RemoteSource) {
+			instructions, err := ParseDockerfile(strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(RemoteSources(instructions)).To(Equal(expected))
+		},
+		Entry("plain ADD of a URL",
+			"FROM scratch\n"+
+				"ADD https://example.com/a.tar.gz /opt/\n",
+			[]RemoteSource{{Line: 2, Command: "ADD", URL: "https://example.com/a.tar.gz"}}),
+		Entry("flags and several sources",
+			"FROM scratch\n"+
+				"ADD --chown=1001:0 --checksum=sha256:abc http://example.com/a ./local https://example.com/b /opt/\n",
+			[]RemoteSource{
+				{Line: 2, Command: "ADD", URL: "http://example.com/a"},
+				{Line: 2, Command: "ADD", URL: "https://example.com/b"},
+			}),
+		Entry("JSON form",
+			"FROM scratch\n"+
+				"ADD [\"https://example.com/a b.txt\", \"/opt/\"]\n",
+			[]RemoteSource{{Line: 2, Command: "ADD", URL: "https://example.com/a b.txt"}}),
+		Entry("git sources",
+			"FROM scratch\n"+
+				"ADD --keep-git-dir=true git@github.com:test/repo.git /src\n",
+			[]RemoteSource{{Line: 2, Command: "ADD", URL: "git@github.com:test/repo.git"}}),
+		Entry("multi-line instruction reported on its first line",
+			"FROM scratch\n"+
+				"ADD \\\n"+
+				"    https://example.com/a.tar.gz \\\n"+
+				"    /opt/\n",
+			[]RemoteSource{{Line: 2, Command: "ADD", URL: "https://example.com/a.tar.gz"}}),
+		Entry("URLs as destinations, in RUN commands and in comments",
+			"FROM scratch\n"+
+				"# ADD https://example.com/commented /opt/\n"+
+				"RUN curl -o /tmp/a https://example.com/a\n"+
+				"COPY ./https:/ /dest\n",
+			nil),
+		Entry("COPY from a stage",
+			"FROM scratch\n"+
+				"COPY --from=builder https://not-a-url /opt/\n",
+			nil),
+		Entry("heredoc bodies that look like instructions",
+			"FROM scratch\n"+
+				"RUN <<EOF\n"+
+				"ADD https://example.com/in-heredoc /opt/\n"+
+				"# not a comment \\\n"+
+				"EOF\n"+
+				"ADD https://example.com/after /opt/\n",
+			[]RemoteSource{{Line: 6, Command: "ADD", URL: "https://example.com/after"}}),
+		Entry("tab-stripped and quoted heredocs, several per instruction",
+			"FROM scratch\n"+
+				"COPY <<-'ONE' <<TWO /opt/\n"+
+				"\tADD https://example.com/one /opt/\n"+
+				"\tONE\n"+
+				"ADD https://example.com/two /opt/\n"+
+				"TWO\n"+
+				"COPY https://example.com/last /opt/\n",
+			[]RemoteSource{{Line: 7, Command: "COPY", URL: "https://example.com/last"}}),
+		Entry("shift operators that aren't heredocs",
+			"FROM scratch\n"+
+				"RUN echo $((1<<2))\n"+
+				"ADD https://example.com/a /opt/\n",
+			[]RemoteSource{{Line: 3, Command: "ADD", URL: "https://example.com/a"}}),
+	)
+})
+
+var _ = Describe("CheckRemoteSources", func() {
+	sources := []RemoteSource{
+		{Line: 2, Command: "ADD", URL: "https://github.com/test/a"},
+		{Line: 5, Command: "ADD", URL: "https://example.com/b"},
+	}
+
+	It("should reject every remote source of a hermetic build", func() {
+		err := CheckRemoteSources(sources, true, regexp.MustCompile(`.*`))
+
+		Expect(err).To(MatchError(ContainSubstring("line 2: ADD https://github.com/test/a; line 5: ADD https://example.com/b")))
+	})
+
+	It("should reject sources outside the allowlist", func() {
+		err := CheckRemoteSources(sources, false, regexp.MustCompile(`^https://github\.com/`))
+
+		Expect(err).To(MatchError("remote sources don't match the allowlist: line 5: ADD https://example.com/b"))
+	})
+
+	It("should allow every source without an allowlist", func() {
+		Expect(CheckRemoteSources(sources, false, nil)).To(Succeed())
+		Expect(CheckRemoteSources(nil, true, nil)).To(Succeed())
+	})
+})
@@ -0,0 +1,208 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// Actions taken when a file of the built image matches a deny pattern
+const (
+	FileDenyActionWarn = "warn"
+	FileDenyActionFail = "fail"
+)
+
+// maxFileMatches bounds the denied files kept in a FileManifest
+const maxFileMatches = 100
+
+// FileEntry describes a file of the built image
+type FileEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+}
+
+// FileManifest summarizes the file manifest written for a built image
+type FileManifest struct {
+	// Path is where the manifest was written, one JSON FileEntry per line
+	Path string `json:"path"`
+
+	// Digest is the sha256 digest of the manifest file
+	Digest string `json:"digest"`
+
+	// Files counts the entries of the manifest
+	Files int `json:"files"`
+
+	// Matches lists the paths matching a deny pattern, at most 100 of them,
+	// and DeniedFiles counts them all
+	Matches     []string `json:"matches"`
+	DeniedFiles int      `json:"denied_files"`
+}
+
+// ValidateFilePatterns rejects malformed deny patterns
+func ValidateFilePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchesFilePattern reports whether a path matches a deny pattern. Patterns
+// without a slash match the file name, others the whole path.
+func matchesFilePattern(pattern, filePath string) bool {
+	if strings.Contains(pattern, "/") {
+		matched, _ := path.Match(strings.TrimPrefix(pattern, "/"), filePath)
+		return matched
+	}
+	matched, _ := path.Match(pattern, path.Base(filePath))
+	return matched
+}
+
+// listImageFilesScript mounts the image in a working container and writes a
+// NUL separated "<type> <mode> <size> <path>" listing of its files. buildah
+// is the shell invocation of buildah, with its global flags.
+func listImageFilesScript(buildah, imageURL, listingPath string) string {
+	return strings.Join([]string{
+		fmt.Sprintf("ctr=$(%s from --pull=never %s) || exit 1", buildah, shellQuote(imageURL)),
+		fmt.Sprintf(`mnt=$(%[1]s mount "$ctr") || { %[1]s rm "$ctr" >/dev/null; exit 1; }`, buildah),
+		fmt.Sprintf(`find "$mnt" -mindepth 1 -printf '%%y %%m %%s %%P\0' > %s`, shellQuote(listingPath)),
+		"status=$?",
+		fmt.Sprintf(`%s rm "$ctr" >/dev/null`, buildah),
+		"exit $status",
+	}, "\n")
+}
+
+// WriteFileManifest converts a listing of the image files into the JSON lines
+// manifest written to w, matching every path against the deny patterns. Both
+// are streamed so that large images aren't held in memory.
+func WriteFileManifest(listing io.Reader, w io.Writer, denyPatterns []string) (*FileManifest, error) {
+	if err := ValidateFilePatterns(denyPatterns); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(w, hash))
+	encoder := json.NewEncoder(out)
+	manifest := &FileManifest{Matches: []string{}}
+
+	scanner := bufio.NewScanner(listing)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(splitNUL)
+	for scanner.Scan() {
+		entry, err := parseFileEntry(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return nil, fmt.Errorf("failed to write file manifest: %w", err)
+		}
+		manifest.Files++
+
+		for _, pattern := range denyPatterns {
+			if matchesFilePattern(pattern, entry.Path) {
+				manifest.DeniedFiles++
+				if len(manifest.Matches) < maxFileMatches {
+					manifest.Matches = append(manifest.Matches, entry.Path)
+				}
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read image file listing: %w", err)
+	}
+	if err := out.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write file manifest: %w", err)
+	}
+
+	manifest.Digest = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	return manifest, nil
+}
+
+// parseFileEntry parses a "<type> <mode> <size> <path>" listing entry. The
+// path is last so that it may contain spaces.
+func parseFileEntry(line string) (*FileEntry, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 || fields[3] == "" {
+		return nil, fmt.Errorf("malformed image file listing entry %q", line)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed size in image file listing entry %q", line)
+	}
+	return &FileEntry{Path: fields[3], Type: fields[0], Size: size, Mode: fields[1]}, nil
+}
+
+// splitNUL is a bufio.SplitFunc for NUL terminated tokens
+func splitNUL(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// exportFileManifest lists the files of the built image into the file
+// manifest at config.FileManifestPath. Denied files fail the build when the
+// deny action is fail and are logged otherwise.
+func exportFileManifest(ctx context.Context, logger *zap.Logger, config *BuildConfig, runner exec.CommandRunner) (*FileManifest, error) {
+	listingPath := config.FileManifestPath + ".listing"
+	defer func() { _ = os.Remove(listingPath) }()
+
+	unshareCmd := UnshareScript(listImageFilesScript(config.buildahScriptCommand(), config.ImageURL, listingPath), config.Context, config.UnshareIDMaps)
+	if err := runner.Run(ctx, unshareCmd[0], unshareCmd[1:]...); err != nil {
+		return nil, fmt.Errorf("failed to list the files of the built image: %w", err)
+	}
+
+	listing, err := os.Open(listingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file listing: %w", err)
+	}
+	defer func() { _ = listing.Close() }()
+
+	file, err := os.Create(config.FileManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file manifest: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	manifest, err := WriteFileManifest(listing, file, config.FileDenyPatterns)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Path = config.FileManifestPath
+
+	logger.Info("Wrote the file manifest of the built image",
+		zap.String("path", manifest.Path),
+		zap.String("digest", manifest.Digest),
+		zap.Int("files", manifest.Files))
+
+	if manifest.DeniedFiles > 0 {
+		if config.FileDenyAction == FileDenyActionFail {
+			return nil, fmt.Errorf("image contains %d denied files: %s",
+				manifest.DeniedFiles, strings.Join(manifest.Matches, ", "))
+		}
+		logger.Warn("Image contains denied files",
+			zap.Int("denied_files", manifest.DeniedFiles),
+			zap.Strings("matches", manifest.Matches))
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,202 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+// listingRunner writes the listing of a fixture tree to the listing file of
+// the file listing script, as find does in the mounted image
+type listingRunner struct {
+	*exec.MockCommandRunner
+	root string
+}
+
+// listingPathArg matches the quoted listing path the find output is redirected to
+var listingPathArg = regexp.MustCompile(`> '([^']+)'`)
+
+func (r *listingRunner) Run(ctx context.Context, name string, args ...string) error {
+	if err := r.MockCommandRunner.Run(ctx, name, args...); err != nil {
+		return err
+	}
+	script := args[len(args)-1]
+	if name != "unshare" || !strings.Contains(script, "buildah mount") {
+		return nil
+	}
+	match := listingPathArg.FindStringSubmatch(script)
+	Expect(match).NotTo(BeNil())
+	return os.WriteFile(match[1], []byte(fixtureListing(r.root)), 0644)
+}
+
+// fixtureListing renders a tree like find -printf '%y %m %s %P\0'
+func fixtureListing(root string) string {
+	var listing strings.Builder
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		kind := "f"
+		if d.IsDir() {
+			kind = "d"
+		}
+		fmt.Fprintf(&listing, "%s %o %d %s\x00", kind, info.Mode().Perm(), info.Size(), filepath.ToSlash(rel))
+		return nil
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return listing.String()
+}
+
+// newFixtureImageTree creates a small image filesystem including sensitive files
+func newFixtureImageTree() string {
+	root := GinkgoT().TempDir()
+	files := map[string]string{
+		"usr/bin/app":          "binary",
+		"app/.npmrc":           "//registry.npmjs.org/:_authToken=secret",
+		"root/.ssh/id_rsa":     "private key",
+		"app/file with spaces": "data",
+	}
+	for name, content := range files {
+		Expect(os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, name), []byte(content), 0600)).To(Succeed())
+	}
+	return root
+}
+
+// readFileManifest decodes the entries of a file manifest
+func readFileManifest(manifestPath string) []FileEntry {
+	file, err := os.Open(manifestPath)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() { _ = file.Close() }()
+
+	var entries []FileEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry FileEntry
+		Expect(json.Unmarshal(scanner.Bytes(), &entry)).To(Succeed())
+		entries = append(entries, entry)
+	}
+	Expect(scanner.Err()).NotTo(HaveOccurred())
+	return entries
+}
+
+var _ = Describe("WriteFileManifest", func() {
+	It("should write one entry per file and match deny patterns", func() {
+		listing := "f 644 6 usr/bin/app\x00d 755 0 app\x00f 600 12 app/.npmrc\x00f 600 11 root/.ssh/id_rsa\x00"
+		var out strings.Builder
+
+		manifest, err := WriteFileManifest(strings.NewReader(listing), &out, []string{".npmrc", "/root/.ssh/*"})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Files).To(Equal(4))
+		Expect(manifest.Matches).To(Equal([]string{"app/.npmrc", "root/.ssh/id_rsa"}))
+		Expect(manifest.DeniedFiles).To(Equal(2))
+		Expect(manifest.Digest).To(MatchRegexp(`^sha256:[0-9a-f]{64}$`))
+		Expect(strings.SplitN(out.String(), "\n", 2)[0]).To(Equal(`{"path":"usr/bin/app","type":"f","size":6,"mode":"644"}`))
+	})
+
+	It("should keep hostile file names intact", func() {
+		listing := "f 644 1 etc/line\nbreak\x00f 644 1 etc/\"quoted\" name\x00"
+		var out strings.Builder
+
+		manifest, err := WriteFileManifest(strings.NewReader(listing), &out, []string{"break"})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Files).To(Equal(2))
+		Expect(manifest.Matches).To(BeEmpty())
+		Expect(strings.Count(out.String(), "\n")).To(Equal(2))
+		Expect(out.String()).To(ContainSubstring(`"path":"etc/line\nbreak"`))
+	})
+
+	It("should bound the matches kept for a pattern matching everything", func() {
+		var listing strings.Builder
+		for i := 0; i < 250; i++ {
+			fmt.Fprintf(&listing, "f 644 1 file-%d\x00", i)
+		}
+
+		manifest, err := WriteFileManifest(strings.NewReader(listing.String()), &strings.Builder{}, []string{"*"})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.DeniedFiles).To(Equal(250))
+		Expect(manifest.Matches).To(HaveLen(100))
+	})
+
+	It("should reject malformed patterns", func() {
+		_, err := WriteFileManifest(strings.NewReader(""), &strings.Builder{}, []string{"[unterminated"})
+		Expect(err).To(MatchError(ContainSubstring(`invalid file pattern "[unterminated"`)))
+	})
+
+	It("should reject malformed listing entries", func() {
+		_, err := WriteFileManifest(strings.NewReader("f 644 many app\x00"), &strings.Builder{}, nil)
+		Expect(err).To(MatchError(ContainSubstring("malformed size")))
+
+		_, err = WriteFileManifest(strings.NewReader("garbage\x00"), &strings.Builder{}, nil)
+		Expect(err).To(MatchError(ContainSubstring("malformed image file listing entry")))
+	})
+})
+
+var _ = Describe("exportFileManifest", func() {
+	var (
+		ctx    context.Context
+		runner *listingRunner
+		config *BuildConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		runner = &listingRunner{MockCommandRunner: exec.NewMockCommandRunner(), root: newFixtureImageTree()}
+		config = &BuildConfig{
+			ImageURL:         "quay.io/test/image:tag",
+			Context:          "/workspace/source",
+			FileManifestPath: filepath.Join(GinkgoT().TempDir(), "file-manifest.jsonl"),
+			FileDenyPatterns: []string{".npmrc", "id_rsa"},
+		}
+	})
+
+	It("should list the files of the mounted image", func() {
+		manifest, err := exportFileManifest(ctx, zap.NewNop(), config, runner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Path).To(Equal(config.FileManifestPath))
+		Expect(manifest.Matches).To(ConsistOf("app/.npmrc", "root/.ssh/id_rsa"))
+		Expect(readFileManifest(config.FileManifestPath)).To(ContainElement(
+			FileEntry{Path: "app/file with spaces", Type: "f", Size: 4, Mode: "600"}))
+		Expect(config.FileManifestPath + ".listing").NotTo(BeAnExistingFile())
+
+		script := runner.GetLastCommand()
+		Expect(script[0]).To(Equal("unshare"))
+		Expect(script[len(script)-1]).To(ContainSubstring(`buildah from --pull=never 'quay.io/test/image:tag'`))
+	})
+
+	It("should fail on denied files when configured", func() {
+		config.FileDenyAction = FileDenyActionFail
+
+		_, err := exportFileManifest(ctx, zap.NewNop(), config, runner)
+
+		Expect(err).To(MatchError(ContainSubstring("image contains 2 denied files")))
+	})
+
+	It("should fail when the image can't be mounted", func() {
+		runner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "image not known"}
+
+		_, err := exportFileManifest(ctx, zap.NewNop(), config, runner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to list the files of the built image")))
+	})
+})
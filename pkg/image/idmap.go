@@ -0,0 +1,165 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Subordinate ID files read by newuidmap and newgidmap
+const (
+	SubUIDPath = "/etc/subuid"
+	SubGIDPath = "/etc/subgid"
+)
+
+// DefaultIDMap is the mapping of unshare --map-users and --map-groups used
+// when the subordinate range of the user can't be detected
+var DefaultIDMap = IDMap{Outer: 1, Inner: 1, Count: 65536}
+
+// IDMaps are the user and group mappings of the unshare commands. An unset
+// mapping is DefaultIDMap.
+type IDMaps struct {
+	Users  IDMap
+	Groups IDMap
+}
+
+// IDMap maps Count IDs starting at Inner in the user namespace to the host
+// IDs starting at Outer, which must be subordinate IDs of the user
+type IDMap struct {
+	Outer int
+	Inner int
+	Count int
+}
+
+// String renders the mapping as unshare takes it, outer,inner,count
+func (m IDMap) String() string {
+	return fmt.Sprintf("%d,%d,%d", m.Outer, m.Inner, m.Count)
+}
+
+// ParseIDMap parses an outer,inner,count mapping
+func ParseIDMap(value string) (IDMap, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 3 {
+		return IDMap{}, fmt.Errorf("invalid ID mapping %q, expected outer,inner,count", value)
+	}
+
+	var numbers [3]int
+	for i, field := range fields {
+		number, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || number < 0 {
+			return IDMap{}, fmt.Errorf("invalid ID mapping %q, expected outer,inner,count", value)
+		}
+		numbers[i] = number
+	}
+	if numbers[2] == 0 {
+		return IDMap{}, fmt.Errorf("invalid ID mapping %q, count must be positive", value)
+	}
+	return IDMap{Outer: numbers[0], Inner: numbers[1], Count: numbers[2]}, nil
+}
+
+// SubIDRange is a range of subordinate IDs granted to a user
+type SubIDRange struct {
+	Start int
+	Count int
+}
+
+// String renders the range as the first and last ID it covers
+func (r SubIDRange) String() string {
+	return fmt.Sprintf("%d-%d (%d IDs)", r.Start, r.Start+r.Count-1, r.Count)
+}
+
+// Covers reports whether the host IDs of m are all in the range
+func (r SubIDRange) Covers(m IDMap) bool {
+	return m.Outer >= r.Start && m.Outer+m.Count <= r.Start+r.Count
+}
+
+// ParseSubIDs returns the first range granted to the user with the given
+// name or numeric ID in the content of /etc/subuid or /etc/subgid, whose
+// lines are user:start:count
+func ParseSubIDs(content []byte, user string, uid int) (SubIDRange, bool, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return SubIDRange{}, false, fmt.Errorf("line %d: expected user:start:count", lineNumber)
+		}
+		if fields[0] != strconv.Itoa(uid) && (user == "" || fields[0] != user) {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil || start < 0 {
+			return SubIDRange{}, false, fmt.Errorf("line %d: invalid start %q", lineNumber, fields[1])
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count <= 0 {
+			return SubIDRange{}, false, fmt.Errorf("line %d: invalid count %q", lineNumber, fields[2])
+		}
+		return SubIDRange{Start: start, Count: count}, true, nil
+	}
+	return SubIDRange{}, false, scanner.Err()
+}
+
+// ResolveIDMap returns the mapping of unshare from override, an
+// outer,inner,count mapping, or else from the range granted to the user in
+// subIDPath. The override must fit in the granted range. DefaultIDMap is
+// used when the range can't be detected.
+func ResolveIDMap(subIDPath, override, user string, uid int) (IDMap, error) {
+	var mapping IDMap
+	if override != "" {
+		parsed, err := ParseIDMap(override)
+		if err != nil {
+			return IDMap{}, err
+		}
+		mapping = parsed
+	}
+
+	content, err := os.ReadFile(subIDPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return orDefault(mapping), nil
+	}
+	if err != nil {
+		return IDMap{}, fmt.Errorf("failed to read %s: %w", subIDPath, err)
+	}
+	granted, found, err := ParseSubIDs(content, user, uid)
+	if err != nil {
+		return IDMap{}, fmt.Errorf("invalid %s: %w", subIDPath, err)
+	}
+	if !found {
+		return orDefault(mapping), nil
+	}
+
+	if override == "" {
+		return IDMap{Outer: granted.Start, Inner: DefaultIDMap.Inner, Count: min(granted.Count, DefaultIDMap.Count)}, nil
+	}
+	if !granted.Covers(mapping) {
+		return IDMap{}, fmt.Errorf("mapping %s needs host IDs %d-%d but %s grants %s to %s, map a range within it",
+			mapping, mapping.Outer, mapping.Outer+mapping.Count-1, subIDPath, granted, subIDOwner(user, uid))
+	}
+	return mapping, nil
+}
+
+// orDefault returns DefaultIDMap in place of an unset mapping
+func orDefault(mapping IDMap) IDMap {
+	if mapping.Count == 0 {
+		return DefaultIDMap
+	}
+	return mapping
+}
+
+// subIDOwner names the user in errors, by name when known
+func subIDOwner(user string, uid int) string {
+	if user != "" {
+		return user
+	}
+	return "UID " + strconv.Itoa(uid)
+}
@@ -0,0 +1,147 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IDMap", func() {
+	Describe("ParseIDMap", func() {
+		It("should parse an outer,inner,count mapping", func() {
+			mapping, err := ParseIDMap("100000, 1, 65536")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mapping).To(Equal(IDMap{Outer: 100000, Inner: 1, Count: 65536}))
+			Expect(mapping.String()).To(Equal("100000,1,65536"))
+		})
+
+		DescribeTable("should reject malformed mappings",
+			func(value string) {
+				_, err := ParseIDMap(value)
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("two fields", "1,65536"),
+			Entry("colon separated", "1:1:65536"),
+			Entry("not a number", "1,1,many"),
+			Entry("negative", "-1,1,65536"),
+			Entry("empty count", "1,1,0"),
+		)
+	})
+
+	Describe("ParseSubIDs", func() {
+		DescribeTable("should find the range of the user",
+			func(content string, expected SubIDRange, expectedFound bool) {
+				granted, found, err := ParseSubIDs([]byte(content), "build", 1000)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(found).To(Equal(expectedFound))
+				Expect(granted).To(Equal(expected))
+			},
+			Entry("single user", "build:1:65536\n", SubIDRange{Start: 1, Count: 65536}, true),
+			Entry("several users", "root:100000:65536\nbuild:165536:65536\n", SubIDRange{Start: 165536, Count: 65536}, true),
+			Entry("numeric user", "1000:200000:1000\n", SubIDRange{Start: 200000, Count: 1000}, true),
+			Entry("first of several ranges", "build:300000:500\nbuild:400000:65536\n", SubIDRange{Start: 300000, Count: 500}, true),
+			Entry("comments and blank lines", "# granted by the operator\n\nbuild:1:4294967294\n", SubIDRange{Start: 1, Count: 4294967294}, true),
+			Entry("other users only", "root:100000:65536\n", SubIDRange{}, false),
+			Entry("empty file", "", SubIDRange{}, false),
+		)
+
+		It("should reject malformed lines", func() {
+			_, _, err := ParseSubIDs([]byte("root:100000:65536\nbuild:1\n"), "build", 1000)
+
+			Expect(err).To(MatchError("line 2: expected user:start:count"))
+		})
+
+		It("should reject an invalid count", func() {
+			_, _, err := ParseSubIDs([]byte("build:1:0\n"), "build", 1000)
+
+			Expect(err).To(MatchError(`line 1: invalid count "0"`))
+		})
+	})
+
+	Describe("ResolveIDMap", func() {
+		var subIDPath string
+
+		BeforeEach(func() {
+			subIDPath = filepath.Join(GinkgoT().TempDir(), "subuid")
+		})
+
+		It("should keep the default mapping without a subordinate ID file", func() {
+			mapping, err := ResolveIDMap(subIDPath, "", "build", 1000)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mapping).To(Equal(DefaultIDMap))
+		})
+
+		It("should keep the default mapping when the user has no range", func() {
+			Expect(os.WriteFile(subIDPath, []byte("root:100000:65536\n"), 0644)).To(Succeed())
+
+			mapping, err := ResolveIDMap(subIDPath, "", "build", 1000)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mapping).To(Equal(DefaultIDMap))
+		})
+
+		It("should map a smaller granted range", func() {
+			Expect(os.WriteFile(subIDPath, []byte("build:524288:10000\n"), 0644)).To(Succeed())
+
+			mapping, err := ResolveIDMap(subIDPath, "", "build", 1000)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mapping).To(Equal(IDMap{Outer: 524288, Inner: 1, Count: 10000}))
+		})
+
+		It("should map at most the default count of a larger range", func() {
+			Expect(os.WriteFile(subIDPath, []byte("build:1:4294967294\n"), 0644)).To(Succeed())
+
+			mapping, err := ResolveIDMap(subIDPath, "", "build", 1000)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mapping).To(Equal(DefaultIDMap))
+		})
+
+		It("should use an override within the granted range", func() {
+			Expect(os.WriteFile(subIDPath, []byte("build:100000:65536\n"), 0644)).To(Succeed())
+
+			mapping, err := ResolveIDMap(subIDPath, "100000,1,1000", "build", 1000)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mapping).To(Equal(IDMap{Outer: 100000, Inner: 1, Count: 1000}))
+		})
+
+		It("should use an override as is when the range can't be detected", func() {
+			mapping, err := ResolveIDMap(subIDPath, "1,1,1000", "build", 1000)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mapping).To(Equal(IDMap{Outer: 1, Inner: 1, Count: 1000}))
+		})
+
+		It("should describe the granted range when an override doesn't fit", func() {
+			Expect(os.WriteFile(subIDPath, []byte("1000:100000:1000\n"), 0644)).To(Succeed())
+
+			_, err := ResolveIDMap(subIDPath, "1,1,65536", "", 1000)
+
+			Expect(err).To(MatchError("mapping 1,1,65536 needs host IDs 1-65536 but " + subIDPath +
+				" grants 100000-100999 (1000 IDs) to UID 1000, map a range within it"))
+		})
+	})
+
+	Describe("IDMaps", func() {
+		It("should map the given ranges in unshare commands", func() {
+			maps := IDMaps{Users: IDMap{Outer: 100000, Inner: 1, Count: 1000}, Groups: IDMap{Outer: 200000, Inner: 1, Count: 2000}}
+
+			command := UnshareScript("true", "/workspace/source", maps)
+
+			Expect(command[4:8]).To(Equal([]string{"--map-users", "100000,1,1000", "--map-groups", "200000,1,2000"}))
+		})
+
+		It("should map DefaultIDMap when unset", func() {
+			command := UnshareScript("true", "/workspace/source", IDMaps{})
+
+			Expect(command[4:8]).To(Equal([]string{"--map-users", "1,1,65536", "--map-groups", "1,1,65536"}))
+		})
+	})
+})
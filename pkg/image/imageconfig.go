@@ -0,0 +1,168 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// ImageConfig holds the parts of the configuration of a built image that
+// can be checked against expectations
+type ImageConfig struct {
+	Entrypoint   []string `json:"entrypoint"`
+	ExposedPorts []string `json:"exposed_ports"`
+	User         string   `json:"user"`
+}
+
+// ImageConfigExpectations lists the expected configuration of the built
+// image. Nil fields aren't checked.
+type ImageConfigExpectations struct {
+	Entrypoint   []string
+	ExposedPorts []string
+	User         *string
+}
+
+// ParseImageConfigExpectations parses the JSON expectations of
+// EXPECT_ENTRYPOINT (an array of strings), EXPECT_EXPOSED_PORTS (an array
+// of port[/protocol] strings) and EXPECT_USER (a string). Empty values
+// aren't checked and nil is returned when none is set.
+func ParseImageConfigExpectations(entrypoint, exposedPorts, user string) (*ImageConfigExpectations, error) {
+	if entrypoint == "" && exposedPorts == "" && user == "" {
+		return nil, nil
+	}
+
+	expectations := &ImageConfigExpectations{}
+	if entrypoint != "" {
+		if err := json.Unmarshal([]byte(entrypoint), &expectations.Entrypoint); err != nil {
+			return nil, fmt.Errorf("invalid EXPECT_ENTRYPOINT, expected a JSON array of strings: %w", err)
+		}
+		if expectations.Entrypoint == nil {
+			expectations.Entrypoint = []string{}
+		}
+	}
+	if exposedPorts != "" {
+		var ports []string
+		if err := json.Unmarshal([]byte(exposedPorts), &ports); err != nil {
+			return nil, fmt.Errorf("invalid EXPECT_EXPOSED_PORTS, expected a JSON array of strings: %w", err)
+		}
+		expectations.ExposedPorts = normalizePorts(ports)
+	}
+	if user != "" {
+		expectations.User = new(string)
+		if err := json.Unmarshal([]byte(user), expectations.User); err != nil {
+			return nil, fmt.Errorf("invalid EXPECT_USER, expected a JSON string: %w", err)
+		}
+	}
+	return expectations, nil
+}
+
+// normalizePorts sorts ports, defaulting their protocol to tcp as the image
+// configuration does
+func normalizePorts(ports []string) []string {
+	normalized := make([]string, 0, len(ports))
+	for _, port := range ports {
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		normalized = append(normalized, port)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// Diff lists the differences between the expectations and the actual
+// configuration, one line per mismatching field
+func (e *ImageConfigExpectations) Diff(actual *ImageConfig) []string {
+	var diff []string
+	if e.Entrypoint != nil && !equalStrings(e.Entrypoint, actual.Entrypoint) {
+		diff = append(diff, fmt.Sprintf("entrypoint: expected %s, got %s", jsonString(e.Entrypoint), jsonString(actual.Entrypoint)))
+	}
+	if e.ExposedPorts != nil && !equalStrings(e.ExposedPorts, actual.ExposedPorts) {
+		diff = append(diff, fmt.Sprintf("exposed ports: expected %s, got %s", jsonString(e.ExposedPorts), jsonString(actual.ExposedPorts)))
+	}
+	if e.User != nil && *e.User != actual.User {
+		diff = append(diff, fmt.Sprintf("user: expected %q, got %q", *e.User, actual.User))
+	}
+	return diff
+}
+
+// equalStrings compares two lists, nil being equal to empty
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonString renders a list as a JSON array, nil as []
+func jsonString(values []string) string {
+	if values == nil {
+		values = []string{}
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// inspectImageConfig reads the configuration of a local image inspected
+// with the buildah arguments args
+func inspectImageConfig(ctx context.Context, args []string, runner exec.CommandRunner) (*ImageConfig, error) {
+	output, err := runner.RunWithOutput(ctx, "buildah", args...)
+	if err != nil {
+		return nil, fmt.Errorf("buildah inspect failed: %w", err)
+	}
+
+	var inspect struct {
+		OCIv1 *struct {
+			Config struct {
+				Entrypoint   []string            `json:"Entrypoint"`
+				ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+				User         string              `json:"User"`
+			} `json:"config"`
+		} `json:"OCIv1"`
+	}
+	if err := json.Unmarshal(output, &inspect); err != nil {
+		return nil, fmt.Errorf("failed to parse buildah inspect output: %w", err)
+	}
+	if inspect.OCIv1 == nil {
+		return nil, fmt.Errorf("image configuration not found in buildah inspect output")
+	}
+
+	ports := make([]string, 0, len(inspect.OCIv1.Config.ExposedPorts))
+	for port := range inspect.OCIv1.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	return &ImageConfig{
+		Entrypoint:   inspect.OCIv1.Config.Entrypoint,
+		ExposedPorts: normalizePorts(ports),
+		User:         inspect.OCIv1.Config.User,
+	}, nil
+}
+
+// checkImageConfig fails before pushing when the configuration of the built
+// image doesn't match ImageConfigExpectations, listing the differences
+func checkImageConfig(ctx context.Context, logger *zap.Logger, config *BuildConfig, runner exec.CommandRunner) (*ImageConfig, error) {
+	actual, err := inspectImageConfig(ctx, config.buildahArgs(BuildahInspectCommand(config.ImageURL)), runner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the image configuration: %w", err)
+	}
+
+	logger.Info("Built image configuration",
+		zap.Strings("entrypoint", actual.Entrypoint),
+		zap.Strings("exposed_ports", actual.ExposedPorts),
+		zap.String("user", actual.User))
+
+	if diff := config.ImageConfigExpectations.Diff(actual); len(diff) > 0 {
+		return nil, fmt.Errorf("image configuration doesn't match the expectations:\n  %s", strings.Join(diff, "\n  "))
+	}
+	return actual, nil
+}
@@ -0,0 +1,58 @@
+package image
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseImageConfigExpectations", func() {
+	It("should return nil without expectations", func() {
+		Expect(ParseImageConfigExpectations("", "", "")).To(BeNil())
+	})
+
+	It("should parse the JSON expectations", func() {
+		expectations, err := ParseImageConfigExpectations(`["/app","--serve"]`, `["8443/tcp","8080","53/udp"]`, `"1001"`)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expectations.Entrypoint).To(Equal([]string{"/app", "--serve"}))
+		Expect(expectations.ExposedPorts).To(Equal([]string{"53/udp", "8080/tcp", "8443/tcp"}))
+		Expect(*expectations.User).To(Equal("1001"))
+	})
+
+	It("should only check the fields that are set", func() {
+		expectations, err := ParseImageConfigExpectations("", "[]", "")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expectations.Entrypoint).To(BeNil())
+		Expect(expectations.ExposedPorts).To(BeEmpty())
+		Expect(expectations.ExposedPorts).NotTo(BeNil())
+		Expect(expectations.User).To(BeNil())
+	})
+
+	It("should reject invalid JSON", func() {
+		_, err := ParseImageConfigExpectations("/app", "", "")
+		Expect(err).To(MatchError(ContainSubstring("invalid EXPECT_ENTRYPOINT, expected a JSON array of strings")))
+
+		_, err = ParseImageConfigExpectations("", "", "1001")
+		Expect(err).To(MatchError(ContainSubstring("invalid EXPECT_USER, expected a JSON string")))
+	})
+})
+
+var _ = Describe("ImageConfigExpectations", func() {
+	It("should report no difference for a matching image", func() {
+		user := ""
+		expectations := &ImageConfigExpectations{Entrypoint: []string{}, ExposedPorts: []string{}, User: &user}
+
+		Expect(expectations.Diff(&ImageConfig{})).To(BeEmpty())
+	})
+
+	It("should list every mismatching field", func() {
+		user := "1001"
+		expectations := &ImageConfigExpectations{ExposedPorts: []string{"8080/tcp"}, User: &user}
+
+		Expect(expectations.Diff(&ImageConfig{Entrypoint: []string{"/bin/sh"}, User: "0"})).To(Equal([]string{
+			`exposed ports: expected ["8080/tcp"], got []`,
+			`user: expected "1001", got "0"`,
+		}))
+	})
+})
@@ -0,0 +1,224 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// LayerCacheRoot returns the buildah storage root used for imageURL in the
+// layer cache at cacheDir. Every repository gets its own root.
+func LayerCacheRoot(cacheDir, imageURL string) string {
+	return filepath.Join(cacheDir, invalidTagChars.ReplaceAllString(Repository(imageURL), "_"))
+}
+
+// layerCacheRoot returns the storage root of the build, empty without a layer cache
+func (c *BuildConfig) layerCacheRoot() string {
+	if c.LayerCacheDir == "" {
+		return ""
+	}
+	return LayerCacheRoot(c.LayerCacheDir, c.ImageURL)
+}
+
+// buildahArgs prefixes the arguments of a buildah command with the global
+// flags selecting the layer cache as storage root
+func (c *BuildConfig) buildahArgs(args []string) []string {
+	if c.LayerCacheDir == "" {
+		return args
+	}
+	return append([]string{"--root", c.layerCacheRoot()}, args...)
+}
+
+// buildahScriptCommand returns the shell invocation of buildah with the
+// global flags of buildahArgs, for buildah commands run from scripts
+func (c *BuildConfig) buildahScriptCommand() string {
+	parts := []string{"buildah"}
+	for _, arg := range c.buildahArgs(nil) {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// layerDirs lists the directories of a storage root holding one
+// subdirectory per layer, for the overlay and vfs drivers
+var layerDirs = []string{"overlay", filepath.Join("vfs", "dir")}
+
+// CachedLayer is a layer directory of the layer cache
+type CachedLayer struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ListCachedLayers lists the layer directories of a storage root with their
+// size and modification time. Files that can't be read, e.g. because they
+// belong to other users of the build namespace, are not counted.
+func ListCachedLayers(root string) ([]CachedLayer, error) {
+	var layers []CachedLayer
+	for _, dir := range layerDirs {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list layer cache: %w", err)
+		}
+
+		for _, entry := range entries {
+			// The overlay driver keeps short symlinks to the layers in l
+			if !entry.IsDir() || entry.Name() == "l" {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(root, dir, entry.Name())
+			layers = append(layers, CachedLayer{Path: path, Size: directorySize(path), ModTime: info.ModTime()})
+		}
+	}
+	return layers, nil
+}
+
+// directorySize sums the sizes of the readable files under path
+func directorySize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// LayersToPrune returns the least recently modified layers to remove so
+// that the others take at most maxSize bytes
+func LayersToPrune(layers []CachedLayer, maxSize int64) []CachedLayer {
+	var total int64
+	for _, layer := range layers {
+		total += layer.Size
+	}
+
+	sorted := append([]CachedLayer(nil), layers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ModTime.Before(sorted[j].ModTime)
+	})
+
+	var prune []CachedLayer
+	for _, layer := range sorted {
+		if total <= maxSize {
+			break
+		}
+		prune = append(prune, layer)
+		total -= layer.Size
+	}
+	return prune
+}
+
+// PruneLayerCache removes the least recently used layers of the storage root
+// until it holds at most maxSize bytes of layers, returning the removed
+// paths. Layers are removed within the user namespace of the build since
+// their files belong to its users, mapped with maps.
+func PruneLayerCache(ctx context.Context, root string, maxSize int64, maps IDMaps, runner exec.CommandRunner) ([]string, error) {
+	layers, err := ListCachedLayers(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, layer := range LayersToPrune(layers, maxSize) {
+		paths = append(paths, layer.Path)
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	if err := removeInNamespace(ctx, root, paths, maps, runner); err != nil {
+		return nil, fmt.Errorf("failed to prune layer cache: %w", err)
+	}
+	return paths, nil
+}
+
+// WipeLayerCache removes a whole storage root of the layer cache
+func WipeLayerCache(ctx context.Context, root string, maps IDMaps, runner exec.CommandRunner) error {
+	if err := removeInNamespace(ctx, filepath.Dir(root), []string{root}, maps, runner); err != nil {
+		return fmt.Errorf("failed to wipe layer cache: %w", err)
+	}
+	return nil
+}
+
+// removeInNamespace removes paths with rm -rf run through unshare from dir
+func removeInNamespace(ctx context.Context, dir string, paths []string, maps IDMaps, runner exec.CommandRunner) error {
+	quoted := make([]string, len(paths))
+	for i, path := range paths {
+		quoted[i] = shellQuote(path)
+	}
+	unshareCmd := UnshareScript("rm -rf -- "+strings.Join(quoted, " "), dir, maps)
+	return runner.Run(ctx, unshareCmd[0], unshareCmd[1:]...)
+}
+
+// storageCorruptionPattern matches buildah errors caused by a damaged store
+var storageCorruptionPattern = regexp.MustCompile(`(?i)layer not known|corrupt`)
+
+// isLayerCacheCorruption reports whether a buildah error points at the
+// storage root of the layer cache rather than at the build itself
+func isLayerCacheCorruption(err error, root string) bool {
+	message := err.Error()
+	return strings.Contains(message, root) || storageCorruptionPattern.MatchString(message)
+}
+
+// pruneLayerCache caps the layer cache of the build at LayerCacheMaxSize.
+// Failures are only logged since the image is already pushed.
+func pruneLayerCache(ctx context.Context, logger *zap.Logger, config *BuildConfig, runner exec.CommandRunner) {
+	removed, err := PruneLayerCache(ctx, config.layerCacheRoot(), config.LayerCacheMaxSize, config.UnshareIDMaps, runner)
+	if err != nil {
+		logger.Warn("Failed to prune the layer cache", zap.Error(err))
+		return
+	}
+	if len(removed) > 0 {
+		logger.Info("Pruned the layer cache",
+			zap.Int("removed_layers", len(removed)),
+			zap.Int64("max_size", config.LayerCacheMaxSize))
+	}
+}
+
+// sizeUnits maps the suffixes accepted by ParseSize to their multiplier
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// ParseSize parses a size in bytes with an optional K, M, G or T binary
+// suffix, optionally followed by i and B, e.g. 512M or 20GiB
+func ParseSize(value string) (int64, error) {
+	number := strings.TrimRight(value, "KMGTiB")
+	unit := strings.TrimSuffix(value[len(number):], "B")
+	binary := strings.HasSuffix(unit, "i")
+	unit = strings.TrimSuffix(unit, "i")
+
+	multiplier, ok := sizeUnits[unit]
+	parsed, err := strconv.ParseInt(number, 10, 64)
+	if !ok || (binary && unit == "") || err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected bytes with an optional K, M, G or T suffix", value)
+	}
+	return parsed * multiplier, nil
+}
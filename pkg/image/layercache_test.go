@@ -0,0 +1,162 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// writeLayer creates a synthetic layer directory holding size bytes, last
+// modified at modTime
+func writeLayer(path string, size int, modTime time.Time) {
+	Expect(os.MkdirAll(filepath.Join(path, "diff"), 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(path, "diff", "data"), make([]byte, size), 0644)).To(Succeed())
+	Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+}
+
+var _ = Describe("LayerCacheRoot", func() {
+	It("should give every repository its own root", func() {
+		Expect(LayerCacheRoot("/workspace/cache", "quay.io/test/image:tag")).To(Equal("/workspace/cache/quay.io_test_image"))
+		Expect(LayerCacheRoot("/workspace/cache", "registry.dev:5000/test/image@sha256:abc")).To(Equal("/workspace/cache/registry.dev_5000_test_image"))
+	})
+})
+
+var _ = Describe("ListCachedLayers", func() {
+	It("should list the overlay and vfs layers with their size", func() {
+		root := GinkgoT().TempDir()
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		writeLayer(filepath.Join(root, "overlay", "a"), 100, modTime)
+		writeLayer(filepath.Join(root, "vfs", "dir", "b"), 50, modTime)
+		Expect(os.MkdirAll(filepath.Join(root, "overlay", "l"), 0755)).To(Succeed())
+
+		layers, err := ListCachedLayers(root)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(layers).To(ConsistOf(
+			CachedLayer{Path: filepath.Join(root, "overlay", "a"), Size: 100, ModTime: modTime},
+			CachedLayer{Path: filepath.Join(root, "vfs", "dir", "b"), Size: 50, ModTime: modTime},
+		))
+	})
+
+	It("should return nothing for an empty cache", func() {
+		layers, err := ListCachedLayers(filepath.Join(GinkgoT().TempDir(), "missing"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(layers).To(BeEmpty())
+	})
+})
+
+var _ = Describe("LayersToPrune", func() {
+	now := time.Now()
+	layers := []CachedLayer{
+		{Path: "recent", Size: 40, ModTime: now},
+		{Path: "oldest", Size: 30, ModTime: now.Add(-3 * time.Hour)},
+		{Path: "older", Size: 20, ModTime: now.Add(-2 * time.Hour)},
+		{Path: "old", Size: 10, ModTime: now.Add(-time.Hour)},
+	}
+
+	paths := func(layers []CachedLayer) []string {
+		var result []string
+		for _, layer := range layers {
+			result = append(result, layer.Path)
+		}
+		return result
+	}
+
+	It("should prune the least recently used layers until the cache fits", func() {
+		Expect(paths(LayersToPrune(layers, 50))).To(Equal([]string{"oldest", "older"}))
+	})
+
+	It("should prune nothing when the cache fits", func() {
+		Expect(LayersToPrune(layers, 100)).To(BeEmpty())
+	})
+
+	It("should prune everything when even the newest layer is too large", func() {
+		Expect(paths(LayersToPrune(layers, 0))).To(Equal([]string{"oldest", "older", "old", "recent"}))
+	})
+})
+
+var _ = Describe("PruneLayerCache", func() {
+	var (
+		root       string
+		mockRunner *exec.MockCommandRunner
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		mockRunner = exec.NewMockCommandRunner()
+		writeLayer(filepath.Join(root, "overlay", "old"), 100, time.Now().Add(-time.Hour))
+		writeLayer(filepath.Join(root, "overlay", "new"), 100, time.Now())
+	})
+
+	It("should remove the pruned layers within the build namespace", func() {
+		removed, err := PruneLayerCache(context.Background(), root, 150, IDMaps{}, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(Equal([]string{filepath.Join(root, "overlay", "old")}))
+		Expect(mockRunner.GetExecutedCommands()).To(Equal([][]string{
+			UnshareScript(`rm -rf -- '`+filepath.Join(root, "overlay", "old")+`'`, root, IDMaps{}),
+		}))
+	})
+
+	It("should not run anything when the cache fits", func() {
+		removed, err := PruneLayerCache(context.Background(), root, 200, IDMaps{}, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(BeEmpty())
+		Expect(mockRunner.GetExecutedCommands()).To(BeEmpty())
+	})
+
+	It("should fail when the layers can't be removed", func() {
+		mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "permission denied"}
+
+		_, err := PruneLayerCache(context.Background(), root, 0, IDMaps{}, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to prune layer cache: permission denied")))
+	})
+})
+
+var _ = Describe("isLayerCacheCorruption", func() {
+	const root = "/workspace/cache/quay.io_test_image"
+
+	DescribeTable("classifying build errors",
+		func(message string, expected bool) {
+			Expect(isLayerCacheCorruption(errors.New(message), root)).To(Equal(expected))
+		},
+		Entry("missing layer", "creating build container: layer not known", true),
+		Entry("corrupted store", "image store is corrupted", true),
+		Entry("error in the storage root", "open "+root+"/overlay-layers/layers.lock: no such file or directory", true),
+		Entry("failing RUN instruction", "error building at STEP \"RUN make\": exit status 2", false),
+	)
+})
+
+var _ = Describe("ParseSize", func() {
+	DescribeTable("parsing sizes",
+		func(value string, expected int64) {
+			Expect(ParseSize(value)).To(Equal(expected))
+		},
+		Entry("bytes", "1024", int64(1024)),
+		Entry("kilobytes", "4K", int64(4096)),
+		Entry("megabytes", "512M", int64(512<<20)),
+		Entry("gibibytes", "20GiB", int64(20<<30)),
+		Entry("terabytes", "1TB", int64(1<<40)),
+	)
+
+	DescribeTable("rejecting invalid sizes",
+		func(value string) {
+			_, err := ParseSize(value)
+			Expect(err).To(MatchError(ContainSubstring("expected bytes with an optional K, M, G or T suffix")))
+		},
+		Entry("empty", ""),
+		Entry("unknown unit", "10X"),
+		Entry("binary marker without unit", "10i"),
+		Entry("negative", "-1G"),
+		Entry("fraction", "1.5G"),
+	)
+})
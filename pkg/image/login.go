@@ -0,0 +1,162 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// Registry types of REGISTRY_TYPE, selecting how the login password is obtained
+const (
+	// RegistryTypeDefault reads the username and password of a mounted secret
+	RegistryTypeDefault = "default"
+
+	// RegistryTypeECR exchanges the AWS credentials for an ECR token with the aws CLI
+	RegistryTypeECR = "ecr"
+)
+
+// ecrUsername is the username of ECR tokens
+const ecrUsername = "AWS"
+
+// ecrHostPattern matches the hosts of ECR private registries, capturing their region
+var ecrHostPattern = regexp.MustCompile(`^\d{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// LoginConfig configures the login to a registry before images are pushed or inspected
+type LoginConfig struct {
+	// Registry is the host logged in to
+	Registry string
+
+	// Type is RegistryTypeDefault or RegistryTypeECR
+	Type string
+
+	// CredentialsPath holds the username and password files of RegistryTypeDefault
+	CredentialsPath string
+
+	// AuthFile is an existing authfile whose entries are kept alongside the login
+	AuthFile string
+
+	TLSVerify bool
+}
+
+// RegistryLogin is a login scoped to a temporary authfile
+type RegistryLogin struct {
+	// AuthFile is the temporary authfile holding the credentials, to be
+	// used by the commands run after the login
+	AuthFile string
+
+	dir string
+}
+
+// Cleanup removes the temporary authfile
+func (l *RegistryLogin) Cleanup() error {
+	if err := os.RemoveAll(l.dir); err != nil {
+		return fmt.Errorf("failed to remove registry authfile: %w", err)
+	}
+	return nil
+}
+
+// Login logs in to the registry with buildah login, writing the credentials
+// to a temporary authfile. The password is fed through stdin so that it never
+// appears on a command line.
+func Login(ctx context.Context, logger *zap.Logger, config *LoginConfig, runner exec.CommandRunner) (*RegistryLogin, error) {
+	username, password, err := loginCredentials(ctx, config, runner)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "registry-auth-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registry authfile directory: %w", err)
+	}
+	login := &RegistryLogin{AuthFile: filepath.Join(dir, "auth.json"), dir: dir}
+
+	// Start from the existing authfile so that its registries stay reachable
+	if config.AuthFile != "" {
+		content, err := os.ReadFile(config.AuthFile)
+		if err == nil {
+			err = os.WriteFile(login.AuthFile, content, 0600)
+		}
+		if err != nil {
+			_ = login.Cleanup()
+			return nil, fmt.Errorf("failed to copy authfile %s: %w", config.AuthFile, err)
+		}
+	}
+
+	logger.Info("Logging in to registry",
+		zap.String("registry", config.Registry),
+		zap.String("type", config.Type),
+		zap.String("username", username))
+
+	if err := exec.RunWithStdin(ctx, runner, []byte(password), "buildah", LoginCommand(config.Registry, login.AuthFile, username, config.TLSVerify)...); err != nil {
+		_ = login.Cleanup()
+		return nil, fmt.Errorf("failed to log in to %s: %w", config.Registry, err)
+	}
+
+	return login, nil
+}
+
+// LoginCommand returns the buildah login arguments reading the password from stdin
+func LoginCommand(registry, authFile, username string, tlsVerify bool) []string {
+	args := []string{"login", "--authfile", authFile, "--username", username, "--password-stdin"}
+	if !tlsVerify {
+		args = append(args, "--tls-verify=false")
+	}
+	return append(args, registry)
+}
+
+// loginCredentials returns the username and password of the registry type
+func loginCredentials(ctx context.Context, config *LoginConfig, runner exec.CommandRunner) (string, string, error) {
+	switch config.Type {
+	case RegistryTypeDefault, "":
+		return readLoginCredentials(config.CredentialsPath)
+	case RegistryTypeECR:
+		password, err := ecrPassword(ctx, config.Registry, runner)
+		return ecrUsername, password, err
+	default:
+		return "", "", fmt.Errorf("unknown registry type %q, expected %s or %s", config.Type, RegistryTypeDefault, RegistryTypeECR)
+	}
+}
+
+// readLoginCredentials reads the username and password files of a mounted secret
+func readLoginCredentials(path string) (string, string, error) {
+	if path == "" {
+		return "", "", fmt.Errorf("registry login requires a credentials path")
+	}
+
+	username, err := os.ReadFile(filepath.Join(path, "username"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read registry username: %w", err)
+	}
+	password, err := os.ReadFile(filepath.Join(path, "password"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read registry password: %w", err)
+	}
+
+	return strings.TrimSpace(string(username)), strings.TrimSpace(string(password)), nil
+}
+
+// ecrPassword exchanges the AWS credentials of the environment for a token of
+// the ECR registry, in the region of its host
+func ecrPassword(ctx context.Context, registry string, runner exec.CommandRunner) (string, error) {
+	match := ecrHostPattern.FindStringSubmatch(registry)
+	if match == nil {
+		return "", fmt.Errorf("%s isn't an ECR registry host", registry)
+	}
+
+	output, err := runner.RunWithOutput(ctx, "aws", "ecr", "get-login-password", "--region", match[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to get ECR login password: %w", err)
+	}
+
+	password := strings.TrimSpace(string(output))
+	if password == "" {
+		return "", fmt.Errorf("aws ecr get-login-password returned an empty password")
+	}
+	return password, nil
+}
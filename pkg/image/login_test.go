@@ -0,0 +1,100 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Login", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		credsDir   string
+	)
+
+	// loginCommand returns the buildah login command that was run
+	loginCommand := func() []string {
+		for _, cmd := range mockRunner.GetExecutedCommands() {
+			if len(cmd) > 1 && cmd[0] == "buildah" && cmd[1] == "login" {
+				return cmd
+			}
+		}
+		Fail("buildah login wasn't run")
+		return nil
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		credsDir = GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(credsDir, "username"), []byte("robot$builder\n"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(credsDir, "password"), []byte("s3cret\n"), 0600)).To(Succeed())
+	})
+
+	It("should feed the password of the mounted secret through stdin", func() {
+		login, err := Login(ctx, zap.NewNop(), &LoginConfig{
+			Registry:        "harbor.example.com",
+			Type:            RegistryTypeDefault,
+			CredentialsPath: credsDir,
+			TLSVerify:       true,
+		}, mockRunner)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(login.Cleanup)
+
+		cmd := loginCommand()
+		Expect(cmd).To(Equal(append([]string{"buildah"}, LoginCommand("harbor.example.com", login.AuthFile, "robot$builder", true)...)))
+		Expect(cmd).NotTo(ContainElement(ContainSubstring("s3cret")))
+		Expect(string(mockRunner.GetStdin("buildah", cmd[1:]...))).To(Equal("s3cret"))
+	})
+
+	It("should exchange an ECR token with the aws CLI", func() {
+		registry := "123456789012.dkr.ecr.eu-west-1.amazonaws.com"
+		mockRunner.SetOutput("aws", []byte("ecr-token\n"), "ecr", "get-login-password", "--region", "eu-west-1")
+
+		login, err := Login(ctx, zap.NewNop(), &LoginConfig{Registry: registry, Type: RegistryTypeECR}, mockRunner)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(login.Cleanup)
+
+		cmd := loginCommand()
+		Expect(cmd).To(Equal([]string{"buildah", "login", "--authfile", login.AuthFile, "--username", "AWS", "--password-stdin", "--tls-verify=false", registry}))
+		Expect(string(mockRunner.GetStdin("buildah", cmd[1:]...))).To(Equal("ecr-token"))
+	})
+
+	It("should reject ECR logins to other registries", func() {
+		_, err := Login(ctx, zap.NewNop(), &LoginConfig{Registry: "quay.io", Type: RegistryTypeECR}, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("quay.io isn't an ECR registry host")))
+	})
+
+	It("should keep the entries of the existing authfile and remove the temporary one on cleanup", func() {
+		authFile := filepath.Join(GinkgoT().TempDir(), "auth.json")
+		Expect(os.WriteFile(authFile, []byte(`{"auths":{"registry.redhat.io":{}}}`), 0600)).To(Succeed())
+
+		login, err := Login(ctx, zap.NewNop(), &LoginConfig{
+			Registry:        "harbor.example.com",
+			CredentialsPath: credsDir,
+			AuthFile:        authFile,
+		}, mockRunner)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.ReadFile(login.AuthFile)).To(Equal([]byte(`{"auths":{"registry.redhat.io":{}}}`)))
+		Expect(login.Cleanup()).To(Succeed())
+		Expect(login.AuthFile).NotTo(BeAnExistingFile())
+		Expect(authFile).To(BeAnExistingFile())
+	})
+
+	It("should remove the temporary authfile when the login fails", func() {
+		mockRunner.DefaultError = &exec.CommandError{ExitCode: 125, Message: "unauthorized"}
+
+		_, err := Login(ctx, zap.NewNop(), &LoginConfig{Registry: "harbor.example.com", CredentialsPath: credsDir}, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to log in to harbor.example.com")))
+		Expect(filepath.Dir(loginCommand()[3])).NotTo(BeADirectory())
+	})
+})
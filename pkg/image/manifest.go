@@ -0,0 +1,106 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Manifest media types returned by registries
+const (
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Annotations buildah records on the manifest of an image for the base image
+// of its last stage
+const (
+	AnnotationBaseName   = "org.opencontainers.image.base.name"
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// Manifest describes a raw manifest fetched from a registry
+type Manifest struct {
+	// MediaType is the declared media type, inferred from the payload when absent
+	MediaType string
+
+	// Digest is the sha256 digest of the raw manifest bytes
+	Digest string
+
+	// IsIndex is true for OCI image indexes and docker manifest lists
+	IsIndex bool
+
+	// ConfigDigest is the digest of the image configuration, which is the
+	// image ID, empty for indexes
+	ConfigDigest string
+
+	// Annotations are the annotations of the manifest itself
+	Annotations map[string]string
+
+	// Manifests are the children of an index
+	Manifests []Descriptor
+
+	// Raw holds the manifest bytes exactly as returned by the registry
+	Raw []byte
+}
+
+// ParseManifest detects whether a raw manifest is an index or an image
+// manifest and computes its digest over the exact bytes
+func ParseManifest(raw []byte) (*Manifest, error) {
+	var payload struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []Descriptor      `json:"manifests"`
+		Layers    []json.RawMessage `json:"layers"`
+		Config    struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("empty manifest")
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	mediaType := payload.MediaType
+	if mediaType == "" {
+		// OCI allows omitting the media type, so infer it from the content
+		switch {
+		case payload.Manifests != nil:
+			mediaType = MediaTypeOCIIndex
+		case payload.Layers != nil:
+			mediaType = MediaTypeOCIManifest
+		}
+	}
+
+	sum := sha256.Sum256(raw)
+	return &Manifest{
+		MediaType:    mediaType,
+		Digest:       "sha256:" + hex.EncodeToString(sum[:]),
+		IsIndex:      mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList,
+		ConfigDigest: payload.Config.Digest,
+		Annotations:  payload.Annotations,
+		Manifests:    payload.Manifests,
+		Raw:          raw,
+	}, nil
+}
+
+// Descriptor is a child manifest of an index
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// RecordedBaseImage returns the base image reference and digest recorded in
+// the annotations of the manifest, if any
+func (m *Manifest) RecordedBaseImage() (string, string, bool) {
+	name, digest := m.Annotations[AnnotationBaseName], m.Annotations[AnnotationBaseDigest]
+	return name, digest, name != "" && digest != ""
+}
@@ -0,0 +1,95 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// readManifestFixture reads a captured registry manifest
+func readManifestFixture(name string) []byte {
+	raw, err := os.ReadFile(filepath.Join("testdata", "manifests", name))
+	Expect(err).NotTo(HaveOccurred())
+	return raw
+}
+
+var _ = Describe("ParseManifest", func() {
+	DescribeTable("should detect the media type and compute a stable digest",
+		func(fixture, mediaType, digest string, isIndex bool) {
+			manifest, err := ParseManifest(readManifestFixture(fixture))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.MediaType).To(Equal(mediaType))
+			Expect(manifest.Digest).To(Equal(digest))
+			Expect(manifest.IsIndex).To(Equal(isIndex))
+		},
+		Entry("OCI index", "oci-index.json", MediaTypeOCIIndex,
+			"sha256:7832fdc2b5eb6e81365720388ed0c8c90f5bd94b8c962c3f310aa8dc6d068e22", true),
+		Entry("docker v2s2 manifest", "docker-manifest.json", MediaTypeDockerManifest,
+			"sha256:9542181892ee54e719c71e67b195e2fd74899f2a6634fb9817fbd436de74525f", false),
+		Entry("OCI manifest", "oci-manifest.json", MediaTypeOCIManifest,
+			"sha256:ebe4eb678c1f997cd52df36c4786253ba8cf9ae29ab9ef3f3dad8efd6a721c6e", false),
+		Entry("OCI manifest without media type", "oci-manifest-no-mediatype.json", MediaTypeOCIManifest,
+			"sha256:b6ade5bb476ede8d3255879d232f2273c913e6b43b4e85b2f78fa086e1a1199d", false),
+	)
+
+	It("should pass unknown media types through verbatim", func() {
+		manifest, err := ParseManifest([]byte(`{"schemaVersion":2,"mediaType":"application/vnd.example.custom+json","layers":[]}`))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.MediaType).To(Equal("application/vnd.example.custom+json"))
+	})
+
+	It("should expose the config digest of an image manifest as its image ID", func() {
+		manifest, err := ParseManifest(readManifestFixture("docker-manifest.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.ConfigDigest).To(Equal("sha256:9c7a54a9a43cca047013b82af109fe963fde787f63f9e016fdc3384500c2823d"))
+
+		index, err := ParseManifest(readManifestFixture("oci-index.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index.ConfigDigest).To(BeEmpty())
+	})
+
+	It("should expose the children of an index", func() {
+		index, err := ParseManifest(readManifestFixture("oci-index.json"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index.Manifests).To(HaveLen(2))
+		Expect(index.Manifests[1]).To(Equal(Descriptor{
+			MediaType: MediaTypeOCIManifest,
+			Digest:    "sha256:2e1d8b58b8d5d7f1f2ae8d05f02bc2f3bae4c9c1c6b9b4acf5c1d9a7a6a1e3b2",
+			Size:      1234,
+			Platform:  &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		}))
+	})
+
+	It("should expose the base image recorded in the annotations", func() {
+		manifest, err := ParseManifest([]byte(`{"schemaVersion":2,"layers":[],"annotations":{` +
+			`"org.opencontainers.image.base.name":"registry.access.redhat.com/ubi9/ubi-minimal:latest",` +
+			`"org.opencontainers.image.base.digest":"sha256:0d1e2f"}}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		name, digest, ok := manifest.RecordedBaseImage()
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("registry.access.redhat.com/ubi9/ubi-minimal:latest"))
+		Expect(digest).To(Equal("sha256:0d1e2f"))
+	})
+
+	It("should not report a base image recorded without its digest", func() {
+		manifest, err := ParseManifest(readManifestFixture("oci-manifest.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, ok := manifest.RecordedBaseImage()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should reject empty and malformed manifests", func() {
+		_, err := ParseManifest(nil)
+		Expect(err).To(HaveOccurred())
+
+		_, err = ParseManifest([]byte("not json"))
+		Expect(err).To(HaveOccurred())
+	})
+})
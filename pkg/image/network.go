@@ -0,0 +1,43 @@
+package image
+
+import (
+	"fmt"
+)
+
+// Network modes of a build
+const (
+	// NetworkModeOpen gives the build unrestricted network access
+	NetworkModeOpen = "open"
+
+	// NetworkModeNone isolates the build from the network, as hermetic builds are
+	NetworkModeNone = "none"
+
+	// NetworkModeProxyOnly would restrict the build to an HTTP proxy. It is
+	// rejected: the pasta network namespace buildah offers translates every
+	// TCP connection of the build to a host socket, so a client ignoring
+	// HTTP_PROXY would still reach any address. Egress has to be restricted
+	// outside the build, e.g. by a network policy allowing only the proxy.
+	NetworkModeProxyOnly = "proxy-only"
+)
+
+// ValidateNetworkMode rejects unknown network modes and the proxy-only mode,
+// which can't be enforced
+func ValidateNetworkMode(mode string) error {
+	switch mode {
+	case "", NetworkModeOpen, NetworkModeNone:
+		return nil
+	case NetworkModeProxyOnly:
+		return fmt.Errorf("%s network mode is not supported: the build can't be restricted to the proxy, "+
+			"use %s with a network policy allowing only the proxy instead", NetworkModeProxyOnly, NetworkModeOpen)
+	default:
+		return fmt.Errorf("invalid network mode %q, expected %s or %s", mode, NetworkModeOpen, NetworkModeNone)
+	}
+}
+
+// networkArgs returns the buildah build arguments of the network mode
+func networkArgs(config *BuildConfig) []string {
+	if config.NetworkMode == NetworkModeNone {
+		return []string{"--network=none"}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package image
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Network modes", func() {
+	var config *BuildConfig
+
+	BeforeEach(func() {
+		config = &BuildConfig{
+			ImageURL:   "quay.io/test/image:tag",
+			Dockerfile: "./Dockerfile",
+			TLSVerify:  true,
+		}
+	})
+
+	Describe("BuildahBuildCommand", func() {
+		It("should leave the network open by default", func() {
+			for _, mode := range []string{"", NetworkModeOpen} {
+				config.NetworkMode = mode
+				Expect(BuildahBuildCommand(config)).To(Equal([]string{
+					"build", "--file", "./Dockerfile", "--tag", "quay.io/test/image:tag", ".",
+				}))
+			}
+		})
+
+		It("should isolate the build in none mode", func() {
+			config.NetworkMode = NetworkModeNone
+
+			Expect(BuildahBuildCommand(config)).To(Equal([]string{
+				"build", "--file", "./Dockerfile", "--tag", "quay.io/test/image:tag", "--network=none", ".",
+			}))
+		})
+	})
+
+	Describe("ValidateNetworkMode", func() {
+		DescribeTable("should accept",
+			func(mode string) {
+				Expect(ValidateNetworkMode(mode)).To(Succeed())
+			},
+			Entry("the default", ""),
+			Entry("open", NetworkModeOpen),
+			Entry("none", NetworkModeNone),
+		)
+
+		DescribeTable("should reject",
+			func(mode, message string) {
+				Expect(ValidateNetworkMode(mode)).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("an unknown mode", "allowlist", `invalid network mode "allowlist"`),
+			Entry("proxy-only, which can't be enforced", NetworkModeProxyOnly,
+				"proxy-only network mode is not supported: the build can't be restricted to the proxy"),
+		)
+	})
+})
@@ -0,0 +1,150 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// Platform identifies the operating system and CPU an image was built for
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses an os/arch[/variant] platform such as linux/arm64/v8
+func ParsePlatform(value string) (Platform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", value)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", value)
+		}
+	}
+
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// String formats the platform as os/arch[/variant]
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// Same reports whether p and other are the same platform, an arm64 platform
+// without a variant being v8
+func (p Platform) Same(other Platform) bool {
+	return p.normalized() == other.normalized()
+}
+
+// normalized returns the platform with the implied arm64 variant
+func (p Platform) normalized() Platform {
+	if p.Architecture == "arm64" && p.Variant == "" {
+		p.Variant = "v8"
+	}
+	return p
+}
+
+// satisfiedBy reports whether an image built for other is what p requests.
+// The variant is only compared when p has one, an arm64 image without a
+// variant being v8.
+func (p Platform) satisfiedBy(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	if p.Variant == "" {
+		return true
+	}
+	variant := other.Variant
+	if variant == "" && other.Architecture == "arm64" {
+		variant = "v8"
+	}
+	return p.Variant == variant
+}
+
+// indexEntry is a child manifest of an image index
+type indexEntry struct {
+	Digest   string   `json:"digest"`
+	Platform Platform `json:"platform"`
+}
+
+// indexEntries returns the child manifests of a raw image index
+func indexEntries(raw []byte) ([]indexEntry, error) {
+	var index struct {
+		Manifests []indexEntry `json:"manifests"`
+	}
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse image index: %w", err)
+	}
+	return index.Manifests, nil
+}
+
+// platformMismatchError reports an image built for other platforms than requested
+func platformMismatchError(built []string, requested Platform) error {
+	return fmt.Errorf("built %s but %s was requested — is qemu-user-static installed?",
+		strings.Join(built, ", "), requested)
+}
+
+// verifyPlatform fails when the image pushed at ref wasn't built for the
+// requested platform, as happens with cross-arch builds when qemu isn't
+// registered. The matching child of an index is the one verified.
+func verifyPlatform(ctx context.Context, logger *zap.Logger, config *BuildConfig, ref string, runner exec.CommandRunner) error {
+	requested, err := ParsePlatform(config.Platform)
+	if err != nil {
+		return err
+	}
+	client := config.registryClient(runner)
+
+	raw, err := client.RawManifest(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to verify the platform of the pushed image: %w", err)
+	}
+	manifest, err := ParseManifest(raw)
+	if err != nil {
+		return fmt.Errorf("failed to verify the platform of the pushed image: %w", err)
+	}
+
+	if manifest.IsIndex {
+		entries, err := indexEntries(raw)
+		if err != nil {
+			return fmt.Errorf("failed to verify the platform of the pushed image: %w", err)
+		}
+		var available []string
+		var child string
+		for _, entry := range entries {
+			if requested.satisfiedBy(entry.Platform) {
+				child = entry.Digest
+				break
+			}
+			available = append(available, entry.Platform.String())
+		}
+		if child == "" {
+			return platformMismatchError(available, requested)
+		}
+		ref = fmt.Sprintf("%s@%s", Repository(ref), child)
+	}
+
+	built, err := client.Platform(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to verify the platform of the pushed image: %w", err)
+	}
+	if !requested.satisfiedBy(built) {
+		return platformMismatchError([]string{built.String()}, requested)
+	}
+
+	logger.Info("Verified the platform of the pushed image", zap.String("platform", built.String()))
+	return nil
+}
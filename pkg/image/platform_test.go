@@ -0,0 +1,130 @@
+package image
+
+import (
+	"context"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("ParsePlatform", func() {
+	DescribeTable("parsing platforms",
+		func(value string, expected Platform) {
+			platform, err := ParsePlatform(value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform).To(Equal(expected))
+			Expect(platform.String()).To(Equal(value))
+		},
+		Entry("os and architecture", "linux/amd64", Platform{OS: "linux", Architecture: "amd64"}),
+		Entry("with a variant", "linux/arm64/v8", Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}),
+	)
+
+	DescribeTable("rejecting invalid platforms",
+		func(value string) {
+			_, err := ParsePlatform(value)
+			Expect(err).To(MatchError(ContainSubstring("expected os/arch[/variant]")))
+		},
+		Entry("empty", ""),
+		Entry("architecture only", "amd64"),
+		Entry("empty part", "linux//v8"),
+		Entry("too many parts", "linux/arm/v7/extra"),
+	)
+})
+
+var _ = Describe("Platform.Same", func() {
+	DescribeTable("comparing platforms",
+		func(a, b string, same bool) {
+			first, err := ParsePlatform(a)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := ParsePlatform(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first.Same(second)).To(Equal(same))
+		},
+		Entry("identical", "linux/amd64", "linux/amd64", true),
+		Entry("arm64 without a variant is v8", "linux/arm64", "linux/arm64/v8", true),
+		Entry("other variant", "linux/arm/v6", "linux/arm/v7", false),
+		Entry("other architecture", "linux/amd64", "linux/arm64", false),
+	)
+})
+
+var _ = Describe("verifyPlatform", func() {
+	const pushedRef = "quay.io/test/image@sha256:pushed"
+
+	var (
+		mockRunner *exec.MockCommandRunner
+		config     *BuildConfig
+	)
+
+	BeforeEach(func() {
+		mockRunner = exec.NewMockCommandRunner()
+		config = &BuildConfig{ImageURL: "quay.io/test/image:tag", TLSVerify: true, Platform: "linux/arm64"}
+	})
+
+	Context("when the registry returns an image manifest", func() {
+		BeforeEach(func() {
+			mockRunner.SetOutput("skopeo", readManifestFixture("docker-manifest.json"), "inspect", "--raw", "docker://"+pushedRef)
+		})
+
+		It("should accept an image built for the requested platform", func() {
+			mockRunner.SetOutput("skopeo", []byte(`{"Os": "linux", "Architecture": "arm64", "Variant": "v8"}`), "inspect", "docker://"+pushedRef)
+
+			Expect(verifyPlatform(context.Background(), zap.NewNop(), config, pushedRef, mockRunner)).To(Succeed())
+		})
+
+		It("should treat arm64 without a variant as v8", func() {
+			config.Platform = "linux/arm64/v8"
+			mockRunner.SetOutput("skopeo", []byte(`{"Os": "linux", "Architecture": "arm64"}`), "inspect", "docker://"+pushedRef)
+
+			Expect(verifyPlatform(context.Background(), zap.NewNop(), config, pushedRef, mockRunner)).To(Succeed())
+		})
+
+		It("should fail when the image was built for another platform", func() {
+			mockRunner.SetOutput("skopeo", []byte(`{"Os": "linux", "Architecture": "amd64"}`), "inspect", "docker://"+pushedRef)
+
+			err := verifyPlatform(context.Background(), zap.NewNop(), config, pushedRef, mockRunner)
+
+			Expect(err).To(MatchError("built linux/amd64 but linux/arm64 was requested — is qemu-user-static installed?"))
+		})
+
+		It("should fail when the platform can't be inspected", func() {
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"}, "inspect", "docker://"+pushedRef)
+
+			err := verifyPlatform(context.Background(), zap.NewNop(), config, pushedRef, mockRunner)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to verify the platform of the pushed image")))
+		})
+	})
+
+	Context("when the registry returns an index", func() {
+		const childRef = "quay.io/test/image@sha256:2e1d8b58b8d5d7f1f2ae8d05f02bc2f3bae4c9c1c6b9b4acf5c1d9a7a6a1e3b2"
+
+		BeforeEach(func() {
+			mockRunner.SetOutput("skopeo", readManifestFixture("oci-index.json"), "inspect", "--raw", "docker://"+pushedRef)
+		})
+
+		It("should verify the child matching the requested platform", func() {
+			mockRunner.SetOutput("skopeo", []byte(`{"Os": "linux", "Architecture": "arm64", "Variant": "v8"}`), "inspect", "docker://"+childRef)
+
+			Expect(verifyPlatform(context.Background(), zap.NewNop(), config, pushedRef, mockRunner)).To(Succeed())
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "inspect", "docker://"+childRef)).To(BeTrue())
+		})
+
+		It("should fail when the matching child was built for another platform", func() {
+			mockRunner.SetOutput("skopeo", []byte(`{"Os": "linux", "Architecture": "amd64"}`), "inspect", "docker://"+childRef)
+
+			err := verifyPlatform(context.Background(), zap.NewNop(), config, pushedRef, mockRunner)
+
+			Expect(err).To(MatchError(ContainSubstring("built linux/amd64 but linux/arm64 was requested")))
+		})
+
+		It("should fail when no child matches the requested platform", func() {
+			config.Platform = "linux/s390x"
+
+			err := verifyPlatform(context.Background(), zap.NewNop(), config, pushedRef, mockRunner)
+
+			Expect(err).To(MatchError("built linux/amd64, linux/arm64/v8 but linux/s390x was requested — is qemu-user-static installed?"))
+		})
+	})
+})
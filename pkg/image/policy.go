@@ -0,0 +1,189 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PolicyAction is the outcome of a base image policy match
+type PolicyAction string
+
+const (
+	// PolicyActionWarn reports the violation without failing the build
+	PolicyActionWarn PolicyAction = "warn"
+
+	// PolicyActionDeny fails the build
+	PolicyActionDeny PolicyAction = "deny"
+)
+
+// BaseImagePolicy restricts which base images a Dockerfile may use
+type BaseImagePolicy struct {
+	// Allowed lists repository prefixes base images must match. An empty
+	// list allows every repository not matched by a rule.
+	Allowed []string `json:"allowed,omitempty"`
+
+	// AllowlistAction applies to base images outside the allowlist, deny by default
+	AllowlistAction PolicyAction `json:"allowlistAction,omitempty"`
+
+	// Rules match disallowed or deprecated base images
+	Rules []BaseImageRule `json:"rules,omitempty"`
+}
+
+// BaseImageRule matches base images by repository prefix, tag glob and digest.
+// Empty fields match anything; at least one field must be set.
+type BaseImageRule struct {
+	Repository string       `json:"repository,omitempty"`
+	Tag        string       `json:"tag,omitempty"`
+	Digest     string       `json:"digest,omitempty"`
+	Action     PolicyAction `json:"action"`
+	Reason     string       `json:"reason,omitempty"`
+}
+
+// PolicyViolation describes a base image that doesn't satisfy the policy
+type PolicyViolation struct {
+	Line      int          `json:"line"`
+	Reference string       `json:"reference"`
+	Pattern   string       `json:"pattern,omitempty"`
+	Action    PolicyAction `json:"action"`
+	Message   string       `json:"message"`
+
+	// Unresolvable is set when the reference depends on a build argument without a value
+	Unresolvable bool `json:"unresolvable,omitempty"`
+}
+
+// ParseBaseImagePolicy parses a JSON base image policy, returning nil for an empty value
+func ParseBaseImagePolicy(value string) (*BaseImagePolicy, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var policy BaseImagePolicy
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		return nil, fmt.Errorf("invalid base image policy: %w", err)
+	}
+
+	switch policy.AllowlistAction {
+	case "":
+		policy.AllowlistAction = PolicyActionDeny
+	case PolicyActionWarn, PolicyActionDeny:
+	default:
+		return nil, fmt.Errorf("invalid base image policy: unknown allowlist action %q", policy.AllowlistAction)
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Action != PolicyActionWarn && rule.Action != PolicyActionDeny {
+			return nil, fmt.Errorf("invalid base image policy: rule %d has unknown action %q", i, rule.Action)
+		}
+		if rule.Repository == "" && rule.Tag == "" && rule.Digest == "" {
+			return nil, fmt.Errorf("invalid base image policy: rule %d matches nothing", i)
+		}
+		if rule.Tag != "" {
+			if _, err := path.Match(rule.Tag, ""); err != nil {
+				return nil, fmt.Errorf("invalid base image policy: rule %d has malformed tag glob: %w", i, err)
+			}
+		}
+	}
+
+	return &policy, nil
+}
+
+// Evaluate checks base images against the policy and returns the violations.
+// Unresolvable references are reported as warnings since they can't be matched.
+func (p *BaseImagePolicy) Evaluate(bases []BaseImage) []PolicyViolation {
+	var violations []PolicyViolation
+	for _, base := range bases {
+		if !base.Resolved {
+			violations = append(violations, PolicyViolation{
+				Line:         base.Line,
+				Reference:    base.Original,
+				Action:       PolicyActionWarn,
+				Message:      fmt.Sprintf("base image %q on line %d can't be resolved and was not checked", base.Original, base.Line),
+				Unresolvable: true,
+			})
+			continue
+		}
+
+		repository, tag, digest := splitReference(base.Reference)
+
+		if len(p.Allowed) > 0 && !hasAnyPrefix(repository, p.Allowed) {
+			violations = append(violations, PolicyViolation{
+				Line:      base.Line,
+				Reference: base.Reference,
+				Pattern:   strings.Join(p.Allowed, ","),
+				Action:    p.AllowlistAction,
+				Message:   fmt.Sprintf("base image %q on line %d is not in the allowlist", base.Reference, base.Line),
+			})
+			continue
+		}
+
+		for _, rule := range p.Rules {
+			if !rule.matches(repository, tag, digest) {
+				continue
+			}
+
+			message := fmt.Sprintf("base image %q on line %d matches %s", base.Reference, base.Line, rule.pattern())
+			if rule.Reason != "" {
+				message += ": " + rule.Reason
+			}
+			violations = append(violations, PolicyViolation{
+				Line:      base.Line,
+				Reference: base.Reference,
+				Pattern:   rule.pattern(),
+				Action:    rule.Action,
+				Message:   message,
+			})
+			break
+		}
+	}
+
+	return violations
+}
+
+func (r BaseImageRule) matches(repository, tag, digest string) bool {
+	if r.Repository != "" && !strings.HasPrefix(repository, r.Repository) {
+		return false
+	}
+	if r.Tag != "" {
+		if matched, _ := path.Match(r.Tag, tag); !matched {
+			return false
+		}
+	}
+	if r.Digest != "" && r.Digest != digest {
+		return false
+	}
+	return true
+}
+
+// pattern renders the rule as a reference-like pattern for messages
+func (r BaseImageRule) pattern() string {
+	pattern := r.Repository
+	if r.Tag != "" {
+		pattern += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		pattern += "@" + r.Digest
+	}
+	return pattern
+}
+
+// splitReference splits an image reference into repository, tag and digest
+func splitReference(reference string) (string, string, string) {
+	_, digest, _ := strings.Cut(reference, "@")
+	repository := Repository(reference)
+
+	withoutDigest, _, _ := strings.Cut(reference, "@")
+	tag := strings.TrimPrefix(strings.TrimPrefix(withoutDigest, repository), ":")
+
+	return repository, tag, digest
+}
+
+func hasAnyPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package image
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BaseImagePolicy", func() {
+	base := func(reference string) BaseImage {
+		return BaseImage{Line: 1, Original: reference, Reference: reference, Resolved: true}
+	}
+
+	DescribeTable("should match rules",
+		func(rule BaseImageRule, reference string, expectViolation bool) {
+			policy := &BaseImagePolicy{Rules: []BaseImageRule{rule}}
+
+			violations := policy.Evaluate([]BaseImage{base(reference)})
+
+			if expectViolation {
+				Expect(violations).To(HaveLen(1))
+				Expect(violations[0].Action).To(Equal(rule.Action))
+			} else {
+				Expect(violations).To(BeEmpty())
+			}
+		},
+		Entry("repository prefix match",
+			BaseImageRule{Repository: "registry.access.redhat.com/ubi7", Action: PolicyActionDeny},
+			"registry.access.redhat.com/ubi7/ubi:7.9", true),
+		Entry("repository prefix mismatch",
+			BaseImageRule{Repository: "registry.access.redhat.com/ubi7", Action: PolicyActionDeny},
+			"registry.access.redhat.com/ubi9/ubi:9.4", false),
+		Entry("tag glob match",
+			BaseImageRule{Repository: "docker.io/library/node", Tag: "1[0-4]*", Action: PolicyActionWarn},
+			"docker.io/library/node:14-alpine", true),
+		Entry("tag glob mismatch",
+			BaseImageRule{Repository: "docker.io/library/node", Tag: "1[0-4]*", Action: PolicyActionWarn},
+			"docker.io/library/node:20", false),
+		Entry("digest match",
+			BaseImageRule{Digest: "sha256:bad", Action: PolicyActionDeny},
+			"quay.io/test/image:1@sha256:bad", true),
+		Entry("digest mismatch",
+			BaseImageRule{Digest: "sha256:bad", Action: PolicyActionDeny},
+			"quay.io/test/image@sha256:good", false),
+		Entry("registry port is not a tag",
+			BaseImageRule{Repository: "localhost:5000/test", Tag: "latest", Action: PolicyActionWarn},
+			"localhost:5000/test/image", false),
+	)
+
+	It("should apply the allowlist action to repositories outside the allowlist", func() {
+		policy, err := ParseBaseImagePolicy(`{"allowed":["registry.access.redhat.com/"],"allowlistAction":"warn"}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		violations := policy.Evaluate([]BaseImage{
+			base("registry.access.redhat.com/ubi9/ubi"),
+			base("docker.io/library/alpine:3"),
+		})
+
+		Expect(violations).To(HaveLen(1))
+		Expect(violations[0].Reference).To(Equal("docker.io/library/alpine:3"))
+		Expect(violations[0].Action).To(Equal(PolicyActionWarn))
+	})
+
+	It("should report unresolvable references as distinct warnings", func() {
+		policy := &BaseImagePolicy{Rules: []BaseImageRule{{Repository: "quay.io", Action: PolicyActionDeny}}}
+
+		violations := policy.Evaluate([]BaseImage{{Line: 3, Original: "${BASE}", Reference: "${BASE}"}})
+
+		Expect(violations).To(HaveLen(1))
+		Expect(violations[0].Unresolvable).To(BeTrue())
+		Expect(violations[0].Action).To(Equal(PolicyActionWarn))
+	})
+
+	DescribeTable("should reject invalid policies",
+		func(value string) {
+			_, err := ParseBaseImagePolicy(value)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("malformed JSON", `{"rules":`),
+		Entry("unknown action", `{"rules":[{"repository":"quay.io","action":"block"}]}`),
+		Entry("empty rule", `{"rules":[{"action":"deny"}]}`),
+		Entry("malformed glob", `{"rules":[{"tag":"[","action":"deny"}]}`),
+		Entry("unknown allowlist action", `{"allowed":["quay.io"],"allowlistAction":"block"}`),
+	)
+
+	It("should return nil for an empty policy", func() {
+		policy, err := ParseBaseImagePolicy("")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy).To(BeNil())
+	})
+})
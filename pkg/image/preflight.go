@@ -0,0 +1,59 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// preflightSuffix is appended to the tag the push preflight pushes to
+const preflightSuffix = "-preflight"
+
+// ErrPreflightCleanup is returned by CheckPushAccess when push access was
+// verified but the preflight tag couldn't be deleted
+var ErrPreflightCleanup = errors.New("failed to delete the preflight tag")
+
+// PreflightReference returns the <tag>-preflight reference of an image, the
+// tag shortened to keep the preflight tag within the registry limit
+func PreflightReference(imageURL string) string {
+	tag := Tag(imageURL)
+	if len(tag)+len(preflightSuffix) > maxTagLength {
+		tag = tag[:maxTagLength-len(preflightSuffix)]
+	}
+	return Repository(imageURL) + ":" + tag + preflightSuffix
+}
+
+// CheckPushAccess verifies that the credentials can push to the repository
+// of imageURL by pushing an empty manifest list, a few hundred bytes without
+// blobs, to its preflight reference. The pushed tag and the local list are
+// removed whatever the outcome; an error wrapping ErrPreflightCleanup means
+// that only deleting the tag failed.
+func CheckPushAccess(ctx context.Context, logger *zap.Logger, imageURL string, tlsVerify bool, runner exec.CommandRunner) error {
+	ref := PreflightReference(imageURL)
+	logger.Info("Checking push access", zap.String("preflight_reference", ref))
+
+	if err := runner.Run(ctx, "buildah", "manifest", "create", ref); err != nil {
+		return fmt.Errorf("failed to create preflight manifest: %w", err)
+	}
+	defer func() {
+		if err := runner.Run(ctx, "buildah", "manifest", "rm", ref); err != nil {
+			logger.Warn("Failed to remove the local preflight manifest", zap.String("manifest", ref), zap.Error(err))
+		}
+	}()
+
+	pushArgs := []string{"manifest", "push", "--all", ref, "docker://" + ref}
+	if !tlsVerify {
+		pushArgs = append(pushArgs, "--tls-verify=false")
+	}
+	if err := runner.Run(ctx, "buildah", pushArgs...); err != nil {
+		return fmt.Errorf("cannot push to %s with the provided credentials: %w", Repository(imageURL), err)
+	}
+
+	if err := runner.Run(ctx, "skopeo", SkopeoDeleteCommand(ref, tlsVerify)...); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrPreflightCleanup, ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package image
+
+import (
+	"context"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("CheckPushAccess", func() {
+	const preflightRef = "quay.io/test/image:v1-preflight"
+
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+	})
+
+	It("should push an empty manifest list to the preflight tag and delete it", func() {
+		Expect(CheckPushAccess(ctx, zap.NewNop(), "quay.io/test/image:v1", true, mockRunner)).To(Succeed())
+
+		Expect(mockRunner.GetExecutedCommands()).To(Equal([][]string{
+			{"buildah", "manifest", "create", preflightRef},
+			{"buildah", "manifest", "push", "--all", preflightRef, "docker://" + preflightRef},
+			{"skopeo", "delete", "docker://" + preflightRef},
+			{"buildah", "manifest", "rm", preflightRef},
+		}))
+	})
+
+	It("should disable TLS verification of the push and the delete", func() {
+		Expect(CheckPushAccess(ctx, zap.NewNop(), "registry.local:5000/test/image:v1", false, mockRunner)).To(Succeed())
+
+		ref := "registry.local:5000/test/image:v1-preflight"
+		Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", ref, "docker://"+ref, "--tls-verify=false")).To(BeTrue())
+		Expect(mockRunner.AssertCommandExecuted("skopeo", "delete", "--tls-verify=false", "docker://"+ref)).To(BeTrue())
+	})
+
+	It("should report that the credentials can't push and remove the local manifest", func() {
+		mockRunner.SetError("buildah", &exec.CommandError{ExitCode: 125, Message: "requested access to the resource is denied"},
+			"manifest", "push", "--all", preflightRef, "docker://"+preflightRef)
+
+		err := CheckPushAccess(ctx, zap.NewNop(), "quay.io/test/image:v1", true, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("cannot push to quay.io/test/image with the provided credentials")))
+		Expect(err).NotTo(MatchError(ErrPreflightCleanup))
+		Expect(mockRunner.AssertCommandExecuted("skopeo", "delete", "docker://"+preflightRef)).To(BeFalse())
+		Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", preflightRef)).To(BeTrue())
+	})
+
+	It("should report a preflight tag that couldn't be deleted", func() {
+		mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+			"delete", "docker://"+preflightRef)
+
+		err := CheckPushAccess(ctx, zap.NewNop(), "quay.io/test/image:v1", true, mockRunner)
+
+		Expect(err).To(MatchError(ErrPreflightCleanup))
+		Expect(err).To(MatchError(ContainSubstring(preflightRef)))
+		Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", preflightRef)).To(BeTrue())
+	})
+
+	Describe("PreflightReference", func() {
+		It("should suffix the tag, latest when there is none", func() {
+			Expect(PreflightReference("registry.local:5000/test/image")).To(Equal("registry.local:5000/test/image:latest-preflight"))
+			Expect(PreflightReference("quay.io/test/image:v1@sha256:abc")).To(Equal("quay.io/test/image:v1-preflight"))
+		})
+
+		It("should keep the preflight tag within the registry limit", func() {
+			ref := PreflightReference("quay.io/test/image:" + strings.Repeat("a", 128))
+
+			Expect(Tag(ref)).To(HaveLen(128))
+			Expect(ref).To(HaveSuffix("-preflight"))
+		})
+	})
+})
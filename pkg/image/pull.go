@@ -0,0 +1,84 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"go.uber.org/zap"
+)
+
+// PullReference returns the reference a base image is pulled by. Digest-pinned
+// references are pulled by digest alone so the tag can't select other content.
+func PullReference(reference string) string {
+	if _, digest, found := strings.Cut(reference, "@"); found {
+		return Repository(reference) + "@" + digest
+	}
+	return reference
+}
+
+// BuildahPullCommand builds the buildah pull command arguments for a base image
+func BuildahPullCommand(config *BuildConfig, reference string) []string {
+	args := []string{"pull"}
+
+	if config.AuthFile != "" {
+		args = append(args, "--authfile", config.AuthFile)
+	}
+	if config.CertDir != "" {
+		args = append(args, "--cert-dir", config.CertDir)
+	}
+	if !EffectiveTLSVerify(reference, config.TLSVerify, config.InsecureRegistries) {
+		args = append(args, "--tls-verify=false")
+	}
+
+	args = append(args, reference)
+	return config.buildahArgs(args)
+}
+
+// dockerfilePath returns the location of the Dockerfile, which is relative to the build context
+func (c *BuildConfig) dockerfilePath() string {
+	if filepath.IsAbs(c.Dockerfile) {
+		return c.Dockerfile
+	}
+	return filepath.Join(c.Context, c.Dockerfile)
+}
+
+// prePullBaseImages pulls the base images of the Dockerfile in the order they
+// appear, so a network-isolated build finds them in local storage. Pulls run
+// under the same unshare wrapper and environment as the build to share its
+// storage.
+func prePullBaseImages(ctx context.Context, logger *zap.Logger, config *BuildConfig, env []string, runner exec.CommandRunner) error {
+	instructions, err := ParseDockerfileFile(config.dockerfilePath())
+	if err != nil {
+		return fmt.Errorf("failed to read base images: %w", err)
+	}
+
+	pulled := make(map[string]bool)
+	for _, base := range BaseImages(instructions, config.BuildArgs) {
+		if !base.Resolved {
+			return fmt.Errorf("failed to pre-pull base image %s on line %d: it uses a build argument without a value",
+				base.Original, base.Line)
+		}
+
+		reference := PullReference(base.Reference)
+		if pulled[reference] {
+			continue
+		}
+		pulled[reference] = true
+
+		logger.Info("Pre-pulling base image", zap.String("reference", reference), zap.Int("line", base.Line))
+		start := time.Now()
+		pullCmd := UnshareCommandWithEnv(BuildahPullCommand(config, reference), config.Context, env, config.UnshareIDMaps)
+		if err := runner.Run(ctx, pullCmd[0], pullCmd[1:]...); err != nil {
+			return fmt.Errorf("failed to pre-pull base image %s on line %d: %w", reference, base.Line, err)
+		}
+		logger.Info("Pre-pulled base image",
+			zap.String("reference", reference),
+			zap.Duration("duration", time.Since(start)))
+	}
+
+	return nil
+}
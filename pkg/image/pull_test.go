@@ -0,0 +1,138 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("PullReference", func() {
+	DescribeTable("should pull digest-pinned references by digest",
+		func(reference, expected string) {
+			Expect(PullReference(reference)).To(Equal(expected))
+		},
+		Entry("tag", "registry.access.redhat.com/ubi9/ubi:latest", "registry.access.redhat.com/ubi9/ubi:latest"),
+		Entry("digest", "quay.io/test/base@sha256:abc", "quay.io/test/base@sha256:abc"),
+		Entry("tag and digest", "quay.io/test/base:v1@sha256:abc", "quay.io/test/base@sha256:abc"),
+		Entry("registry port", "localhost:5000/base:v1@sha256:abc", "localhost:5000/base@sha256:abc"),
+	)
+})
+
+var _ = Describe("BuildahPullCommand", func() {
+	It("should pass the authfile, cert dir and TLS settings", func() {
+		config := &BuildConfig{
+			TLSVerify:          true,
+			AuthFile:           "/auth/config.json",
+			CertDir:            "/certs",
+			InsecureRegistries: []string{"localhost:5000"},
+		}
+
+		Expect(BuildahPullCommand(config, "quay.io/test/base:v1")).To(Equal([]string{
+			"pull", "--authfile", "/auth/config.json", "--cert-dir", "/certs", "quay.io/test/base:v1",
+		}))
+		Expect(BuildahPullCommand(config, "localhost:5000/base:v1")).To(Equal([]string{
+			"pull", "--authfile", "/auth/config.json", "--cert-dir", "/certs", "--tls-verify=false", "localhost:5000/base:v1",
+		}))
+	})
+})
+
+var _ = Describe("BuildAndPush base image pre-pull", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *BuildConfig
+	)
+
+	// buildahCommands returns the buildah subcommand and last argument of each unshare command in order
+	buildahCommands := func() []string {
+		var commands []string
+		for _, cmd := range mockRunner.GetExecutedCommands() {
+			if cmd[0] != "unshare" {
+				continue
+			}
+			fields := strings.Fields(cmd[len(cmd)-1])
+			commands = append(commands, strings.Trim(fields[1], `'`)+" "+strings.Trim(fields[len(fields)-1], `'`))
+		}
+		return commands
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		mockRunner.DefaultOutput = []byte(`{"Digest": "sha256:abcdef"}`)
+
+		buildContext := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(buildContext, "Dockerfile"), []byte(
+			"ARG BASE=quay.io/test/base:v1\n"+
+				"FROM $BASE AS builder\n"+
+				"FROM registry.access.redhat.com/ubi9/ubi-minimal:latest@sha256:123\n"+
+				"COPY --from=builder /app /app\n"+
+				"FROM builder\n"+
+				"FROM quay.io/test/base:v1\n"+
+				"FROM scratch\n"), 0644)).To(Succeed())
+
+		config = &BuildConfig{
+			ImageURL:      "quay.io/test/image:latest",
+			Dockerfile:    "./Dockerfile",
+			Context:       buildContext,
+			TLSVerify:     true,
+			Hermetic:      true,
+			PrefetchInput: "gomod",
+			PrefetchPath:  "/workspace/cachi2",
+		}
+	})
+
+	It("should pull each base image once, by digest when pinned, before the isolated build", func() {
+		_, err := BuildAndPush(ctx, zap.NewNop(), config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buildahCommands()).To(Equal([]string{
+			"pull quay.io/test/base:v1",
+			"pull registry.access.redhat.com/ubi9/ubi-minimal@sha256:123",
+			"build .",
+		}))
+	})
+
+	It("should substitute build arguments in base references", func() {
+		config.BuildArgs = []string{"BASE=quay.io/test/other:v2"}
+
+		_, err := BuildAndPush(ctx, zap.NewNop(), config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buildahCommands()[0]).To(Equal("pull quay.io/test/other:v2"))
+	})
+
+	It("should name the base image that couldn't be pre-pulled and not build", func() {
+		pullCmd := UnshareCommand(BuildahPullCommand(config, "registry.access.redhat.com/ubi9/ubi-minimal@sha256:123"), config.Context, IDMaps{})
+		mockRunner.SetError(pullCmd[0], &exec.CommandError{ExitCode: 125, Message: "manifest unknown"}, pullCmd[1:]...)
+
+		_, err := BuildAndPush(ctx, zap.NewNop(), config, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring(
+			"failed to pre-pull base image registry.access.redhat.com/ubi9/ubi-minimal@sha256:123 on line 3")))
+		Expect(buildahCommands()).NotTo(ContainElement("build ."))
+	})
+
+	It("should fail for base images using a build argument without a value", func() {
+		Expect(os.WriteFile(filepath.Join(config.Context, "Dockerfile"), []byte("ARG BASE\nFROM $BASE\n"), 0644)).To(Succeed())
+
+		_, err := BuildAndPush(ctx, zap.NewNop(), config, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to pre-pull base image $BASE on line 2")))
+	})
+
+	It("should not pull base images for a non-hermetic build", func() {
+		config.Hermetic = false
+
+		_, err := BuildAndPush(ctx, zap.NewNop(), config, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buildahCommands()).To(Equal([]string{"build ."}))
+	})
+})
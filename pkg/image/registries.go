@@ -0,0 +1,135 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SystemRegistriesConf is the containers registries configuration extended for insecure registries and mirrors
+const SystemRegistriesConf = "/etc/containers/registries.conf"
+
+// RegistryHost returns the registry host of an image reference, defaulting to docker.io
+func RegistryHost(imageURL string) string {
+	imageURL = strings.TrimPrefix(imageURL, "docker://")
+	host, _, found := strings.Cut(imageURL, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return "docker.io"
+	}
+	return strings.ToLower(host)
+}
+
+// IsInsecureRegistry reports whether the registry of an image is one of the insecure hosts
+func IsInsecureRegistry(imageURL string, insecureRegistries []string) bool {
+	host := RegistryHost(imageURL)
+	for _, insecure := range insecureRegistries {
+		if strings.EqualFold(strings.TrimSpace(insecure), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLocalRegistry reports whether a registry host, with an optional port, is
+// a loopback or in-cluster registry such as the ones of kind or minikube:
+// localhost, a loopback address or a Kubernetes service name
+func IsLocalRegistry(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return strings.HasSuffix(host, ".svc") || strings.HasSuffix(host, ".svc.cluster.local")
+}
+
+// AddLocalRegistries appends the local registry hosts of imageURLs missing
+// from insecureRegistries, so that pushes to them skip TLS verification and
+// fall back to plain HTTP
+func AddLocalRegistries(insecureRegistries []string, imageURLs ...string) []string {
+	for _, imageURL := range imageURLs {
+		if imageURL == "" {
+			continue
+		}
+		host := RegistryHost(imageURL)
+		if IsLocalRegistry(host) && !IsInsecureRegistry(imageURL, insecureRegistries) {
+			insecureRegistries = append(insecureRegistries, host)
+		}
+	}
+	return insecureRegistries
+}
+
+// EffectiveTLSVerify returns whether TLS should be verified for an image,
+// which is never the case for insecure registries
+func EffectiveTLSVerify(imageURL string, tlsVerify bool, insecureRegistries []string) bool {
+	return tlsVerify && !IsInsecureRegistry(imageURL, insecureRegistries)
+}
+
+// RegistriesConf appends registry entries marking the given hosts insecure,
+// and routing the pulls of the source registries of mirrors through their
+// mirror, to the base registries.conf content
+func RegistriesConf(base string, hosts []string, mirrors map[string]string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	if base != "" && !strings.HasSuffix(base, "\n") {
+		b.WriteString("\n")
+	}
+
+	writeMirror := func(source string) {
+		mirror, ok := mirrors[source]
+		if !ok {
+			return
+		}
+		fmt.Fprintf(&b, "\n[[registry.mirror]]\nlocation = %q\n", mirror)
+		if IsInsecureRegistry(mirror+"/", hosts) {
+			b.WriteString("insecure = true\n")
+		}
+	}
+
+	// A registry is declared once, so insecure sources hold their mirror
+	declared := make(map[string]bool)
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "\n[[registry]]\nlocation = %q\ninsecure = true\n", host)
+		writeMirror(host)
+		declared[host] = true
+	}
+
+	sources := make([]string, 0, len(mirrors))
+	for source := range mirrors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if declared[source] {
+			continue
+		}
+		fmt.Fprintf(&b, "\n[[registry]]\nlocation = %q\n", source)
+		writeMirror(source)
+	}
+	return b.String()
+}
+
+// WriteRegistriesConf writes a registries.conf extending the system
+// configuration with the insecure hosts and the mirrors into dir and returns
+// its path. The system configuration itself is never modified.
+func WriteRegistriesConf(dir, systemConf string, hosts []string, mirrors map[string]string) (string, error) {
+	base, err := os.ReadFile(systemConf)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s: %w", systemConf, err)
+	}
+
+	path := filepath.Join(dir, "registries.conf")
+	if err := os.WriteFile(path, []byte(RegistriesConf(string(base), hosts, mirrors)), 0644); err != nil {
+		return "", fmt.Errorf("failed to write registries.conf: %w", err)
+	}
+	return path, nil
+}
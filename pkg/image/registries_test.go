@@ -0,0 +1,140 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Insecure registries", func() {
+	DescribeTable("RegistryHost",
+		func(imageURL, expected string) {
+			Expect(RegistryHost(imageURL)).To(Equal(expected))
+		},
+		Entry("registry with domain", "quay.io/org/image:tag", "quay.io"),
+		Entry("registry with port", "registry.dev:5000/image@sha256:abc", "registry.dev:5000"),
+		Entry("localhost", "localhost/image", "localhost"),
+		Entry("docker hub shorthand", "library/ubuntu:22.04", "docker.io"),
+		Entry("single name", "ubuntu", "docker.io"),
+		Entry("docker transport prefix", "docker://Registry.Dev/image", "registry.dev"),
+	)
+
+	DescribeTable("EffectiveTLSVerify",
+		func(imageURL string, tlsVerify bool, expected bool) {
+			insecure := []string{"registry.dev:5000", "plain.example.com"}
+			Expect(EffectiveTLSVerify(imageURL, tlsVerify, insecure)).To(Equal(expected))
+		},
+		Entry("insecure host", "registry.dev:5000/image:tag", true, false),
+		Entry("insecure host matched case-insensitively", "PLAIN.example.com/image", true, false),
+		Entry("same host on another port keeps verification", "registry.dev/image:tag", true, true),
+		Entry("other host keeps verification", "quay.io/org/image:tag", true, true),
+		Entry("verification disabled globally", "quay.io/org/image:tag", false, false),
+	)
+
+	DescribeTable("IsLocalRegistry",
+		func(host string, expected bool) {
+			Expect(IsLocalRegistry(host)).To(Equal(expected))
+		},
+		Entry("localhost", "localhost", true),
+		Entry("localhost with port", "localhost:5000", true),
+		Entry("localhost subdomain", "kind-registry.localhost:5000", true),
+		Entry("loopback address", "127.0.0.1:5000", true),
+		Entry("other loopback address", "127.0.1.1", true),
+		Entry("IPv6 loopback", "[::1]:5000", true),
+		Entry("service", "registry.kube-system.svc:5000", true),
+		Entry("fully qualified service", "registry.kube-system.svc.cluster.local", true),
+		Entry("public registry", "quay.io", false),
+		Entry("private network address", "10.0.0.5:5000", false),
+		Entry("service-like path elsewhere", "svc.example.com", false),
+		Entry("docker hub", "docker.io", false),
+	)
+
+	Describe("AddLocalRegistries", func() {
+		It("should add the local registries of the images once", func() {
+			insecure := AddLocalRegistries([]string{"registry.dev:5000"},
+				"localhost:5000/app:latest",
+				"docker://localhost:5000/app:v1",
+				"registry.ns.svc:5000/app",
+				"quay.io/org/app:tag",
+				"registry.dev:5000/app",
+				"")
+
+			Expect(insecure).To(Equal([]string{"registry.dev:5000", "localhost:5000", "registry.ns.svc:5000"}))
+		})
+
+		It("should keep strict TLS for the other registries", func() {
+			insecure := AddLocalRegistries(nil, "quay.io/org/app:tag", "registry.example.com:5000/app")
+
+			Expect(insecure).To(BeEmpty())
+			Expect(EffectiveTLSVerify("quay.io/org/app:tag", true, insecure)).To(BeTrue())
+		})
+	})
+
+	Describe("RegistriesConf", func() {
+		It("should append insecure registry entries to the base configuration", func() {
+			conf := RegistriesConf(`unqualified-search-registries = ["registry.fedoraproject.org"]`,
+				[]string{"registry.dev:5000", "plain.example.com"}, nil)
+
+			Expect(conf).To(Equal(`unqualified-search-registries = ["registry.fedoraproject.org"]` + "\n" +
+				"\n[[registry]]\nlocation = \"registry.dev:5000\"\ninsecure = true\n" +
+				"\n[[registry]]\nlocation = \"plain.example.com\"\ninsecure = true\n"))
+		})
+	})
+
+	Describe("RegistriesConf with mirrors", func() {
+		It("should route the pulls of the source registries through their mirror", func() {
+			conf := RegistriesConf("", nil, map[string]string{
+				"quay.io":   "quay-mirror.internal.example.com",
+				"docker.io": "dockerhub-mirror.internal.example.com/dockerhub",
+			})
+
+			Expect(conf).To(Equal(
+				"\n[[registry]]\nlocation = \"docker.io\"\n" +
+					"\n[[registry.mirror]]\nlocation = \"dockerhub-mirror.internal.example.com/dockerhub\"\n" +
+					"\n[[registry]]\nlocation = \"quay.io\"\n" +
+					"\n[[registry.mirror]]\nlocation = \"quay-mirror.internal.example.com\"\n"))
+		})
+
+		It("should declare an insecure source registry once and keep its mirror", func() {
+			conf := RegistriesConf("", []string{"registry.dev:5000", "mirror.dev:5000"},
+				map[string]string{"registry.dev:5000": "mirror.dev:5000"})
+
+			Expect(conf).To(Equal(
+				"\n[[registry]]\nlocation = \"registry.dev:5000\"\ninsecure = true\n" +
+					"\n[[registry.mirror]]\nlocation = \"mirror.dev:5000\"\ninsecure = true\n" +
+					"\n[[registry]]\nlocation = \"mirror.dev:5000\"\ninsecure = true\n"))
+		})
+	})
+
+	Describe("WriteRegistriesConf", func() {
+		It("should extend the system configuration without modifying it", func() {
+			dir := GinkgoT().TempDir()
+			systemConf := filepath.Join(GinkgoT().TempDir(), "registries.conf")
+			Expect(os.WriteFile(systemConf, []byte("short-name-mode = \"enforcing\"\n"), 0644)).To(Succeed())
+
+			path, err := WriteRegistriesConf(dir, systemConf, []string{"registry.dev:5000"}, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(dir, "registries.conf")))
+			content, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("short-name-mode = \"enforcing\"\n" +
+				"\n[[registry]]\nlocation = \"registry.dev:5000\"\ninsecure = true\n"))
+
+			system, err := os.ReadFile(systemConf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(system)).To(Equal("short-name-mode = \"enforcing\"\n"))
+		})
+
+		It("should work without a system configuration", func() {
+			path, err := WriteRegistriesConf(GinkgoT().TempDir(), "/nonexistent/registries.conf", []string{"localhost:5000"}, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			content, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("\n[[registry]]\nlocation = \"localhost:5000\"\ninsecure = true\n"))
+		})
+	})
+})
@@ -0,0 +1,129 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+)
+
+// RegistryOptions configures how a RegistryClient reaches registries
+type RegistryOptions struct {
+	// TLSVerify verifies TLS certificates of every registry
+	TLSVerify bool
+
+	// InsecureRegistries lists registry hosts reached without TLS verification
+	// even when TLSVerify is set
+	InsecureRegistries []string
+}
+
+// manifestUnknownPattern matches the stderr of skopeo for a reference the
+// registry doesn't have
+var manifestUnknownPattern = regexp.MustCompile(`(?i)manifest unknown|name unknown|not found`)
+
+// IsManifestUnknown reports whether a failed skopeo command was told by the
+// registry that the reference doesn't exist, rather than failing to reach it
+func IsManifestUnknown(err error) bool {
+	var cmdErr *exec.CommandError
+	return errors.As(err, &cmdErr) && manifestUnknownPattern.MatchString(cmdErr.Message)
+}
+
+// RegistryClient queries and copies images in registries through skopeo
+type RegistryClient struct {
+	runner  exec.CommandRunner
+	options RegistryOptions
+}
+
+// NewRegistryClient creates a registry client running skopeo with runner
+func NewRegistryClient(runner exec.CommandRunner, options RegistryOptions) *RegistryClient {
+	return &RegistryClient{
+		runner:  runner,
+		options: options,
+	}
+}
+
+// tlsVerify returns whether TLS is verified for the registry of ref
+func (c *RegistryClient) tlsVerify(ref string) bool {
+	return EffectiveTLSVerify(ref, c.options.TLSVerify, c.options.InsecureRegistries)
+}
+
+// RawManifest returns the raw manifest of an image. An error means the image
+// doesn't exist or the registry couldn't be reached.
+func (c *RegistryClient) RawManifest(ctx context.Context, ref string) ([]byte, error) {
+	args := SkopeoExistsCommand(ref, c.tlsVerify(ref))
+
+	output, err := c.runner.RunWithOutput(ctx, "skopeo", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest of %s: %w", ref, err)
+	}
+
+	return output, nil
+}
+
+// Exists reports whether an image exists in the registry. Registry errors
+// are reported as a missing image.
+func (c *RegistryClient) Exists(ctx context.Context, ref string) (bool, error) {
+	_, err := c.RawManifest(ctx, ref)
+	return err == nil, nil
+}
+
+// ManifestDigest retrieves the manifest digest of an image from the registry
+func (c *RegistryClient) ManifestDigest(ctx context.Context, ref string) (string, error) {
+	args := SkopeoInspectCommand(ref, c.tlsVerify(ref))
+
+	output, err := c.runner.RunWithOutput(ctx, "skopeo", args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+
+	var result struct {
+		Digest string `json:"Digest"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return "", fmt.Errorf("failed to parse skopeo output: %w", err)
+	}
+	if result.Digest == "" {
+		return "", fmt.Errorf("digest not found in skopeo output")
+	}
+
+	return result.Digest, nil
+}
+
+// Platform retrieves the platform of an image from its configuration
+func (c *RegistryClient) Platform(ctx context.Context, ref string) (Platform, error) {
+	args := SkopeoInspectCommand(ref, c.tlsVerify(ref))
+
+	output, err := c.runner.RunWithOutput(ctx, "skopeo", args...)
+	if err != nil {
+		return Platform{}, fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+
+	var result struct {
+		Os           string
+		Architecture string
+		Variant      string
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return Platform{}, fmt.Errorf("failed to parse skopeo output: %w", err)
+	}
+	if result.Os == "" || result.Architecture == "" {
+		return Platform{}, fmt.Errorf("platform not found in skopeo output")
+	}
+
+	return Platform{OS: result.Os, Architecture: result.Architecture, Variant: result.Variant}, nil
+}
+
+// Copy copies an image between registry references without changing its
+// digest. TLS is only verified when it is verified for both registries.
+func (c *RegistryClient) Copy(ctx context.Context, src, dst string) error {
+	args := SkopeoCopyCommand(src, dst, c.tlsVerify(src) && c.tlsVerify(dst))
+
+	if err := c.runner.Run(ctx, "skopeo", args...); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+
+	return nil
+}
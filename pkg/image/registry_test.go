@@ -0,0 +1,165 @@
+package image
+
+import (
+	"context"
+	"errors"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RegistryClient", func() {
+	var (
+		mockRunner *exec.MockCommandRunner
+		client     *RegistryClient
+		ctx        context.Context
+	)
+
+	BeforeEach(func() {
+		mockRunner = exec.NewMockCommandRunner()
+		client = NewRegistryClient(mockRunner, RegistryOptions{TLSVerify: true})
+		ctx = context.Background()
+	})
+
+	Describe("RawManifest", func() {
+		It("should return the raw bytes from skopeo inspect --raw", func() {
+			raw := readManifestFixture("oci-index.json")
+			mockRunner.SetOutput("skopeo", raw, "inspect", "--raw", "docker://quay.io/test/image:tag")
+
+			output, err := client.RawManifest(ctx, "quay.io/test/image:tag")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal(raw))
+		})
+
+		It("should fail when the image doesn't exist", func() {
+			mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "manifest unknown"}
+
+			_, err := client.RawManifest(ctx, "quay.io/test/image:tag")
+
+			Expect(err).To(MatchError(ContainSubstring("failed to fetch manifest of quay.io/test/image:tag")))
+		})
+
+		It("should skip TLS verification for insecure registries", func() {
+			client = NewRegistryClient(mockRunner, RegistryOptions{
+				TLSVerify:          true,
+				InsecureRegistries: []string{"localhost:5000"},
+			})
+			mockRunner.SetOutput("skopeo", []byte("{}"), "inspect", "--raw", "--tls-verify=false", "docker://localhost:5000/test/image:tag")
+
+			_, err := client.RawManifest(ctx, "localhost:5000/test/image:tag")
+
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("Exists", func() {
+		It("should report an existing image", func() {
+			mockRunner.SetOutput("skopeo", []byte("{}"), "inspect", "--raw", "docker://quay.io/test/image:tag")
+
+			exists, err := client.Exists(ctx, "quay.io/test/image:tag")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeTrue())
+		})
+
+		It("should report a missing image without an error", func() {
+			mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "manifest unknown"}
+
+			exists, err := client.Exists(ctx, "quay.io/test/image:tag")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+	})
+
+	Describe("ManifestDigest", func() {
+		It("should return the digest from skopeo inspect", func() {
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:abc123"}`), "inspect", "docker://quay.io/test/image:tag")
+
+			digest, err := client.ManifestDigest(ctx, "quay.io/test/image:tag")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digest).To(Equal("sha256:abc123"))
+		})
+
+		It("should disable TLS verification when configured", func() {
+			client = NewRegistryClient(mockRunner, RegistryOptions{TLSVerify: false})
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:abc123"}`), "inspect", "--tls-verify=false", "docker://quay.io/test/image:tag")
+
+			digest, err := client.ManifestDigest(ctx, "quay.io/test/image:tag")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digest).To(Equal("sha256:abc123"))
+		})
+
+		It("should fail when skopeo fails", func() {
+			mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "unauthorized"}
+
+			_, err := client.ManifestDigest(ctx, "quay.io/test/image:tag")
+
+			Expect(err).To(MatchError(ContainSubstring("failed to inspect image quay.io/test/image:tag")))
+		})
+
+		It("should fail on unparsable output", func() {
+			mockRunner.DefaultOutput = []byte("not json")
+
+			_, err := client.ManifestDigest(ctx, "quay.io/test/image:tag")
+
+			Expect(err).To(MatchError(ContainSubstring("failed to parse skopeo output")))
+		})
+
+		It("should fail when the output has no digest", func() {
+			mockRunner.DefaultOutput = []byte(`{"Name": "quay.io/test/image"}`)
+
+			_, err := client.ManifestDigest(ctx, "quay.io/test/image:tag")
+
+			Expect(err).To(MatchError(ContainSubstring("digest not found")))
+		})
+	})
+
+	Describe("Copy", func() {
+		It("should copy preserving digests", func() {
+			err := client.Copy(ctx, "quay.io/test/image@sha256:abc", "quay.io/test/image:v1")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "copy", "--all", "--preserve-digests",
+				"docker://quay.io/test/image@sha256:abc", "docker://quay.io/test/image:v1")).To(BeTrue())
+		})
+
+		It("should skip TLS verification when either registry is insecure", func() {
+			client = NewRegistryClient(mockRunner, RegistryOptions{
+				TLSVerify:          true,
+				InsecureRegistries: []string{"localhost:5000"},
+			})
+
+			err := client.Copy(ctx, "quay.io/test/image@sha256:abc", "localhost:5000/test/image:v1")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "copy", "--all", "--preserve-digests",
+				"--src-tls-verify=false", "--dest-tls-verify=false",
+				"docker://quay.io/test/image@sha256:abc", "docker://localhost:5000/test/image:v1")).To(BeTrue())
+		})
+
+		It("should fail when skopeo fails", func() {
+			mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "denied"}
+
+			err := client.Copy(ctx, "quay.io/test/image@sha256:abc", "quay.io/test/image:v1")
+
+			Expect(err).To(MatchError(ContainSubstring("failed to copy quay.io/test/image@sha256:abc to quay.io/test/image:v1")))
+		})
+	})
+})
+
+var _ = Describe("IsManifestUnknown", func() {
+	DescribeTable("classifying skopeo errors",
+		func(err error, unknown bool) {
+			Expect(IsManifestUnknown(err)).To(Equal(unknown))
+		},
+		Entry("manifest unknown", &exec.CommandError{ExitCode: 1, Message: "reading manifest tag in quay.io/test/image: manifest unknown"}, true),
+		Entry("repository unknown", &exec.CommandError{ExitCode: 1, Message: "name unknown: repository not found"}, true),
+		Entry("unauthorized", &exec.CommandError{ExitCode: 1, Message: "unauthorized: authentication required"}, false),
+		Entry("not a command error", errors.New("manifest unknown"), false),
+	)
+})
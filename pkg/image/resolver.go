@@ -0,0 +1,117 @@
+package image
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultResolveWorkers bounds the registry queries a Resolver runs at once
+const DefaultResolveWorkers = 4
+
+// ManifestResult is the raw manifest of a reference, or the error fetching it
+type ManifestResult struct {
+	Ref string
+	Raw []byte
+	Err error
+}
+
+// ManifestCache keeps the raw manifests fetched during a run so that a
+// reference is only fetched once. Failures aren't kept, a missing image may
+// be pushed later in the run. It is safe for concurrent use.
+type ManifestCache struct {
+	mu        sync.Mutex
+	manifests map[string][]byte
+}
+
+// NewManifestCache creates an empty manifest cache
+func NewManifestCache() *ManifestCache {
+	return &ManifestCache{manifests: make(map[string][]byte)}
+}
+
+// get returns the cached manifest of ref
+func (c *ManifestCache) get(ref string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw, found := c.manifests[ref]
+	return raw, found
+}
+
+// put caches the manifest of ref
+func (c *ManifestCache) put(ref string, raw []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.manifests[ref] = raw
+}
+
+// Resolver fetches the raw manifests of many references concurrently through
+// a RegistryClient, with at most workers queries in flight
+type Resolver struct {
+	client  *RegistryClient
+	workers int
+	cache   *ManifestCache
+}
+
+// NewResolver creates a resolver running up to workers queries at once,
+// DefaultResolveWorkers when not positive. cache may be shared between
+// resolvers and is not used when nil.
+func NewResolver(client *RegistryClient, workers int, cache *ManifestCache) *Resolver {
+	if workers <= 0 {
+		workers = DefaultResolveWorkers
+	}
+	return &Resolver{client: client, workers: workers, cache: cache}
+}
+
+// Resolve returns the manifest of each of refs, in the order of refs whatever
+// the order the queries complete in. Identical references are fetched once.
+func (r *Resolver) Resolve(ctx context.Context, refs []string) []ManifestResult {
+	var unique []string
+	index := make(map[string]int, len(refs))
+	for _, ref := range refs {
+		if _, seen := index[ref]; !seen {
+			index[ref] = len(unique)
+			unique = append(unique, ref)
+		}
+	}
+
+	fetched := make([]ManifestResult, len(unique))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(r.workers, len(unique)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fetched[i] = r.fetch(ctx, unique[i])
+			}
+		}()
+	}
+	for i := range unique {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	results := make([]ManifestResult, len(refs))
+	for i, ref := range refs {
+		results[i] = fetched[index[ref]]
+	}
+	return results
+}
+
+// fetch returns the manifest of ref from the cache, or the registry
+func (r *Resolver) fetch(ctx context.Context, ref string) ManifestResult {
+	if r.cache != nil {
+		if raw, found := r.cache.get(ref); found {
+			return ManifestResult{Ref: ref, Raw: raw}
+		}
+	}
+
+	raw, err := r.client.RawManifest(ctx, ref)
+	if err != nil {
+		return ManifestResult{Ref: ref, Err: err}
+	}
+	if r.cache != nil {
+		r.cache.put(ref, raw)
+	}
+	return ManifestResult{Ref: ref, Raw: raw}
+}
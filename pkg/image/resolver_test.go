@@ -0,0 +1,132 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// slowRunner delays the commands of a mock runner, tracking how many run at once
+type slowRunner struct {
+	*exec.MockCommandRunner
+	delay func(args []string) time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (r *slowRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r.mu.Lock()
+	r.inFlight++
+	r.max = max(r.max, r.inFlight)
+	r.mu.Unlock()
+
+	time.Sleep(r.delay(args))
+
+	r.mu.Lock()
+	r.inFlight--
+	r.mu.Unlock()
+	return r.MockCommandRunner.RunWithOutput(ctx, name, args...)
+}
+
+var _ = Describe("Resolver", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		runner     *slowRunner
+		client     *RegistryClient
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "manifest unknown"}
+		runner = &slowRunner{MockCommandRunner: mockRunner, delay: func([]string) time.Duration { return 0 }}
+		client = NewRegistryClient(runner, RegistryOptions{TLSVerify: true})
+	})
+
+	manifest := func(ref string) {
+		mockRunner.SetOutput("skopeo", []byte(`{"ref":"`+ref+`"}`), "inspect", "--raw", "docker://"+ref)
+	}
+
+	It("should fetch identical references once and keep the order of the references", func() {
+		manifest("quay.io/test/image:a")
+		manifest("quay.io/test/image:b")
+
+		results := NewResolver(client, 2, nil).Resolve(ctx, []string{
+			"quay.io/test/image:a", "quay.io/test/image:missing", "quay.io/test/image:b", "quay.io/test/image:a",
+		})
+
+		Expect(results).To(HaveLen(4))
+		Expect(results[0].Ref).To(Equal("quay.io/test/image:a"))
+		Expect(string(results[0].Raw)).To(Equal(`{"ref":"quay.io/test/image:a"}`))
+		Expect(results[1].Err).To(MatchError(ContainSubstring("manifest unknown")))
+		Expect(string(results[2].Raw)).To(Equal(`{"ref":"quay.io/test/image:b"}`))
+		Expect(results[3]).To(Equal(results[0]))
+		Expect(mockRunner.GetExecutedCommands()).To(HaveLen(3))
+	})
+
+	It("should keep the order of the references whatever the order the queries complete in", func() {
+		var refs []string
+		for i := 0; i < 6; i++ {
+			ref := fmt.Sprintf("quay.io/test/image:%d", i)
+			manifest(ref)
+			refs = append(refs, ref)
+		}
+		// The first references complete last
+		runner.delay = func(args []string) time.Duration {
+			for i := range refs {
+				if args[len(args)-1] == "docker://"+refs[i] {
+					return time.Duration(len(refs)-i) * 5 * time.Millisecond
+				}
+			}
+			return 0
+		}
+
+		results := NewResolver(client, len(refs), nil).Resolve(ctx, refs)
+
+		for i, result := range results {
+			Expect(result.Ref).To(Equal(refs[i]))
+			Expect(string(result.Raw)).To(ContainSubstring(refs[i]))
+		}
+	})
+
+	It("should bound the queries in flight", func() {
+		runner.delay = func([]string) time.Duration { return 20 * time.Millisecond }
+		var refs []string
+		for i := 0; i < 10; i++ {
+			refs = append(refs, fmt.Sprintf("quay.io/test/image:%d", i))
+		}
+
+		NewResolver(client, 3, nil).Resolve(ctx, refs)
+
+		Expect(mockRunner.GetExecutedCommands()).To(HaveLen(10))
+		Expect(runner.max).To(Equal(3))
+	})
+
+	It("should share the manifests fetched through the cache, but not the failures", func() {
+		manifest("quay.io/test/image:a")
+		cache := NewManifestCache()
+
+		NewResolver(client, 0, cache).Resolve(ctx, []string{"quay.io/test/image:a", "quay.io/test/image:missing"})
+		results := NewResolver(client, 0, cache).Resolve(ctx, []string{"quay.io/test/image:a", "quay.io/test/image:missing"})
+
+		Expect(string(results[0].Raw)).To(Equal(`{"ref":"quay.io/test/image:a"}`))
+		Expect(results[1].Err).To(HaveOccurred())
+		Expect(mockRunner.GetExecutedCommands()).To(ConsistOf([][]string{
+			{"skopeo", "inspect", "--raw", "docker://quay.io/test/image:a"},
+			{"skopeo", "inspect", "--raw", "docker://quay.io/test/image:missing"},
+			{"skopeo", "inspect", "--raw", "docker://quay.io/test/image:missing"},
+		}))
+	})
+
+	It("should resolve no references", func() {
+		Expect(NewResolver(client, 0, nil).Resolve(ctx, nil)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,40 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+)
+
+// SBOMFileName is the name of the CycloneDX SBOM written next to the scanned archive
+const SBOMFileName = "sbom-cyclonedx.json"
+
+// ExportOCIArchive writes the locally built image to an OCI archive at path,
+// for tools that can't read the rootless container storage. storageRoot is
+// the buildah storage root of the image, empty for the default one, and maps
+// the mappings of the unshare command.
+func ExportOCIArchive(ctx context.Context, imageURL, path, workDir, storageRoot string, maps IDMaps, runner exec.CommandRunner) error {
+	args := []string{"push", imageURL, "oci-archive:" + path}
+	if storageRoot != "" {
+		args = append([]string{"--root", storageRoot}, args...)
+	}
+	unshareCmd := UnshareCommand(args, workDir, maps)
+	if err := runner.Run(ctx, unshareCmd[0], unshareCmd[1:]...); err != nil {
+		return fmt.Errorf("failed to export image to OCI archive: %w", err)
+	}
+	return nil
+}
+
+// GenerateSyftSBOM scans the OCI archive at imageRef with syft, writing a
+// CycloneDX SBOM next to it, and returns the path of the SBOM
+func GenerateSyftSBOM(ctx context.Context, imageRef string, runner exec.CommandRunner) (string, error) {
+	outputPath := filepath.Join(filepath.Dir(imageRef), SBOMFileName)
+	args := []string{"packages", "oci-archive:" + imageRef, "-o", "cyclonedx-json", "--file", outputPath}
+
+	if err := runner.Run(ctx, "syft", args...); err != nil {
+		return "", fmt.Errorf("syft failed to generate SBOM: %w", err)
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,48 @@
+package image
+
+import (
+	"context"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GenerateSyftSBOM", func() {
+	It("should scan the OCI archive into a CycloneDX SBOM next to it", func() {
+		mockRunner := exec.NewMockCommandRunner()
+
+		path, err := GenerateSyftSBOM(context.Background(), "/workspace/sbom/image.tar", mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(path).To(Equal("/workspace/sbom/sbom-cyclonedx.json"))
+		Expect(mockRunner.GetLastCommand()).To(Equal([]string{
+			"syft", "packages", "oci-archive:/workspace/sbom/image.tar",
+			"-o", "cyclonedx-json",
+			"--file", "/workspace/sbom/sbom-cyclonedx.json",
+		}))
+	})
+
+	It("should fail when syft fails", func() {
+		mockRunner := exec.NewMockCommandRunner()
+		mockRunner.SetError("syft", &exec.CommandError{ExitCode: 1, Message: "archive not found"},
+			"packages", "oci-archive:/workspace/sbom/image.tar", "-o", "cyclonedx-json", "--file", "/workspace/sbom/sbom-cyclonedx.json")
+
+		_, err := GenerateSyftSBOM(context.Background(), "/workspace/sbom/image.tar", mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("syft failed to generate SBOM: archive not found")))
+	})
+})
+
+var _ = Describe("ExportOCIArchive", func() {
+	It("should push the local image to an OCI archive under unshare", func() {
+		mockRunner := exec.NewMockCommandRunner()
+
+		Expect(ExportOCIArchive(context.Background(), "quay.io/test/image:tag", "/workspace/sbom/image.tar", "/workspace/sbom", "", IDMaps{}, mockRunner)).To(Succeed())
+
+		cmd := mockRunner.GetLastCommand()
+		Expect(cmd[0]).To(Equal("unshare"))
+		Expect(cmd).To(ContainElement("/workspace/sbom"))
+		Expect(cmd[len(cmd)-1]).To(Equal(`'buildah' 'push' 'quay.io/test/image:tag' 'oci-archive:/workspace/sbom/image.tar'`))
+	})
+})
@@ -0,0 +1,84 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Tag deletion APIs of the registry, removing the temporary tags of pushes
+const (
+	// TagDeletionNone leaves the temporary tags in place
+	TagDeletionNone = "none"
+
+	// TagDeletionQuay deletes tags with the tag API of Quay
+	TagDeletionQuay = "quay"
+)
+
+// tagDeleteTimeout bounds a tag deletion request
+const tagDeleteTimeout = 30 * time.Second
+
+// TagDeleter deletes a tag without deleting the manifest it points to.
+//
+// The registry API can only delete manifests: skopeo delete resolves the tag
+// and deletes its manifest by digest, which on Quay and distribution removes
+// every tag of that manifest, such as the final tag a temporary tag was
+// copied to. skopeo delete must never be used on a tag whose content has to
+// survive; only the tag APIs of registries delete a single tag.
+type TagDeleter interface {
+	DeleteTag(ctx context.Context, repository, tag string) error
+}
+
+// QuayTagDeleter deletes tags with the Quay API, authenticated with an OAuth
+// token of an application allowed to write to the repository
+type QuayTagDeleter struct {
+	Token string
+
+	// BaseURL is the URL of the Quay API, https://<registry> when empty
+	BaseURL string
+
+	Client *http.Client
+}
+
+// NewQuayTagDeleter creates a Quay tag deleter authenticated with token
+func NewQuayTagDeleter(token string) *QuayTagDeleter {
+	return &QuayTagDeleter{Token: token, Client: http.DefaultClient}
+}
+
+// DeleteTag deletes tag from repository, a <registry>/<namespace>/<name> reference
+func (d *QuayTagDeleter) DeleteTag(ctx context.Context, repository, tag string) error {
+	registry, path, found := strings.Cut(repository, "/")
+	if !found || path == "" {
+		return fmt.Errorf("invalid repository %q, expected <registry>/<namespace>/<name>", repository)
+	}
+	baseURL := d.BaseURL
+	if baseURL == "" {
+		baseURL = "https://" + registry
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/repository/%s/tag/%s", strings.TrimSuffix(baseURL, "/"), path, url.PathEscape(tag))
+
+	ctx, cancel := context.WithTimeout(ctx, tagDeleteTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create tag deletion request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+d.Token)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete tag %s: %w", tag, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("deleting tag %s returned %s: %s", tag, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
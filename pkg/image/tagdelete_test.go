@@ -0,0 +1,55 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QuayTagDeleter", func() {
+	var (
+		server   *httptest.Server
+		status   int
+		requests []*http.Request
+		deleter  *QuayTagDeleter
+	)
+
+	BeforeEach(func() {
+		status = http.StatusNoContent
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("denied"))
+		}))
+		DeferCleanup(server.Close)
+
+		deleter = NewQuayTagDeleter("token")
+		deleter.BaseURL = server.URL
+	})
+
+	It("should delete the tag with the tag API", func() {
+		Expect(deleter.DeleteTag(context.Background(), "quay.io/test/image", "tmp-push-run-1")).To(Succeed())
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Method).To(Equal(http.MethodDelete))
+		Expect(requests[0].URL.Path).To(Equal("/api/v1/repository/test/image/tag/tmp-push-run-1"))
+		Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer token"))
+	})
+
+	It("should report the status of a failed deletion", func() {
+		status = http.StatusForbidden
+
+		err := deleter.DeleteTag(context.Background(), "quay.io/test/image", "tmp-push-run-1")
+
+		Expect(err).To(MatchError("deleting tag tmp-push-run-1 returned 403 Forbidden: denied"))
+	})
+
+	It("should reject a repository without a registry", func() {
+		Expect(deleter.DeleteTag(context.Background(), "image", "tag")).To(MatchError(ContainSubstring("invalid repository")))
+		Expect(requests).To(BeEmpty())
+	})
+})
@@ -0,0 +1,60 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ListImageTags", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+	})
+
+	It("should return the tags listed by skopeo", func() {
+		fixture, err := os.ReadFile(filepath.Join("testdata", "tags", "list-tags.json"))
+		Expect(err).NotTo(HaveOccurred())
+		mockRunner.SetOutput("skopeo", fixture, "list-tags", "docker://quay.io/test/image")
+
+		tags, err := ListImageTags(ctx, "quay.io/test/image", true, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(Equal([]string{"latest", "v1.0.0", "v1.1.0", "sha256-0f3c1e5b2d8a.sig", "tmp-push-abc123def456"}))
+	})
+
+	It("should return an empty list for a repository without tags", func() {
+		mockRunner.SetOutput("skopeo", []byte(`{"Repository":"quay.io/test/image","Tags":null}`),
+			"list-tags", "--tls-verify=false", "docker://quay.io/test/image")
+
+		tags, err := ListImageTags(ctx, "quay.io/test/image", false, mockRunner)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(BeEmpty())
+	})
+
+	It("should fail when skopeo fails", func() {
+		mockRunner.DefaultError = &exec.CommandError{ExitCode: 1, Message: "repository not found"}
+
+		_, err := ListImageTags(ctx, "quay.io/test/missing", true, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to list tags of quay.io/test/missing")))
+	})
+
+	It("should fail on invalid output", func() {
+		mockRunner.DefaultOutput = []byte("not json")
+
+		_, err := ListImageTags(ctx, "quay.io/test/image", true, mockRunner)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to parse skopeo output")))
+	})
+})
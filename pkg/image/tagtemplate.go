@@ -0,0 +1,67 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// LabelRef records the branch or tag an image was built from
+const LabelRef = "io.konflux.ref"
+
+// validTag matches the tags registries accept
+var validTag = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// TagTemplateData is the data a tag template is rendered with
+type TagTemplateData struct {
+	// Ref is the branch or tag name without its refs/heads/ or refs/tags/
+	// prefix, with the characters tags don't allow replaced by dashes
+	Ref string
+
+	// SHA and ShortSHA are the full and 7 character commit SHA
+	SHA      string
+	ShortSHA string
+}
+
+// NewTagTemplateData creates the data of a commit built from ref
+func NewTagTemplateData(ref, sha string) TagTemplateData {
+	ref = strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	shortSHA := sha
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+	return TagTemplateData{
+		Ref:      invalidTagChars.ReplaceAllString(ref, "-"),
+		SHA:      sha,
+		ShortSHA: shortSHA,
+	}
+}
+
+// ParseTagTemplate parses a text/template deriving a tag, such as
+// "{{.Ref}}-{{.ShortSHA}}". The template is rendered with sample data so
+// that references to unknown fields are reported when parsing.
+func ParseTagTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := RenderTag(tmpl, NewTagTemplateData("main", "0123456789abcdef0123456789abcdef01234567")); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// RenderTag renders a tag template, failing when the result isn't a valid tag
+func RenderTag(tmpl *template.Template, data TagTemplateData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	tag := strings.TrimSpace(b.String())
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("rendered tag %q isn't a valid tag", tag)
+	}
+	return tag, nil
+}
@@ -0,0 +1,52 @@
+package image
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tag templates", func() {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+
+	DescribeTable("RenderTag",
+		func(text, ref, expected string) {
+			tmpl, err := ParseTagTemplate(text)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(RenderTag(tmpl, NewTagTemplateData(ref, sha))).To(Equal(expected))
+		},
+		Entry("ref and short SHA", "{{.Ref}}-{{.ShortSHA}}", "main", "main-0123456"),
+		Entry("full branch ref", "{{.Ref}}", "refs/heads/release-1.2", "release-1.2"),
+		Entry("tag ref", "v-{{.Ref}}", "refs/tags/1.2.0", "v-1.2.0"),
+		Entry("ref with slashes", "{{.Ref}}-{{.ShortSHA}}", "refs/heads/feature/new+thing", "feature-new-thing-0123456"),
+		Entry("full SHA", "sha-{{.SHA}}", "main", "sha-"+sha),
+		Entry("conditional ref", "{{if .Ref}}{{.Ref}}{{else}}detached{{end}}", "", "detached"),
+	)
+
+	It("should reject unknown fields when parsing", func() {
+		_, err := ParseTagTemplate("{{.Branch}}-{{.ShortSHA}}")
+
+		Expect(err).To(MatchError(ContainSubstring("can't evaluate field Branch")))
+	})
+
+	It("should reject malformed templates", func() {
+		_, err := ParseTagTemplate("{{.Ref")
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject templates rendering invalid tags", func() {
+		_, err := ParseTagTemplate("{{.Ref}}:{{.ShortSHA}}")
+
+		Expect(err).To(MatchError(`rendered tag "main:0123456" isn't a valid tag`))
+	})
+
+	It("should reject an empty ref rendering an invalid tag", func() {
+		tmpl, err := ParseTagTemplate("{{.Ref}}")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = RenderTag(tmpl, NewTagTemplateData("", sha))
+
+		Expect(err).To(MatchError(`rendered tag "" isn't a valid tag`))
+	})
+})
@@ -0,0 +1,78 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+)
+
+// Image labels recording the tool versions that produced an image
+const (
+	LabelBuildahVersion = "io.konflux.buildah-version"
+	LabelSkopeoVersion  = "io.konflux.skopeo-version"
+)
+
+// ToolVersions holds the versions of the container tools used by a build.
+// A version is empty when it couldn't be determined.
+type ToolVersions struct {
+	Buildah string `json:"buildah,omitempty"`
+	Skopeo  string `json:"skopeo,omitempty"`
+}
+
+// Labels returns the image labels for the known tool versions
+func (v ToolVersions) Labels() map[string]string {
+	labels := make(map[string]string)
+	if v.Buildah != "" {
+		labels[LabelBuildahVersion] = v.Buildah
+	}
+	if v.Skopeo != "" {
+		labels[LabelSkopeoVersion] = v.Skopeo
+	}
+	return labels
+}
+
+// versionPattern matches the version in "<tool> version 1.2.3 ..." output
+var versionPattern = regexp.MustCompile(`\bversion\s+v?(\d+\.\d+(?:\.\d+)?[0-9A-Za-z.+~-]*)`)
+
+// ParseToolVersion extracts the version from the output of "<tool> --version"
+func ParseToolVersion(output string) (string, error) {
+	match := versionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("no version found in %q", strings.TrimSpace(output))
+	}
+	return match[1], nil
+}
+
+// DetectToolVersion runs "<tool> --version" and parses its version
+func DetectToolVersion(ctx context.Context, tool string, runner exec.CommandRunner) (string, error) {
+	output, err := runner.RunWithOutput(ctx, tool, "--version")
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s version: %w", tool, err)
+	}
+
+	version, err := ParseToolVersion(string(output))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s version: %w", tool, err)
+	}
+	return version, nil
+}
+
+// DetectToolVersions detects the buildah and skopeo versions. Tools whose
+// version can't be determined are left empty and reported in the errors.
+func DetectToolVersions(ctx context.Context, runner exec.CommandRunner) (ToolVersions, []error) {
+	var versions ToolVersions
+	var errs []error
+
+	var err error
+	if versions.Buildah, err = DetectToolVersion(ctx, "buildah", runner); err != nil {
+		errs = append(errs, err)
+	}
+	if versions.Skopeo, err = DetectToolVersion(ctx, "skopeo", runner); err != nil {
+		errs = append(errs, err)
+	}
+
+	return versions, errs
+}
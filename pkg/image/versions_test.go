@@ -0,0 +1,73 @@
+package image
+
+import (
+	"context"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tool versions", func() {
+	DescribeTable("ParseToolVersion",
+		func(output, expected string) {
+			version, err := ParseToolVersion(output)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(expected))
+		},
+		Entry("buildah 1.23", "buildah version 1.23.1 (image-spec 1.0.1-dev, runtime-spec 1.0.2-dev)\n", "1.23.1"),
+		Entry("buildah 1.33", "buildah version 1.33.7 (image-spec 1.1.0, runtime-spec 1.1.0)\n", "1.33.7"),
+		Entry("buildah 1.37", "buildah version 1.37.5 (image-spec 1.1.0, runtime-spec 1.2.0)\n", "1.37.5"),
+		Entry("buildah development build", "buildah version 1.39.0-dev (image-spec 1.1.0, runtime-spec 1.2.0)\n", "1.39.0-dev"),
+		Entry("skopeo 1.9", "skopeo version 1.9.3\n", "1.9.3"),
+		Entry("skopeo 1.14", "skopeo version 1.14.2\n", "1.14.2"),
+		Entry("skopeo with commit", "skopeo version 1.13.3 commit: 4c0f3c0b7ae1e4b0c4d8b3e1f9a6f0f5d2c8e7a1\n", "1.13.3"),
+	)
+
+	It("should fail on output without a version", func() {
+		_, err := ParseToolVersion("command not found")
+
+		Expect(err).To(MatchError(ContainSubstring("no version found")))
+	})
+
+	It("should not pick up shell characters into the version", func() {
+		version, err := ParseToolVersion("buildah version 1.33.7;rm -rf /")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(Equal("1.33.7"))
+	})
+
+	Describe("DetectToolVersions", func() {
+		var mockRunner *exec.MockCommandRunner
+
+		BeforeEach(func() {
+			mockRunner = exec.NewMockCommandRunner()
+		})
+
+		It("should detect buildah and skopeo versions", func() {
+			mockRunner.SetOutput("buildah", []byte("buildah version 1.33.7 (image-spec 1.1.0, runtime-spec 1.1.0)\n"), "--version")
+			mockRunner.SetOutput("skopeo", []byte("skopeo version 1.14.2\n"), "--version")
+
+			versions, errs := DetectToolVersions(context.Background(), mockRunner)
+
+			Expect(errs).To(BeEmpty())
+			Expect(versions).To(Equal(ToolVersions{Buildah: "1.33.7", Skopeo: "1.14.2"}))
+			Expect(versions.Labels()).To(Equal(map[string]string{
+				LabelBuildahVersion: "1.33.7",
+				LabelSkopeoVersion:  "1.14.2",
+			}))
+		})
+
+		It("should report failures while keeping the versions it found", func() {
+			mockRunner.SetOutput("buildah", []byte("buildah version 1.37.5 (image-spec 1.1.0, runtime-spec 1.2.0)\n"), "--version")
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 127, Message: "not found"}, "--version")
+
+			versions, errs := DetectToolVersions(context.Background(), mockRunner)
+
+			Expect(errs).To(HaveLen(1))
+			Expect(versions).To(Equal(ToolVersions{Buildah: "1.37.5"}))
+			Expect(versions.Labels()).To(Equal(map[string]string{LabelBuildahVersion: "1.37.5"}))
+		})
+	})
+})
@@ -2,88 +2,220 @@ package imageindex
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"github.com/konflux-ci/monolithic-builder/pkg/metrics"
+	"github.com/konflux-ci/monolithic-builder/pkg/results"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
 	"go.uber.org/zap"
 )
 
 // Builder implements the monolithic build-image-index functionality
 type Builder struct {
-	logger *zap.Logger
-	config *Config
+	logger  *zap.Logger
+	config  *Config
+	runner  exec.CommandRunner
+	results *results.Writer
+
+	// httpClient sends the webhook notification
+	httpClient *http.Client
+
+	// warnings collects the non-fatal problems written as the WARNINGS result
+	warnings warnings.Collector
+
+	// registryRetries retries the commands rejected by a registry rate limit
+	// and counts them for INDEX_METRICS
+	registryRetries *exec.RetryingCommandRunner
+
+	// failedCommand remembers the last failed command for the failure report
+	failedCommand *exec.FailedCommand
 }
 
 // NewBuilder creates a new Builder instance
-func NewBuilder(logger *zap.Logger, config *Config) *Builder {
+func NewBuilder(logger *zap.Logger, config *Config, runner exec.CommandRunner) *Builder {
+	registryRetries := exec.NewRetryingCommandRunner(runner, exec.RateLimitMaxRetries, exec.RateLimitBaseDelay, exec.IsRateLimited)
+	registryRetries.OnRetry = func(retry int, err error) {
+		logger.Warn("Registry rate limit hit, backing off",
+			zap.Int("retry", retry),
+			zap.Int("max_retries", exec.RateLimitMaxRetries),
+			zap.Error(err))
+	}
+	failedCommand := &exec.FailedCommand{}
+	registryRetries.OnAttempt = failedCommand.Record
+
 	return &Builder{
-		logger: logger,
-		config: config,
+		logger:          logger,
+		config:          config,
+		runner:          registryRetries,
+		results:         results.NewWriter(config.ResultsPath),
+		httpClient:      http.DefaultClient,
+		registryRetries: registryRetries,
+		failedCommand:   failedCommand,
 	}
 }
 
 // Execute runs the complete monolithic build-image-index process
-func (b *Builder) Execute(ctx context.Context) error {
+func (b *Builder) Execute(ctx context.Context) (err error) {
 	b.logger.Info("Starting monolithic build-image-index task",
 		zap.String("image_url", b.config.ImageURL),
 		zap.Strings("images", b.config.Images),
-		zap.Bool("always_build_index", b.config.AlwaysBuildIndex))
+		zap.Bool("always_build_index", b.config.AlwaysBuildIndex),
+		zap.String("results_path", b.results.Dir()))
+
+	if err := b.dumpConfig(); err != nil {
+		return fmt.Errorf("failed to dump effective configuration: %w", err)
+	}
+
+	if b.config.TempDir != "" {
+		cleanup, err := b.setupTempDir()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	b.warnings = warnings.Collector{Strict: b.config.StrictWarnings}
+	defer b.writeWarnings()
+
+	step := "tool-versions"
+	var resultImageURL, resultImageDigest, indexMediaType, previousIndexDigest string
+	componentCount := len(b.config.Images)
+	defer func() {
+		if err != nil {
+			b.fail(step, resultImageURL, resultImageDigest, err)
+		}
+	}()
+
+	if err := b.logToolVersions(ctx); err != nil {
+		return err
+	}
 
 	// Determine if we should build an index
-	shouldBuildIndex := b.shouldBuildIndex()
+	step = EventStepIndex
+	indexMetrics := metrics.New()
 
-	var resultImageURL, resultImageDigest string
+	// An entry that can't be pinned would break the provenance of the index
+	images, err := b.pinnedImages(ctx)
+	if err != nil {
+		return err
+	}
+	componentCount = len(images)
+	shouldBuildIndex := b.shouldBuildIndex(images)
 
-	if shouldBuildIndex && len(b.config.Images) > 1 {
+	// Updating an index replaces its entries even for a single image
+	if shouldBuildIndex && (len(images) > 1 || (b.config.UpdateExisting && len(images) > 0)) {
 		// Build multi-architecture index
 		b.logger.Info("Building multi-architecture image index")
-		indexResult, err := b.buildImageIndex(ctx)
+		indexResult, err := b.buildImageIndex(ctx, images)
 		if err != nil {
 			return fmt.Errorf("failed to build image index: %w", err)
 		}
 		resultImageURL = indexResult.ImageURL
 		resultImageDigest = indexResult.ImageDigest
-	} else if len(b.config.Images) == 1 {
+		indexMediaType = indexResult.MediaType
+		previousIndexDigest = indexResult.PreviousDigest
+		componentCount = indexResult.ComponentCount
+		indexMetrics.SetSeconds(metrics.KeyBuildSeconds, indexResult.BuildDuration)
+		indexMetrics.SetSeconds(metrics.KeyPushSeconds, indexResult.PushDuration)
+		indexMetrics.SetBool(metrics.KeySkipped, false)
+	} else if len(images) == 1 {
 		// Single image - extract URL and digest
 		b.logger.Info("Single image provided, extracting details")
-		imageRef := b.config.Images[0]
-		parts := strings.Split(imageRef, "@")
-		if len(parts) == 2 {
-			resultImageURL = parts[0]
-			resultImageDigest = parts[1]
-		} else {
-			resultImageURL = imageRef
-			// Try to get digest
-			digest, err := b.getImageDigest(ctx, imageRef)
-			if err != nil {
-				b.logger.Warn("Failed to get image digest", zap.Error(err))
-				resultImageDigest = ""
-			} else {
-				resultImageDigest = digest
-			}
+		resultImageURL, resultImageDigest, _ = strings.Cut(images[0], "@")
+		if b.config.ImageExpiresAfter != "" {
+			b.logger.Info("No index pushed, the image keeps the expiration label of its build",
+				zap.String("expires_after", b.config.ImageExpiresAfter))
 		}
+		indexMetrics.SetBool(metrics.KeySkipped, true)
 	} else {
 		return fmt.Errorf("no images provided for index creation")
 	}
+	b.emitStepEvent(ctx, EventStepIndex, resultImageURL, resultImageDigest)
 
-	// Add expiration label if specified
-	if b.config.ImageExpiresAfter != "" {
-		if err := b.addExpirationLabel(ctx, resultImageURL); err != nil {
-			b.logger.Warn("Failed to add expiration label", zap.Error(err))
+	if b.config.KeylessSigning {
+		step = "signing"
+		if err := b.signImage(ctx, resultImageURL, resultImageDigest); err != nil {
+			return fmt.Errorf("failed to sign image: %w", err)
 		}
 	}
 
 	// Write results
+	step = EventStepResults
 	if err := b.writeResult("IMAGE_URL", resultImageURL); err != nil {
 		return fmt.Errorf("failed to write IMAGE_URL result: %w", err)
 	}
-	if err := b.writeResult("IMAGE_DIGEST", resultImageDigest); err != nil {
+	if err := b.writeResult("IMAGE_DIGEST", FormatDigest(resultImageDigest, b.config.DigestFormat)); err != nil {
 		return fmt.Errorf("failed to write IMAGE_DIGEST result: %w", err)
 	}
 
+	if err := b.writeResult("IMAGES", strings.Join(images, ",")); err != nil {
+		return fmt.Errorf("failed to write IMAGES result: %w", err)
+	}
+
+	if indexMediaType != "" {
+		if err := b.writeResult("INDEX_MEDIA_TYPE", indexMediaType); err != nil {
+			return fmt.Errorf("failed to write INDEX_MEDIA_TYPE result: %w", err)
+		}
+	}
+
+	if previousIndexDigest != "" {
+		if err := b.writeResult("PREVIOUS_INDEX_DIGEST", FormatDigest(previousIndexDigest, b.config.DigestFormat)); err != nil {
+			return fmt.Errorf("failed to write PREVIOUS_INDEX_DIGEST result: %w", err)
+		}
+	}
+
+	if b.config.WriteComponentCount {
+		if err := b.writeResult("COMPONENT_COUNT", strconv.Itoa(componentCount)); err != nil {
+			return fmt.Errorf("failed to write COMPONENT_COUNT result: %w", err)
+		}
+	}
+
+	if b.config.WriteIndexSize {
+		size, err := b.getIndexSize(ctx)
+		if err != nil {
+			b.logger.Warn("Failed to compute index size, skipping INDEX_SIZE_BYTES result", zap.Error(err))
+			if err := b.warn(warnings.CategoryIndexSize, fmt.Sprintf("failed to compute index size: %v", err)); err != nil {
+				return err
+			}
+		} else {
+			if err := b.writeResult("INDEX_SIZE_BYTES", strconv.FormatInt(size, 10)); err != nil {
+				return fmt.Errorf("failed to write INDEX_SIZE_BYTES result: %w", err)
+			}
+			indexMetrics.SetInt(metrics.KeyImageSizeBytes, size)
+		}
+	}
+
+	// Index operations are only retried on registry rate limits
+	indexMetrics.SetInt(metrics.KeyRetriesTotal, int64(b.registryRetries.Retries))
+	indexMetrics.SetInt(metrics.KeyRateLimited, int64(b.registryRetries.RateLimited))
+	indexMetrics.SetSeconds(metrics.KeyBackoffSeconds, b.registryRetries.Backoff)
+	if err := b.writeMetrics(indexMetrics); err != nil {
+		return err
+	}
+	b.emitStepEvent(ctx, EventStepResults, resultImageURL, resultImageDigest)
+
+	if b.config.WriteYAMLSummary {
+		step = EventStepSummary
+		if err := b.writeYAMLSummary(ctx, resultImageURL, resultImageDigest); err != nil {
+			return err
+		}
+		b.emitStepEvent(ctx, EventStepSummary, resultImageURL, resultImageDigest)
+	}
+
+	if b.config.WebhookURL != "" {
+		b.sendNotification(ctx, resultImageURL, resultImageDigest)
+	}
+
 	b.logger.Info("Monolithic build-image-index task completed successfully",
 		zap.String("image_url", resultImageURL),
 		zap.String("image_digest", resultImageDigest))
@@ -91,127 +223,498 @@ func (b *Builder) Execute(ctx context.Context) error {
 	return nil
 }
 
-// shouldBuildIndex determines whether to build an image index
-func (b *Builder) shouldBuildIndex() bool {
-	// Always build if explicitly requested
-	if b.config.AlwaysBuildIndex {
+// fail flushes FAILED_STEP, ERROR_CATEGORY, the failure report and the image
+// URL and digest once known, within FailureGracePeriod, and logs the report
+// last. Write failures are only logged so that they never mask the error of
+// the build.
+func (b *Builder) fail(step, imageURL, imageDigest string, err error) {
+	b.logger.Error("Build-image-index step failed", zap.String("step", step), zap.Error(err))
+
+	category := results.ErrorCategory(err)
+	report := results.NewFailureReport(step, category, err, b.failedCommand.Command(err))
+	flushed := results.FlushWithin(b.config.FailureGracePeriod, func() {
+		partial := [][2]string{
+			{"FAILED_STEP", step},
+			{"ERROR_CATEGORY", category},
+		}
+		if imageURL != "" {
+			partial = append(partial, [2]string{"IMAGE_URL", imageURL})
+		}
+		if imageDigest != "" {
+			partial = append(partial, [2]string{"IMAGE_DIGEST", FormatDigest(imageDigest, b.config.DigestFormat)})
+		}
+		for _, result := range partial {
+			if err := b.writeResult(result[0], result[1]); err != nil {
+				b.logger.Warn("Failed to write partial result", zap.String("result", result[0]), zap.Error(err))
+			}
+		}
+		if err := report.Write(b.results.Dir()); err != nil {
+			b.logger.Warn("Failed to write the failure report", zap.Error(err))
+		}
+	})
+	if !flushed {
+		b.logger.Warn("Partial results weren't flushed within the grace period",
+			zap.Duration("grace_period", b.config.FailureGracePeriod))
+	}
+	b.logger.Error(report.String())
+}
+
+// pinnedImages returns Images pinned by digest as url@digest, in their order
+// and without duplicates. The digest of an image referenced by tag only is
+// looked up in the registry.
+func (b *Builder) pinnedImages(ctx context.Context) ([]string, error) {
+	var pinned []string
+	seen := make(map[string]bool)
+	for _, imageRef := range b.config.Images {
+		if seen[imageRef] {
+			continue
+		}
+		seen[imageRef] = true
+
+		if !strings.Contains(imageRef, "@") {
+			digest, err := b.registry().ManifestDigest(ctx, imageRef)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve the digest of %s: %w", imageRef, err)
+			}
+			imageRef += "@" + digest
+			if seen[imageRef] {
+				continue
+			}
+			seen[imageRef] = true
+		}
+		pinned = append(pinned, imageRef)
+	}
+	return pinned, nil
+}
+
+// shouldBuildIndex determines whether to build an image index of the pinned images
+func (b *Builder) shouldBuildIndex(images []string) bool {
+	// Always build if explicitly requested or updating the existing index
+	if b.config.AlwaysBuildIndex || b.config.UpdateExisting {
 		return true
 	}
 
 	// Build index if we have multiple images
-	return len(b.config.Images) > 1
+	return len(images) > 1
 }
 
 // ImageIndexResult holds the results of building an image index
 type ImageIndexResult struct {
 	ImageURL    string
 	ImageDigest string
+
+	// MediaType is the media type of the pushed index, as the registry
+	// returns it, empty when the index couldn't be fetched
+	MediaType string
+
+	// PreviousDigest is the digest of the index UPDATE_EXISTING updated,
+	// empty when it was created
+	PreviousDigest string
+
+	// ComponentCount is the number of entries of the pushed index, the
+	// images added and the entries kept from the updated index
+	ComponentCount int
+
+	// BuildDuration measures assembling the manifest list and PushDuration pushing it
+	BuildDuration time.Duration
+	PushDuration  time.Duration
 }
 
-// buildImageIndex creates a multi-architecture image index
-func (b *Builder) buildImageIndex(ctx context.Context) (*ImageIndexResult, error) {
+// buildImageIndex creates a multi-architecture image index of the pinned images
+func (b *Builder) buildImageIndex(ctx context.Context, images []string) (*ImageIndexResult, error) {
 	// Create a manifest list using buildah
 	manifestName := b.config.ImageURL + "-index"
+	buildStart := time.Now()
+
+	// Quay expires the tag of an index annotated with quay.expires-after
+	var expiresAt string
+	if b.config.ImageExpiresAfter != "" {
+		var err error
+		if expiresAt, err = b.expiresAt(); err != nil {
+			return nil, err
+		}
+	}
 
-	// Create manifest
-	b.logger.Info("Creating image manifest", zap.String("manifest", manifestName))
-	createArgs := []string{"manifest", "create", manifestName}
+	// Updating an index keeps the entries of the platforms not in Images
+	var existing *image.Manifest
+	var platforms []image.Platform
+	if b.config.UpdateExisting {
+		var err error
+		if existing, err = b.existingIndex(ctx); err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			if platforms, err = b.imagePlatforms(ctx, images); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	cmd := exec.CommandContext(ctx, "buildah", createArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Create manifest, unless appending to one that already exists locally
+	if b.config.AppendMode && b.manifestExists(ctx, manifestName) {
+		b.logger.Info("Appending to existing image manifest", zap.String("manifest", manifestName))
+	} else {
+		b.logger.Info("Creating image manifest", zap.String("manifest", manifestName))
+		createArgs := append([]string{"manifest", "create"}, b.tlsVerifyArgs(b.config.ImageURL)...)
+		createArgs = append(createArgs, manifestName)
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to create manifest: %w", err)
+		if err := b.runner.Run(ctx, "buildah", createArgs...); err != nil {
+			return nil, fmt.Errorf("failed to create manifest: %w", err)
+		}
+	}
+
+	componentCount := len(images)
+	if existing != nil {
+		kept, err := b.addExistingEntries(ctx, manifestName, existing, platforms)
+		if err != nil {
+			return nil, err
+		}
+		b.logger.Info("Updating existing image index",
+			zap.String("previous_digest", existing.Digest),
+			zap.Int("kept_entries", kept),
+			zap.Int("added_entries", len(images)))
+		componentCount += kept
 	}
 
 	// Add images to manifest
-	for _, imageRef := range b.config.Images {
+	for _, imageRef := range images {
 		b.logger.Info("Adding image to manifest", zap.String("image", imageRef))
-		addArgs := []string{"manifest", "add", manifestName, imageRef}
-
-		addCmd := exec.CommandContext(ctx, "buildah", addArgs...)
-		addCmd.Stdout = os.Stdout
-		addCmd.Stderr = os.Stderr
+		addArgs := append([]string{"manifest", "add"}, b.tlsVerifyArgs(imageRef)...)
+		addArgs = append(addArgs, manifestName, imageRef)
 
-		if err := addCmd.Run(); err != nil {
+		if err := b.runner.Run(ctx, "buildah", addArgs...); err != nil {
 			return nil, fmt.Errorf("failed to add image %s to manifest: %w", imageRef, err)
 		}
 	}
 
+	if expiresAt != "" {
+		if err := b.annotateExpiration(ctx, manifestName, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+	buildDuration := time.Since(buildStart)
+
 	// Push manifest to registry
 	b.logger.Info("Pushing image index to registry")
-	pushArgs := []string{"manifest", "push", "--all", manifestName, fmt.Sprintf("docker://%s", b.config.ImageURL)}
+	pushStart := time.Now()
+
+	// Append mode keeps the manifest so later runs can add to it. The
+	// manifest is pruned by the last push.
+	prune := b.config.PruneAfterPush && !b.config.AppendMode
+	extraPushes := len(b.config.AdditionalImageURLs)
+	format := ""
+	if err := b.runner.Run(ctx, "buildah", b.manifestPushArgs(manifestName, prune && extraPushes == 0, format)...); err != nil {
+		if !b.config.FallbackToDockerManifest || !isMediaTypeRejection(err) {
+			return nil, fmt.Errorf("failed to push manifest: %w", err)
+		}
 
-	if !b.config.TLSVerify {
-		pushArgs = append(pushArgs, "--tls-verify=false")
+		b.logger.Warn("Registry rejected the OCI image index, retrying as a Docker manifest list", zap.Error(err))
+		format = "v2s2"
+		if err := b.runner.Run(ctx, "buildah", b.manifestPushArgs(manifestName, prune && extraPushes == 0, format)...); err != nil {
+			return nil, fmt.Errorf("failed to push manifest as a Docker manifest list: %w", err)
+		}
 	}
 
-	pushCmd := exec.CommandContext(ctx, "buildah", pushArgs...)
-	pushCmd.Stdout = os.Stdout
-	pushCmd.Stderr = os.Stderr
-
-	if err := pushCmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to push manifest: %w", err)
+	for i, imageURL := range b.config.AdditionalImageURLs {
+		setting := b.config.registrySetting(imageURL)
+		b.logger.Info("Pushing image index to additional registry",
+			zap.String("image_url", imageURL),
+			zap.Bool("tls_verify", setting.TLSVerify))
+		args := b.extraPushArgs(manifestName, imageURL, setting, prune && i == extraPushes-1, format)
+		if err := b.runner.Run(ctx, "buildah", args...); err != nil {
+			return nil, fmt.Errorf("failed to push manifest to %s: %w", imageURL, err)
+		}
 	}
 
 	// Get the digest of the pushed index
-	digest, err := b.getImageDigest(ctx, b.config.ImageURL)
+	digest, err := b.registry().ManifestDigest(ctx, b.config.ImageURL)
 	if err != nil {
 		b.logger.Warn("Failed to get index digest", zap.Error(err))
+		if err := b.warn(warnings.CategoryDigest, fmt.Sprintf("failed to get index digest: %v", err)); err != nil {
+			return nil, err
+		}
 		digest = ""
 	}
+	pushDuration := time.Since(pushStart)
 
-	// Clean up local manifest
-	rmArgs := []string{"manifest", "rm", manifestName}
-	rmCmd := exec.CommandContext(ctx, "buildah", rmArgs...)
-	_ = rmCmd.Run() // Ignore errors for cleanup
+	mediaType := b.indexMediaType(ctx, digest)
 
-	return &ImageIndexResult{
-		ImageURL:    b.config.ImageURL,
-		ImageDigest: digest,
-	}, nil
+	if expiresAt != "" {
+		ref := b.config.ImageURL
+		if digest != "" {
+			ref = image.Repository(b.config.ImageURL) + "@" + digest
+		}
+		if err := b.verifyExpiration(ctx, ref, expiresAt); err != nil {
+			b.logger.Warn("Failed to verify the expiration of the pushed index", zap.Error(err))
+			if err := b.warn(warnings.CategoryExpirationLabel, fmt.Sprintf("failed to verify the expiration annotation: %v", err)); err != nil {
+				return nil, err
+			}
+		} else {
+			b.logger.Info("Pushed index expires", zap.String("expires_at", expiresAt))
+		}
+	}
+
+	// Clean up local manifest, keeping it in append mode so later runs can add to it
+	if !b.config.AppendMode && !prune {
+		rmArgs := []string{"manifest", "rm", manifestName}
+		_ = b.runner.Run(ctx, "buildah", rmArgs...) // Ignore errors for cleanup
+	}
+
+	result := &ImageIndexResult{
+		ImageURL:       b.config.ImageURL,
+		ImageDigest:    digest,
+		MediaType:      mediaType,
+		ComponentCount: componentCount,
+		BuildDuration:  buildDuration,
+		PushDuration:   pushDuration,
+	}
+	if existing != nil {
+		result.PreviousDigest = existing.Digest
+	}
+	return result, nil
 }
 
-// getImageDigest retrieves the digest of an image
-func (b *Builder) getImageDigest(ctx context.Context, imageURL string) (string, error) {
-	args := []string{"inspect", "--format", "{{.Digest}}"}
-	if !b.config.TLSVerify {
-		args = append(args, "--tls-verify=false")
+// indexMediaType returns the media type of the pushed index, fetched by
+// digest when known. Failures are only logged and return an empty type.
+func (b *Builder) indexMediaType(ctx context.Context, digest string) string {
+	ref := b.config.ImageURL
+	if digest != "" {
+		ref = image.Repository(b.config.ImageURL) + "@" + digest
 	}
-	args = append(args, fmt.Sprintf("docker://%s", imageURL))
 
-	cmd := exec.CommandContext(ctx, "skopeo", args...)
-	output, err := cmd.Output()
+	raw, err := b.registry().RawManifest(ctx, ref)
+	if err != nil {
+		b.logger.Warn("Failed to fetch the pushed index", zap.Error(err))
+		return ""
+	}
+	manifest, err := image.ParseManifest(raw)
 	if err != nil {
-		return "", fmt.Errorf("failed to inspect image: %w", err)
+		b.logger.Warn("Failed to parse the pushed index", zap.Error(err))
+		return ""
 	}
+	return manifest.MediaType
+}
 
-	return strings.TrimSpace(string(output)), nil
+// manifestPushArgs builds the buildah manifest push arguments, pushing in the
+// given format when set
+func (b *Builder) manifestPushArgs(manifestName string, prune bool, format string) []string {
+	pushArgs := []string{"manifest", "push", "--all"}
+	if prune {
+		pushArgs = append(pushArgs, "--rm")
+	}
+	if format != "" {
+		pushArgs = append(pushArgs, "--format", format)
+	}
+	pushArgs = append(pushArgs, manifestName, fmt.Sprintf("docker://%s", b.config.ImageURL))
+	return append(pushArgs, b.tlsVerifyArgs(b.config.ImageURL)...)
 }
 
-// addExpirationLabel adds expiration label to the image
-// NOTE: The original build-image-index task declares IMAGE_EXPIRES_AFTER parameter
-// but does not actually implement the functionality. We match this behavior.
-func (b *Builder) addExpirationLabel(ctx context.Context, imageURL string) error {
-	if b.config.ImageExpiresAfter == "" {
+// extraPushArgs builds the buildah manifest push arguments of an additional
+// image URL, with the TLS verification and authfile of its registry setting
+func (b *Builder) extraPushArgs(manifestName, imageURL string, setting RegistrySetting, prune bool, format string) []string {
+	pushArgs := []string{"manifest", "push", "--all"}
+	if prune {
+		pushArgs = append(pushArgs, "--rm")
+	}
+	if format != "" {
+		pushArgs = append(pushArgs, "--format", format)
+	}
+	if setting.AuthFile != "" {
+		pushArgs = append(pushArgs, "--authfile", setting.AuthFile)
+	}
+	pushArgs = append(pushArgs, manifestName, "docker://"+imageURL)
+	if !setting.TLSVerify {
+		pushArgs = append(pushArgs, "--tls-verify=false")
+	}
+	return pushArgs
+}
+
+// tlsVerifyArgs returns the flag disabling TLS verification of the buildah
+// manifest commands on imageURL when its registry setting, or TLSVerify, is
+// unset
+func (b *Builder) tlsVerifyArgs(imageURL string) []string {
+	if b.config.registrySetting(imageURL).TLSVerify {
 		return nil
 	}
+	return []string{"--tls-verify=false"}
+}
+
+// mediaTypeRejections are stderr fragments of registries refusing a manifest
+// for its media type. A bare MANIFEST_INVALID isn't one: the registry
+// rejects the manifest for another reason, which a Docker manifest list
+// wouldn't fix.
+var mediaTypeRejections = []string{
+	"unsupported media type",
+	"unknown media type",
+	"invalid media type",
+	"http status: 415",
+}
 
-	// Log that we received the parameter (matching original task behavior)
-	b.logger.Info("IMAGE_EXPIRES_AFTER parameter received",
-		zap.String("image", imageURL),
-		zap.String("expires_after", b.config.ImageExpiresAfter))
+// isMediaTypeRejection reports whether a failed push was rejected for the
+// media type of the manifest
+func isMediaTypeRejection(err error) bool {
+	var cmdErr *exec.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
 
-	// TODO: Implement expiration label functionality
-	// The original build-image-index task declares this parameter but doesn't implement it
-	b.logger.Warn("IMAGE_EXPIRES_AFTER functionality not yet implemented (matches original task)")
+	message := strings.ToLower(cmdErr.Message)
+	for _, rejection := range mediaTypeRejections {
+		if strings.Contains(message, rejection) {
+			return true
+		}
+	}
+	return false
+}
 
+// manifestExists reports whether a manifest list with the given name exists in local storage
+func (b *Builder) manifestExists(ctx context.Context, manifestName string) bool {
+	return b.runner.Run(ctx, "buildah", "manifest", "exists", manifestName) == nil
+}
+
+// registry returns a registry client honouring the configured TLS verification
+func (b *Builder) registry() *image.RegistryClient {
+	return image.NewRegistryClient(b.runner, image.RegistryOptions{
+		TLSVerify:          b.config.TLSVerify,
+		InsecureRegistries: b.config.insecureRegistries(),
+	})
+}
+
+// getIndexSize sums the compressed layer sizes of all platform images
+func (b *Builder) getIndexSize(ctx context.Context) (int64, error) {
+	var total int64
+	for _, imageRef := range b.config.Images {
+		size, err := b.getImageSize(ctx, imageRef)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+
+	b.logger.Info("Computed image index size", zap.Int64("size_bytes", total))
+	return total, nil
+}
+
+// getImageSize returns the total compressed size of the layers of an image
+func (b *Builder) getImageSize(ctx context.Context, imageRef string) (int64, error) {
+	args := []string{"inspect"}
+	if !b.config.registrySetting(imageRef).TLSVerify {
+		args = append(args, "--tls-verify=false")
+	}
+	args = append(args, fmt.Sprintf("docker://%s", imageRef))
+
+	output, err := b.runner.RunWithOutput(ctx, "skopeo", args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect image %s: %w", imageRef, err)
+	}
+
+	var inspect struct {
+		LayersData []struct {
+			Size int64 `json:"Size"`
+		} `json:"LayersData"`
+	}
+	if err := json.Unmarshal(output, &inspect); err != nil {
+		return 0, fmt.Errorf("failed to parse inspect output of %s: %w", imageRef, err)
+	}
+
+	var size int64
+	for _, layer := range inspect.LayersData {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// logToolVersions logs the buildah and skopeo versions for reproducibility
+// audits. Versions that can't be determined are recorded as warnings.
+func (b *Builder) logToolVersions(ctx context.Context) error {
+	versions, errs := image.DetectToolVersions(ctx, b.runner)
+	for _, err := range errs {
+		b.logger.Warn("Failed to detect tool version", zap.Error(err))
+		if err := b.warn(warnings.CategoryToolVersion, err.Error()); err != nil {
+			return err
+		}
+	}
+
+	b.logger.Info("Container tool versions",
+		zap.String("buildah", versions.Buildah),
+		zap.String("skopeo", versions.Skopeo))
+	return nil
+}
+
+// warn records a non-fatal problem, returning an error when STRICT_WARNINGS
+// escalates its category
+func (b *Builder) warn(category, message string) error {
+	return b.warnings.Add("", category, message)
+}
+
+// writeWarnings writes the WARNINGS result. Failures are only logged since
+// it runs after the index has been published or has failed.
+func (b *Builder) writeWarnings() {
+	result, err := b.warnings.Result()
+	if err != nil {
+		b.logger.Warn("Skipping WARNINGS result", zap.Error(err))
+		return
+	}
+	if err := b.writeResult("WARNINGS", result); err != nil {
+		b.logger.Warn("Failed to write WARNINGS result", zap.Error(err))
+	}
+}
+
+// dumpConfig logs the sanitized effective configuration at debug level and,
+// when DEBUG_CONFIG is enabled, writes it to effective-config.json in the results directory
+func (b *Builder) dumpConfig() error {
+	dump, err := b.config.DumpSanitized()
+	if err != nil {
+		return err
+	}
+
+	b.logger.Debug("Effective configuration", zap.ByteString("config", dump))
+
+	if b.config.DebugConfig {
+		return b.writeResult("effective-config.json", string(dump))
+	}
+	return nil
+}
+
+// writeMetrics writes the INDEX_METRICS result for the Konflux metrics exporter
+func (b *Builder) writeMetrics(m *metrics.Metrics) error {
+	formatted, err := m.Format()
+	if err != nil {
+		b.logger.Warn("Skipping INDEX_METRICS result", zap.Error(err))
+		return nil
+	}
+	if err := b.writeResult("INDEX_METRICS", formatted); err != nil {
+		return fmt.Errorf("failed to write INDEX_METRICS result: %w", err)
+	}
 	return nil
 }
 
 // writeResult writes a result to the Tekton results directory
 func (b *Builder) writeResult(name, value string) error {
-	resultPath := filepath.Join(b.config.ResultsPath, name)
-	return os.WriteFile(resultPath, []byte(value), 0644)
+	return b.results.Write(name, value)
+}
+
+// setupTempDir creates a temporary directory under TempDir and makes the
+// commands run afterwards use it as TMPDIR. The returned function removes it.
+func (b *Builder) setupTempDir() (func(), error) {
+	dir := b.config.TempDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(b.config.WorkspacePath, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create BUILD_TMPDIR: %w", err)
+	}
+	tempDir, err := os.MkdirTemp(dir, "tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory in %s: %w", dir, err)
+	}
+
+	b.logger.Debug("Staging temporary files", zap.String("tmpdir", tempDir))
+	b.runner = exec.NewEnvCommandRunner(b.runner, []string{"TMPDIR=" + tempDir}, "buildah", "skopeo", "cosign")
+
+	return func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			b.logger.Warn("Failed to remove temporary directory", zap.String("tmpdir", tempDir), zap.Error(err))
+		}
+	}, nil
 }
@@ -0,0 +1,899 @@
+package imageindex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"github.com/konflux-ci/monolithic-builder/pkg/results"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Builder", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *Config
+		builder    *Builder
+	)
+
+	const manifestName = "quay.io/test/image:tag-index"
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		config = &Config{
+			ImageURL:    "quay.io/test/image:tag",
+			Images:      []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image@sha256:arm64"},
+			ResultsPath: GinkgoT().TempDir(),
+			TLSVerify:   true,
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+		mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+			"inspect", "docker://quay.io/test/image:tag")
+	})
+
+	Context("when append mode is enabled", func() {
+		BeforeEach(func() {
+			config.AppendMode = true
+		})
+
+		It("should skip manifest create when the manifest exists locally", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "exists", manifestName)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", manifestName)).To(BeFalse())
+			Expect(mockRunner.AssertCommandExecuted(
+				"buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:amd64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted(
+				"buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:arm64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", manifestName)).To(BeFalse())
+		})
+
+		It("should create the manifest when it doesn't exist yet", func() {
+			mockRunner.SetError("buildah", &exec.CommandError{ExitCode: 1, Message: "not found"},
+				"manifest", "exists", manifestName)
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", manifestName)).To(BeTrue())
+		})
+	})
+
+	Context("when append mode is disabled", func() {
+		It("should always create the manifest without checking for an existing one", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "exists", manifestName)).To(BeFalse())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", manifestName)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", manifestName)).To(BeTrue())
+		})
+	})
+
+	Context("when the index size is requested", func() {
+		BeforeEach(func() {
+			config.WriteIndexSize = true
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:amd64","LayersData":[{"Size":1000},{"Size":234}]}`),
+				"inspect", "docker://quay.io/test/image@sha256:amd64")
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest":"sha256:arm64","LayersData":[{"Size":2000}]}`),
+				"inspect", "docker://quay.io/test/image@sha256:arm64")
+		})
+
+		It("should write the total layer size of all platform images", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "INDEX_SIZE_BYTES"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("3234"))
+
+			content, err = os.ReadFile(filepath.Join(config.ResultsPath, "INDEX_METRICS"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("image_size_bytes=3234\n"))
+		})
+
+		It("should skip the result when an image can't be inspected", func() {
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+				"inspect", "docker://quay.io/test/image@sha256:arm64")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(filepath.Join(config.ResultsPath, "INDEX_SIZE_BYTES")).NotTo(BeAnExistingFile())
+		})
+
+		It("should not inspect the images unless requested", func() {
+			config.WriteIndexSize = false
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "inspect", "docker://quay.io/test/image@sha256:amd64")).To(BeFalse())
+			Expect(filepath.Join(config.ResultsPath, "INDEX_SIZE_BYTES")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("IMAGES", func() {
+		It("should pin every image by digest in order and without duplicates", func() {
+			config.Images = []string{
+				"quay.io/test/image@sha256:amd64",
+				"quay.io/test/image:arm64",
+				"quay.io/test/image@sha256:amd64",
+				"quay.io/test/image:arm64-alias",
+			}
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:arm64"}`), "inspect", "docker://quay.io/test/image:arm64")
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:arm64"}`), "inspect", "docker://quay.io/test/image:arm64-alias")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "IMAGES"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("quay.io/test/image@sha256:amd64,quay.io/test/image:arm64@sha256:arm64," +
+				"quay.io/test/image:arm64-alias@sha256:arm64"))
+		})
+
+		It("should pin a single image referenced by tag", func() {
+			config.Images = []string{"quay.io/test/image:amd64"}
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:amd64"}`), "inspect", "docker://quay.io/test/image:amd64")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "IMAGES"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("quay.io/test/image:amd64@sha256:amd64"))
+			content, err = os.ReadFile(filepath.Join(config.ResultsPath, "IMAGE_DIGEST"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("sha256:amd64"))
+		})
+
+		It("should fail before building the index when an image can't be pinned", func() {
+			config.Images = []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image:arm64"}
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "manifest unknown"},
+				"inspect", "docker://quay.io/test/image:arm64")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to resolve the digest of quay.io/test/image:arm64")))
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", manifestName)).To(BeFalse())
+			Expect(filepath.Join(config.ResultsPath, "IMAGES")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when the component count is requested", func() {
+		BeforeEach(func() {
+			config.WriteComponentCount = true
+		})
+
+		It("should write the number of platform images", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "COMPONENT_COUNT"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("2"))
+		})
+
+		It("should count duplicate images once", func() {
+			config.Images = append(config.Images, "quay.io/test/image@sha256:amd64")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "COMPONENT_COUNT"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("2"))
+		})
+
+		It("should count a single image without an index", func() {
+			config.Images = []string{"quay.io/test/image@sha256:amd64"}
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "COMPONENT_COUNT"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("1"))
+		})
+
+		It("should not write the result unless requested", func() {
+			config.WriteComponentCount = false
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(filepath.Join(config.ResultsPath, "COMPONENT_COUNT")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("manifest commands", func() {
+		manifestCommands := func() [][]string {
+			var commands [][]string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "buildah" && len(cmd) > 1 && cmd[1] == "manifest" {
+					commands = append(commands, cmd)
+				}
+			}
+			return commands
+		}
+
+		It("should create the manifest, add each image, push and remove it", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(manifestCommands()).To(Equal([][]string{
+				{"buildah", "manifest", "create", manifestName},
+				{"buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:amd64"},
+				{"buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:arm64"},
+				{"buildah", "manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag"},
+				{"buildah", "manifest", "rm", manifestName},
+			}))
+		})
+
+		It("should add the pinned images once each", func() {
+			config.Images = []string{
+				"quay.io/test/image@sha256:amd64",
+				"quay.io/test/image:arm64",
+				"quay.io/test/image@sha256:amd64",
+			}
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:arm64"}`), "inspect", "docker://quay.io/test/image:arm64")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(manifestCommands()).To(Equal([][]string{
+				{"buildah", "manifest", "create", manifestName},
+				{"buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:amd64"},
+				{"buildah", "manifest", "add", manifestName, "quay.io/test/image:arm64@sha256:arm64"},
+				{"buildah", "manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag"},
+				{"buildah", "manifest", "rm", manifestName},
+			}))
+		})
+
+		It("should not build an index of a single image listed twice", func() {
+			config.Images = []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image@sha256:amd64"}
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(manifestCommands()).To(BeEmpty())
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "IMAGE_DIGEST"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("sha256:amd64"))
+		})
+
+		It("should disable TLS verification of the registry commands", func() {
+			config.TLSVerify = false
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+				"inspect", "--tls-verify=false", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(manifestCommands()).To(Equal([][]string{
+				{"buildah", "manifest", "create", "--tls-verify=false", manifestName},
+				{"buildah", "manifest", "add", "--tls-verify=false", manifestName, "quay.io/test/image@sha256:amd64"},
+				{"buildah", "manifest", "add", "--tls-verify=false", manifestName, "quay.io/test/image@sha256:arm64"},
+				{"buildah", "manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag", "--tls-verify=false"},
+				{"buildah", "manifest", "rm", manifestName},
+			}))
+		})
+	})
+
+	Describe("TLSVerify", func() {
+		It("should disable TLS verification of every manifest command", func() {
+			config.TLSVerify = false
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+				"inspect", "--tls-verify=false", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", "--tls-verify=false", manifestName)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", "--tls-verify=false", manifestName,
+				"quay.io/test/image@sha256:amd64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", "--tls-verify=false", manifestName,
+				"quay.io/test/image@sha256:arm64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", manifestName,
+				"docker://quay.io/test/image:tag", "--tls-verify=false")).To(BeTrue())
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "skopeo" && len(cmd) > 1 && cmd[1] == "inspect" {
+					Expect(cmd).To(ContainElement("--tls-verify=false"))
+				}
+			}
+		})
+
+		It("should verify TLS by default", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				Expect(cmd).NotTo(ContainElement("--tls-verify=false"))
+			}
+		})
+	})
+
+	Describe("local registries", func() {
+		const localManifestName = "localhost:5000/test/image:tag-index"
+
+		BeforeEach(func() {
+			config.ImageURL = "localhost:5000/test/image:tag"
+			config.Images = []string{"localhost:5000/test/image@sha256:amd64", "quay.io/test/image@sha256:arm64"}
+			config.addLocalRegistries(append([]string{config.ImageURL}, config.Images...)...)
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+				"inspect", "--tls-verify=false", "docker://localhost:5000/test/image:tag")
+		})
+
+		It("should skip TLS verification for the local registry only", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", "--tls-verify=false", localManifestName)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", "--tls-verify=false", localManifestName,
+				"localhost:5000/test/image@sha256:amd64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", localManifestName,
+				"quay.io/test/image@sha256:arm64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", localManifestName,
+				"docker://localhost:5000/test/image:tag", "--tls-verify=false")).To(BeTrue())
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "IMAGE_DIGEST"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("sha256:index"))
+		})
+
+		It("should keep an explicit setting of the local registry", func() {
+			config.RegistrySettings = []RegistrySetting{{Registry: "localhost:5000", TLSVerify: true}}
+			config.addLocalRegistries(config.ImageURL)
+			mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+				"inspect", "docker://localhost:5000/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(config.RegistrySettings).To(HaveLen(1))
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", localManifestName,
+				"docker://localhost:5000/test/image:tag")).To(BeTrue())
+		})
+	})
+
+	Describe("AdditionalImageURLs", func() {
+		BeforeEach(func() {
+			config.AdditionalImageURLs = []string{"registry.local:5000/test/image:tag", "quay.io/mirror/image:tag"}
+			config.RegistrySettings = []RegistrySetting{
+				{Registry: "registry.local:5000", TLSVerify: false, AuthFile: "/auth/local.json"},
+				{Registry: "quay.io/mirror", TLSVerify: true},
+			}
+		})
+
+		It("should push the index to each registry with its TLS setting", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", manifestName,
+				"docker://quay.io/test/image:tag")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", "--authfile", "/auth/local.json",
+				manifestName, "docker://registry.local:5000/test/image:tag", "--tls-verify=false")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", manifestName,
+				"docker://quay.io/mirror/image:tag")).To(BeTrue())
+		})
+
+		It("should prune the manifest with the last push", func() {
+			config.PruneAfterPush = true
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", manifestName,
+				"docker://quay.io/test/image:tag")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "push", "--all", "--rm", manifestName,
+				"docker://quay.io/mirror/image:tag")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", manifestName)).To(BeFalse())
+		})
+
+		It("should fail when an additional push fails", func() {
+			mockRunner.SetError("buildah", &exec.CommandError{ExitCode: 1, Message: "x509: certificate signed by unknown authority"},
+				"manifest", "push", "--all", manifestName, "docker://quay.io/mirror/image:tag")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to push manifest to quay.io/mirror/image:tag")))
+		})
+	})
+
+	Describe("TempDir", func() {
+		It("should run the container tools with a TMPDIR under the workspace and remove it", func() {
+			config.WorkspacePath = GinkgoT().TempDir()
+			config.TempDir = "tmp"
+
+			cleanup, err := builder.setupTempDir()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(builder.runner.Run(ctx, "buildah", "manifest", "create", manifestName)).To(Succeed())
+			Expect(builder.runner.Run(ctx, "cosign", "version")).To(Succeed())
+			Expect(builder.runner.Run(ctx, "true")).To(Succeed())
+
+			commands := mockRunner.GetExecutedCommands()
+			Expect(commands).To(HaveLen(3))
+			tmpDir := strings.TrimPrefix(commands[0][1], "TMPDIR=")
+			Expect(tmpDir).To(HavePrefix(filepath.Join(config.WorkspacePath, "tmp", "tmp-")))
+			Expect(tmpDir).To(BeADirectory())
+			Expect(commands[0]).To(Equal([]string{"env", "TMPDIR=" + tmpDir, "buildah", "manifest", "create", manifestName}))
+			Expect(commands[1]).To(Equal([]string{"env", "TMPDIR=" + tmpDir, "cosign", "version"}))
+			Expect(commands[2]).To(Equal([]string{"true"}))
+
+			cleanup()
+			Expect(tmpDir).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("IMAGE_DIGEST", func() {
+		It("should write the full digest by default", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "IMAGE_DIGEST"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("sha256:index"))
+		})
+
+		It("should write the bare digest when requested", func() {
+			config.DigestFormat = DigestFormatBare
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "IMAGE_DIGEST"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("index"))
+		})
+	})
+
+	Describe("IMAGE_EXPIRES_AFTER", func() {
+		expirationAnnotation := func() string {
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if len(cmd) == 7 && cmd[1] == "manifest" && cmd[2] == "annotate" && cmd[3] == "--index" {
+					return cmd[5]
+				}
+			}
+			return ""
+		}
+
+		It("should annotate the index with an RFC3339 expiration in the future before pushing it", func() {
+			config.ImageExpiresAfter = "1w"
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			key, value, found := strings.Cut(expirationAnnotation(), "=")
+			Expect(found).To(BeTrue())
+			Expect(key).To(Equal("quay.expires-after"))
+			expiresAt, err := time.Parse(time.RFC3339, value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(expiresAt).To(BeTemporally(">", time.Now().Add(6*24*time.Hour)))
+			Expect(expiresAt).To(BeTemporally("<=", time.Now().Add(7*24*time.Hour)))
+
+			var annotated, pushed bool
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if len(cmd) > 2 && cmd[1] == "manifest" && cmd[2] == "annotate" {
+					annotated = true
+				}
+				if len(cmd) > 2 && cmd[1] == "manifest" && cmd[2] == "push" {
+					Expect(annotated).To(BeTrue(), "the index is annotated before it is pushed")
+					pushed = true
+				}
+			}
+			Expect(pushed).To(BeTrue())
+		})
+
+		It("should warn when the pushed index lost the annotation", func() {
+			config.ImageExpiresAfter = "12h"
+			mockRunner.SetOutput("skopeo", []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[]}`),
+				"inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "WARNINGS"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring(`"category":"expiration-label"`))
+			Expect(string(content)).To(ContainSubstring(`pushed index has quay.expires-after=\"\"`))
+		})
+
+		DescribeTable("should fail on an expiration that isn't in the future before creating the index",
+			func(expiresAfter, message string) {
+				config.ImageExpiresAfter = expiresAfter
+
+				err := builder.Execute(ctx)
+
+				Expect(err).To(MatchError(ContainSubstring(message)))
+				Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", manifestName)).To(BeFalse())
+			},
+			Entry("malformed", "soon", `invalid IMAGE_EXPIRES_AFTER "soon"`),
+			Entry("zero", "0d", `invalid IMAGE_EXPIRES_AFTER "0d": the expiration must be in the future`),
+		)
+
+		It("should verify the annotation of the pushed index", func() {
+			mockRunner.SetOutput("skopeo", []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json",`+
+				`"manifests":[],"annotations":{"quay.expires-after":"2030-01-01T00:00:00Z"}}`),
+				"inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+
+			Expect(builder.verifyExpiration(ctx, "quay.io/test/image@sha256:index", "2030-01-01T00:00:00Z")).To(Succeed())
+			Expect(builder.verifyExpiration(ctx, "quay.io/test/image@sha256:index", "2031-01-01T00:00:00Z")).To(
+				MatchError(`pushed index has quay.expires-after="2030-01-01T00:00:00Z", expected "2031-01-01T00:00:00Z"`))
+		})
+	})
+
+	Describe("WARNINGS", func() {
+		BeforeEach(func() {
+			config.WriteIndexSize = true
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+				"inspect", "docker://quay.io/test/image@sha256:amd64")
+		})
+
+		It("should collect the warnings of the index build", func() {
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+				"inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var result struct {
+				Count    int
+				Warnings []warnings.Warning
+			}
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "WARNINGS"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(content, &result)).To(Succeed())
+			var categories []string
+			for _, warning := range result.Warnings {
+				categories = append(categories, warning.Category)
+			}
+			Expect(result.Count).To(Equal(4))
+			Expect(categories).To(Equal([]string{warnings.CategoryToolVersion, warnings.CategoryToolVersion,
+				warnings.CategoryDigest, warnings.CategoryIndexSize}))
+		})
+
+		It("should fail when STRICT_WARNINGS escalates a category", func() {
+			config.StrictWarnings = []string{warnings.CategoryIndexSize}
+			mockRunner.SetOutput("buildah", []byte("buildah version 1.33.7 (image-spec 1.1.0, runtime-spec 1.1.0)"), "--version")
+			mockRunner.SetOutput("skopeo", []byte("skopeo version 1.14.2"), "--version")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("index-size warning escalated to an error")))
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "WARNINGS"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(HavePrefix(`{"count":1,`))
+		})
+	})
+
+	Describe("INDEX_MEDIA_TYPE", func() {
+		DescribeTable("should write the media type of the captured index",
+			func(fixture, mediaType string) {
+				raw, err := os.ReadFile(filepath.Join("testdata", "manifests", fixture))
+				Expect(err).NotTo(HaveOccurred())
+				mockRunner.SetOutput("skopeo", raw, "inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+
+				Expect(builder.Execute(ctx)).To(Succeed())
+
+				content, err := os.ReadFile(filepath.Join(config.ResultsPath, "INDEX_MEDIA_TYPE"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal(mediaType))
+			},
+			Entry("OCI index", "oci-index.json", image.MediaTypeOCIIndex),
+			Entry("docker manifest list", "docker-manifest-list.json", image.MediaTypeDockerManifestList),
+		)
+
+		It("should skip the result when the index can't be fetched", func() {
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "manifest unknown"},
+				"inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(filepath.Join(config.ResultsPath, "INDEX_MEDIA_TYPE")).NotTo(BeAnExistingFile())
+		})
+
+		It("should not write the result for a single image", func() {
+			config.Images = []string{"quay.io/test/image@sha256:amd64"}
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(filepath.Join(config.ResultsPath, "INDEX_MEDIA_TYPE")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("INDEX_METRICS", func() {
+		It("should write the index build and push durations", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "INDEX_METRICS"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(MatchRegexp(
+				`^build_seconds=\d+\.\d{3}\npush_seconds=\d+\.\d{3}\nskipped=false\nretries_total=0\n` +
+					`rate_limited_total=0\ntotal_backoff_seconds=0\.000$`))
+		})
+
+		It("should mark a single image as skipped", func() {
+			config.Images = []string{"quay.io/test/image@sha256:amd64"}
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "INDEX_METRICS"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("skipped=true\nretries_total=0\nrate_limited_total=0\ntotal_backoff_seconds=0.000"))
+		})
+
+		It("should count the registry rate limits backed off from", func() {
+			mockRunner.QueueResult("skopeo", nil, &exec.CommandError{
+				ExitCode: 1,
+				Message:  "exit status 1: toomanyrequests: rate limit exceeded, Retry-After: 0",
+			}, "inspect", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "INDEX_METRICS"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(HaveSuffix("retries_total=1\nrate_limited_total=1\ntotal_backoff_seconds=0.000"))
+		})
+	})
+
+	Describe("PruneAfterPush", func() {
+		It("should remove the manifest as part of the push", func() {
+			config.PruneAfterPush = true
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah",
+				"manifest", "push", "--all", "--rm", manifestName, "docker://quay.io/test/image:tag")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", manifestName)).To(BeFalse())
+		})
+
+		It("should push without --rm and remove the manifest separately by default", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah",
+				"manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag")).To(BeTrue())
+			Expect(mockRunner.String()).NotTo(ContainSubstring("--rm"))
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", manifestName)).To(BeTrue())
+		})
+
+		It("should keep the manifest in append mode", func() {
+			config.PruneAfterPush = true
+			config.AppendMode = true
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.String()).NotTo(ContainSubstring("--rm"))
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", manifestName)).To(BeFalse())
+		})
+	})
+
+	Describe("FAILED_STEP", func() {
+		readResult := func(name string) string {
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, name))
+			Expect(err).NotTo(HaveOccurred())
+			return string(content)
+		}
+
+		It("should flush the failed step when the push fails", func() {
+			pushErr := &exec.CommandError{ExitCode: 125, Message: "unauthorized"}
+			mockRunner.SetError("buildah", pushErr,
+				"manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to build image index")))
+			Expect(errors.Is(err, pushErr)).To(BeTrue())
+			Expect(readResult("FAILED_STEP")).To(Equal(EventStepIndex))
+			Expect(readResult("ERROR_CATEGORY")).To(Equal(results.ErrorCategoryCommand))
+
+			var report results.FailureReport
+			Expect(json.Unmarshal([]byte(readResult(results.FailureReportFile)), &report)).To(Succeed())
+			Expect(report.Command).To(Equal([]string{"buildah", "manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag"}))
+			Expect(report.ExitCode).To(Equal(125))
+			Expect(report.StderrTail).To(Equal("unauthorized"))
+			Expect(report.Hint).To(Equal(results.FailureHint(results.ErrorCategoryCommand, EventStepIndex)))
+			_, statErr := os.Stat(filepath.Join(config.ResultsPath, "IMAGE_URL"))
+			Expect(os.IsNotExist(statErr)).To(BeTrue())
+		})
+
+		It("should flush the image of the index when signing fails", func() {
+			config.KeylessSigning = true
+			GinkgoT().Setenv(EnvCosignIdentityToken, "oidc-token")
+			mockRunner.SetError("cosign", &exec.CommandError{ExitCode: 1, Message: "fulcio unavailable"},
+				"sign", "--yes", "--identity-token", "oidc-token", "quay.io/test/image@sha256:index")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to sign image")))
+			Expect(readResult("FAILED_STEP")).To(Equal("signing"))
+			Expect(readResult("ERROR_CATEGORY")).To(Equal(results.ErrorCategoryCommand))
+			Expect(readResult("IMAGE_URL")).To(Equal("quay.io/test/image:tag"))
+			Expect(readResult("IMAGE_DIGEST")).To(Equal("sha256:index"))
+
+			report := readResult(results.FailureReportFile)
+			Expect(report).To(ContainSubstring(`"step": "signing"`))
+			Expect(report).To(ContainSubstring(`"--identity-token",`))
+			Expect(report).NotTo(ContainSubstring("oidc-token"))
+		})
+
+		It("should not write FAILED_STEP when the build succeeds", func() {
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			_, err := os.Stat(filepath.Join(config.ResultsPath, "FAILED_STEP"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(filepath.Join(config.ResultsPath, results.FailureReportFile)).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("UpdateExisting", func() {
+		const (
+			repository   = "quay.io/test/image"
+			amd64Digest  = "sha256:a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"
+			arm64Digest  = "sha256:b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2"
+			ppc64Digest  = "sha256:c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3"
+			attestDigest = "sha256:d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4"
+		)
+
+		var existingDigest string
+
+		platform := func(imageRef, os, arch string) {
+			mockRunner.SetOutput("skopeo", []byte(`{"Os":"`+os+`","Architecture":"`+arch+`"}`), "inspect", "docker://"+imageRef)
+		}
+
+		BeforeEach(func() {
+			config.UpdateExisting = true
+			raw, err := os.ReadFile(filepath.Join("testdata", "manifests", "existing-index.json"))
+			Expect(err).NotTo(HaveOccurred())
+			manifest, err := image.ParseManifest(raw)
+			Expect(err).NotTo(HaveOccurred())
+			existingDigest = manifest.Digest
+			mockRunner.SetOutput("skopeo", raw, "inspect", "--raw", "docker://quay.io/test/image:tag")
+		})
+
+		It("should replace the rebuilt platform and keep the other entries with their annotations", func() {
+			config.Images = []string{"quay.io/test/image@sha256:newarm64"}
+			config.WriteComponentCount = true
+			platform("quay.io/test/image@sha256:newarm64", "linux", "arm64")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			var manifestCommands [][]string
+			for _, cmd := range mockRunner.GetExecutedCommands() {
+				if cmd[0] == "buildah" && cmd[1] == "manifest" {
+					manifestCommands = append(manifestCommands, cmd)
+				}
+			}
+			Expect(manifestCommands).To(Equal([][]string{
+				{"buildah", "manifest", "create", manifestName},
+				{"buildah", "manifest", "add", "--os", "linux", "--arch", "amd64", manifestName, repository + "@" + amd64Digest},
+				{"buildah", "manifest", "add", "--os", "linux", "--arch", "ppc64le", manifestName, repository + "@" + ppc64Digest},
+				{"buildah", "manifest", "annotate", "--annotation", "io.konflux.pipeline=nightly",
+					"--annotation", "org.opencontainers.image.revision=0123456789abcdef", manifestName, ppc64Digest},
+				{"buildah", "manifest", "annotate", "--index", "--annotation", "org.opencontainers.image.created=2024-06-01T12:00:00Z", manifestName},
+				{"buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:newarm64"},
+				{"buildah", "manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag"},
+				{"buildah", "manifest", "rm", manifestName},
+			}))
+
+			content, err := os.ReadFile(filepath.Join(config.ResultsPath, "PREVIOUS_INDEX_DIGEST"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(existingDigest))
+			content, err = os.ReadFile(filepath.Join(config.ResultsPath, "IMAGE_DIGEST"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("sha256:index"))
+			content, err = os.ReadFile(filepath.Join(config.ResultsPath, "COMPONENT_COUNT"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("3"))
+		})
+
+		It("should keep every entry, attestations included, when adding a new platform", func() {
+			config.Images = []string{"quay.io/test/image@sha256:s390x"}
+			platform("quay.io/test/image@sha256:s390x", "linux", "s390x")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", "--os", "linux", "--arch", "arm64", "--variant", "v8",
+				manifestName, repository+"@"+arm64Digest)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", "--os", "unknown", "--arch", "unknown",
+				manifestName, repository+"@"+attestDigest)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:s390x")).To(BeTrue())
+		})
+
+		It("should create the index when none exists yet", func() {
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "reading manifest tag in quay.io/test/image: manifest unknown"},
+				"inspect", "--raw", "docker://quay.io/test/image:tag")
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:amd64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "add", manifestName, "quay.io/test/image@sha256:arm64")).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("skopeo", "inspect", "docker://quay.io/test/image@sha256:amd64")).To(BeFalse())
+			Expect(filepath.Join(config.ResultsPath, "PREVIOUS_INDEX_DIGEST")).NotTo(BeAnExistingFile())
+		})
+
+		It("should fail rather than drop the existing entries when the index can't be fetched", func() {
+			mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized: access to the requested resource is not authorized"},
+				"inspect", "--raw", "docker://quay.io/test/image:tag")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to fetch the existing index")))
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "create", manifestName)).To(BeFalse())
+		})
+
+		It("should reject two images for the same platform", func() {
+			platform("quay.io/test/image@sha256:amd64", "linux", "arm64")
+			platform("quay.io/test/image@sha256:arm64", "linux", "arm64")
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring(
+				"images quay.io/test/image@sha256:amd64 and quay.io/test/image@sha256:arm64 are both for platform linux/arm64")))
+		})
+	})
+
+	Describe("FallbackToDockerManifest", func() {
+		var ociPush, dockerPush []string
+
+		BeforeEach(func() {
+			ociPush = []string{"manifest", "push", "--all", manifestName, "docker://quay.io/test/image:tag"}
+			dockerPush = []string{"manifest", "push", "--all", "--format", "v2s2", manifestName, "docker://quay.io/test/image:tag"}
+			mockRunner.QueueResult("buildah", nil, &exec.CommandError{
+				ExitCode: 125,
+				Message:  "exit status 125: Error: writing manifest: uploading manifest to quay.io/test/image:tag: manifest invalid: unsupported media type",
+			}, ociPush...)
+		})
+
+		It("should retry a push rejected for its media type as a Docker manifest list", func() {
+			config.FallbackToDockerManifest = true
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", ociPush...)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", dockerPush...)).To(BeTrue())
+			Expect(mockRunner.AssertCommandExecuted("buildah", "manifest", "rm", manifestName)).To(BeTrue())
+		})
+
+		It("should fail without the fallback", func() {
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("unsupported media type")))
+			Expect(mockRunner.AssertCommandExecuted("buildah", dockerPush...)).To(BeFalse())
+		})
+
+		It("should not retry other push failures", func() {
+			config.FallbackToDockerManifest = true
+			mockRunner.Reset()
+			mockRunner.QueueResult("buildah", nil, &exec.CommandError{ExitCode: 125, Message: "unauthorized: access denied"}, ociPush...)
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to push manifest: unauthorized")))
+			Expect(mockRunner.AssertCommandExecuted("buildah", dockerPush...)).To(BeFalse())
+		})
+
+		DescribeTable("should not retry manifest errors unrelated to the media type",
+			func(message string) {
+				config.FallbackToDockerManifest = true
+				mockRunner.Reset()
+				mockRunner.QueueResult("buildah", nil, &exec.CommandError{ExitCode: 125, Message: message}, ociPush...)
+
+				err := builder.Execute(ctx)
+
+				Expect(err).To(MatchError(ContainSubstring("failed to push manifest")))
+				Expect(mockRunner.AssertCommandExecuted("buildah", dockerPush...)).To(BeFalse())
+			},
+			Entry("a plain MANIFEST_INVALID", "Error: writing manifest: uploading manifest to quay.io/test/image:tag: "+
+				"manifest invalid: manifest invalid"),
+			Entry("an error echoing the mediaType key", `Error: manifest_invalid: {"mediaType":"application/vnd.oci.image.index.v1+json"} `+
+				"references a blob that doesn't exist"),
+		)
+
+		It("should retry a push rejected with a 415 status", func() {
+			config.FallbackToDockerManifest = true
+			mockRunner.Reset()
+			mockRunner.QueueResult("buildah", nil, &exec.CommandError{
+				ExitCode: 125,
+				Message:  "Error: writing manifest: uploading manifest to quay.io/test/image:tag: received unexpected HTTP status: 415",
+			}, ociPush...)
+
+			Expect(builder.Execute(ctx)).To(Succeed())
+
+			Expect(mockRunner.AssertCommandExecuted("buildah", dockerPush...)).To(BeTrue())
+		})
+
+		It("should report a failed fallback push", func() {
+			config.FallbackToDockerManifest = true
+			mockRunner.QueueResult("buildah", nil, &exec.CommandError{ExitCode: 125, Message: "unauthorized"}, dockerPush...)
+
+			err := builder.Execute(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("failed to push manifest as a Docker manifest list")))
+		})
+	})
+})
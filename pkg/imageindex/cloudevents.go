@@ -0,0 +1,126 @@
+package imageindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultCloudEventType is the type of the step events unless CLOUD_EVENT_TYPE is set
+const DefaultCloudEventType = "dev.konflux.build-image-index.step.finished"
+
+// CloudEventSource identifies the builder as the source of its events
+const CloudEventSource = "/konflux-ci/monolithic-builder/build-image-index"
+
+// cloudEventTimeout bounds each event request
+const cloudEventTimeout = 10 * time.Second
+
+// Steps of Execute after which an event is sent
+const (
+	// EventStepIndex follows the creation of the index, or the resolution of a single image
+	EventStepIndex = "index"
+
+	// EventStepResults follows the writing of the Tekton results
+	EventStepResults = "results"
+
+	// EventStepSummary follows the writing of the YAML summary
+	EventStepSummary = "summary"
+)
+
+// CloudEvent is a CloudEvents 1.0 envelope in the structured JSON mode
+type CloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject,omitempty"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            *StepEventData `json:"data"`
+}
+
+// StepEventData is the payload of a step event
+type StepEventData struct {
+	Step        string `json:"step"`
+	ImageURL    string `json:"image_url"`
+	ImageDigest string `json:"image_digest"`
+}
+
+// newCloudEvent creates the event of a finished step
+func newCloudEvent(eventType string, data *StepEventData) (*CloudEvent, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate event ID: %w", err)
+	}
+
+	return &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(id),
+		Source:          CloudEventSource,
+		Type:            eventType,
+		Subject:         data.Step,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// sendCloudEvent posts an event to the CloudEvents endpoint
+func (b *Builder) sendCloudEvent(ctx context.Context, event *CloudEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode cloud event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cloudEventTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.config.CloudEventsEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create cloud event request: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send cloud event: %w", withoutURL(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("cloud events endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// emitStepEvent sends the event of a finished step when an endpoint is
+// configured. Failures are only logged so that telemetry never fails the build.
+func (b *Builder) emitStepEvent(ctx context.Context, step, imageURL, imageDigest string) {
+	if b.config.CloudEventsEndpoint == "" {
+		return
+	}
+
+	event, err := newCloudEvent(b.config.CloudEventType, &StepEventData{
+		Step:        step,
+		ImageURL:    imageURL,
+		ImageDigest: imageDigest,
+	})
+	if err == nil {
+		err = b.sendCloudEvent(ctx, event)
+	}
+	if err != nil {
+		b.logger.Warn("Failed to send cloud event", zap.String("step", step), zap.Error(err))
+		return
+	}
+	b.logger.Debug("Sent cloud event", zap.String("step", step), zap.String("id", event.ID))
+}
@@ -0,0 +1,146 @@
+package imageindex
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("CloudEvents", func() {
+	var (
+		ctx         context.Context
+		mockRunner  *exec.MockCommandRunner
+		config      *Config
+		builder     *Builder
+		server      *httptest.Server
+		mu          sync.Mutex
+		events      []CloudEvent
+		contentType string
+		status      int
+	)
+
+	// received returns the events received so far
+	received := func() []CloudEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]CloudEvent(nil), events...)
+	}
+
+	// steps returns the steps of the events received so far
+	steps := func() []string {
+		var names []string
+		for _, event := range received() {
+			names = append(names, event.Data.Step)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		events = nil
+		status = http.StatusAccepted
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var event CloudEvent
+			Expect(json.Unmarshal(body, &event)).To(Succeed())
+
+			mu.Lock()
+			events = append(events, event)
+			contentType = r.Header.Get("Content-Type")
+			mu.Unlock()
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(server.Close)
+
+		mockRunner = exec.NewMockCommandRunner()
+		config = &Config{
+			ImageURL:            "quay.io/test/image:tag",
+			Images:              []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image@sha256:arm64"},
+			ResultsPath:         GinkgoT().TempDir(),
+			TLSVerify:           true,
+			CloudEventsEndpoint: server.URL,
+			CloudEventType:      DefaultCloudEventType,
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+		mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+			"inspect", "docker://quay.io/test/image:tag")
+	})
+
+	It("should send an event after each major step", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(steps()).To(Equal([]string{EventStepIndex, EventStepResults}))
+		Expect(contentType).To(Equal("application/cloudevents+json"))
+
+		event := received()[0]
+		Expect(event.SpecVersion).To(Equal("1.0"))
+		Expect(event.ID).To(HaveLen(32))
+		Expect(event.Source).To(Equal(CloudEventSource))
+		Expect(event.Type).To(Equal(DefaultCloudEventType))
+		Expect(event.Subject).To(Equal(EventStepIndex))
+		Expect(event.DataContentType).To(Equal("application/json"))
+		Expect(event.Time).To(BeTemporally("~", time.Now(), time.Minute))
+		Expect(event.Data).To(Equal(&StepEventData{
+			Step:        EventStepIndex,
+			ImageURL:    "quay.io/test/image:tag",
+			ImageDigest: "sha256:index",
+		}))
+		Expect(received()[1].ID).NotTo(Equal(event.ID))
+	})
+
+	It("should use the configured event type", func() {
+		config.CloudEventType = "com.example.index.step"
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		for _, event := range received() {
+			Expect(event.Type).To(Equal("com.example.index.step"))
+		}
+	})
+
+	It("should send an event after the YAML summary", func() {
+		config.WriteYAMLSummary = true
+		config.YAMLOutputPath = filepath.Join(GinkgoT().TempDir(), "summary.yaml")
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(steps()).To(Equal([]string{EventStepIndex, EventStepResults, EventStepSummary}))
+	})
+
+	It("should not fail the build when the endpoint fails", func() {
+		status = http.StatusServiceUnavailable
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(received()).To(HaveLen(2))
+	})
+
+	It("should not leak the endpoint in errors", func() {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		config.CloudEventsEndpoint = closed.URL + "/events/s3cret-token"
+
+		err := builder.sendCloudEvent(ctx, &CloudEvent{})
+
+		Expect(err).To(MatchError(ContainSubstring("failed to send cloud event")))
+		Expect(err.Error()).NotTo(ContainSubstring("s3cret-token"))
+	})
+
+	It("should not send events without an endpoint", func() {
+		config.CloudEventsEndpoint = ""
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(received()).To(BeEmpty())
+	})
+})
@@ -1,9 +1,26 @@
 package imageindex
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/duration"
+	"github.com/konflux-ci/monolithic-builder/pkg/envconfig"
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
+	"github.com/konflux-ci/monolithic-builder/pkg/results"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
+)
+
+// Digest formats of the IMAGE_DIGEST result
+const (
+	// DigestFormatFull writes the digest with its algorithm, e.g. sha256:abc
+	DigestFormatFull = "full"
+
+	// DigestFormatBare writes the digest without its algorithm, e.g. abc
+	DigestFormatBare = "bare"
 )
 
 // Config holds all configuration parameters for the monolithic build-image-index task
@@ -15,37 +32,210 @@ type Config struct {
 	AlwaysBuildIndex  bool
 	Images            []string
 
+	// AppendMode adds the images to an existing local manifest list instead
+	// of creating a new one, and keeps the manifest after pushing
+	AppendMode bool
+
+	// UpdateExisting starts the index from the one published at ImageURL,
+	// replacing the entries of the platforms of Images and keeping the
+	// others, so that a pipeline can rebuild a single platform
+	UpdateExisting bool
+
+	// PruneAfterPush removes the local manifest as part of the push with
+	// "buildah manifest push --rm" instead of a separate "manifest rm"
+	PruneAfterPush bool
+
+	// FallbackToDockerManifest retries a push rejected for its OCI media type
+	// as a Docker manifest list, for registries without OCI support
+	FallbackToDockerManifest bool
+
+	// DigestFormat is the format of the IMAGE_DIGEST result, DigestFormatFull
+	// or DigestFormatBare
+	DigestFormat string
+
+	// WriteComponentCount writes the number of entries of the index as COMPONENT_COUNT
+	WriteComponentCount bool
+
+	// WriteIndexSize writes the total compressed layer size of all images as INDEX_SIZE_BYTES
+	WriteIndexSize bool
+
 	// Workspace paths
-	ResultsPath string
+	WorkspacePath string
+	ResultsPath   string
+
+	// TempDir is where buildah, skopeo and cosign stage temporary files,
+	// relative to the workspace unless absolute. The builder creates a
+	// directory there, propagated as TMPDIR and removed at the end.
+	TempDir string
 
 	// Registry configuration
 	TLSVerify bool
+
+	// AdditionalImageURLs receive the index pushed to ImageURL, each with the
+	// RegistrySettings entry matching its registry
+	AdditionalImageURLs []string
+	RegistrySettings    []RegistrySetting
+
+	// AutoInsecureLocalRegistries adds a setting without TLS verification
+	// for the loopback and in-cluster registries of ImageURL, Images and
+	// AdditionalImageURLs that no RegistrySettings entry matches
+	AutoInsecureLocalRegistries bool
+
+	// StrictWarnings lists the warning categories that fail the build
+	// instead of only being reported in the WARNINGS result
+	StrictWarnings []string
+
+	// FailureGracePeriod bounds the flush of the results gathered so far,
+	// with FAILED_STEP and ERROR_CATEGORY, when the build fails
+	FailureGracePeriod time.Duration
+
+	// Debugging
+	DebugConfig bool
+
+	// WebhookURL receives a POST once the index is published, with a JSON
+	// body rendered from WebhookPayloadTemplate
+	WebhookURL             string
+	WebhookPayloadTemplate string
+
+	// CloudEventsEndpoint receives a CloudEvent of type CloudEventType after
+	// each major step of the build
+	CloudEventsEndpoint string
+	CloudEventType      string
+
+	// KeylessSigning signs the published image with cosign keyless signing,
+	// exchanging the OIDC token of COSIGN_IDENTITY_TOKEN with Fulcio.
+	// OIDCIssuer overrides the issuer cosign expects the token from.
+	KeylessSigning bool
+	OIDCIssuer     string
+
+	// WriteYAMLSummary writes the image, digest, platforms and creation time
+	// of the published image as YAML to YAMLOutputPath, for GitOps tooling
+	WriteYAMLSummary bool
+	YAMLOutputPath   string
 }
 
-// LoadConfigFromEnv loads configuration from environment variables
+// LoadConfigFromEnv loads configuration from the params files or environment
+// variables
 func LoadConfigFromEnv() (*Config, error) {
+	if err := envconfig.Validate(); err != nil {
+		return nil, err
+	}
+
 	config := &Config{
-		ImageURL:          getEnv("IMAGE", ""),
-		CommitSHA:         getEnv("COMMIT_SHA", ""),
-		ImageExpiresAfter: getEnv("IMAGE_EXPIRES_AFTER", ""),
-		AlwaysBuildIndex:  getEnvBool("ALWAYS_BUILD_INDEX", false),
-		Images:            getEnvArray("IMAGES"),
-		ResultsPath:       getEnv("RESULTS_PATH", "/tekton/results"),
-		TLSVerify:         getEnvBool("TLSVERIFY", true),
+		ImageURL:            getEnv("IMAGE", ""),
+		CommitSHA:           getEnv("COMMIT_SHA", ""),
+		ImageExpiresAfter:   getEnv("IMAGE_EXPIRES_AFTER", ""),
+		AlwaysBuildIndex:    getEnvBool("ALWAYS_BUILD_INDEX", false),
+		Images:              getEnvArray("IMAGES"),
+		AppendMode:          getEnvBool("MANIFEST_APPEND_MODE", false),
+		PruneAfterPush:      getEnvBool("MANIFEST_PRUNE_AFTER_PUSH", false),
+		UpdateExisting:      getEnvBool("UPDATE_EXISTING", false),
+		WriteIndexSize:      getEnvBool("WRITE_INDEX_SIZE", false),
+		WriteComponentCount: getEnvBool("WRITE_COMPONENT_COUNT", false),
+		TLSVerify:           getEnvBool("TLSVERIFY", true),
+		WorkspacePath:       getEnv("WORKSPACE_PATH", "/workspace"),
+		TempDir:             getEnv("BUILD_TMPDIR", ""),
+		DebugConfig:         getEnvBool("DEBUG_CONFIG", false),
+
+		FallbackToDockerManifest: getEnvBool("FALLBACK_TO_DOCKER_MANIFEST", false),
+
+		AdditionalImageURLs:         getEnvArray("ADDITIONAL_IMAGES"),
+		AutoInsecureLocalRegistries: getEnvBool("AUTO_INSECURE_LOCAL_REGISTRIES", true),
+
+		DigestFormat: getEnv("IMAGE_DIGEST_FORMAT", DigestFormatFull),
+
+		WebhookURL:             getEnv("WEBHOOK_URL", ""),
+		WebhookPayloadTemplate: getEnv("WEBHOOK_PAYLOAD_TEMPLATE", ""),
+
+		YAMLOutputPath: getEnv("YAML_SUMMARY_OUTPUT", ""),
+
+		CloudEventsEndpoint: getEnv("CLOUD_EVENTS_ENDPOINT", ""),
+		CloudEventType:      getEnv("CLOUD_EVENT_TYPE", DefaultCloudEventType),
+
+		KeylessSigning: getEnvBool("KEYLESS_SIGNING", false),
+		OIDCIssuer:     getEnv("OIDC_ISSUER", ""),
 	}
+	config.WriteYAMLSummary = config.YAMLOutputPath != ""
+
+	if config.ImageExpiresAfter != "" {
+		if _, err := duration.ParseExtended(config.ImageExpiresAfter); err != nil {
+			return nil, fmt.Errorf("invalid IMAGE_EXPIRES_AFTER: %w", err)
+		}
+	}
+
+	if config.DigestFormat != DigestFormatFull && config.DigestFormat != DigestFormatBare {
+		return nil, fmt.Errorf("invalid IMAGE_DIGEST_FORMAT %q, expected %s or %s",
+			config.DigestFormat, DigestFormatFull, DigestFormatBare)
+	}
+
+	if config.UpdateExisting && config.AppendMode {
+		return nil, fmt.Errorf("UPDATE_EXISTING can't be combined with MANIFEST_APPEND_MODE")
+	}
+
+	registrySettings, err := ParseRegistrySettings(getEnv("REGISTRY_SETTINGS", ""), config.TLSVerify)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REGISTRY_SETTINGS: %w", err)
+	}
+	config.RegistrySettings = registrySettings
+	if config.AutoInsecureLocalRegistries {
+		refs := append([]string{config.ImageURL}, config.Images...)
+		config.addLocalRegistries(append(refs, config.AdditionalImageURLs...)...)
+	}
+
+	if _, err := ParseWebhookTemplate(config.WebhookPayloadTemplate); err != nil {
+		return nil, err
+	}
+
+	if config.KeylessSigning && os.Getenv(EnvCosignIdentityToken) == "" {
+		return nil, fmt.Errorf("%s is required when KEYLESS_SIGNING is set", EnvCosignIdentityToken)
+	}
+
+	strictWarnings, err := warnings.ParseCategories(getEnv("STRICT_WARNINGS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STRICT_WARNINGS: %w", err)
+	}
+	config.StrictWarnings = strictWarnings
+
+	failureGracePeriod, err := getEnvDuration("FAILURE_GRACE_PERIOD", results.DefaultFailureGracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	config.FailureGracePeriod = failureGracePeriod
+
+	resultsPath, err := results.ResolveDir()
+	if err != nil {
+		return nil, err
+	}
+	config.ResultsPath = resultsPath
 
 	return config, nil
 }
 
+// FormatDigest renders a digest in the given format. Digests are kept as-is
+// in the full format.
+func FormatDigest(digest, format string) string {
+	if format == DigestFormatBare {
+		if _, hex, found := strings.Cut(digest, ":"); found {
+			return hex
+		}
+	}
+	return digest
+}
+
+// DumpSanitized renders the effective configuration as JSON with secret-bearing values masked
+func (c *Config) DumpSanitized() ([]byte, error) {
+	return redact.JSON(c)
+}
+
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := envconfig.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
 func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
+	if value := envconfig.Getenv(key); value != "" {
 		parsed, err := strconv.ParseBool(value)
 		if err == nil {
 			return parsed
@@ -54,8 +244,21 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getEnvDuration parses a duration such as 90s, 2h or 7d
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := envconfig.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := duration.ParseExtended(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
 func getEnvArray(key string) []string {
-	if value := os.Getenv(key); value != "" {
+	if value := envconfig.Getenv(key); value != "" {
 		return strings.Split(value, ",")
 	}
 	return []string{}
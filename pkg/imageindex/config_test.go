@@ -0,0 +1,125 @@
+package imageindex
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+var _ = Describe("Config", func() {
+	Describe("DumpSanitized", func() {
+		It("should match the golden effective configuration", func() {
+			config := &Config{
+				ImageURL:    "quay.io/test/image:tag",
+				CommitSHA:   "abc123def456",
+				Images:      []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image@sha256:arm64"},
+				ResultsPath: "/tekton/results",
+				TLSVerify:   true,
+			}
+
+			dump, err := config.DumpSanitized()
+			Expect(err).NotTo(HaveOccurred())
+
+			goldenPath := filepath.Join("testdata", "effective-config.golden.json")
+			if *updateGolden {
+				Expect(os.WriteFile(goldenPath, dump, 0644)).To(Succeed())
+			}
+			golden, err := os.ReadFile(goldenPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(dump)).To(Equal(string(golden)))
+		})
+	})
+
+	Describe("FormatDigest", func() {
+		const digest = "sha256:4b1e6cd0e5c1a0e9f1f8a3e6a5d2c4b7e9f0a1b2c3d4e5f60718293a4b5c6d7e"
+
+		DescribeTable("formatting digests",
+			func(format, expected string) {
+				Expect(FormatDigest(digest, format)).To(Equal(expected))
+			},
+			Entry("full", DigestFormatFull, digest),
+			Entry("bare", DigestFormatBare, "4b1e6cd0e5c1a0e9f1f8a3e6a5d2c4b7e9f0a1b2c3d4e5f60718293a4b5c6d7e"),
+		)
+
+		It("should keep an empty digest empty", func() {
+			Expect(FormatDigest("", DigestFormatBare)).To(BeEmpty())
+		})
+	})
+
+	Describe("IMAGE_DIGEST_FORMAT", func() {
+		BeforeEach(func() {
+			GinkgoT().Setenv("RESULTS_PATH", GinkgoT().TempDir())
+		})
+
+		It("should default to the full format", func() {
+			config, err := LoadConfigFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.DigestFormat).To(Equal(DigestFormatFull))
+		})
+
+		It("should reject an unknown format", func() {
+			GinkgoT().Setenv("IMAGE_DIGEST_FORMAT", "short")
+
+			_, err := LoadConfigFromEnv()
+			Expect(err).To(MatchError(`invalid IMAGE_DIGEST_FORMAT "short", expected full or bare`))
+		})
+	})
+
+	Describe("UPDATE_EXISTING", func() {
+		It("should reject the append mode", func() {
+			GinkgoT().Setenv("RESULTS_PATH", GinkgoT().TempDir())
+			GinkgoT().Setenv("UPDATE_EXISTING", "true")
+			GinkgoT().Setenv("MANIFEST_APPEND_MODE", "true")
+
+			_, err := LoadConfigFromEnv()
+			Expect(err).To(MatchError("UPDATE_EXISTING can't be combined with MANIFEST_APPEND_MODE"))
+		})
+	})
+
+	Describe("PARAMS_PATH", func() {
+		It("should prefer the params files over the environment variables", func() {
+			paramsDir := GinkgoT().TempDir()
+			GinkgoT().Setenv("RESULTS_PATH", GinkgoT().TempDir())
+			GinkgoT().Setenv("PARAMS_PATH", paramsDir)
+			GinkgoT().Setenv("IMAGE", "quay.io/test/env:tag")
+			GinkgoT().Setenv("TLSVERIFY", "false")
+			Expect(os.WriteFile(filepath.Join(paramsDir, "IMAGE"), []byte("quay.io/test/file:tag\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(paramsDir, "IMAGES"), []byte("quay.io/test/file@sha256:amd64,quay.io/test/file@sha256:arm64\n"), 0644)).To(Succeed())
+
+			config, err := LoadConfigFromEnv()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.ImageURL).To(Equal("quay.io/test/file:tag"))
+			Expect(config.Images).To(Equal([]string{"quay.io/test/file@sha256:amd64", "quay.io/test/file@sha256:arm64"}))
+			Expect(config.TLSVerify).To(BeFalse())
+		})
+	})
+
+	Describe("REGISTRY_SETTINGS", func() {
+		BeforeEach(func() {
+			GinkgoT().Setenv("RESULTS_PATH", GinkgoT().TempDir())
+		})
+
+		It("should load the additional images and their registry settings", func() {
+			GinkgoT().Setenv("ADDITIONAL_IMAGES", "registry.local:5000/test/image:tag")
+			GinkgoT().Setenv("REGISTRY_SETTINGS", `[{"registry":"registry.local:5000","tlsVerify":false}]`)
+
+			config, err := LoadConfigFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.AdditionalImageURLs).To(Equal([]string{"registry.local:5000/test/image:tag"}))
+			Expect(config.RegistrySettings).To(Equal([]RegistrySetting{{Registry: "registry.local:5000", TLSVerify: false}}))
+		})
+
+		It("should reject invalid settings", func() {
+			GinkgoT().Setenv("REGISTRY_SETTINGS", `[{"registry":""}]`)
+
+			_, err := LoadConfigFromEnv()
+			Expect(err).To(MatchError(`invalid REGISTRY_SETTINGS: invalid registry "", expected a registry host without scheme`))
+		})
+	})
+})
@@ -0,0 +1,54 @@
+package imageindex
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/duration"
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+)
+
+// annotationExpiresAfter is the annotation Quay expires tags by, the same
+// key as the label buildah build sets on the images
+const annotationExpiresAfter = "quay.expires-after"
+
+// expiresAt returns the RFC3339 expiration time of ImageExpiresAfter from now,
+// failing on durations that don't parse or don't lie in the future
+func (b *Builder) expiresAt() (string, error) {
+	expiresAfter, err := duration.ParseExtended(b.config.ImageExpiresAfter)
+	if err != nil {
+		return "", fmt.Errorf("invalid IMAGE_EXPIRES_AFTER %q: %w", b.config.ImageExpiresAfter, err)
+	}
+	if expiresAfter <= 0 {
+		return "", fmt.Errorf("invalid IMAGE_EXPIRES_AFTER %q: the expiration must be in the future", b.config.ImageExpiresAfter)
+	}
+	return time.Now().Add(expiresAfter).UTC().Format(time.RFC3339), nil
+}
+
+// annotateExpiration sets the quay.expires-after annotation on the local index
+func (b *Builder) annotateExpiration(ctx context.Context, manifestName, expiresAt string) error {
+	err := b.runner.Run(ctx, "buildah", "manifest", "annotate", "--index",
+		"--annotation", annotationExpiresAfter+"="+expiresAt, manifestName)
+	if err != nil {
+		return fmt.Errorf("failed to annotate manifest with the expiration: %w", err)
+	}
+	return nil
+}
+
+// verifyExpiration checks that the index pushed at ref kept the expiration
+// annotation, which Docker manifest lists drop
+func (b *Builder) verifyExpiration(ctx context.Context, ref, expiresAt string) error {
+	raw, err := b.registry().RawManifest(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the pushed index: %w", err)
+	}
+	manifest, err := image.ParseManifest(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse the pushed index: %w", err)
+	}
+	if got := manifest.Annotations[annotationExpiresAfter]; got != expiresAt {
+		return fmt.Errorf("pushed index has %s=%q, expected %q", annotationExpiresAfter, got, expiresAt)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package imageindex_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestImageindex(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Imageindex Suite")
+}
@@ -0,0 +1,92 @@
+package imageindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+)
+
+// RegistrySetting configures the pushes to the registry, or the repositories
+// of a registry, whose references start with Registry
+type RegistrySetting struct {
+	Registry  string
+	TLSVerify bool
+	AuthFile  string
+}
+
+// ParseRegistrySettings parses the JSON array of REGISTRY_SETTINGS, such as
+// [{"registry":"registry.local:5000","tlsVerify":false}]. TLSVerify defaults
+// to tlsVerify when omitted.
+func ParseRegistrySettings(value string, tlsVerify bool) ([]RegistrySetting, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var entries []struct {
+		Registry  string `json:"registry"`
+		TLSVerify *bool  `json:"tlsVerify"`
+		AuthFile  string `json:"authFile"`
+	}
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of registry, tlsVerify and authFile objects: %w", err)
+	}
+
+	settings := make([]RegistrySetting, 0, len(entries))
+	for _, entry := range entries {
+		registry := strings.TrimSuffix(entry.Registry, "/")
+		if registry == "" || strings.Contains(registry, "://") {
+			return nil, fmt.Errorf("invalid registry %q, expected a registry host without scheme", entry.Registry)
+		}
+		setting := RegistrySetting{Registry: registry, TLSVerify: tlsVerify, AuthFile: entry.AuthFile}
+		if entry.TLSVerify != nil {
+			setting.TLSVerify = *entry.TLSVerify
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// registrySetting returns the setting of the longest registry prefix of
+// imageURL, or the TLSVerify of the config when none matches
+func (c *Config) registrySetting(imageURL string) RegistrySetting {
+	match := RegistrySetting{TLSVerify: c.TLSVerify}
+	for _, setting := range c.RegistrySettings {
+		if imageURL != setting.Registry && !strings.HasPrefix(imageURL, setting.Registry+"/") {
+			continue
+		}
+		if len(setting.Registry) > len(match.Registry) {
+			match = setting
+		}
+	}
+	return match
+}
+
+// addLocalRegistries adds a setting without TLS verification for the
+// loopback and in-cluster registries of imageURLs that no setting matches
+func (c *Config) addLocalRegistries(imageURLs ...string) {
+	for _, imageURL := range imageURLs {
+		if imageURL == "" {
+			continue
+		}
+		host := image.RegistryHost(imageURL)
+		if !image.IsLocalRegistry(host) || c.registrySetting(imageURL).Registry != "" {
+			continue
+		}
+		c.RegistrySettings = append(c.RegistrySettings, RegistrySetting{Registry: host, TLSVerify: false})
+	}
+}
+
+// insecureRegistries lists the registry hosts whose setting disables TLS
+// verification, for the registry client. Settings of repositories are
+// skipped as the client matches hosts only.
+func (c *Config) insecureRegistries() []string {
+	var hosts []string
+	for _, setting := range c.RegistrySettings {
+		if !setting.TLSVerify && !strings.Contains(setting.Registry, "/") {
+			hosts = append(hosts, setting.Registry)
+		}
+	}
+	return hosts
+}
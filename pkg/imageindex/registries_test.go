@@ -0,0 +1,78 @@
+package imageindex
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RegistrySettings", func() {
+	Describe("ParseRegistrySettings", func() {
+		It("should default TLSVerify to the global setting", func() {
+			settings, err := ParseRegistrySettings(
+				`[{"registry":"registry.local:5000/","tlsVerify":false,"authFile":"/auth/local.json"},{"registry":"quay.io"}]`, true)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(settings).To(Equal([]RegistrySetting{
+				{Registry: "registry.local:5000", TLSVerify: false, AuthFile: "/auth/local.json"},
+				{Registry: "quay.io", TLSVerify: true},
+			}))
+		})
+
+		It("should return no settings for an empty value", func() {
+			Expect(ParseRegistrySettings("", true)).To(BeEmpty())
+		})
+
+		It("should reject a registry with a scheme", func() {
+			_, err := ParseRegistrySettings(`[{"registry":"https://quay.io"}]`, true)
+			Expect(err).To(MatchError(`invalid registry "https://quay.io", expected a registry host without scheme`))
+		})
+
+		It("should reject invalid JSON", func() {
+			_, err := ParseRegistrySettings(`{"registry":"quay.io"}`, true)
+			Expect(err).To(MatchError(ContainSubstring("expected a JSON array")))
+		})
+	})
+
+	Describe("registrySetting", func() {
+		config := &Config{
+			TLSVerify: true,
+			RegistrySettings: []RegistrySetting{
+				{Registry: "quay.io", TLSVerify: true, AuthFile: "/auth/quay.json"},
+				{Registry: "quay.io/mirror", TLSVerify: false, AuthFile: "/auth/mirror.json"},
+			},
+		}
+
+		DescribeTable("matching the registry prefix",
+			func(imageURL string, expected RegistrySetting) {
+				Expect(config.registrySetting(imageURL)).To(Equal(expected))
+			},
+			Entry("registry", "quay.io/test/image:tag", config.RegistrySettings[0]),
+			Entry("longest prefix", "quay.io/mirror/image:tag", config.RegistrySettings[1]),
+			Entry("no match", "registry.local/image:tag", RegistrySetting{TLSVerify: true}),
+			Entry("host prefix of another host", "quay.io.example.com/image:tag", RegistrySetting{TLSVerify: true}),
+		)
+	})
+
+	Describe("addLocalRegistries", func() {
+		It("should skip TLS verification for local registries without a setting", func() {
+			config := &Config{
+				TLSVerify:        true,
+				RegistrySettings: []RegistrySetting{{Registry: "registry.ns.svc:5000", TLSVerify: true}},
+			}
+
+			config.addLocalRegistries(
+				"localhost:5000/app:tag",
+				"localhost:5000/app@sha256:amd64",
+				"registry.ns.svc:5000/app:tag",
+				"quay.io/org/app:tag",
+				"")
+
+			Expect(config.RegistrySettings).To(Equal([]RegistrySetting{
+				{Registry: "registry.ns.svc:5000", TLSVerify: true},
+				{Registry: "localhost:5000", TLSVerify: false},
+			}))
+			Expect(config.registrySetting("quay.io/org/app:tag").TLSVerify).To(BeTrue())
+			Expect(config.insecureRegistries()).To(Equal([]string{"localhost:5000"}))
+		})
+	})
+})
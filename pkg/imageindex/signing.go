@@ -0,0 +1,46 @@
+package imageindex
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"go.uber.org/zap"
+)
+
+// EnvCosignIdentityToken holds the OIDC identity token exchanged with Fulcio
+// for a signing certificate by keyless signing. It is read when signing so
+// that the token never ends up in the configuration.
+const EnvCosignIdentityToken = "COSIGN_IDENTITY_TOKEN"
+
+// cosignSignArgs builds the cosign sign arguments signing ref keylessly
+func cosignSignArgs(ref, identityToken, oidcIssuer string) []string {
+	args := []string{"sign", "--yes", "--identity-token", identityToken}
+	if oidcIssuer != "" {
+		args = append(args, "--oidc-issuer", oidcIssuer)
+	}
+	return append(args, ref)
+}
+
+// signImage signs the published image by digest with cosign keyless
+// signing, using the OIDC token of COSIGN_IDENTITY_TOKEN
+func (b *Builder) signImage(ctx context.Context, imageURL, imageDigest string) error {
+	identityToken := os.Getenv(EnvCosignIdentityToken)
+	if identityToken == "" {
+		return fmt.Errorf("%s is required for keyless signing", EnvCosignIdentityToken)
+	}
+	if imageDigest == "" {
+		return fmt.Errorf("can't sign %s without its digest", imageURL)
+	}
+
+	ref := image.Repository(imageURL) + "@" + imageDigest
+	b.logger.Info("Signing image with cosign keyless signing",
+		zap.String("image", ref),
+		zap.String("oidc_issuer", b.config.OIDCIssuer))
+
+	if err := b.runner.Run(ctx, "cosign", cosignSignArgs(ref, identityToken, b.config.OIDCIssuer)...); err != nil {
+		return fmt.Errorf("cosign sign failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package imageindex
+
+import (
+	"context"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Keyless signing", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *Config
+		builder    *Builder
+	)
+
+	const indexRef = "quay.io/test/image@sha256:index"
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		config = &Config{
+			ImageURL:       "quay.io/test/image:tag",
+			Images:         []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image@sha256:arm64"},
+			ResultsPath:    GinkgoT().TempDir(),
+			TLSVerify:      true,
+			KeylessSigning: true,
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+		mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+			"inspect", "docker://quay.io/test/image:tag")
+		GinkgoT().Setenv(EnvCosignIdentityToken, "oidc-token")
+	})
+
+	It("should sign the published index by digest with the identity token", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(mockRunner.AssertCommandExecuted("cosign", "sign", "--yes", "--identity-token", "oidc-token", indexRef)).To(BeTrue())
+	})
+
+	It("should pass the OIDC issuer", func() {
+		config.OIDCIssuer = "https://token.actions.githubusercontent.com"
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(mockRunner.AssertCommandExecuted("cosign", "sign", "--yes", "--identity-token", "oidc-token",
+			"--oidc-issuer", "https://token.actions.githubusercontent.com", indexRef)).To(BeTrue())
+	})
+
+	It("should sign a single image by its digest", func() {
+		config.Images = []string{"quay.io/test/image@sha256:amd64"}
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(mockRunner.AssertCommandExecuted("cosign", "sign", "--yes", "--identity-token", "oidc-token",
+			"quay.io/test/image@sha256:amd64")).To(BeTrue())
+	})
+
+	It("should fail without running cosign when the identity token is empty", func() {
+		GinkgoT().Setenv(EnvCosignIdentityToken, "")
+
+		Expect(builder.Execute(ctx)).To(MatchError(ContainSubstring("COSIGN_IDENTITY_TOKEN is required for keyless signing")))
+
+		for _, cmd := range mockRunner.GetExecutedCommands() {
+			Expect(cmd[0]).NotTo(Equal("cosign"))
+		}
+	})
+
+	It("should fail when cosign fails", func() {
+		mockRunner.SetError("cosign", &exec.CommandError{ExitCode: 1, Message: "getting signer: fulcio rejected the token"},
+			"sign", "--yes", "--identity-token", "oidc-token", indexRef)
+
+		Expect(builder.Execute(ctx)).To(MatchError(ContainSubstring("cosign sign failed: getting signer: fulcio rejected the token")))
+	})
+
+	It("should not sign when keyless signing is disabled", func() {
+		config.KeylessSigning = false
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		for _, cmd := range mockRunner.GetExecutedCommands() {
+			Expect(cmd[0]).NotTo(Equal("cosign"))
+		}
+	})
+
+	It("should require the identity token when loading the configuration", func() {
+		GinkgoT().Setenv("RESULTS_PATH", GinkgoT().TempDir())
+		GinkgoT().Setenv("KEYLESS_SIGNING", "true")
+		GinkgoT().Setenv(EnvCosignIdentityToken, "")
+
+		_, err := LoadConfigFromEnv()
+
+		Expect(err).To(MatchError("COSIGN_IDENTITY_TOKEN is required when KEYLESS_SIGNING is set"))
+	})
+})
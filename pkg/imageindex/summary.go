@@ -0,0 +1,57 @@
+package imageindex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+// Summary describes the published image for GitOps tooling such as the
+// ArgoCD image updater, which reads image references from YAML files
+type Summary struct {
+	Image     string    `yaml:"image"`
+	Digest    string    `yaml:"digest"`
+	Platforms []string  `yaml:"platforms"`
+	CreatedAt time.Time `yaml:"createdAt"`
+}
+
+// writeYAMLSummary writes the YAML summary of the published image to
+// YAMLOutputPath. Failing to list the platforms is only logged.
+func (b *Builder) writeYAMLSummary(ctx context.Context, imageURL, imageDigest string) error {
+	imageRef := imageURL
+	if imageDigest != "" {
+		imageRef = fmt.Sprintf("%s@%s", image.Repository(imageURL), imageDigest)
+	}
+
+	platforms, err := b.indexPlatforms(ctx, imageRef)
+	if err != nil {
+		b.logger.Warn("Failed to list the platforms of the image index", zap.Error(err))
+		platforms = []string{}
+	}
+
+	content, err := yaml.Marshal(&Summary{
+		Image:     imageURL,
+		Digest:    imageDigest,
+		Platforms: platforms,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render YAML summary: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.config.YAMLOutputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create YAML summary directory: %w", err)
+	}
+	if err := os.WriteFile(b.config.YAMLOutputPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write YAML summary: %w", err)
+	}
+
+	b.logger.Info("Wrote YAML summary", zap.String("path", b.config.YAMLOutputPath))
+	return nil
+}
@@ -0,0 +1,80 @@
+package imageindex
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+var _ = Describe("YAML summary", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *Config
+		builder    *Builder
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockRunner = exec.NewMockCommandRunner()
+		config = &Config{
+			ImageURL:         "quay.io/test/image:tag",
+			Images:           []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image@sha256:arm64"},
+			ResultsPath:      GinkgoT().TempDir(),
+			TLSVerify:        true,
+			WriteYAMLSummary: true,
+			YAMLOutputPath:   filepath.Join(GinkgoT().TempDir(), "gitops", "image.yaml"),
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+		mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+			"inspect", "docker://quay.io/test/image:tag")
+		mockRunner.SetOutput("skopeo", []byte(`{"manifests":[`+
+			`{"platform":{"os":"linux","architecture":"amd64"}},`+
+			`{"platform":{"os":"linux","architecture":"arm64","variant":"v8"}}]}`),
+			"inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+	})
+
+	// readSummary parses the written YAML summary
+	readSummary := func() map[string]interface{} {
+		content, err := os.ReadFile(config.YAMLOutputPath)
+		Expect(err).NotTo(HaveOccurred())
+		var summary map[string]interface{}
+		Expect(yaml.Unmarshal(content, &summary)).To(Succeed())
+		return summary
+	}
+
+	It("should write the published index as valid YAML", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		summary := readSummary()
+		Expect(summary).To(HaveKeyWithValue("image", "quay.io/test/image:tag"))
+		Expect(summary).To(HaveKeyWithValue("digest", "sha256:index"))
+		Expect(summary).To(HaveKeyWithValue("platforms", ConsistOf("linux/amd64", "linux/arm64/v8")))
+		Expect(summary).To(HaveKey("createdAt"))
+		Expect(summary["createdAt"]).To(BeTemporally("~", time.Now(), time.Minute))
+	})
+
+	It("should write an empty platform list when the manifest can't be read", func() {
+		mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "unauthorized"},
+			"inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(readSummary()).To(HaveKeyWithValue("platforms", BeEmpty()))
+	})
+
+	It("should not write a summary unless requested", func() {
+		config.WriteYAMLSummary = false
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(config.YAMLOutputPath).NotTo(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,142 @@
+package imageindex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"go.uber.org/zap"
+)
+
+// annotationReferenceDigest links an attestation manifest of an index to the
+// image it describes
+const annotationReferenceDigest = "vnd.docker.reference.digest"
+
+// existingIndex fetches the index published at ImageURL for UPDATE_EXISTING,
+// nil when there is none. Only a reference the registry doesn't know falls
+// back to creating the index; other errors fail rather than publishing an
+// index that lost the platforms it had.
+func (b *Builder) existingIndex(ctx context.Context) (*image.Manifest, error) {
+	raw, err := b.registry().RawManifest(ctx, b.config.ImageURL)
+	if image.IsManifestUnknown(err) {
+		b.logger.Info("No existing image index, creating one", zap.String("image_url", b.config.ImageURL))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the existing index: %w", err)
+	}
+
+	manifest, err := image.ParseManifest(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the existing index: %w", err)
+	}
+	if !manifest.IsIndex {
+		b.logger.Info("Existing image isn't an index, creating one",
+			zap.String("image_url", b.config.ImageURL),
+			zap.String("media_type", manifest.MediaType))
+		return nil, nil
+	}
+	return manifest, nil
+}
+
+// imagePlatforms inspects the platform of each of images, failing when two
+// images are for the same platform as only one of them can replace it
+func (b *Builder) imagePlatforms(ctx context.Context, images []string) ([]image.Platform, error) {
+	platforms := make([]image.Platform, 0, len(images))
+	for i, imageRef := range images {
+		platform, err := b.registry().Platform(ctx, imageRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get platform of %s: %w", imageRef, err)
+		}
+		for j, other := range platforms {
+			if platform.Same(other) {
+				return nil, fmt.Errorf("images %s and %s are both for platform %s", images[j], images[i], platform)
+			}
+		}
+		platforms = append(platforms, platform)
+	}
+	return platforms, nil
+}
+
+// addExistingEntries adds the children of the existing index to the local
+// manifest, but the platforms replaced by Images and the attestations of the
+// replaced children. The platform and annotations of each child and the
+// annotations of the index are preserved. It returns the number of kept
+// children.
+func (b *Builder) addExistingEntries(ctx context.Context, manifestName string, existing *image.Manifest, replaced []image.Platform) (int, error) {
+	repository := image.Repository(b.config.ImageURL)
+
+	dropped := make(map[string]bool)
+	for _, child := range existing.Manifests {
+		if child.Platform != nil && platformReplaced(*child.Platform, replaced) {
+			dropped[child.Digest] = true
+		}
+	}
+
+	added := make(map[string]bool)
+	for _, child := range existing.Manifests {
+		if dropped[child.Digest] || dropped[child.Annotations[annotationReferenceDigest]] || added[child.Digest] {
+			continue
+		}
+		added[child.Digest] = true
+
+		childRef := repository + "@" + child.Digest
+		b.logger.Info("Keeping existing index entry", zap.String("image", childRef), zap.Stringer("platform", child.Platform))
+		addArgs := append([]string{"manifest", "add"}, b.tlsVerifyArgs(childRef)...)
+		if child.Platform != nil {
+			addArgs = append(addArgs, "--os", child.Platform.OS, "--arch", child.Platform.Architecture)
+			if child.Platform.Variant != "" {
+				addArgs = append(addArgs, "--variant", child.Platform.Variant)
+			}
+		}
+		addArgs = append(addArgs, manifestName, childRef)
+		if err := b.runner.Run(ctx, "buildah", addArgs...); err != nil {
+			return 0, fmt.Errorf("failed to add existing image %s to manifest: %w", childRef, err)
+		}
+
+		if len(child.Annotations) > 0 {
+			annotateArgs := append([]string{"manifest", "annotate"}, annotationArgs(child.Annotations)...)
+			annotateArgs = append(annotateArgs, manifestName, child.Digest)
+			if err := b.runner.Run(ctx, "buildah", annotateArgs...); err != nil {
+				return 0, fmt.Errorf("failed to annotate existing image %s: %w", childRef, err)
+			}
+		}
+	}
+
+	if len(existing.Annotations) > 0 {
+		annotateArgs := append([]string{"manifest", "annotate", "--index"}, annotationArgs(existing.Annotations)...)
+		annotateArgs = append(annotateArgs, manifestName)
+		if err := b.runner.Run(ctx, "buildah", annotateArgs...); err != nil {
+			return 0, fmt.Errorf("failed to annotate manifest: %w", err)
+		}
+	}
+	return len(added), nil
+}
+
+// platformReplaced reports whether platform is one of replaced
+func platformReplaced(platform image.Platform, replaced []image.Platform) bool {
+	for _, other := range replaced {
+		if platform.Same(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// annotationArgs returns the --annotation arguments of buildah manifest
+// annotate, sorted by key
+func annotationArgs(annotations map[string]string) []string {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		args = append(args, "--annotation", strings.Join([]string{key, annotations[key]}, "="))
+	}
+	return args
+}
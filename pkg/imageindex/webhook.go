@@ -0,0 +1,172 @@
+package imageindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/image"
+	"go.uber.org/zap"
+)
+
+// DefaultWebhookPayloadTemplate renders every notification field as JSON
+const DefaultWebhookPayloadTemplate = `{"image_url":{{json .ImageURL}},"image_digest":{{json .ImageDigest}},` +
+	`"platforms":{{json .Platforms}},"build_time":{{json .BuildTime}}}`
+
+// webhookTimeout bounds the notification request
+const webhookTimeout = 10 * time.Second
+
+// Notification holds the fields available to the webhook payload template
+type Notification struct {
+	ImageURL    string
+	ImageDigest string
+	Platforms   []string
+	BuildTime   time.Time
+}
+
+// webhookFuncs are the functions available to the webhook payload template
+var webhookFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"join": strings.Join,
+}
+
+// ParseWebhookTemplate parses a webhook payload template, the default one when empty
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultWebhookPayloadTemplate
+	}
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook payload template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// RenderWebhookPayload renders the payload of a notification, which must be valid JSON
+func RenderWebhookPayload(text string, notification *Notification) ([]byte, error) {
+	tmpl, err := ParseWebhookTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload bytes.Buffer
+	if err := tmpl.Execute(&payload, notification); err != nil {
+		return nil, fmt.Errorf("failed to render webhook payload: %w", err)
+	}
+	if !json.Valid(payload.Bytes()) {
+		return nil, fmt.Errorf("webhook payload is not valid JSON: %s", payload.String())
+	}
+	return payload.Bytes(), nil
+}
+
+// notify posts the completion notification to the webhook
+func (b *Builder) notify(ctx context.Context, notification *Notification) error {
+	payload, err := RenderWebhookPayload(b.config.WebhookPayloadTemplate, notification)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.config.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook notification: %w", withoutURL(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// withoutURL strips the URL from the errors of net/url and net/http, since
+// the tokens of webhooks are part of their URL
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// indexPlatforms lists the os/architecture[/variant] platforms of a pushed
+// index, none for a single image
+func (b *Builder) indexPlatforms(ctx context.Context, imageRef string) ([]string, error) {
+	raw, err := b.registry().RawManifest(ctx, imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var index struct {
+		Manifests []struct {
+			Platform *struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %w", imageRef, err)
+	}
+
+	platforms := []string{}
+	for _, manifest := range index.Manifests {
+		if manifest.Platform == nil {
+			continue
+		}
+		platform := manifest.Platform.OS + "/" + manifest.Platform.Architecture
+		if manifest.Platform.Variant != "" {
+			platform += "/" + manifest.Platform.Variant
+		}
+		platforms = append(platforms, platform)
+	}
+	return platforms, nil
+}
+
+// sendNotification notifies the webhook of the published image. Failures are
+// only logged since the image is already published.
+func (b *Builder) sendNotification(ctx context.Context, imageURL, imageDigest string) {
+	imageRef := imageURL
+	if imageDigest != "" {
+		imageRef = fmt.Sprintf("%s@%s", image.Repository(imageURL), imageDigest)
+	}
+
+	platforms, err := b.indexPlatforms(ctx, imageRef)
+	if err != nil {
+		b.logger.Warn("Failed to list the platforms of the image index", zap.Error(err))
+		platforms = []string{}
+	}
+
+	err = b.notify(ctx, &Notification{
+		ImageURL:    imageURL,
+		ImageDigest: imageDigest,
+		Platforms:   platforms,
+		BuildTime:   time.Now().UTC(),
+	})
+	if err != nil {
+		b.logger.Warn("Failed to send webhook notification", zap.Error(err))
+		return
+	}
+	b.logger.Info("Sent webhook notification", zap.String("image_url", imageURL))
+}
@@ -0,0 +1,140 @@
+package imageindex
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Webhook", func() {
+	var (
+		ctx        context.Context
+		mockRunner *exec.MockCommandRunner
+		config     *Config
+		builder    *Builder
+		server     *httptest.Server
+		requests   chan *http.Request
+		bodies     chan []byte
+		status     int
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		requests = make(chan *http.Request, 1)
+		bodies = make(chan []byte, 1)
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- r
+			bodies <- body
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(server.Close)
+
+		mockRunner = exec.NewMockCommandRunner()
+		config = &Config{
+			ImageURL:    "quay.io/test/image:tag",
+			Images:      []string{"quay.io/test/image@sha256:amd64", "quay.io/test/image@sha256:arm64"},
+			ResultsPath: GinkgoT().TempDir(),
+			TLSVerify:   true,
+			WebhookURL:  server.URL,
+		}
+		builder = NewBuilder(zap.NewNop(), config, mockRunner)
+		mockRunner.SetOutput("skopeo", []byte(`{"Digest": "sha256:index"}`),
+			"inspect", "docker://quay.io/test/image:tag")
+		mockRunner.SetOutput("skopeo", []byte(`{"manifests":[`+
+			`{"platform":{"os":"linux","architecture":"amd64"}},`+
+			`{"platform":{"os":"linux","architecture":"arm64","variant":"v8"}}]}`),
+			"inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+	})
+
+	It("should post the default payload once the index is published", func() {
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.Method).To(Equal(http.MethodPost))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+
+		var payload struct {
+			ImageURL    string    `json:"image_url"`
+			ImageDigest string    `json:"image_digest"`
+			Platforms   []string  `json:"platforms"`
+			BuildTime   time.Time `json:"build_time"`
+		}
+		Expect(json.Unmarshal(<-bodies, &payload)).To(Succeed())
+		Expect(payload.ImageURL).To(Equal("quay.io/test/image:tag"))
+		Expect(payload.ImageDigest).To(Equal("sha256:index"))
+		Expect(payload.Platforms).To(Equal([]string{"linux/amd64", "linux/arm64/v8"}))
+		Expect(payload.BuildTime).To(BeTemporally("~", time.Now(), time.Minute))
+	})
+
+	It("should render a custom template", func() {
+		config.WebhookPayloadTemplate = `{"text":{{json (printf "Published %s on %s" .ImageURL (join .Platforms ", "))}}}`
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(string(<-bodies)).To(Equal(`{"text":"Published quay.io/test/image:tag on linux/amd64, linux/arm64/v8"}`))
+	})
+
+	It("should not fail the build when the webhook fails", func() {
+		status = http.StatusInternalServerError
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Eventually(requests).Should(Receive())
+	})
+
+	It("should notify without platforms when they can't be listed", func() {
+		mockRunner.SetError("skopeo", &exec.CommandError{ExitCode: 1, Message: "not found"},
+			"inspect", "--raw", "docker://quay.io/test/image@sha256:index")
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Expect(string(<-bodies)).To(ContainSubstring(`"platforms":[]`))
+	})
+
+	It("should not leak the webhook URL in errors", func() {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		config.WebhookURL = closed.URL + "/hooks/s3cret-token"
+
+		err := builder.notify(ctx, &Notification{ImageURL: "quay.io/test/image:tag"})
+
+		Expect(err).To(MatchError(ContainSubstring("failed to send webhook notification")))
+		Expect(err.Error()).NotTo(ContainSubstring("s3cret-token"))
+	})
+
+	It("should not send notifications without a webhook URL", func() {
+		config.WebhookURL = ""
+
+		Expect(builder.Execute(ctx)).To(Succeed())
+
+		Consistently(requests, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	Describe("RenderWebhookPayload", func() {
+		It("should reject payloads that aren't valid JSON", func() {
+			_, err := RenderWebhookPayload(`{"text":"{{.ImageURL}}`, &Notification{ImageURL: "quay.io/test/image:tag"})
+			Expect(err).To(MatchError(ContainSubstring("webhook payload is not valid JSON")))
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := RenderWebhookPayload(`{{.Missing}}`, &Notification{})
+			Expect(err).To(MatchError(ContainSubstring("failed to render webhook payload")))
+		})
+
+		It("should reject malformed templates", func() {
+			_, err := ParseWebhookTemplate(`{{.ImageURL`)
+			Expect(err).To(MatchError(ContainSubstring("failed to parse webhook payload template")))
+		})
+	})
+})
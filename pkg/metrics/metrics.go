@@ -0,0 +1,80 @@
+// Package metrics formats the flat key=value metrics results ingested by the
+// Konflux metrics exporter
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric keys read by the fleet dashboards. Renaming a key silently breaks
+// the dashboards, so these are pinned by tests.
+const (
+	KeyCloneSeconds    = "clone_seconds"
+	KeyPrefetchSeconds = "prefetch_seconds"
+	KeyBuildSeconds    = "build_seconds"
+	KeyPushSeconds     = "push_seconds"
+	KeyImageSizeBytes  = "image_size_bytes"
+	KeyRetriesTotal    = "retries_total"
+	KeyRateLimited     = "rate_limited_total"
+	KeyBackoffSeconds  = "total_backoff_seconds"
+	KeySkipped         = "skipped"
+)
+
+// MaxResultSize is the size limit Tekton applies to a single result
+const MaxResultSize = 4096
+
+// Metrics collects metric values, keeping the order they were first set in
+type Metrics struct {
+	keys   []string
+	values map[string]string
+}
+
+// New creates an empty metrics set
+func New() *Metrics {
+	return &Metrics{values: make(map[string]string)}
+}
+
+// SetSeconds records a duration in seconds
+func (m *Metrics) SetSeconds(key string, d time.Duration) {
+	m.set(key, strconv.FormatFloat(d.Seconds(), 'f', 3, 64))
+}
+
+// SetInt records an integer value such as a size in bytes or a count
+func (m *Metrics) SetInt(key string, value int64) {
+	m.set(key, strconv.FormatInt(value, 10))
+}
+
+// SetBool records a boolean flag
+func (m *Metrics) SetBool(key string, value bool) {
+	m.set(key, strconv.FormatBool(value))
+}
+
+// Get returns the formatted value of a metric
+func (m *Metrics) Get(key string) (string, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// Format renders the metrics as key=value lines, failing when the result
+// wouldn't fit in a Tekton result
+func (m *Metrics) Format() (string, error) {
+	var b strings.Builder
+	for _, key := range m.keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, m.values[key])
+	}
+
+	if b.Len() > MaxResultSize {
+		return "", fmt.Errorf("metrics result is %d bytes, exceeding the %d byte limit", b.Len(), MaxResultSize)
+	}
+	return b.String(), nil
+}
+
+func (m *Metrics) set(key, value string) {
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
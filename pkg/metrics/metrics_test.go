@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	It("should keep the key names the dashboards depend on", func() {
+		Expect([]string{
+			KeyCloneSeconds, KeyPrefetchSeconds, KeyBuildSeconds, KeyPushSeconds,
+			KeyImageSizeBytes, KeyRetriesTotal, KeyRateLimited, KeyBackoffSeconds, KeySkipped,
+		}).To(Equal([]string{
+			"clone_seconds", "prefetch_seconds", "build_seconds", "push_seconds",
+			"image_size_bytes", "retries_total", "rate_limited_total", "total_backoff_seconds", "skipped",
+		}))
+	})
+
+	It("should format values as key=value lines in the order they were set", func() {
+		m := New()
+		m.SetSeconds(KeyBuildSeconds, 1500*time.Millisecond)
+		m.SetInt(KeyImageSizeBytes, 1024)
+		m.SetBool(KeySkipped, false)
+		m.SetSeconds(KeyBuildSeconds, 2*time.Second)
+
+		Expect(m.Format()).To(Equal("build_seconds=2.000\nimage_size_bytes=1024\nskipped=false\n"))
+	})
+
+	It("should refuse results larger than the Tekton limit", func() {
+		m := New()
+		for i := 0; i < 300; i++ {
+			m.SetInt(fmt.Sprintf("metric_number_%d", i), int64(i))
+		}
+
+		_, err := m.Format()
+		Expect(err).To(MatchError(ContainSubstring("exceeding the 4096 byte limit")))
+	})
+})
@@ -1,15 +1,48 @@
 package prefetch
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
 	"go.uber.org/zap"
 )
 
+// fetchDepsMaxRetries is the number of times cachi2 fetch-deps is retried
+// after a transient network error
+const fetchDepsMaxRetries = 3
+
+// fetchDepsBaseDelay is the delay before the first fetch-deps retry, doubled
+// for each further retry
+var fetchDepsBaseDelay = 5 * time.Second
+
+// Formats of the environment file generated by cachi2 generate-env
+const (
+	// EnvFormatEnv is a shell script of export statements
+	EnvFormatEnv = "env"
+
+	// EnvFormatJSON is a JSON list of name and value objects, read without a
+	// shell. Requires a cachi2 version supporting it, older versions falling
+	// back to EnvFormatEnv.
+	EnvFormatJSON = "json"
+)
+
+// DefaultOutputDirForEnv is where the build sees the cachi2 output unless
+// OutputDirForEnv is set
+const DefaultOutputDirForEnv = "/cachi2/output"
+
+// ErrDependencyResolution is wrapped by the error returned when cachi2
+// check-deps finds declared dependencies that can't be resolved
+var ErrDependencyResolution = errors.New("dependency resolution failed")
+
 // Config holds configuration for dependency prefetching
 type Config struct {
 	Input              string
@@ -20,10 +53,45 @@ type Config struct {
 	ConfigFileContent  string
 	GitAuthPath        string
 	NetrcPath          string
+
+	// PackageManagerCredsPath holds <pkgmgr>.credentials files, e.g. a
+	// mounted Secret, from which pip.conf and .npmrc are generated in the
+	// source directory for the duration of the prefetch
+	PackageManagerCredsPath string
+
+	// ScratchPath holds authentication material and generated config files and
+	// is used as HOME for cachi2. The user's home directory is used when empty.
+	ScratchPath string
+
+	// DryRunCheck runs cachi2 check-deps before fetch-deps, failing early
+	// when declared dependencies can't be resolved. Requires cachi2 0.12+.
+	DryRunCheck bool
+
+	// EnvFormat is the format requested from cachi2 generate-env,
+	// EnvFormatEnv when empty
+	EnvFormat string
+
+	// OutputDirForEnv is the path of the cachi2 output within the build, which
+	// the generated environment and injected files point to.
+	// DefaultOutputDirForEnv when empty.
+	OutputDirForEnv string
+
+	// OnRetry is called before each retry of cachi2 fetch-deps
+	OnRetry func(retry int, err error)
 }
 
-// FetchDependencies uses Cachi2 to prefetch build dependencies
-func FetchDependencies(ctx context.Context, logger *zap.Logger, config *Config) error {
+// FetchDependencies uses Cachi2 to prefetch build dependencies, running cachi2
+// with runner. HOME points at ScratchPath, when set, so that cachi2 only sees
+// this build's authentication files.
+func FetchDependencies(ctx context.Context, logger *zap.Logger, config *Config, runner exec.CommandRunner) error {
+	if config.ScratchPath != "" {
+		runner = exec.NewEnvCommandRunner(runner, []string{"HOME=" + config.ScratchPath}, "cachi2")
+	}
+	return fetchDependencies(ctx, logger, config, runner)
+}
+
+// fetchDependencies prefetches build dependencies, running cachi2 with runner
+func fetchDependencies(ctx context.Context, logger *zap.Logger, config *Config, runner exec.CommandRunner) error {
 	logger.Info("Starting dependency prefetch with Cachi2",
 		zap.String("input", config.Input),
 		zap.String("source_path", config.SourcePath),
@@ -39,19 +107,45 @@ func FetchDependencies(ctx context.Context, logger *zap.Logger, config *Config)
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	if config.ScratchPath != "" {
+		if err := os.MkdirAll(config.ScratchPath, 0700); err != nil {
+			return fmt.Errorf("failed to create scratch directory: %w", err)
+		}
+	}
+
 	// Setup authentication if available
 	if err := setupAuthentication(config); err != nil {
 		logger.Warn("Failed to setup authentication", zap.Error(err))
 	}
 
+	restoreCredentials, err := setupPackageManagerCredentials(logger, config)
+	defer func() {
+		if err := restoreCredentials(); err != nil {
+			logger.Warn("Failed to restore package manager configuration", zap.Error(err))
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("failed to setup package manager credentials: %w", err)
+	}
+
 	// Write config file if provided
 	if config.ConfigFileContent != "" {
-		configPath := filepath.Join(config.OutputPath, "cachi2.yaml")
+		configDir := config.OutputPath
+		if config.ScratchPath != "" {
+			configDir = config.ScratchPath
+		}
+		configPath := filepath.Join(configDir, "cachi2.yaml")
 		if err := os.WriteFile(configPath, []byte(config.ConfigFileContent), 0644); err != nil {
 			return fmt.Errorf("failed to write config file: %w", err)
 		}
 	}
 
+	if config.DryRunCheck {
+		if err := checkDependencies(ctx, logger, config, runner); err != nil {
+			return err
+		}
+	}
+
 	// Build cachi2 fetch-deps command
 	args := []string{"fetch-deps"}
 	args = append(args, fmt.Sprintf("--source=%s", config.SourcePath))
@@ -70,23 +164,30 @@ func FetchDependencies(ctx context.Context, logger *zap.Logger, config *Config)
 	// Add input specification
 	args = append(args, config.Input)
 
-	// Execute cachi2 fetch-deps
-	logger.Info("Executing cachi2 fetch-deps", zap.Strings("args", args))
-	cmd := exec.CommandContext(ctx, "cachi2", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Execute cachi2 fetch-deps, retrying transient network errors
+	retrying := exec.NewRetryingCommandRunner(runner, fetchDepsMaxRetries, fetchDepsBaseDelay, isTransientError)
+	retrying.OnRetry = func(retry int, err error) {
+		logger.Warn("cachi2 fetch-deps failed with a transient error, retrying",
+			zap.Int("retry", retry),
+			zap.Int("max_retries", fetchDepsMaxRetries),
+			zap.Error(err))
+		if config.OnRetry != nil {
+			config.OnRetry(retry, err)
+		}
+	}
 
-	if err := cmd.Run(); err != nil {
+	logger.Info("Executing cachi2 fetch-deps", zap.Strings("args", args))
+	if err := retrying.Run(ctx, "cachi2", args...); err != nil {
 		return fmt.Errorf("cachi2 fetch-deps failed: %w", err)
 	}
 
 	// Generate environment file
-	if err := generateEnvironmentFile(ctx, logger, config.OutputPath); err != nil {
+	if err := generateEnvironmentFile(ctx, logger, config, runner); err != nil {
 		return fmt.Errorf("failed to generate environment file: %w", err)
 	}
 
 	// Inject files
-	if err := injectFiles(ctx, logger, config.OutputPath); err != nil {
+	if err := injectFiles(ctx, logger, config, runner); err != nil {
 		return fmt.Errorf("failed to inject files: %w", err)
 	}
 
@@ -94,32 +195,211 @@ func FetchDependencies(ctx context.Context, logger *zap.Logger, config *Config)
 	return nil
 }
 
-// generateEnvironmentFile creates the cachi2 environment file
-func generateEnvironmentFile(ctx context.Context, logger *zap.Logger, outputPath string) error {
-	args := []string{"generate-env", outputPath}
-	args = append(args, "--format", "env")
-	args = append(args, "--for-output-dir", "/cachi2/output")
-	args = append(args, "--output", filepath.Join(filepath.Dir(outputPath), "cachi2.env"))
+// checkDependencies validates with cachi2 check-deps that all declared
+// dependencies are resolvable. A non-zero exit that isn't a transient network
+// error is reported as ErrDependencyResolution.
+func checkDependencies(ctx context.Context, logger *zap.Logger, config *Config, runner exec.CommandRunner) error {
+	args := []string{"check-deps", "--source", config.SourcePath, config.Input}
+
+	logger.Info("Executing cachi2 check-deps", zap.Strings("args", args))
+	err := runner.Run(ctx, "cachi2", args...)
+	if err == nil {
+		return nil
+	}
+
+	var cmdErr *exec.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.ExitCode != 0 && !isTransientError(err) {
+		return fmt.Errorf("%w: %s", ErrDependencyResolution, cmdErr.Message)
+	}
+	return fmt.Errorf("cachi2 check-deps failed: %w", err)
+}
+
+// generateEnvironmentFile creates the cachi2 environment file in the
+// requested format. A cachi2 rejecting the JSON format gets asked for the
+// env format instead.
+func generateEnvironmentFile(ctx context.Context, logger *zap.Logger, config *Config, runner exec.CommandRunner) error {
+	jsonPath := jsonEnvironmentFilePath(config.OutputPath)
+	if config.EnvFormat == EnvFormatJSON {
+		err := runGenerateEnv(ctx, logger, config, EnvFormatJSON, jsonPath, runner)
+		var cmdErr *exec.CommandError
+		if err == nil || !errors.As(err, &cmdErr) {
+			return err
+		}
+		logger.Warn("cachi2 can't generate a JSON environment file, falling back to the env format", zap.Error(err))
+	}
+
+	// ReadEnvironment prefers the JSON file, so drop one left by an earlier run
+	if err := os.Remove(jsonPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale JSON environment file: %w", err)
+	}
+
+	return runGenerateEnv(ctx, logger, config, EnvFormatEnv, environmentFilePath(config.OutputPath), runner)
+}
+
+// runGenerateEnv runs cachi2 generate-env writing the format to path
+func runGenerateEnv(ctx context.Context, logger *zap.Logger, config *Config, format, path string, runner exec.CommandRunner) error {
+	args := []string{"generate-env", config.OutputPath}
+	args = append(args, "--format", format)
+	args = append(args, "--for-output-dir", config.outputDirForEnv())
+	args = append(args, "--output", path)
 
 	logger.Info("Generating cachi2 environment file", zap.Strings("args", args))
-	cmd := exec.CommandContext(ctx, "cachi2", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return runner.Run(ctx, "cachi2", args...)
+}
+
+// outputDirForEnv returns OutputDirForEnv, DefaultOutputDirForEnv when empty
+func (c *Config) outputDirForEnv() string {
+	if c.OutputDirForEnv == "" {
+		return DefaultOutputDirForEnv
+	}
+	return c.OutputDirForEnv
+}
+
+// environmentFilePath returns the location of the cachi2 environment file for an output directory
+func environmentFilePath(outputPath string) string {
+	return filepath.Join(filepath.Dir(outputPath), "cachi2.env")
+}
+
+// jsonEnvironmentFilePath returns the location of the JSON cachi2
+// environment file for an output directory
+func jsonEnvironmentFilePath(outputPath string) string {
+	return filepath.Join(filepath.Dir(outputPath), "cachi2.env.json")
+}
 
-	return cmd.Run()
+// ReadEnvironment returns the variables set by the cachi2 environment file
+// of an output directory, preferring the JSON file when generated
+func ReadEnvironment(outputPath string) (map[string]string, error) {
+	data, err := os.ReadFile(jsonEnvironmentFilePath(outputPath))
+	if os.IsNotExist(err) {
+		return ParseEnvironmentFile(environmentFilePath(outputPath))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseJSONEnvironment(data)
+}
+
+// parseJSONEnvironment parses the name and value list of a JSON cachi2
+// environment file
+func parseJSONEnvironment(data []byte) (map[string]string, error) {
+	var variables []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &variables); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON environment file: %w", err)
+	}
+
+	env := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		if variable.Name == "" {
+			return nil, fmt.Errorf("JSON environment file has a variable without a name")
+		}
+		env[variable.Name] = variable.Value
+	}
+	return env, nil
+}
+
+// GeneratePipURL returns the pip index URL configured by cachi2 for the given output directory
+func GeneratePipURL(outputPath string) (string, error) {
+	envPath := environmentFilePath(outputPath)
+	env, err := ReadEnvironment(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cachi2 environment file: %w", err)
+	}
+
+	for _, key := range []string{"PIP_INDEX_URL", "PIP_EXTRA_INDEX_URL"} {
+		if url := env[key]; url != "" {
+			return url, nil
+		}
+	}
+
+	return "", fmt.Errorf("no pip index URL found in %s", envPath)
+}
+
+// WritePipConfig writes a pip.conf pointing at the cachi2 pip index into the source directory
+func WritePipConfig(outputPath, sourcePath string) error {
+	url, err := GeneratePipURL(outputPath)
+	if err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("[global]\nindex-url = %s\n", url)
+	if err := os.WriteFile(filepath.Join(sourcePath, "pip.conf"), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write pip.conf: %w", err)
+	}
+
+	return nil
+}
+
+// ParseEnvironmentFile parses the export KEY="value" lines of a cachi2
+// environment file, stripping the export prefix and the quotes
+func ParseEnvironmentFile(envFilePath string) (map[string]string, error) {
+	file, err := os.Open(envFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+
+		env[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// unquote strips shell quoting from an environment file value
+func unquote(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				return unquoted
+			}
+			return value[1 : len(value)-1]
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
 }
 
 // injectFiles injects prefetched files into the build context
-func injectFiles(ctx context.Context, logger *zap.Logger, outputPath string) error {
-	args := []string{"inject-files", outputPath}
-	args = append(args, "--for-output-dir", "/cachi2/output")
+func injectFiles(ctx context.Context, logger *zap.Logger, config *Config, runner exec.CommandRunner) error {
+	args := []string{"inject-files", config.OutputPath}
+	args = append(args, "--for-output-dir", config.outputDirForEnv())
 
 	logger.Info("Injecting cachi2 files", zap.Strings("args", args))
-	cmd := exec.CommandContext(ctx, "cachi2", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return runner.Run(ctx, "cachi2", args...)
+}
+
+// isTransientError reports whether a failed cachi2 command is worth retrying:
+// it exited non-zero with a network error or timeout on stderr
+func isTransientError(err error) bool {
+	var cmdErr *exec.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.ExitCode == 0 {
+		return false
+	}
 
-	return cmd.Run()
+	message := strings.ToLower(cmdErr.Message)
+	return strings.Contains(message, "network") || strings.Contains(message, "timeout")
 }
 
 // setupAuthentication configures authentication for cachi2
@@ -127,9 +407,9 @@ func setupAuthentication(config *Config) error {
 	// Setup git authentication
 	if config.GitAuthPath != "" {
 		// Copy git auth to home directory
-		homeDir, err := os.UserHomeDir()
+		homeDir, err := authHome(config)
 		if err != nil {
-			return fmt.Errorf("failed to get home directory: %w", err)
+			return err
 		}
 
 		gitConfigDir := filepath.Join(homeDir, ".git")
@@ -153,9 +433,9 @@ func setupAuthentication(config *Config) error {
 
 	// Setup netrc authentication
 	if config.NetrcPath != "" {
-		homeDir, err := os.UserHomeDir()
+		homeDir, err := authHome(config)
 		if err != nil {
-			return fmt.Errorf("failed to get home directory: %w", err)
+			return err
 		}
 
 		srcPath := filepath.Join(config.NetrcPath, ".netrc")
@@ -175,6 +455,19 @@ func setupAuthentication(config *Config) error {
 	return nil
 }
 
+// authHome returns the directory authentication files are copied into
+func authHome(config *Config) (string, error) {
+	if config.ScratchPath != "" {
+		return config.ScratchPath, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return homeDir, nil
+}
+
 // copyFile copies a file from src to dst
 func copyFile(src, dst string) error {
 	data, err := os.ReadFile(src)
@@ -0,0 +1,354 @@
+package prefetch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+// fixtureOutputPath returns the cachi2 output directory whose environment file is the given fixture
+func fixtureOutputPath(fixture string) string {
+	return filepath.Join("testdata", fixture, "output")
+}
+
+var _ = Describe("GeneratePipURL", func() {
+	It("should return PIP_INDEX_URL from the environment file", func() {
+		url, err := GeneratePipURL(fixtureOutputPath("pip-index"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url).To(Equal("file:///cachi2/output/deps/pip/simple"))
+	})
+
+	It("should fall back to PIP_EXTRA_INDEX_URL", func() {
+		url, err := GeneratePipURL(fixtureOutputPath("pip-extra-index"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url).To(Equal("file:///cachi2/output/deps/pip/extra"))
+	})
+
+	It("should fail when no pip index is configured", func() {
+		_, err := GeneratePipURL(fixtureOutputPath("no-pip"))
+
+		Expect(err).To(MatchError(ContainSubstring("no pip index URL found")))
+	})
+
+	It("should fail when the environment file is missing", func() {
+		_, err := GeneratePipURL(fixtureOutputPath("missing"))
+
+		Expect(err).To(MatchError(ContainSubstring("failed to read cachi2 environment file")))
+	})
+})
+
+var _ = Describe("ParseEnvironmentFile", func() {
+	It("should strip the export prefix and the quotes", func() {
+		env, err := ParseEnvironmentFile(filepath.Join("testdata", "quoted-env", "cachi2.env"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(env).To(Equal(map[string]string{
+			"GOFLAGS":               "-mod=mod -modcacherw",
+			"GOMODCACHE":            "/cachi2/output/deps/gomod/pkg/mod",
+			"GOPROXY":               "file:///cachi2/output/deps/gomod/pkg/mod/cache/download",
+			"PIP_INDEX_URL":         "file:///cachi2/output/deps/pip/simple",
+			"NPM_CONFIG_USERCONFIG": `/cachi2/output/deps/npm/"npmrc"`,
+		}))
+	})
+
+	It("should fail when the file is missing", func() {
+		_, err := ParseEnvironmentFile(filepath.Join("testdata", "missing", "cachi2.env"))
+
+		Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+	})
+})
+
+var _ = Describe("ReadEnvironment", func() {
+	It("should parse a JSON environment file", func() {
+		env, err := ReadEnvironment(fixtureOutputPath("json-env"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(env).To(Equal(map[string]string{
+			"GOFLAGS":        "-mod=mod -modcacherw",
+			"GOMODCACHE":     "/cachi2/output/deps/gomod/pkg/mod",
+			"PIP_FIND_LINKS": "/cachi2/output/deps/pip",
+			"PIP_INDEX_URL":  "file:///cachi2/output/deps/pip/simple",
+		}))
+	})
+
+	It("should read the env format without a JSON file", func() {
+		env, err := ReadEnvironment(fixtureOutputPath("pip-index"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(env).To(HaveKeyWithValue("GOFLAGS", "-mod=vendor"))
+		Expect(env).To(HaveKeyWithValue("PIP_INDEX_URL", "file:///cachi2/output/deps/pip/simple"))
+	})
+
+	It("should reject a malformed JSON file", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "cachi2.env.json"), []byte(`{"GOFLAGS":"-mod=mod"}`), 0644)).To(Succeed())
+
+		_, err := ReadEnvironment(filepath.Join(dir, "output"))
+
+		Expect(err).To(MatchError(ContainSubstring("failed to parse JSON environment file")))
+	})
+
+	It("should let GeneratePipURL read the JSON file", func() {
+		Expect(GeneratePipURL(fixtureOutputPath("json-env"))).To(Equal("file:///cachi2/output/deps/pip/simple"))
+	})
+})
+
+var _ = Describe("WritePipConfig", func() {
+	It("should write pip.conf into the source directory", func() {
+		sourcePath := GinkgoT().TempDir()
+
+		Expect(WritePipConfig(fixtureOutputPath("pip-index"), sourcePath)).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(sourcePath, "pip.conf"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("[global]\nindex-url = file:///cachi2/output/deps/pip/simple\n"))
+	})
+
+	It("should not write pip.conf without a pip index", func() {
+		sourcePath := GinkgoT().TempDir()
+
+		Expect(WritePipConfig(fixtureOutputPath("no-pip"), sourcePath)).NotTo(Succeed())
+		Expect(filepath.Join(sourcePath, "pip.conf")).NotTo(BeAnExistingFile())
+	})
+})
+
+var _ = Describe("setupAuthentication", func() {
+	// newAuthConfig returns a config whose netrc source holds the given content
+	newAuthConfig := func(netrc string) *Config {
+		netrcPath := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(netrcPath, ".netrc"), []byte(netrc), 0600)).To(Succeed())
+		return &Config{NetrcPath: netrcPath, ScratchPath: GinkgoT().TempDir()}
+	}
+
+	It("should copy authentication into the scratch directory instead of HOME", func() {
+		home := GinkgoT().TempDir()
+		GinkgoT().Setenv("HOME", home)
+		config := newAuthConfig("machine a.example.com")
+
+		Expect(setupAuthentication(config)).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(config.ScratchPath, ".netrc"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("machine a.example.com"))
+		Expect(filepath.Join(home, ".netrc")).NotTo(BeAnExistingFile())
+	})
+
+	It("should keep concurrent builds' authentication apart", func() {
+		first := newAuthConfig("machine a.example.com")
+		second := newAuthConfig("machine b.example.com")
+
+		done := make(chan error, 2)
+		for _, config := range []*Config{first, second} {
+			go func(config *Config) { done <- setupAuthentication(config) }(config)
+		}
+		Expect(<-done).To(Succeed())
+		Expect(<-done).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(first.ScratchPath, ".netrc"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("machine a.example.com"))
+		content, err = os.ReadFile(filepath.Join(second.ScratchPath, ".netrc"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("machine b.example.com"))
+	})
+})
+
+var _ = Describe("fetchDependencies", func() {
+	var (
+		ctx       context.Context
+		runner    *exec.MockCommandRunner
+		config    *Config
+		fetchArgs []string
+		retries   int
+	)
+
+	BeforeEach(func() {
+		baseDelay := fetchDepsBaseDelay
+		fetchDepsBaseDelay = time.Millisecond
+		DeferCleanup(func() { fetchDepsBaseDelay = baseDelay })
+
+		ctx = context.Background()
+		runner = exec.NewMockCommandRunner()
+		retries = 0
+		config = &Config{
+			Input:       "pip",
+			SourcePath:  GinkgoT().TempDir(),
+			OutputPath:  filepath.Join(GinkgoT().TempDir(), "output"),
+			ScratchPath: GinkgoT().TempDir(),
+			OnRetry:     func(int, error) { retries++ },
+		}
+		fetchArgs = []string{"fetch-deps", "--source=" + config.SourcePath, "--output=" + config.OutputPath, "pip"}
+	})
+
+	// fetchDepsRuns counts the fetch-deps invocations
+	fetchDepsRuns := func() int {
+		count := 0
+		for _, cmd := range runner.GetExecutedCommands() {
+			if len(cmd) > 1 && cmd[1] == "fetch-deps" {
+				count++
+			}
+		}
+		return count
+	}
+
+	It("should retry fetch-deps on transient network errors", func() {
+		runner.QueueResult("cachi2", nil, &exec.CommandError{ExitCode: 1, Message: "Network is unreachable"}, fetchArgs...)
+		runner.QueueResult("cachi2", nil, &exec.CommandError{ExitCode: 1, Message: "read timeout"}, fetchArgs...)
+
+		Expect(fetchDependencies(ctx, zap.NewNop(), config, runner)).To(Succeed())
+
+		Expect(fetchDepsRuns()).To(Equal(3))
+		Expect(retries).To(Equal(2))
+		Expect(runner.AssertCommandExecuted("cachi2", "inject-files", config.OutputPath, "--for-output-dir", "/cachi2/output")).To(BeTrue())
+	})
+
+	It("should not retry other failures", func() {
+		runner.QueueResult("cachi2", nil, &exec.CommandError{ExitCode: 2, Message: "invalid input"}, fetchArgs...)
+
+		err := fetchDependencies(ctx, zap.NewNop(), config, runner)
+
+		Expect(err).To(MatchError(ContainSubstring("cachi2 fetch-deps failed: invalid input")))
+		Expect(fetchDepsRuns()).To(Equal(1))
+		Expect(retries).To(BeZero())
+	})
+
+	It("should give up after three retries", func() {
+		runner.SetError("cachi2", &exec.CommandError{ExitCode: 1, Message: "network error"}, fetchArgs...)
+
+		Expect(fetchDependencies(ctx, zap.NewNop(), config, runner)).NotTo(Succeed())
+
+		Expect(fetchDepsRuns()).To(Equal(4))
+		Expect(retries).To(Equal(3))
+	})
+
+	Context("with the dry-run check enabled", func() {
+		var checkArgs []string
+
+		BeforeEach(func() {
+			config.DryRunCheck = true
+			checkArgs = []string{"check-deps", "--source", config.SourcePath, "pip"}
+		})
+
+		It("should check dependencies before fetching them", func() {
+			Expect(fetchDependencies(ctx, zap.NewNop(), config, runner)).To(Succeed())
+
+			commands := runner.GetExecutedCommands()
+			Expect(commands[0]).To(Equal(append([]string{"cachi2"}, checkArgs...)))
+			Expect(fetchDepsRuns()).To(Equal(1))
+		})
+
+		It("should report unresolvable dependencies without fetching", func() {
+			runner.SetError("cachi2", &exec.CommandError{ExitCode: 1, Message: "package foo==9.9 not found"}, checkArgs...)
+
+			err := fetchDependencies(ctx, zap.NewNop(), config, runner)
+
+			Expect(errors.Is(err, ErrDependencyResolution)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("package foo==9.9 not found")))
+			Expect(fetchDepsRuns()).To(BeZero())
+		})
+
+		It("should distinguish other check-deps failures", func() {
+			runner.SetError("cachi2", &exec.CommandError{ExitCode: 1, Message: "Network is unreachable"}, checkArgs...)
+
+			err := fetchDependencies(ctx, zap.NewNop(), config, runner)
+
+			Expect(errors.Is(err, ErrDependencyResolution)).To(BeFalse())
+			Expect(err).To(MatchError(ContainSubstring("cachi2 check-deps failed")))
+			Expect(fetchDepsRuns()).To(BeZero())
+		})
+	})
+
+	Context("with the JSON environment format", func() {
+		var jsonArgs, envArgs []string
+
+		BeforeEach(func() {
+			config.EnvFormat = EnvFormatJSON
+			jsonArgs = []string{"generate-env", config.OutputPath, "--format", "json", "--for-output-dir", "/cachi2/output",
+				"--output", filepath.Join(filepath.Dir(config.OutputPath), "cachi2.env.json")}
+			envArgs = []string{"generate-env", config.OutputPath, "--format", "env", "--for-output-dir", "/cachi2/output",
+				"--output", filepath.Join(filepath.Dir(config.OutputPath), "cachi2.env")}
+		})
+
+		It("should request the JSON format from generate-env", func() {
+			Expect(fetchDependencies(ctx, zap.NewNop(), config, runner)).To(Succeed())
+
+			Expect(runner.AssertCommandExecuted("cachi2", jsonArgs...)).To(BeTrue())
+			Expect(runner.AssertCommandExecuted("cachi2", envArgs...)).To(BeFalse())
+		})
+
+		It("should fall back to the env format when cachi2 doesn't support JSON", func() {
+			runner.SetError("cachi2", &exec.CommandError{ExitCode: 2, Message: "invalid choice: 'json'"}, jsonArgs...)
+			stale := filepath.Join(filepath.Dir(config.OutputPath), "cachi2.env.json")
+			Expect(os.WriteFile(stale, []byte("[]"), 0644)).To(Succeed())
+
+			Expect(fetchDependencies(ctx, zap.NewNop(), config, runner)).To(Succeed())
+
+			Expect(runner.AssertCommandExecuted("cachi2", envArgs...)).To(BeTrue())
+			Expect(stale).NotTo(BeAnExistingFile())
+		})
+	})
+
+	It("should point the environment and injected files to OutputDirForEnv", func() {
+		config.OutputDirForEnv = "/workspace/cachi2/output"
+
+		Expect(fetchDependencies(ctx, zap.NewNop(), config, runner)).To(Succeed())
+
+		Expect(runner.AssertCommandExecuted("cachi2", "generate-env", config.OutputPath, "--format", "env",
+			"--for-output-dir", "/workspace/cachi2/output",
+			"--output", filepath.Join(filepath.Dir(config.OutputPath), "cachi2.env"))).To(BeTrue())
+		Expect(runner.AssertCommandExecuted("cachi2", "inject-files", config.OutputPath,
+			"--for-output-dir", "/workspace/cachi2/output")).To(BeTrue())
+	})
+
+	It("should not check dependencies by default", func() {
+		Expect(fetchDependencies(ctx, zap.NewNop(), config, runner)).To(Succeed())
+
+		for _, cmd := range runner.GetExecutedCommands() {
+			Expect(cmd).NotTo(ContainElement("check-deps"))
+		}
+	})
+})
+
+var _ = Describe("isTransientError", func() {
+	DescribeTable("classifying cachi2 failures",
+		func(err error, transient bool) {
+			Expect(isTransientError(err)).To(Equal(transient))
+		},
+		Entry("network error", &exec.CommandError{ExitCode: 1, Message: "network is unreachable"}, true),
+		Entry("timeout", &exec.CommandError{ExitCode: 1, Message: "ReadTimeout: timed out"}, true),
+		Entry("other failure", &exec.CommandError{ExitCode: 1, Message: "lockfile is invalid"}, false),
+		Entry("zero exit code", &exec.CommandError{ExitCode: 0, Message: "network"}, false),
+		Entry("not a command error", context.DeadlineExceeded, false),
+	)
+})
+
+var _ = Describe("FetchDependencies", func() {
+	It("should run cachi2 with the runner and the scratch directory as HOME", func() {
+		runner := exec.NewMockCommandRunner()
+		config := &Config{
+			Input:       "pip",
+			SourcePath:  GinkgoT().TempDir(),
+			OutputPath:  filepath.Join(GinkgoT().TempDir(), "output"),
+			ScratchPath: GinkgoT().TempDir(),
+		}
+		home := "HOME=" + config.ScratchPath
+
+		Expect(FetchDependencies(context.Background(), zap.NewNop(), config, runner)).To(Succeed())
+
+		Expect(runner.GetExecutedCommands()).NotTo(BeEmpty())
+		for _, cmd := range runner.GetExecutedCommands() {
+			Expect(cmd[:3]).To(Equal([]string{"env", home, "cachi2"}))
+		}
+		Expect(runner.AssertCommandExecuted("env", home, "cachi2",
+			"fetch-deps", "--source="+config.SourcePath, "--output="+config.OutputPath, "pip")).To(BeTrue())
+	})
+})
@@ -0,0 +1,214 @@
+package prefetch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// packageManagerCredentials are the fields of a <pkgmgr>.credentials file:
+// KEY=value lines with url and either username and password or token
+type packageManagerCredentials struct {
+	URL      string
+	Username string
+	Password string
+	Token    string
+}
+
+// credentialsGenerators maps the package managers whose credentials are
+// supported to the config file generated in the source directory
+var credentialsGenerators = map[string]struct {
+	configFile string
+	generate   func(creds *packageManagerCredentials) ([]byte, error)
+}{
+	"pip": {configFile: "pip.conf", generate: generatePipConf},
+	"npm": {configFile: ".npmrc", generate: generateNpmrc},
+}
+
+// setupPackageManagerCredentials generates the package manager config files
+// in the source directory from the credentials found in
+// PackageManagerCredsPath. The returned function restores the source
+// directory so that the credentials don't end up in the image.
+func setupPackageManagerCredentials(logger *zap.Logger, config *Config) (func() error, error) {
+	var restores []func() error
+	restore := func() error {
+		var errs []error
+		for i := len(restores) - 1; i >= 0; i-- {
+			errs = append(errs, restores[i]())
+		}
+		return errors.Join(errs...)
+	}
+
+	if config.PackageManagerCredsPath == "" {
+		return restore, nil
+	}
+
+	for _, pkgManager := range []string{"npm", "pip"} {
+		credsPath := filepath.Join(config.PackageManagerCredsPath, pkgManager+".credentials")
+		content, err := os.ReadFile(credsPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return restore, fmt.Errorf("failed to read %s credentials: %w", pkgManager, err)
+		}
+
+		creds, err := parsePackageManagerCredentials(content)
+		if err != nil {
+			return restore, fmt.Errorf("invalid %s: %w", credsPath, err)
+		}
+
+		generator := credentialsGenerators[pkgManager]
+		configContent, err := generator.generate(creds)
+		if err != nil {
+			return restore, fmt.Errorf("failed to generate %s from %s credentials: %w", generator.configFile, pkgManager, err)
+		}
+
+		configPath := filepath.Join(config.SourcePath, generator.configFile)
+		restoreFile, err := replaceFile(configPath, configContent)
+		if err != nil {
+			return restore, fmt.Errorf("failed to write %s: %w", generator.configFile, err)
+		}
+		restores = append(restores, restoreFile)
+
+		logger.Info("Generated package manager configuration from credentials",
+			zap.String("package_manager", pkgManager),
+			zap.String("config_file", generator.configFile))
+	}
+	return restore, nil
+}
+
+// parsePackageManagerCredentials parses KEY=value lines, ignoring blank lines
+// and comments
+func parsePackageManagerCredentials(content []byte) (*packageManagerCredentials, error) {
+	creds := &packageManagerCredentials{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			// The line isn't quoted as it may be a secret
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "url":
+			creds.URL = value
+		case "username":
+			creds.Username = value
+		case "password":
+			creds.Password = value
+		case "token":
+			creds.Token = value
+		default:
+			return nil, fmt.Errorf("unknown key %q, expected url, username, password or token", key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if creds.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	return creds, nil
+}
+
+// parseCredentialsURL parses the URL of the credentials, which must be absolute
+func parseCredentialsURL(creds *packageManagerCredentials) (*url.URL, error) {
+	u, err := url.Parse(creds.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("url %q must be absolute", creds.URL)
+	}
+	return u, nil
+}
+
+// generatePipConf sets the index URL of pip, with the username and password
+// as its user info
+func generatePipConf(creds *packageManagerCredentials) ([]byte, error) {
+	if creds.Token != "" {
+		return nil, fmt.Errorf("pip doesn't support token, use username and password")
+	}
+
+	u, err := parseCredentialsURL(creds)
+	if err != nil {
+		return nil, err
+	}
+	if creds.Username != "" {
+		u.User = url.UserPassword(creds.Username, creds.Password)
+	}
+	return []byte(fmt.Sprintf("[global]\nindex-url = %s\n", u.String())), nil
+}
+
+// generateNpmrc sets the npm registry, authenticated with the token or with
+// the username and password
+func generateNpmrc(creds *packageManagerCredentials) ([]byte, error) {
+	u, err := parseCredentialsURL(creds)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	var auth string
+	switch {
+	case creds.Token != "":
+		auth = "_authToken=" + creds.Token
+	case creds.Username != "":
+		auth = "_auth=" + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Password))
+	default:
+		return nil, fmt.Errorf("npm requires token or username and password")
+	}
+
+	return []byte(fmt.Sprintf("registry=%s\n//%s%s:%s\n", u.String(), u.Host, u.Path, auth)), nil
+}
+
+// replaceFile writes a file readable by its owner only, returning a function
+// restoring the previous content or removing the file when it didn't exist
+func replaceFile(path string, content []byte) (func() error, error) {
+	original, err := os.ReadFile(path)
+	existed := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var mode os.FileMode = 0600
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		if existed {
+			if err := os.WriteFile(path, original, mode); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", path, err)
+			}
+			return os.Chmod(path, mode)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		return nil
+	}, nil
+}
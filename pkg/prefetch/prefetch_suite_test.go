@@ -0,0 +1,13 @@
+package prefetch_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrefetch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Prefetch Suite")
+}
@@ -0,0 +1,187 @@
+package provenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Predicate format versions Tekton Chains supports
+const (
+	VersionSLSA02 = "slsa/v0.2"
+	VersionSLSA10 = "slsa/v1.0"
+
+	// DefaultVersion is the format of an empty version
+	DefaultVersion = VersionSLSA02
+)
+
+// BuildResult describes a completed build a predicate is generated for
+type BuildResult struct {
+	// BuilderID and BuildType identify the builder and the kind of build
+	BuilderID string
+	BuildType string
+
+	// Parameters are the parameters the build was invoked with, secrets
+	// already masked
+	Parameters map[string]interface{}
+
+	// SourceURL and CommitSHA identify the built git commit, if any
+	SourceURL string
+	CommitSHA string
+
+	// ImageURL and ImageDigest identify the pushed image, if any
+	ImageURL    string
+	ImageDigest string
+
+	// Tools lists the tools that produced the image, in a stable order
+	Tools []Tool
+}
+
+// Tool is a tool and its version
+type Tool struct {
+	Name    string
+	Version string
+}
+
+// ValidateVersion fails on a predicate format version that isn't supported
+func ValidateVersion(version string) error {
+	switch version {
+	case "", VersionSLSA02, VersionSLSA10:
+		return nil
+	}
+	return fmt.Errorf("unsupported predicate version %q, expected %s or %s", version, VersionSLSA02, VersionSLSA10)
+}
+
+// GeneratePredicate encodes the SLSA provenance predicate of a build in the
+// given format version
+func GeneratePredicate(result *BuildResult, version string) ([]byte, error) {
+	if err := ValidateVersion(version); err != nil {
+		return nil, err
+	}
+
+	var predicate interface{}
+	switch version {
+	case VersionSLSA10:
+		predicate = predicateV1(result)
+	default:
+		predicate = predicateV02(result)
+	}
+	return json.Marshal(predicate)
+}
+
+// Material is an artifact that went into or came out of the build, a
+// resource descriptor in SLSA v1.0
+type Material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// PredicateV02 is a minimal SLSA v0.2 provenance predicate
+type PredicateV02 struct {
+	Builder    BuilderV02    `json:"builder"`
+	BuildType  string        `json:"buildType"`
+	Invocation InvocationV02 `json:"invocation"`
+	Materials  []Material    `json:"materials"`
+}
+
+// BuilderV02 identifies the entity that ran the build
+type BuilderV02 struct {
+	ID string `json:"id"`
+}
+
+// InvocationV02 holds the parameters the build was invoked with
+type InvocationV02 struct {
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// predicateV02 lists the source, the pushed image and the tools as materials
+func predicateV02(result *BuildResult) *PredicateV02 {
+	predicate := &PredicateV02{
+		Builder:    BuilderV02{ID: result.BuilderID},
+		BuildType:  result.BuildType,
+		Invocation: InvocationV02{Parameters: result.Parameters},
+		Materials:  []Material{},
+	}
+
+	if source, ok := sourceMaterial(result); ok {
+		predicate.Materials = append(predicate.Materials, source)
+	}
+	if result.ImageURL != "" {
+		material := Material{URI: "oci://" + result.ImageURL}
+		if algorithm, hex, found := strings.Cut(result.ImageDigest, ":"); found {
+			material.Digest = map[string]string{algorithm: hex}
+		}
+		predicate.Materials = append(predicate.Materials, material)
+	}
+	for _, tool := range result.Tools {
+		if tool.Version != "" {
+			predicate.Materials = append(predicate.Materials, Material{
+				URI: fmt.Sprintf("pkg:generic/%s@%s", tool.Name, tool.Version),
+			})
+		}
+	}
+	return predicate
+}
+
+// PredicateV1 is a minimal SLSA v1.0 provenance predicate
+type PredicateV1 struct {
+	BuildDefinition BuildDefinitionV1 `json:"buildDefinition"`
+	RunDetails      RunDetailsV1      `json:"runDetails"`
+}
+
+// BuildDefinitionV1 describes the inputs of the build
+type BuildDefinitionV1 struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	ResolvedDependencies []Material             `json:"resolvedDependencies"`
+}
+
+// RunDetailsV1 describes the builder that ran the build
+type RunDetailsV1 struct {
+	Builder BuilderV1 `json:"builder"`
+}
+
+// BuilderV1 identifies the builder, with the versions of its tools
+type BuilderV1 struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// predicateV1 resolves the source as a dependency and records the tools as
+// builder versions. The pushed image is the subject of the statement rather
+// than part of the predicate.
+func predicateV1(result *BuildResult) *PredicateV1 {
+	predicate := &PredicateV1{
+		BuildDefinition: BuildDefinitionV1{
+			BuildType:            result.BuildType,
+			ExternalParameters:   result.Parameters,
+			ResolvedDependencies: []Material{},
+		},
+		RunDetails: RunDetailsV1{Builder: BuilderV1{ID: result.BuilderID}},
+	}
+
+	if source, ok := sourceMaterial(result); ok {
+		predicate.BuildDefinition.ResolvedDependencies = append(predicate.BuildDefinition.ResolvedDependencies, source)
+	}
+	for _, tool := range result.Tools {
+		if tool.Version == "" {
+			continue
+		}
+		if predicate.RunDetails.Builder.Version == nil {
+			predicate.RunDetails.Builder.Version = make(map[string]string)
+		}
+		predicate.RunDetails.Builder.Version[tool.Name] = tool.Version
+	}
+	return predicate
+}
+
+// sourceMaterial returns the git commit of the build
+func sourceMaterial(result *BuildResult) (Material, bool) {
+	if result.SourceURL == "" {
+		return Material{}, false
+	}
+	return Material{
+		URI:    "git+" + result.SourceURL,
+		Digest: map[string]string{"sha1": result.CommitSHA},
+	}, true
+}
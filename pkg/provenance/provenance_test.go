@@ -0,0 +1,87 @@
+package provenance
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GeneratePredicate", func() {
+	var result *BuildResult
+
+	BeforeEach(func() {
+		result = &BuildResult{
+			BuilderID:   "https://konflux-ci.dev/monolithic-builder",
+			BuildType:   "https://konflux-ci.dev/monolithic-builder/build-container@v1",
+			Parameters:  map[string]interface{}{"IMAGE_URL": "quay.io/test/image:tag"},
+			SourceURL:   "https://github.com/konflux-ci/testrepo",
+			CommitSHA:   "0123456789abcdef0123456789abcdef01234567",
+			ImageURL:    "quay.io/test/image:tag",
+			ImageDigest: "sha256:4b5f3d8e0c1a",
+			Tools:       []Tool{{Name: "buildah", Version: "1.33.7"}, {Name: "skopeo"}},
+		}
+	})
+
+	It("should generate a SLSA v0.2 predicate with the source, the image and the tools as materials", func() {
+		predicate, err := GeneratePredicate(result, VersionSLSA02)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(predicate).To(MatchJSON(`{
+			"builder": {"id": "https://konflux-ci.dev/monolithic-builder"},
+			"buildType": "https://konflux-ci.dev/monolithic-builder/build-container@v1",
+			"invocation": {"parameters": {"IMAGE_URL": "quay.io/test/image:tag"}},
+			"materials": [
+				{"uri": "git+https://github.com/konflux-ci/testrepo", "digest": {"sha1": "0123456789abcdef0123456789abcdef01234567"}},
+				{"uri": "oci://quay.io/test/image:tag", "digest": {"sha256": "4b5f3d8e0c1a"}},
+				{"uri": "pkg:generic/buildah@1.33.7"}
+			]
+		}`))
+	})
+
+	It("should generate a SLSA v1.0 predicate with the source as a resolved dependency", func() {
+		predicate, err := GeneratePredicate(result, VersionSLSA10)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(predicate).To(MatchJSON(`{
+			"buildDefinition": {
+				"buildType": "https://konflux-ci.dev/monolithic-builder/build-container@v1",
+				"externalParameters": {"IMAGE_URL": "quay.io/test/image:tag"},
+				"resolvedDependencies": [
+					{"uri": "git+https://github.com/konflux-ci/testrepo", "digest": {"sha1": "0123456789abcdef0123456789abcdef01234567"}}
+				]
+			},
+			"runDetails": {
+				"builder": {"id": "https://konflux-ci.dev/monolithic-builder", "version": {"buildah": "1.33.7"}}
+			}
+		}`))
+	})
+
+	It("should keep empty dependency lists for a build without source", func() {
+		result = &BuildResult{BuilderID: "builder", BuildType: "type"}
+
+		v02, err := GeneratePredicate(result, VersionSLSA02)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v02).To(ContainSubstring(`"materials":[]`))
+
+		v1, err := GeneratePredicate(result, VersionSLSA10)
+		Expect(err).NotTo(HaveOccurred())
+		var predicate PredicateV1
+		Expect(json.Unmarshal(v1, &predicate)).To(Succeed())
+		Expect(predicate.BuildDefinition.ResolvedDependencies).To(BeEmpty())
+		Expect(predicate.RunDetails.Builder.Version).To(BeNil())
+	})
+
+	It("should default to SLSA v0.2", func() {
+		v02, err := GeneratePredicate(result, VersionSLSA02)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(GeneratePredicate(result, "")).To(Equal(v02))
+	})
+
+	It("should reject an unsupported version", func() {
+		_, err := GeneratePredicate(result, "slsa/v2")
+
+		Expect(err).To(MatchError(`unsupported predicate version "slsa/v2", expected slsa/v0.2 or slsa/v1.0`))
+	})
+})
@@ -0,0 +1,83 @@
+package redact_test
+
+import (
+	"reflect"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/buildcontainer"
+	"github.com/konflux-ci/monolithic-builder/pkg/imageindex"
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// nonSecretFields lists the configuration fields reviewed as never carrying a
+// credential, beyond the passwords of URLs that Fields masks in every string.
+// A new field must be added either here or to redact.SecretFields.
+var nonSecretFields = map[string]bool{
+	// Source
+	"SourceMode": true, "SourcePath": true, "AllowDirtySource": true, "GitURL": true,
+	"GitRevision": true, "GitRefspec": true, "GitDepth": true, "GitSubmodules": true,
+	"GitRef": true, "TagTemplate": true, "GitSparseCheckout": true, "GitExcludePaths": true,
+	"PatchPath": true, "WriteCommitTitle": true, "VerifyTagSignature": true,
+	"TagSigningKeyPath": true, "CommitSHA": true, "SkipGitClone": true, "CloneOnly": true,
+
+	// Image and registries
+	"ImageURL": true, "Dockerfile": true, "Context": true, "Rebuild": true, "SkipChecks": true,
+	"RebuildOnBaseChange": true, "Hermetic": true, "TLSVerify": true, "ImageExpiresAfter": true,
+	"PushByDigestOnly": true, "BaseImagePolicy": true, "LabelFile": true, "DockerfileFrom": true,
+	"ExistenceCheckTags": true, "RemoteSourceAllowlist": true, "InsecureRegistries": true,
+	"AutoInsecureLocalRegistries": true, "ImageRegistryMirrors": true, "AuthFile": true,
+	"CertDir": true, "RegistryLogin": true, "RegistryType": true, "RegistryCredentialsPath": true,
+	"PreflightPushCheck": true, "VerifyImageID": true, "RequireDigest": true, "AtomicTag": true,
+	"RunID": true, "TagDeletion": true, "QuayTokenPath": true, "MaxLayers": true,
+	"MaxHistory": true, "MaxLayerCount": true, "FileManifest": true, "FileDenyPatterns": true,
+	"FileDenyAction": true, "ImageConfigExpectations": true, "Platform": true,
+
+	// Prefetch and build
+	"PrefetchInput": true, "DevPackageManagers": true, "Cachi2LogLevel": true,
+	"PrefetchDryRunCheck": true, "PrefetchEnvFormat": true, "Cachi2OutputDirForEnv": true,
+	"InjectCachi2Env": true, "BuildArgsFile": true, "IgnoreFile": true, "NetworkMode": true,
+	"StorageDriver": true, "UnshareUIDMap": true, "UnshareGIDMap": true,
+	"LayerCacheDir": true, "LayerCacheMaxSize": true, "EnableBuildCache": true,
+	"BuildCacheDir": true, "UserNS": true, "UserNSUIDMap": true, "UserNSGIDMap": true,
+
+	// Workspace and credentials paths
+	"WorkspacePath": true, "WorkspaceSubPath": true, "ResultsPath": true,
+	"WorkspaceReadOnly": true, "TempDir": true, "GitAuthPath": true, "NetrcPath": true,
+	"PackageManagerCredsPath": true,
+
+	// Outputs and behavior
+	"ToolVersionLabels": true, "UseCommitTimestamp": true, "EmitProvenancePredicate": true,
+	"ProvenancePredicateType": true, "EmitEffectiveBuildArgs": true, "GenerateSBOM": true,
+	"Resume": true, "Deadline": true, "StepBudgets": true, "FailureGracePeriod": true,
+	"CleanupTempTags": true, "TempTagPattern": true, "TempTagTTL": true,
+	"TempTagCleanupMax": true, "StrictWarnings": true, "DebugConfig": true,
+
+	// Image index
+	"AlwaysBuildIndex": true, "Images": true, "AppendMode": true, "UpdateExisting": true,
+	"PruneAfterPush": true, "FallbackToDockerManifest": true, "DigestFormat": true,
+	"WriteComponentCount": true, "WriteIndexSize": true, "AdditionalImageURLs": true,
+	"RegistrySettings": true, "WebhookPayloadTemplate": true, "CloudEventsEndpoint": true,
+	"CloudEventType": true, "KeylessSigning": true, "OIDCIssuer": true,
+	"WriteYAMLSummary": true, "YAMLOutputPath": true,
+}
+
+var _ = Describe("Config classification", func() {
+	DescribeTable("should classify every field as secret or not",
+		func(cfg interface{}) {
+			t := reflect.TypeOf(cfg)
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if !field.IsExported() {
+					continue
+				}
+				secret, public := redact.SecretFields[field.Name], nonSecretFields[field.Name]
+				Expect(secret || public).To(BeTrue(),
+					"%s.%s must be added to redact.SecretFields or to nonSecretFields", t, field.Name)
+				Expect(secret && public).To(BeFalse(), "%s.%s is classified as both secret and not", t, field.Name)
+			}
+		},
+		Entry("buildcontainer", buildcontainer.Config{}),
+		Entry("imageindex", imageindex.Config{}),
+	)
+})
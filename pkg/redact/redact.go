@@ -0,0 +1,187 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces secret-bearing values in sanitized output
+const Mask = "********"
+
+// SecretFields lists the configuration field names whose values may carry
+// credentials. It is shared by every Config type so that a new secret-bearing
+// field only has to be registered here to be masked everywhere.
+var SecretFields = map[string]bool{
+	"BuildArgs":               true,
+	"Cachi2ConfigFileContent": true,
+	"WebhookURL":              true,
+}
+
+// secretNamePattern matches the names of variables that likely hold a credential
+var secretNamePattern = regexp.MustCompile(`(?i)token|secret|passw(or)?d|credential|auth|api_?key|private_?key|access_?key`)
+
+// IsSecretName reports whether a variable name looks like it holds a credential
+func IsSecretName(name string) bool {
+	return secretNamePattern.MatchString(name)
+}
+
+// Value masks the value of a named variable when the name looks secret, or
+// the password of a URL value carrying credentials. It reports whether the
+// value was changed.
+func Value(name, value string) (string, bool) {
+	if value == "" {
+		return value, false
+	}
+	if IsSecretName(name) {
+		return Mask, true
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return u.Redacted(), true
+		}
+	}
+	return value, false
+}
+
+// Fields renders every exported field of the struct pointed to by cfg,
+// masking the values of SecretFields. The passwords of the URLs in the other
+// string values are masked too, paths and other values are kept as-is.
+func Fields(cfg interface{}) (map[string]interface{}, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct, got %s", v.Kind())
+	}
+
+	fields := make(map[string]interface{}, v.NumField())
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		value := v.Field(i)
+		if SecretFields[field.Name] {
+			fields[field.Name] = maskValue(value)
+		} else {
+			fields[field.Name] = maskURLs(value)
+		}
+	}
+
+	return fields, nil
+}
+
+// JSON renders the sanitized fields of cfg as indented JSON
+func JSON(cfg interface{}) ([]byte, error) {
+	fields, err := Fields(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(fields, "", "  ")
+}
+
+// maskValue masks a secret value while keeping enough shape to debug with:
+// empty values stay empty and KEY=value list entries keep their key
+func maskValue(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.String:
+		if value.Len() == 0 {
+			return ""
+		}
+		return Mask
+	case reflect.Slice, reflect.Array:
+		items := make([]string, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			items = append(items, fmt.Sprint(value.Index(i).Interface()))
+		}
+		return KeyValues(items)
+	default:
+		if value.IsZero() {
+			return value.Interface()
+		}
+		return Mask
+	}
+}
+
+// maskURLs masks the passwords of the URLs in a string value or in the items
+// of a string list or map. Other values are returned as-is.
+func maskURLs(value reflect.Value) interface{} {
+	switch {
+	case value.Kind() == reflect.String:
+		masked, _ := Value("", value.String())
+		return masked
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String && !value.IsNil():
+		items := make([]string, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			item, _ := Value("", value.Index(i).String())
+			items = append(items, item)
+		}
+		return items
+	case value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String &&
+		value.Type().Elem().Kind() == reflect.String && !value.IsNil():
+		items := make(map[string]string, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			items[iter.Key().String()], _ = Value("", iter.Value().String())
+		}
+		return items
+	default:
+		return value.Interface()
+	}
+}
+
+// KeyValues masks the values of KEY=value entries, keeping their keys.
+// Entries without a key are masked entirely.
+func KeyValues(items []string) []string {
+	masked := make([]string, 0, len(items))
+	for _, item := range items {
+		if key, _, found := strings.Cut(item, "="); found {
+			masked = append(masked, key+"="+Mask)
+		} else {
+			masked = append(masked, Mask)
+		}
+	}
+	return masked
+}
+
+// secretFlags lists the command-line flags whose value is a credential
+var secretFlags = map[string]bool{
+	"--creds":          true,
+	"--src-creds":      true,
+	"--dest-creds":     true,
+	"--identity-token": true,
+	"--password":       true,
+	"--token":          true,
+}
+
+// Args masks the credentials of a command line: the values of secretFlags,
+// KEY=value arguments with a secret-looking key and URLs carrying a password
+func Args(args []string) []string {
+	masked := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i > 0 && secretFlags[args[i-1]] {
+			masked = append(masked, Mask)
+			continue
+		}
+
+		key, value, found := strings.Cut(arg, "=")
+		switch {
+		case found && secretFlags[key]:
+			masked = append(masked, key+"="+Mask)
+		case found && !strings.HasPrefix(key, "-"):
+			value, _ = Value(key, value)
+			masked = append(masked, key+"="+value)
+		default:
+			arg, _ = Value("", arg)
+			masked = append(masked, arg)
+		}
+	}
+	return masked
+}
@@ -0,0 +1,74 @@
+package results
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment variables consulted when locating the results directory
+const (
+	// EnvResultsPath overrides the results directory
+	EnvResultsPath = "RESULTS_PATH"
+
+	// EnvStepName names the step when running as a StepAction, whose
+	// results live under StepsDir/<step>/results
+	EnvStepName = "TEKTON_STEP_NAME"
+)
+
+// Tekton results locations
+const (
+	// TaskResultsDir holds the results of a Task
+	TaskResultsDir = "/tekton/results"
+
+	// StepsDir holds a directory per step, each with its own results
+	StepsDir = "/tekton/steps"
+)
+
+// ResolveDir returns the directory results should be written to: RESULTS_PATH
+// when set, then the StepAction results directory of TEKTON_STEP_NAME, then
+// the Task results directory. An explicit RESULTS_PATH must be writable; it
+// is an error when no candidate is, rather than writing results Tekton
+// ignores.
+func ResolveDir() (string, error) {
+	return resolveDir(os.Getenv, StepsDir, TaskResultsDir)
+}
+
+// resolveDir implements ResolveDir with the environment and Tekton directories injected
+func resolveDir(getenv func(string) string, stepsDir, taskResultsDir string) (string, error) {
+	if dir := getenv(EnvResultsPath); dir != "" {
+		if err := checkWritable(dir); err != nil {
+			return "", fmt.Errorf("%s %s is not writable: %w", EnvResultsPath, dir, err)
+		}
+		return dir, nil
+	}
+
+	var candidates []string
+	if step := getenv(EnvStepName); step != "" {
+		candidates = append(candidates, filepath.Join(stepsDir, step, "results"))
+	}
+	candidates = append(candidates, taskResultsDir)
+
+	var failures []string
+	for _, dir := range candidates {
+		err := checkWritable(dir)
+		if err == nil {
+			return dir, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", dir, err))
+	}
+
+	return "", fmt.Errorf("no writable results directory found, set %s (tried %s)",
+		EnvResultsPath, strings.Join(failures, "; "))
+}
+
+// checkWritable verifies that a file can be created in dir
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".write-check-")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
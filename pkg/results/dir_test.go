@@ -0,0 +1,91 @@
+package results
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("resolveDir", func() {
+	var (
+		env            map[string]string
+		tekton         string
+		stepsDir       string
+		taskResultsDir string
+	)
+
+	getenv := func(key string) string { return env[key] }
+
+	BeforeEach(func() {
+		env = map[string]string{}
+		tekton = GinkgoT().TempDir()
+		stepsDir = filepath.Join(tekton, "steps")
+		taskResultsDir = filepath.Join(tekton, "results")
+	})
+
+	It("should use RESULTS_PATH when set", func() {
+		env[EnvResultsPath] = GinkgoT().TempDir()
+		env[EnvStepName] = "build"
+		Expect(os.MkdirAll(taskResultsDir, 0755)).To(Succeed())
+
+		dir, err := resolveDir(getenv, stepsDir, taskResultsDir)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dir).To(Equal(env[EnvResultsPath]))
+	})
+
+	It("should fail when RESULTS_PATH isn't writable instead of falling back", func() {
+		env[EnvResultsPath] = filepath.Join(tekton, "missing")
+		Expect(os.MkdirAll(taskResultsDir, 0755)).To(Succeed())
+
+		_, err := resolveDir(getenv, stepsDir, taskResultsDir)
+
+		Expect(err).To(MatchError(ContainSubstring("RESULTS_PATH " + env[EnvResultsPath] + " is not writable")))
+	})
+
+	It("should use the StepAction results directory of the step", func() {
+		env[EnvStepName] = "step-build"
+		stepResults := filepath.Join(stepsDir, "step-build", "results")
+		Expect(os.MkdirAll(stepResults, 0755)).To(Succeed())
+		Expect(os.MkdirAll(taskResultsDir, 0755)).To(Succeed())
+
+		dir, err := resolveDir(getenv, stepsDir, taskResultsDir)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dir).To(Equal(stepResults))
+	})
+
+	It("should fall back to the Task results directory", func() {
+		env[EnvStepName] = "step-build"
+		Expect(os.MkdirAll(taskResultsDir, 0755)).To(Succeed())
+
+		dir, err := resolveDir(getenv, stepsDir, taskResultsDir)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dir).To(Equal(taskResultsDir))
+	})
+
+	It("should use the Task results directory outside a StepAction", func() {
+		Expect(os.MkdirAll(taskResultsDir, 0755)).To(Succeed())
+
+		dir, err := resolveDir(getenv, stepsDir, taskResultsDir)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dir).To(Equal(taskResultsDir))
+		entries, err := os.ReadDir(taskResultsDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("should fail listing every candidate when none is writable", func() {
+		env[EnvStepName] = "step-build"
+
+		_, err := resolveDir(getenv, stepsDir, taskResultsDir)
+
+		Expect(err).To(MatchError(ContainSubstring("no writable results directory found")))
+		Expect(err).To(MatchError(ContainSubstring(filepath.Join(stepsDir, "step-build", "results"))))
+		Expect(err).To(MatchError(ContainSubstring(taskResultsDir)))
+	})
+})
@@ -0,0 +1,66 @@
+package results
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
+)
+
+// DefaultFailureGracePeriod bounds the flush of partial results unless
+// FAILURE_GRACE_PERIOD is set
+const DefaultFailureGracePeriod = 10 * time.Second
+
+// Categories of the ERROR_CATEGORY result
+const (
+	ErrorCategoryTimeout       = "timeout"
+	ErrorCategoryRateLimit     = "rate-limit"
+	ErrorCategoryStrictWarning = "strict-warning"
+	ErrorCategoryCommand       = "command"
+	ErrorCategoryInternal      = "internal"
+)
+
+// ErrorCategory classifies the fatal error of a builder for ERROR_CATEGORY
+func ErrorCategory(err error) string {
+	var strictErr *warnings.StrictError
+	var cmdErr *exec.CommandError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorCategoryTimeout
+	case exec.IsRateLimited(err):
+		return ErrorCategoryRateLimit
+	case errors.As(err, &strictErr):
+		return ErrorCategoryStrictWarning
+	case errors.As(err, &cmdErr):
+		return ErrorCategoryCommand
+	default:
+		return ErrorCategoryInternal
+	}
+}
+
+// FlushWithin runs flush, waiting for it no longer than grace so that a
+// hanging write never holds back the error being reported. A zero grace waits
+// for flush to complete. It reports whether flush completed in time.
+func FlushWithin(grace time.Duration, flush func()) bool {
+	if grace <= 0 {
+		flush()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		flush()
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
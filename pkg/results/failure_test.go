@@ -0,0 +1,51 @@
+package results
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/warnings"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failure", func() {
+	DescribeTable("ErrorCategory",
+		func(err error, expected string) {
+			Expect(ErrorCategory(err)).To(Equal(expected))
+		},
+		Entry("deadline", fmt.Errorf("step build: %w", context.DeadlineExceeded), ErrorCategoryTimeout),
+		Entry("rate limit", &exec.CommandError{ExitCode: 1, Message: "toomanyrequests: slow down"}, ErrorCategoryRateLimit),
+		Entry("strict warning", fmt.Errorf("failed: %w", &warnings.StrictError{}), ErrorCategoryStrictWarning),
+		Entry("command", fmt.Errorf("failed to push: %w", &exec.CommandError{ExitCode: 125, Message: "unauthorized"}), ErrorCategoryCommand),
+		Entry("other", errors.New("invalid Dockerfile"), ErrorCategoryInternal),
+	)
+
+	Describe("FlushWithin", func() {
+		It("should report a flush completed in time", func() {
+			flushed := false
+
+			Expect(FlushWithin(time.Second, func() { flushed = true })).To(BeTrue())
+			Expect(flushed).To(BeTrue())
+		})
+
+		It("should stop waiting for a flush past the grace period", func() {
+			release := make(chan struct{})
+			defer close(release)
+
+			start := time.Now()
+			Expect(FlushWithin(20*time.Millisecond, func() { <-release })).To(BeFalse())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("should wait for the flush without a grace period", func() {
+			flushed := false
+
+			Expect(FlushWithin(0, func() { flushed = true })).To(BeTrue())
+			Expect(flushed).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,119 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
+)
+
+// FailureReportFile is the failure report written to the results directory
+// when a builder fails
+const FailureReportFile = "failure-report.json"
+
+// FailureReport describes the failure of a builder in a single artifact for
+// triaging failed runs
+type FailureReport struct {
+	Category string `json:"category"`
+	Step     string `json:"step"`
+	Error    string `json:"error"`
+
+	// Command is the redacted command line that failed, if the error came
+	// from one
+	Command    []string `json:"command,omitempty"`
+	ExitCode   int      `json:"exitCode,omitempty"`
+	StderrTail string   `json:"stderrTail,omitempty"`
+
+	Hint string `json:"hint"`
+}
+
+// failureHint is a remediation hint for the failures of a category, limited
+// to a step unless step is empty
+type failureHint struct {
+	category string
+	step     string
+	hint     string
+}
+
+// failureHints is the catalogue of remediation hints. The first entry
+// matching a failure wins, so step-specific hints come before the hint of
+// their category.
+var failureHints = []failureHint{
+	{ErrorCategoryTimeout, "", "The build ran out of time: raise BUILD_DEADLINE or the task timeout, or enable the build cache"},
+	{ErrorCategoryRateLimit, "", "The registry rate limit was still hit after the retries: retry later or authenticate the pulls"},
+	{ErrorCategoryStrictWarning, "", "A warning was escalated by STRICT_WARNINGS: fix its cause or drop its category from STRICT_WARNINGS"},
+	{ErrorCategoryCommand, "clone", "Check that GIT_URL and the revision exist and that the git credentials can read the repository"},
+	{ErrorCategoryCommand, "prefetch", "Check the prefetch input and that the lock files of the dependencies are up to date"},
+	{ErrorCategoryCommand, "build", "Fix the Containerfile instruction reported in the stderr tail, or the base image it pulls"},
+	{ErrorCategoryCommand, "push", "Check that the registry credentials can push to the image repository"},
+	{ErrorCategoryCommand, "index", "Check that every platform image exists and that the credentials can push the index"},
+	{ErrorCategoryCommand, "", "Inspect the failed command and its stderr tail"},
+	{ErrorCategoryInternal, "", "Check the configuration of the failed step; report a bug if the error persists"},
+}
+
+// FailureHint returns the remediation hint of a failure of category in step
+func FailureHint(category, step string) string {
+	for _, hint := range failureHints {
+		if hint.category == category && (hint.step == "" || hint.step == step) {
+			return hint.hint
+		}
+	}
+	return ""
+}
+
+// NewFailureReport assembles the report of err failing step. command is the
+// command line err came from, nil when unknown, and is redacted.
+func NewFailureReport(step, category string, err error, command []string) *FailureReport {
+	report := &FailureReport{
+		Category: category,
+		Step:     step,
+		Error:    err.Error(),
+		Hint:     FailureHint(category, step),
+	}
+	if len(command) > 0 {
+		report.Command = redact.Args(command)
+	}
+
+	var cmdErr *exec.CommandError
+	if errors.As(err, &cmdErr) {
+		report.ExitCode = cmdErr.ExitCode
+		report.StderrTail = cmdErr.Stderr
+		if report.StderrTail == "" {
+			report.StderrTail = cmdErr.Message
+		}
+	}
+	return report
+}
+
+// String renders the report on one line for the final log line
+func (r *FailureReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "step %s failed (%s): %s", r.Step, r.Category, r.Error)
+	if len(r.Command) > 0 {
+		fmt.Fprintf(&b, "; command: %s", strings.Join(r.Command, " "))
+		if r.ExitCode != 0 {
+			fmt.Fprintf(&b, " (exit code %d)", r.ExitCode)
+		}
+	}
+	if r.Hint != "" {
+		fmt.Fprintf(&b, "; hint: %s", r.Hint)
+	}
+	return b.String()
+}
+
+// Write writes the report to FailureReportFile in dir
+func (r *FailureReport) Write(dir string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode failure report: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, FailureReportFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write failure report: %w", err)
+	}
+	return nil
+}
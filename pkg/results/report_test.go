@@ -0,0 +1,56 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/konflux-ci/monolithic-builder/pkg/exec"
+	"github.com/konflux-ci/monolithic-builder/pkg/redact"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FailureReport", func() {
+	It("should have a hint for every category", func() {
+		for _, category := range []string{
+			ErrorCategoryTimeout, ErrorCategoryRateLimit, ErrorCategoryStrictWarning, ErrorCategoryCommand, ErrorCategoryInternal,
+		} {
+			Expect(FailureHint(category, "unknown-step")).NotTo(BeEmpty(), category)
+		}
+	})
+
+	It("should prefer the hint of the step over the hint of its category", func() {
+		Expect(FailureHint(ErrorCategoryCommand, "clone")).To(ContainSubstring("GIT_URL"))
+		Expect(FailureHint(ErrorCategoryCommand, "cleanup-temp-tags")).To(Equal(FailureHint(ErrorCategoryCommand, "")))
+	})
+
+	It("should report the command, exit code and stderr tail of a failed command", func() {
+		err := fmt.Errorf("failed to push image: %w", &exec.CommandError{ExitCode: 125, Message: "exit status 125: denied", Stderr: "denied"})
+
+		report := NewFailureReport("push", ErrorCategoryCommand, err, []string{"skopeo", "copy", "--dest-creds", "robot:token", "oci:/archive", "docker://quay.io/test/image:tag"})
+
+		Expect(report.Command).To(Equal([]string{"skopeo", "copy", "--dest-creds", redact.Mask, "oci:/archive", "docker://quay.io/test/image:tag"}))
+		Expect(report.ExitCode).To(Equal(125))
+		Expect(report.StderrTail).To(Equal("denied"))
+		Expect(report.String()).To(Equal("step push failed (command): failed to push image: exit status 125: denied; " +
+			"command: skopeo copy --dest-creds ******** oci:/archive docker://quay.io/test/image:tag (exit code 125); " +
+			"hint: " + FailureHint(ErrorCategoryCommand, "push")))
+	})
+
+	It("should write the report to the results directory", func() {
+		dir := GinkgoT().TempDir()
+		report := NewFailureReport("build", ErrorCategoryInternal, errors.New("invalid Containerfile"), nil)
+
+		Expect(report.Write(dir)).To(Succeed())
+
+		data, err := os.ReadFile(filepath.Join(dir, FailureReportFile))
+		Expect(err).NotTo(HaveOccurred())
+		var written FailureReport
+		Expect(json.Unmarshal(data, &written)).To(Succeed())
+		Expect(&written).To(Equal(report))
+		Expect(string(data)).NotTo(ContainSubstring(`"command"`))
+	})
+})
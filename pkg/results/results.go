@@ -0,0 +1,60 @@
+// Package results writes Tekton task results
+package results
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Writer writes task results into a directory. Each result is written to a
+// temporary file and renamed into place, so concurrent writers never leave a
+// partially written result behind.
+type Writer struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewWriter creates a writer for the given results directory
+func NewWriter(dir string) *Writer {
+	return &Writer{dir: dir}
+}
+
+// Dir returns the results directory
+func (w *Writer) Dir() string {
+	return w.dir
+}
+
+// Write atomically writes a result value, sanitized by Sanitize
+func (w *Writer) Write(name, value string) error {
+	value, err := Sanitize(name, value)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tmp, err := os.CreateTemp(w.dir, "."+name+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary result file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.WriteString(value); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write result %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write result %s: %w", name, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write result %s: %w", name, err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(w.dir, name)); err != nil {
+		return fmt.Errorf("failed to write result %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package results_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestResults(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Results Suite")
+}
@@ -0,0 +1,72 @@
+package results
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	var (
+		dir    string
+		writer *Writer
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		writer = NewWriter(dir)
+	})
+
+	It("should write a result file", func() {
+		Expect(writer.Write("IMAGE_DIGEST", "sha256:abc")).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(dir, "IMAGE_DIGEST"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("sha256:abc"))
+	})
+
+	It("should replace an existing result without leaving temporary files", func() {
+		Expect(writer.Write("build", "true")).To(Succeed())
+		Expect(writer.Write("build", "false")).To(Succeed())
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+
+		content, err := os.ReadFile(filepath.Join(dir, "build"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("false"))
+	})
+
+	It("should keep every result whole under concurrent writes", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+				Expect(writer.Write("shared", fmt.Sprintf("value-%02d", i))).To(Succeed())
+				Expect(writer.Write(fmt.Sprintf("result-%02d", i), "done")).To(Succeed())
+			}(i)
+		}
+		wg.Wait()
+
+		content, err := os.ReadFile(filepath.Join(dir, "shared"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(MatchRegexp(`^value-\d{2}$`))
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(21))
+	})
+
+	It("should fail when the results directory doesn't exist", func() {
+		writer = NewWriter(filepath.Join(dir, "missing"))
+
+		Expect(writer.Write("build", "true")).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,58 @@
+package results
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// singleLineResults lists the results holding a single value, such as a
+// digest or a boolean, that downstream tasks compare as is
+var singleLineResults = map[string]bool{
+	"IMAGE_DIGEST":          true,
+	"IMAGE_URL":             true,
+	"IMAGE_REF":             true,
+	"IMAGES":                true,
+	"IMAGE_MEDIA_TYPE":      true,
+	"INDEX_MEDIA_TYPE":      true,
+	"PREVIOUS_INDEX_DIGEST": true,
+	"INDEX_SIZE_BYTES":      true,
+	"COMPONENT_COUNT":       true,
+	"DOCKERFILE_DIGEST":     true,
+	"SBOM_PATH":             true,
+	"DIRTY":                 true,
+	"BASE_IMAGE_CHANGED":    true,
+	"FAILED_STEP":           true,
+	"ERROR_CATEGORY":        true,
+	"build":                 true,
+	"commit":                true,
+	"commit_title":          true,
+	"url":                   true,
+}
+
+// IsSingleLine reports whether a result must hold a single line
+func IsSingleLine(name string) bool {
+	return singleLineResults[name]
+}
+
+// Sanitize normalizes a result value: surrounding whitespace is trimmed, CRLF
+// line endings become LF and the other control characters but tabs and
+// newlines are escaped as \uXXXX. Newlines are an error in single-line
+// results.
+func Sanitize(name, value string) (string, error) {
+	value = strings.TrimSpace(strings.ReplaceAll(value, "\r\n", "\n"))
+
+	if IsSingleLine(name) && strings.Contains(value, "\n") {
+		return "", fmt.Errorf("result %s must be a single line, got %d lines", name, strings.Count(value, "\n")+1)
+	}
+
+	var b strings.Builder
+	for _, r := range value {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			fmt.Fprintf(&b, `\u%04x`, r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,100 @@
+package results
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// emittedResults are the results written by the builders, with values shaped
+// like the ones the builders produce
+var emittedResults = []struct {
+	name  string
+	value string
+}{
+	{"IMAGE_DIGEST", "sha256:4b5f3d8e0c1a\n"},
+	{"IMAGE_URL", "quay.io/test/image:tag"},
+	{"IMAGE_REF", "quay.io/test/image:tag@sha256:4b5f3d8e0c1a"},
+	{"IMAGES", "quay.io/test/image@sha256:amd64,quay.io/test/image@sha256:arm64\n"},
+	{"IMAGE_MEDIA_TYPE", "application/vnd.oci.image.index.v1+json\n"},
+	{"INDEX_MEDIA_TYPE", "application/vnd.docker.distribution.manifest.list.v2+json"},
+	{"INDEX_SIZE_BYTES", "123456"},
+	{"COMPONENT_COUNT", "3"},
+	{"PREVIOUS_INDEX_DIGEST", "sha256:0a9b8c7d6e5f"},
+	{"DOCKERFILE_DIGEST", "sha256:0d1e2f"},
+	{"SBOM_PATH", "/workspace/.monolithic-builder/sbom.json"},
+	{"DIRTY", "false"},
+	{"BASE_IMAGE_CHANGED", "unknown"},
+	{"FAILED_STEP", "push"},
+	{"ERROR_CATEGORY", "rate-limit"},
+	{"build", "true"},
+	{"commit", "0123456789abcdef0123456789abcdef01234567\n"},
+	{"commit_title", "  Fix the \x1b[1mbuild\x1b[0m  "},
+	{"url", "https://github.com/konflux-ci/testrepo"},
+	{"TIMEOUT", "build deadline exceeded in step build:\r\nmanifest push\tfailed\x00"},
+	{"BUILD_METRICS", "clone_seconds=1.500\nbuild_seconds=20.000\nskipped=false\n"},
+	{"RETRY_SUMMARY", `{"git":{"attempts":1,"retries":0},"inspect":{"attempts":3,"retries":2}}`},
+	{"INDEX_METRICS", "build_seconds=0.100\npush_seconds=0.200\nskipped=false\nretries_total=0\n"},
+	{"CHECKS", `{"base_image_policy":{"status":"passed"}}`},
+	{"WARNINGS", `{"count":1,"warnings":[{"category":"digest","message":"failed"}]}`},
+	{"BUILD_ARGS_USED", `["GO_VERSION=1.21","DEBUG=true"]`},
+	{"BUILD_ARGS_EFFECTIVE", `[{"arg":"DEBUG=true","source":"cli"},{"arg":"GO_VERSION=1.21","source":"file"}]`},
+	{"PREDICATE", "{\n  \"buildType\": \"https://konflux-ci.dev/build\"\n}\n"},
+}
+
+var _ = Describe("Sanitize", func() {
+	It("should match the golden bytes of every result the builders emit", func() {
+		dir := GinkgoT().TempDir()
+		writer := NewWriter(dir)
+
+		var b strings.Builder
+		for _, result := range emittedResults {
+			Expect(writer.Write(result.name, result.value)).To(Succeed())
+			content, err := os.ReadFile(filepath.Join(dir, result.name))
+			Expect(err).NotTo(HaveOccurred())
+			fmt.Fprintf(&b, "%s: %q\n", result.name, content)
+		}
+
+		goldenPath := filepath.Join("testdata", "results.golden")
+		if *updateGolden {
+			Expect(os.MkdirAll("testdata", 0755)).To(Succeed())
+			Expect(os.WriteFile(goldenPath, []byte(b.String()), 0644)).To(Succeed())
+		}
+		golden, err := os.ReadFile(goldenPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.String()).To(Equal(string(golden)))
+	})
+
+	It("should trim surrounding whitespace", func() {
+		Expect(Sanitize("IMAGE_DIGEST", " sha256:abc\n")).To(Equal("sha256:abc"))
+	})
+
+	It("should escape control characters but tabs and newlines", func() {
+		Expect(Sanitize("TIMEOUT", "a\x07b\tc\r\nd\re")).To(Equal("a\\u0007b\tc\nd\\u000de"))
+	})
+
+	It("should reject newlines in single-line results", func() {
+		_, err := Sanitize("IMAGE_DIGEST", "sha256:abc\nError: manifest unknown")
+
+		Expect(err).To(MatchError("result IMAGE_DIGEST must be a single line, got 2 lines"))
+	})
+
+	It("should keep newlines in multi-line results", func() {
+		Expect(Sanitize("BUILD_METRICS", "a=1\nb=2\n")).To(Equal("a=1\nb=2"))
+	})
+
+	It("should not write a rejected result", func() {
+		dir := GinkgoT().TempDir()
+
+		Expect(NewWriter(dir).Write("commit", "abc\ndef")).NotTo(Succeed())
+
+		Expect(filepath.Join(dir, "commit")).NotTo(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,188 @@
+// Package warnings collects the non-fatal problems met by the builders so
+// that they can be surfaced as a result rather than only logged
+package warnings
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Categories group warnings so that STRICT_WARNINGS can escalate them to errors
+const (
+	// CategoryDigest is a digest that couldn't be retrieved
+	CategoryDigest = "digest"
+
+	// CategorySubmodule is a git submodule that couldn't be updated
+	CategorySubmodule = "submodule"
+
+	// CategoryExpirationLabel is an expiration label that couldn't be added
+	CategoryExpirationLabel = "expiration-label"
+
+	// CategoryToolVersion is a tool whose version couldn't be detected
+	CategoryToolVersion = "tool-version"
+
+	// CategoryExistingImage is an existing image that couldn't be reused
+	CategoryExistingImage = "existing-image"
+
+	// CategoryBaseImagePolicy is a violation of a base image policy in warn mode
+	CategoryBaseImagePolicy = "base-image-policy"
+
+	// CategoryImageID is a pushed image ID differing from the built one
+	CategoryImageID = "image-id"
+
+	// CategoryDeniedFile is a denied file found in the image in warn mode
+	CategoryDeniedFile = "denied-file"
+
+	// CategoryTempTagCleanup is a temporary tag that couldn't be cleaned up
+	CategoryTempTagCleanup = "temp-tag-cleanup"
+
+	// CategoryIndexSize is an index size that couldn't be computed
+	CategoryIndexSize = "index-size"
+)
+
+// categories lists the known categories
+var categories = map[string]bool{
+	CategoryDigest:          true,
+	CategorySubmodule:       true,
+	CategoryExpirationLabel: true,
+	CategoryToolVersion:     true,
+	CategoryExistingImage:   true,
+	CategoryBaseImagePolicy: true,
+	CategoryImageID:         true,
+	CategoryDeniedFile:      true,
+	CategoryTempTagCleanup:  true,
+	CategoryIndexSize:       true,
+}
+
+// ParseCategories parses a comma-separated list of category names, as given
+// in STRICT_WARNINGS, rejecting unknown names
+func ParseCategories(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !categories[name] {
+			known := make([]string, 0, len(categories))
+			for category := range categories {
+				known = append(known, category)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown warning category %q, expected one of %s", name, strings.Join(known, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Warning is a non-fatal problem met by a builder
+type Warning struct {
+	Category string    `json:"category"`
+	Step     string    `json:"step,omitempty"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// StrictError is returned when a warning of a strict category is added
+type StrictError struct {
+	Warning Warning
+}
+
+func (e *StrictError) Error() string {
+	return fmt.Sprintf("%s warning escalated to an error by STRICT_WARNINGS: %s", e.Warning.Category, e.Warning.Message)
+}
+
+// Collector collects the warnings of a build. The zero value collects
+// without escalating any category.
+type Collector struct {
+	// Strict lists the categories whose warnings are errors
+	Strict []string
+
+	warnings []Warning
+}
+
+// Add records a warning met by step, returning a *StrictError when its
+// category is strict. Escalated warnings are recorded too.
+func (c *Collector) Add(step, category, message string) error {
+	warning := Warning{Category: category, Step: step, Message: message, Time: time.Now().UTC()}
+	c.warnings = append(c.warnings, warning)
+
+	for _, strict := range c.Strict {
+		if strict == category {
+			return &StrictError{Warning: warning}
+		}
+	}
+	return nil
+}
+
+// Warnings returns the collected warnings in order
+func (c *Collector) Warnings() []Warning {
+	return append([]Warning(nil), c.warnings...)
+}
+
+// Messages returns the messages of the collected warnings in order
+func (c *Collector) Messages() []string {
+	messages := make([]string, len(c.warnings))
+	for i, warning := range c.warnings {
+		messages[i] = warning.Message
+	}
+	return messages
+}
+
+// MaxResultWarnings bounds the warnings listed in the WARNINGS result, whose
+// size is limited by Tekton
+const MaxResultWarnings = 10
+
+// maxResultMessageLength bounds the length of a message in the WARNINGS
+// result in characters
+const maxResultMessageLength = 200
+
+// Result renders the WARNINGS result: the warning count and the first
+// MaxResultWarnings warnings with their messages truncated
+func (c *Collector) Result() (string, error) {
+	listed := c.Warnings()
+	if len(listed) > MaxResultWarnings {
+		listed = listed[:MaxResultWarnings]
+	}
+	for i := range listed {
+		if runes := []rune(listed[i].Message); len(runes) > maxResultMessageLength {
+			listed[i].Message = string(runes[:maxResultMessageLength-1]) + "…"
+		}
+	}
+
+	result := struct {
+		Count     int       `json:"count"`
+		Warnings  []Warning `json:"warnings"`
+		Truncated bool      `json:"truncated,omitempty"`
+	}{
+		Count:     len(c.warnings),
+		Warnings:  listed,
+		Truncated: len(c.warnings) > len(listed),
+	}
+	if result.Warnings == nil {
+		result.Warnings = []Warning{}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to render warnings: %w", err)
+	}
+	return string(data), nil
+}
+
+// JSON renders the full list of warnings as indented JSON
+func (c *Collector) JSON() ([]byte, error) {
+	warnings := c.Warnings()
+	if warnings == nil {
+		warnings = []Warning{}
+	}
+	data, err := json.MarshalIndent(warnings, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to render warnings: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,13 @@
+package warnings_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestWarnings(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Warnings Suite")
+}
@@ -0,0 +1,111 @@
+package warnings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Collector", func() {
+	var collector *Collector
+
+	BeforeEach(func() {
+		collector = &Collector{}
+	})
+
+	It("should record warnings in order with their step and category", func() {
+		Expect(collector.Add("init", CategoryExistingImage, "first")).To(Succeed())
+		Expect(collector.Add("build", CategoryDeniedFile, "second")).To(Succeed())
+
+		collected := collector.Warnings()
+		Expect(collected).To(HaveLen(2))
+		Expect(collected[0].Step).To(Equal("init"))
+		Expect(collected[0].Category).To(Equal(CategoryExistingImage))
+		Expect(collected[0].Time).NotTo(BeZero())
+		Expect(collector.Messages()).To(Equal([]string{"first", "second"}))
+	})
+
+	It("should escalate the warnings of strict categories", func() {
+		collector.Strict = []string{CategoryDigest}
+
+		Expect(collector.Add("build", CategoryToolVersion, "no version")).To(Succeed())
+		err := collector.Add("build", CategoryDigest, "no digest")
+
+		var strictErr *StrictError
+		Expect(errors.As(err, &strictErr)).To(BeTrue())
+		Expect(strictErr.Warning.Message).To(Equal("no digest"))
+		Expect(err).To(MatchError("digest warning escalated to an error by STRICT_WARNINGS: no digest"))
+		Expect(collector.Messages()).To(Equal([]string{"no version", "no digest"}))
+	})
+
+	Describe("Result", func() {
+		It("should render an empty list without warnings", func() {
+			Expect(collector.Result()).To(Equal(`{"count":0,"warnings":[]}`))
+		})
+
+		It("should list at most MaxResultWarnings warnings with truncated messages", func() {
+			for i := 0; i < MaxResultWarnings+2; i++ {
+				Expect(collector.Add("build", CategoryDigest, fmt.Sprintf("warning %d", i))).To(Succeed())
+			}
+			Expect(collector.Add("build", CategoryDigest, strings.Repeat("x", 500))).To(Succeed())
+
+			result, err := collector.Result()
+			Expect(err).NotTo(HaveOccurred())
+
+			var decoded struct {
+				Count     int
+				Warnings  []Warning
+				Truncated bool
+			}
+			Expect(json.Unmarshal([]byte(result), &decoded)).To(Succeed())
+			Expect(decoded.Count).To(Equal(MaxResultWarnings + 3))
+			Expect(decoded.Warnings).To(HaveLen(MaxResultWarnings))
+			Expect(decoded.Truncated).To(BeTrue())
+		})
+
+		It("should truncate long messages", func() {
+			Expect(collector.Add("build", CategoryDigest, strings.Repeat("é", 500))).To(Succeed())
+
+			result, err := collector.Result()
+			Expect(err).NotTo(HaveOccurred())
+
+			var decoded struct{ Warnings []Warning }
+			Expect(json.Unmarshal([]byte(result), &decoded)).To(Succeed())
+			Expect([]rune(decoded.Warnings[0].Message)).To(HaveLen(maxResultMessageLength))
+			Expect(decoded.Warnings[0].Message).To(HaveSuffix("…"))
+			Expect(collector.Messages()[0]).To(HaveLen(1000))
+		})
+	})
+
+	It("should render the full list as JSON", func() {
+		for i := 0; i < MaxResultWarnings+2; i++ {
+			Expect(collector.Add("build", CategoryDigest, "warning")).To(Succeed())
+		}
+
+		data, err := collector.JSON()
+		Expect(err).NotTo(HaveOccurred())
+
+		var decoded []Warning
+		Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+		Expect(decoded).To(HaveLen(MaxResultWarnings + 2))
+	})
+})
+
+var _ = Describe("ParseCategories", func() {
+	It("should parse a comma-separated list", func() {
+		Expect(ParseCategories(" digest, ,submodule")).To(Equal([]string{CategoryDigest, CategorySubmodule}))
+	})
+
+	It("should accept an empty value", func() {
+		Expect(ParseCategories("")).To(BeEmpty())
+	})
+
+	It("should reject unknown categories", func() {
+		_, err := ParseCategories("digest,typo")
+		Expect(err).To(MatchError(HavePrefix(`unknown warning category "typo", expected one of base-image-policy, `)))
+	})
+})